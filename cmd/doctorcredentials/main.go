@@ -0,0 +1,38 @@
+// Command doctorcredentials sets the email and password a doctor signs in
+// with at POST /doctors/login. The password is read from DOCTOR_PASSWORD so
+// it stays out of the shell history:
+//
+//	DOCTOR_PASSWORD=... MONGODB_URL=... go run ./cmd/doctorcredentials -doctor_id 64f0c... -email a.rao@example.org
+//
+// Running it again for a doctor replaces their credentials.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	controller "golang-hospital-management/controllers"
+)
+
+func main() {
+	doctorId := flag.String("doctor_id", "", "doctor to set the credentials of")
+	email := flag.String("email", "", "email the doctor signs in with")
+	flag.Parse()
+
+	password := os.Getenv("DOCTOR_PASSWORD")
+	if *doctorId == "" || *email == "" || password == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := controller.SetDoctorCredentials(ctx, *doctorId, *email, password); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("doctor %s signs in as %s", *doctorId, *email)
+}
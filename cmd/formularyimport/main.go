@@ -0,0 +1,78 @@
+// Command formularyimport loads a drug formulary dataset, or with
+// -kind interactions a drug interaction table, into the database.
+//
+//	MONGODB_URL=... go run ./cmd/formularyimport -file data/formulary.sample.csv
+//	MONGODB_URL=... go run ./cmd/formularyimport -kind interactions -file data/interactions.sample.csv
+//
+// The format follows the file extension unless -format is given. Records are
+// matched on their code, so running it again with a newer dataset updates the
+// formulary in place.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	controller "golang-hospital-management/controllers"
+	helper "golang-hospital-management/helpers"
+)
+
+func main() {
+	file := flag.String("file", "", "CSV or JSON formulary dataset")
+	format := flag.String("format", "", "csv or json, by default from the file extension")
+	kind := flag.String("kind", "drugs", "drugs or interactions")
+	flag.Parse()
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	var result controller.FormularyImport
+	switch *kind {
+	case "drugs":
+		records, err := helper.ParseFormulary(f, *format)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result, err = controller.ImportFormulary(ctx, records)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "interactions":
+		records, err := helper.ParseInteractions(f, *format)
+		if err != nil {
+			log.Fatal(err)
+		}
+		result, err = controller.ImportInteractions(ctx, records)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown -kind %q, expected drugs or interactions", *kind)
+	}
+
+	report, _ := json.MarshalIndent(result, "", "  ")
+	os.Stdout.Write(append(report, '\n'))
+	if len(result.Rejected) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,73 @@
+// Command terminologyimport loads a version of a code system, ICD-10 or a
+// SNOMED CT subset, into the database.
+//
+//	MONGODB_URL=... go run ./cmd/terminologyimport -version 2024 -file data/icd10.sample.csv
+//	MONGODB_URL=... go run ./cmd/terminologyimport -version 2024 -file icd10cm_codes_2024.txt
+//	MONGODB_URL=... go run ./cmd/terminologyimport -system SNOMED -version 20240101 -file data/snomed.sample.txt
+//
+// The format follows the file extension unless -format is given; a .txt file
+// is read as the CMS ICD-10-CM code file, or for SNOMED as an RF2
+// description file. A version already loaded is refused; load a changed file
+// as a new version. The version becomes the current one unless
+// -activate=false.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	controller "golang-hospital-management/controllers"
+	helper "golang-hospital-management/helpers"
+)
+
+func main() {
+	file := flag.String("file", "", "code set file")
+	format := flag.String("format", "", "csv, cms or rf2, by default from the file extension")
+	system := flag.String("system", helper.ICD10, "ICD10 or SNOMED")
+	version := flag.String("version", "", "version of the code set, e.g. 2024")
+	activate := flag.Bool("activate", true, "make the version the current one")
+	flag.Parse()
+
+	if *file == "" || *version == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	*system = strings.ToUpper(*system)
+	if *system != helper.ICD10 && *system != helper.SNOMED {
+		log.Fatalf("unknown -system %q, expected ICD10 or SNOMED", *system)
+	}
+	if *format == "" {
+		*format = helper.TerminologyFormat(*system, strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), "."))
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := helper.ParseTerminology(f, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	result, err := controller.ImportTerminology(ctx, *system, *version, filepath.Base(*file), records, *activate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, _ := json.MarshalIndent(result, "", "  ")
+	os.Stdout.Write(append(report, '\n'))
+	if len(result.Rejected) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,322 @@
+package controller
+
+import (
+	"context"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var allergyCollection *mongo.Collection = database.OpenCollection(database.Client, "allergy")
+
+const (
+	allergyActive         = "ACTIVE"
+	allergyEnteredInError = "ENTERED_IN_ERROR"
+)
+
+// The allergy status of a patient. NO_KNOWN_ALLERGIES means someone asked
+// and there were none; NOT_RECORDED means nobody has asked yet.
+const (
+	allergiesNotRecorded = "NOT_RECORDED"
+	allergiesNoneKnown   = "NO_KNOWN_ALLERGIES"
+	allergiesRecorded    = "RECORDED"
+)
+
+// inactiveAllergy lists the allergy states that no longer warn.
+var inactiveAllergy = []string{"INACTIVE", "RESOLVED", allergyEnteredInError}
+
+// PatientAllergies is a patient's allergy list with its overall status.
+type PatientAllergies struct {
+	Allergy_status string                    `json:"allergy_status"`
+	Assessment     *models.AllergyAssessment `json:"assessment"`
+	Allergies      []models.Allergy          `json:"allergies"`
+}
+
+// PatientDetail is the patient as GetPatient returns it, with the latest
+// released lab results.
+type PatientDetail struct {
+	models.Patient
+	PatientAllergies
+	Lab_results []models.LabResult `json:"lab_results"`
+}
+
+// GetPatientAllergies lists the allergies of a patient. Records entered in
+// error are left out unless asked for with include_errors=true.
+func GetPatientAllergies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var patient models.Patient
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": c.Param("patient_id")}).Decode(&patient); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
+			return
+		}
+
+		allergies, err := patientAllergies(ctx, patient, c.Query("include_errors") == "true")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the allergies"})
+			return
+		}
+		c.JSON(http.StatusOK, allergies)
+	}
+}
+
+func GetAllergy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var allergy models.Allergy
+		if err := allergyCollection.FindOne(ctx, bson.M{"allergy_id": c.Param("allergy_id")}).Decode(&allergy); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "allergy not found"})
+			return
+		}
+		c.JSON(http.StatusOK, allergy)
+	}
+}
+
+// CreateAllergy records an allergy of the patient. It replaces a "no known
+// allergies" assessment, which is no longer true.
+func CreateAllergy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var allergy models.Allergy
+		if err := c.BindJSON(&allergy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(allergy); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		patientId := c.Param("patient_id")
+		count, err := patientCollection.CountDocuments(ctx, bson.M{"patient_id": patientId})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
+			return
+		}
+		if msg := checkFormularyCode(ctx, allergy.Drug_code); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if allergy.Status == nil {
+			status := allergyActive
+			allergy.Status = &status
+		}
+		if allergy.Atc_class != nil {
+			atc := strings.ToUpper(*allergy.Atc_class)
+			allergy.Atc_class = &atc
+		}
+		allergy.Patient_id = &patientId
+		allergy.Recorded_by = c.GetString("uid")
+		allergy.Created_at = helper.Now()
+		allergy.Updated_at = helper.Now()
+		allergy.ID = primitive.NewObjectID()
+		allergy.Allergy_id = allergy.ID.Hex()
+
+		if _, insertErr := allergyCollection.InsertOne(ctx, allergy); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "allergy was not recorded"})
+			return
+		}
+
+		if *allergy.Status == allergyActive {
+			clearNoKnownAllergies(ctx, patientId)
+		}
+		c.JSON(http.StatusOK, allergy)
+	}
+}
+
+func UpdateAllergy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var change models.AllergyChange
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(change); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if msg := checkFormularyCode(ctx, change.Drug_code); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		var updateObj primitive.D
+		if change.Substance != nil {
+			updateObj = append(updateObj, bson.E{Key: "substance", Value: change.Substance})
+		}
+		if change.Drug_code != nil {
+			updateObj = append(updateObj, bson.E{Key: "drug_code", Value: change.Drug_code})
+		}
+		if change.Atc_class != nil {
+			updateObj = append(updateObj, bson.E{Key: "atc_class", Value: strings.ToUpper(*change.Atc_class)})
+		}
+		if change.Type != nil {
+			updateObj = append(updateObj, bson.E{Key: "type", Value: change.Type})
+		}
+		if change.Category != nil {
+			updateObj = append(updateObj, bson.E{Key: "category", Value: change.Category})
+		}
+		if change.Reaction != nil {
+			updateObj = append(updateObj, bson.E{Key: "reaction", Value: change.Reaction})
+		}
+		if change.Severity != nil {
+			updateObj = append(updateObj, bson.E{Key: "severity", Value: change.Severity})
+		}
+		if change.Status != nil {
+			updateObj = append(updateObj, bson.E{Key: "status", Value: change.Status})
+		}
+		if change.Onset != nil {
+			updateObj = append(updateObj, bson.E{Key: "onset", Value: change.Onset})
+		}
+		if change.Notes != nil {
+			updateObj = append(updateObj, bson.E{Key: "notes", Value: change.Notes})
+		}
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: helper.Now()})
+
+		var allergy models.Allergy
+		err := allergyCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"allergy_id": c.Param("allergy_id")},
+			bson.D{{Key: "$set", Value: updateObj}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&allergy)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "allergy not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "allergy update failed"})
+			return
+		}
+		if allergy.Status != nil && *allergy.Status == allergyActive {
+			clearNoKnownAllergies(ctx, *allergy.Patient_id)
+		}
+		c.JSON(http.StatusOK, allergy)
+	}
+}
+
+// DeleteAllergy marks the record as entered in error. Allergy records are
+// kept so the history of what was believed about the patient stays readable.
+func DeleteAllergy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var allergy models.Allergy
+		err := allergyCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"allergy_id": c.Param("allergy_id")},
+			bson.M{"$set": bson.M{"status": allergyEnteredInError, "updated_at": helper.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&allergy)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "allergy not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "allergy was not deleted"})
+			return
+		}
+		c.JSON(http.StatusOK, allergy)
+	}
+}
+
+// RecordNoKnownAllergies records that the patient was asked and has no
+// allergies. It is refused while an active allergy is on record.
+func RecordNoKnownAllergies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		patientId := c.Param("patient_id")
+		count, err := allergyCollection.CountDocuments(ctx, bson.M{"patient_id": patientId, "status": bson.M{"$nin": inactiveAllergy}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the allergies"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the patient has active allergies on record"})
+			return
+		}
+
+		assessment := models.AllergyAssessment{
+			No_known_allergies: true,
+			Recorded_by:        c.GetString("uid"),
+			Recorded_at:        helper.Now(),
+		}
+		result, err := patientCollection.UpdateOne(ctx, bson.M{"patient_id": patientId}, bson.M{
+			"$set": bson.M{"allergy_assessment": assessment, "updated_at": helper.Now()},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "allergy status update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
+			return
+		}
+		c.JSON(http.StatusOK, PatientAllergies{
+			Allergy_status: allergiesNoneKnown,
+			Assessment:     &assessment,
+			Allergies:      []models.Allergy{},
+		})
+	}
+}
+
+// patientAllergies loads the patient's allergy records and works out the
+// allergy status from them and the patient's assessment.
+func patientAllergies(ctx context.Context, patient models.Patient, includeErrors bool) (PatientAllergies, error) {
+	result := PatientAllergies{Allergy_status: allergiesNotRecorded, Allergies: []models.Allergy{}}
+
+	filter := bson.M{"patient_id": patient.Patient_id}
+	if !includeErrors {
+		filter["status"] = bson.M{"$ne": allergyEnteredInError}
+	}
+	cursor, err := allergyCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return result, err
+	}
+	if err = cursor.All(ctx, &result.Allergies); err != nil {
+		return result, err
+	}
+
+	for _, allergy := range result.Allergies {
+		if allergy.Status == nil || !containsString(inactiveAllergy, *allergy.Status) {
+			result.Allergy_status = allergiesRecorded
+			return result, nil
+		}
+	}
+	if patient.Allergy_assessment != nil && patient.Allergy_assessment.No_known_allergies {
+		result.Allergy_status = allergiesNoneKnown
+		result.Assessment = patient.Allergy_assessment
+	}
+	return result, nil
+}
+
+// clearNoKnownAllergies drops a "no known allergies" assessment once an
+// active allergy is on record.
+func clearNoKnownAllergies(ctx context.Context, patientId string) {
+	patientCollection.UpdateOne(ctx, bson.M{"patient_id": patientId, "allergy_assessment.no_known_allergies": true}, bson.M{
+		"$unset": bson.M{"allergy_assessment": ""},
+		"$set":   bson.M{"updated_at": helper.Now()},
+	})
+}
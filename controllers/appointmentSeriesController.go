@@ -0,0 +1,397 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var appointmentSeriesCollection *mongo.Collection = database.OpenCollection(database.Client, "appointmentSeries")
+
+const (
+	seriesScopeThis      = "THIS"
+	seriesScopeFollowing = "FOLLOWING"
+	seriesScopeAll       = "ALL"
+)
+
+func CreateAppointmentSeries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var series models.AppointmentSeries
+		var doctor models.Doctor
+
+		if err := c.BindJSON(&series); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(series)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		rule, err := helper.ParseRecurrence(*series.Recurrence)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = doctorCollection.FindOne(ctx, bson.M{"doctor_id": series.Doctor_id}).Decode(&doctor)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "message:Doctor not found"})
+			return
+		}
+
+		// the type settles duration and buffer once for every occurrence
+		template := models.Appointment{
+			Duration_minutes: series.Duration_minutes,
+			Type_id:          series.Type_id,
+			Doctor_id:        series.Doctor_id,
+		}
+		problem, err := applyAppointmentType(ctx, &template)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the appointment type"})
+			return
+		}
+		if problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+			return
+		}
+		series.Duration_minutes = template.Duration_minutes
+		if series.Duration_minutes == 0 {
+			series.Duration_minutes = defaultAppointmentMinutes
+		}
+		status := "ACTIVE"
+		series.Status = &status
+		series.Conflicts = []models.SeriesConflict{}
+		series.Created_at = helper.Now()
+		series.Updated_at = helper.Now()
+		series.ID = primitive.NewObjectID()
+		series.Series_id = series.ID.Hex()
+
+		// expand on the facility's wall clock so a 09:00 slot stays at 09:00
+		// across DST changes
+		start := helper.InFacility(*series.Start_Date)
+		for i, occurrence := range rule.Occurrences(start) {
+			occurrence = occurrence.UTC()
+			appointment := models.Appointment{
+				Appointment_Date: occurrence,
+				Duration_minutes: series.Duration_minutes,
+				Buffer_minutes:   template.Buffer_minutes,
+				Type_id:          series.Type_id,
+				Invoice_id:       series.Invoice_id,
+				Doctor_id:        series.Doctor_id,
+				Patient_id:       series.Patient_id,
+				Series_id:        &series.Series_id,
+				Series_index:     i,
+			}
+			// each occurrence is confirmed to the patient and sent as its
+			// own S12, as a single booking is
+			_, conflict, status, msg := bookNewAppointment(ctx, &appointment)
+			if conflict != nil {
+				series.Conflicts = append(series.Conflicts, models.SeriesConflict{Occurrence_Date: occurrence, Appointment_id: conflict.Appointment_id})
+				continue
+			}
+			if msg != "" {
+				c.JSON(status, gin.H{"error": msg})
+				return
+			}
+			series.Occurrences++
+		}
+
+		if _, insertErr := appointmentSeriesCollection.InsertOne(ctx, series); insertErr != nil {
+			msg := fmt.Sprintf("appointment series was not created")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+
+		localizeSeries(&series)
+		c.JSON(http.StatusOK, series)
+	}
+}
+
+func GetAppointmentSeries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		seriesId := c.Param("series_id")
+		var series models.AppointmentSeries
+
+		err := appointmentSeriesCollection.FindOne(ctx, bson.M{"series_id": seriesId}).Decode(&series)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the appointment series"})
+			return
+		}
+
+		appointments, err := seriesAppointments(ctx, seriesId, -1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
+			return
+		}
+
+		localizeSeries(&series)
+		for i := range appointments {
+			localizeAppointment(&appointments[i])
+		}
+		c.JSON(http.StatusOK, gin.H{"series": series, "appointments": appointments})
+	}
+}
+
+// UpdateAppointmentSeries moves or reassigns "this", "this and following" or
+// "all" occurrences. A new Appointment_date on the anchor appointment is
+// applied to the others as the same shift on the facility's wall clock.
+// Either every occurrence moves or, when any new time conflicts, none does.
+func UpdateAppointmentSeries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		seriesId := c.Param("series_id")
+		var change models.SeriesChange
+
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(change)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if change.Doctor_id != nil {
+			var doctor models.Doctor
+			err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": change.Doctor_id}).Decode(&doctor)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "message:Doctor not found"})
+				return
+			}
+		}
+
+		anchor, targets, err := seriesTargets(ctx, seriesId, change)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		type plannedMove struct {
+			appointment models.Appointment
+			start       time.Time
+			minutes     int
+			doctorId    string
+		}
+		var moves []plannedMove
+		var targetIds []string
+		for _, appointment := range targets {
+			newDate := appointment.Appointment_Date
+			if change.Appointment_Date != nil && anchor != nil {
+				newDate = helper.ShiftWallClock(newDate, anchor.Appointment_Date, *change.Appointment_Date)
+			}
+			minutes := appointmentMinutes(appointment)
+			if change.Duration_minutes != 0 {
+				minutes = change.Duration_minutes
+			}
+			doctorId := *appointment.Doctor_id
+			if change.Doctor_id != nil {
+				doctorId = *change.Doctor_id
+			}
+			moves = append(moves, plannedMove{appointment, newDate, minutes, doctorId})
+			targetIds = append(targetIds, appointment.Appointment_id)
+		}
+
+		extra := bson.M{}
+		if *change.Scope == seriesScopeThis {
+			// a single edited occurrence no longer follows the series rule
+			extra["series_index"] = -1
+		}
+
+		bookingMu.Lock()
+		defer bookingMu.Unlock()
+
+		// every new slot is checked before any is written; the occurrences
+		// being moved do not count against each other where they are now
+		conflicts := []models.SeriesConflict{}
+		for i, move := range moves {
+			conflict, err := moveConflict(ctx, move.appointment, move.start, move.minutes, move.doctorId, targetIds...)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the new times"})
+				return
+			}
+			if conflict == nil {
+				end := move.start.Add(time.Duration(move.minutes+move.appointment.Buffer_minutes) * time.Minute)
+				for _, other := range moves[:i] {
+					otherEnd := other.start.Add(time.Duration(other.minutes+other.appointment.Buffer_minutes) * time.Minute)
+					if other.doctorId == move.doctorId && other.start.Before(end) && otherEnd.After(move.start) {
+						conflict = &bookingConflict{Appointment_id: other.appointment.Appointment_id}
+						break
+					}
+				}
+			}
+			if conflict != nil {
+				conflicts = append(conflicts, models.SeriesConflict{
+					Occurrence_Date:  move.start,
+					Occurrence_local: helper.FormatLocal(move.start),
+					Appointment_id:   conflict.Appointment_id,
+				})
+			}
+		}
+		if len(conflicts) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "some occurrences cannot move to their new time", "conflicts": conflicts})
+			return
+		}
+
+		for _, move := range moves {
+			if err := moveAppointment(ctx, move.appointment, move.start, move.minutes, move.doctorId, extra); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "appointment update failed"})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"updated": len(moves), "conflicts": conflicts})
+	}
+}
+
+func CancelAppointmentSeries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		seriesId := c.Param("series_id")
+		var change models.SeriesChange
+
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(change)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		_, targets, err := seriesTargets(ctx, seriesId, change)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(targets) == 0 {
+			c.JSON(http.StatusOK, gin.H{"cancelled": 0})
+			return
+		}
+
+		var ids []string
+		var newlyCancelled []string
+		for _, appointment := range targets {
+			ids = append(ids, appointment.Appointment_id)
+			if appointment.Status == nil || *appointment.Status != appointmentCancelled {
+				newlyCancelled = append(newlyCancelled, appointment.Appointment_id)
+			}
+		}
+
+		updatedAt := helper.Now()
+		result, err := appointmentCollection.UpdateMany(
+			ctx,
+			bson.M{"appointment_id": bson.M{"$in": ids}},
+			bson.M{"$set": bson.M{"status": appointmentCancelled, "updated_at": updatedAt}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "appointment update failed"})
+			return
+		}
+		queueCancellationMessages(ctx, newlyCancelled)
+
+		if *change.Scope == seriesScopeAll {
+			_, err = appointmentSeriesCollection.UpdateOne(
+				ctx,
+				bson.M{"series_id": seriesId},
+				bson.M{"$set": bson.M{"status": "CANCELLED", "updated_at": updatedAt}},
+			)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "appointment series update failed"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// seriesTargets resolves which scheduled appointments of a series a change
+// applies to. The anchor is the appointment named in the change, and is nil
+// only for the "all" scope when none was given.
+func seriesTargets(ctx context.Context, seriesId string, change models.SeriesChange) (*models.Appointment, []models.Appointment, error) {
+	var anchor *models.Appointment
+
+	if change.Appointment_id != "" {
+		var found models.Appointment
+		err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": change.Appointment_id, "series_id": seriesId}).Decode(&found)
+		if err != nil {
+			return nil, nil, fmt.Errorf("appointment %s is not part of series %s", change.Appointment_id, seriesId)
+		}
+		anchor = &found
+	} else if *change.Scope != seriesScopeAll {
+		return nil, nil, fmt.Errorf("Appointment_id is required for scope %s", *change.Scope)
+	}
+
+	switch *change.Scope {
+	case seriesScopeThis:
+		return anchor, []models.Appointment{*anchor}, nil
+	case seriesScopeFollowing:
+		if anchor.Series_index < 0 {
+			return nil, nil, fmt.Errorf("appointment %s was edited on its own and no longer follows the series", anchor.Appointment_id)
+		}
+		targets, err := seriesAppointments(ctx, seriesId, anchor.Series_index)
+		return anchor, targets, err
+	default:
+		targets, err := seriesAppointments(ctx, seriesId, -1)
+		if err == nil && anchor == nil && len(targets) > 0 {
+			anchor = &targets[0]
+		}
+		return anchor, targets, err
+	}
+}
+
+// seriesAppointments lists the scheduled appointments of a series in order,
+// starting at fromIndex when it is not negative.
+func seriesAppointments(ctx context.Context, seriesId string, fromIndex int) ([]models.Appointment, error) {
+	filter := bson.M{"series_id": seriesId, "status": bson.M{"$ne": appointmentCancelled}}
+	if fromIndex >= 0 {
+		filter["series_index"] = bson.M{"$gte": fromIndex}
+	}
+
+	opts := options.Find().SetSort(bson.M{"appointment_date": 1})
+	cursor, err := appointmentCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	appointments := []models.Appointment{}
+	err = cursor.All(ctx, &appointments)
+	return appointments, err
+}
+
+func localizeSeries(series *models.AppointmentSeries) {
+	if series.Start_Date != nil {
+		series.Start_local = helper.FormatLocal(*series.Start_Date)
+	}
+	series.Time_zone = helper.FacilityLocation().String()
+	for i := range series.Conflicts {
+		series.Conflicts[i].Occurrence_local = helper.FormatLocal(series.Conflicts[i].Occurrence_Date)
+	}
+}
@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var appointmentTypeCollection *mongo.Collection = database.OpenCollection(database.Client, "appointmentType")
+
+func GetAppointmentTypes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if speciality := c.Query("speciality"); speciality != "" {
+			// types open to every speciality have none listed
+			filter["$or"] = bson.A{
+				bson.M{"specialities": speciality},
+				bson.M{"specialities": bson.M{"$in": bson.A{nil, bson.A{}}}},
+			}
+		}
+		if c.Query("active") == "true" {
+			filter["active"] = bson.M{"$ne": false}
+		}
+
+		result, err := appointmentTypeCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointment types"})
+			return
+		}
+
+		allTypes := []models.AppointmentType{}
+		if err = result.All(ctx, &allTypes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointment types"})
+			return
+		}
+		c.JSON(http.StatusOK, allTypes)
+	}
+}
+
+func GetAppointmentType() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var appointmentType models.AppointmentType
+		err := appointmentTypeCollection.FindOne(ctx, bson.M{"type_id": c.Param("type_id")}).Decode(&appointmentType)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "appointment type not found"})
+			return
+		}
+		c.JSON(http.StatusOK, appointmentType)
+	}
+}
+
+func CreateAppointmentType() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var appointmentType models.AppointmentType
+
+		if err := c.BindJSON(&appointmentType); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(appointmentType)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := appointmentTypeCollection.CountDocuments(ctx, bson.M{"code": appointmentType.Code})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the code"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "an appointment type with this code already exists"})
+			return
+		}
+
+		if appointmentType.Active == nil {
+			active := true
+			appointmentType.Active = &active
+		}
+		appointmentType.Created_at = helper.Now()
+		appointmentType.Updated_at = helper.Now()
+		appointmentType.ID = primitive.NewObjectID()
+		appointmentType.Type_id = appointmentType.ID.Hex()
+
+		result, insertErr := appointmentTypeCollection.InsertOne(ctx, appointmentType)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "appointment type was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func UpdateAppointmentType() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var change models.AppointmentTypeChange
+
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(change)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+
+		if change.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: change.Name})
+		}
+		if change.Duration_minutes != nil {
+			updateObj = append(updateObj, bson.E{Key: "duration_minutes", Value: *change.Duration_minutes})
+		}
+		if change.Buffer_minutes != nil {
+			updateObj = append(updateObj, bson.E{Key: "buffer_minutes", Value: *change.Buffer_minutes})
+		}
+		if change.Specialities != nil {
+			updateObj = append(updateObj, bson.E{Key: "specialities", Value: change.Specialities})
+		}
+		if change.Prep_instructions != nil {
+			updateObj = append(updateObj, bson.E{Key: "prep_instructions", Value: change.Prep_instructions})
+		}
+		if change.Default_fee != nil {
+			updateObj = append(updateObj, bson.E{Key: "default_fee", Value: change.Default_fee})
+		}
+		if change.Active != nil {
+			updateObj = append(updateObj, bson.E{Key: "active", Value: change.Active})
+		}
+
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: helper.Now()})
+
+		result, err := appointmentTypeCollection.UpdateOne(
+			ctx,
+			bson.M{"type_id": c.Param("type_id")},
+			bson.D{{Key: "$set", Value: updateObj}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "appointment type update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// applyAppointmentType fills in the duration and buffer of the appointment's
+// type, unless the booking set its own duration, and checks the doctor may
+// perform it. A non-empty message is a problem with the request.
+func applyAppointmentType(ctx context.Context, appointment *models.Appointment) (string, error) {
+	if appointment.Type_id == nil {
+		return "", nil
+	}
+
+	var appointmentType models.AppointmentType
+	err := appointmentTypeCollection.FindOne(ctx, bson.M{"type_id": appointment.Type_id}).Decode(&appointmentType)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "appointment type not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if appointmentType.Active != nil && !*appointmentType.Active {
+		return "appointment type " + *appointmentType.Code + " is no longer offered", nil
+	}
+
+	if len(appointmentType.Specialities) > 0 && appointment.Doctor_id != nil {
+		var doctor models.Doctor
+		if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": appointment.Doctor_id}).Decode(&doctor); err != nil {
+			return "message:Doctor not found", nil
+		}
+		allowed := false
+		for _, speciality := range appointmentType.Specialities {
+			if doctor.Speciality != nil && *doctor.Speciality == speciality {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "doctors of this speciality do not perform " + *appointmentType.Name, nil
+		}
+	}
+
+	if appointment.Duration_minutes == 0 {
+		appointment.Duration_minutes = appointmentType.Duration_minutes
+	}
+	appointment.Buffer_minutes = appointmentType.Buffer_minutes
+	return "", nil
+}
+
+// appointmentTypeOf returns the type of the appointment, or nil when it has
+// none or the type was removed.
+func appointmentTypeOf(ctx context.Context, appointment models.Appointment) *models.AppointmentType {
+	if appointment.Type_id == nil {
+		return nil
+	}
+	var appointmentType models.AppointmentType
+	if err := appointmentTypeCollection.FindOne(ctx, bson.M{"type_id": appointment.Type_id}).Decode(&appointmentType); err != nil {
+		return nil
+	}
+	return &appointmentType
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
 	"golang-hospital-management/models"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,7 +16,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -30,10 +31,13 @@ func GetAppoinments() gin.HandlerFunc {
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
 		}
-		var allAppointment []bson.M
+		var allAppointment []models.Appointment
 		if err = result.All(ctx, &allAppointment); err != nil {
 			log.Fatal(err)
 		}
+		for i := range allAppointment {
+			localizeAppointment(&allAppointment[i])
+		}
 		c.JSON(http.StatusOK, allAppointment)
 	}
 }
@@ -44,17 +48,20 @@ func GetAppoinment() gin.HandlerFunc {
 		appointmentId := c.Param("appointment_id")
 		var Appointment models.Appointment
 
-		err := appointmentCollection.FindOne(ctx, bson.M{"order_id": appointmentId}).Decode(&Appointment)
+		err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": appointmentId}).Decode(&Appointment)
 		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the appointments"})
 		}
+		localizeAppointment(&Appointment)
 		c.JSON(http.StatusOK, Appointment)
 	}
 }
 
 func CreateAppointment() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		var doctor models.Doctor
 
 		var appointment models.Appointment
@@ -63,6 +70,11 @@ func CreateAppointment() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		// status, rescheduling and series membership are the server's to set
+		appointment.Status = nil
+		appointment.Reschedule = nil
+		appointment.Series_id = nil
+		appointment.Series_index = 0
 
 		validationErr := validate.Struct(appointment)
 
@@ -72,8 +84,7 @@ func CreateAppointment() gin.HandlerFunc {
 		}
 
 		if appointment.Doctor_id != nil {
-			err := appointmentCollection.FindOne(ctx, bson.M{"Doctor_id": appointment.Doctor_id}).Decode(&doctor)
-			defer cancel()
+			err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": appointment.Doctor_id}).Decode(&doctor)
 			if err != nil {
 				msg := fmt.Sprintf("message:Doctor not found")
 				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
@@ -81,75 +92,343 @@ func CreateAppointment() gin.HandlerFunc {
 			}
 		}
 
-		appointment.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		appointment.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-
-		appointment.ID = primitive.NewObjectID()
-		appointment.Appointment_id = appointment.ID.Hex()
-
-		result, insertErr := appointmentCollection.InsertOne(ctx, appointment, nil)
-
-		if insertErr != nil {
-			msg := fmt.Sprintf("appointment was not created")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		result, ok := createAppointment(ctx, c, &appointment)
+		if !ok {
 			return
 		}
 
-		defer cancel()
 		c.JSON(http.StatusOK, result)
 	}
 }
 
+// createAppointment applies the appointment's type, books it and confirms it
+// to the patient. Every request that creates an appointment on a patient's
+// behalf goes through here; on failure the response has been written.
+func createAppointment(ctx context.Context, c *gin.Context, appointment *models.Appointment) (*mongo.InsertOneResult, bool) {
+	result, conflict, status, msg := bookNewAppointment(ctx, appointment)
+	if conflict != nil {
+		c.JSON(status, gin.H{"error": msg, "conflict": conflict})
+		return nil, false
+	}
+	if msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return nil, false
+	}
+	return result, true
+}
+
+// bookNewAppointment applies the appointment's type, books it and sends the
+// patient's confirmation and the S12. The REST and FHIR endpoints, series
+// expansion and queue serving all book through it. A non-empty message is
+// the problem, with its status and, for a clash, the conflict.
+func bookNewAppointment(ctx context.Context, appointment *models.Appointment) (*mongo.InsertOneResult, *bookingConflict, int, string) {
+	problem, err := applyAppointmentType(ctx, appointment)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, "error occured while reading the appointment type"
+	}
+	if problem != "" {
+		return nil, nil, http.StatusBadRequest, problem
+	}
+
+	result, conflict, insertErr := bookAppointment(ctx, appointment)
+
+	if conflict != nil {
+		return nil, conflict, http.StatusConflict, conflict.Message
+	}
+	if insertErr != nil {
+		msg := fmt.Sprintf("appointment was not created")
+		return nil, nil, http.StatusInternalServerError, msg
+	}
+
+	sendAppointmentConfirmation(ctx, *appointment)
+	queueAppointmentMessage(ctx, *appointment, "S12")
+	return result, nil, 0, ""
+}
+
+// UpdateAppointment reassigns an appointment to another doctor. The new
+// doctor is checked for leave and clashes as any move is, through
+// rescheduleAppointment.
 func UpdateAppointment() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		var doctor models.Doctor
 		var appointment models.Appointment
-
-		var updateObj primitive.D
+		var change models.Appointment
 
 		appoinmentId := c.Param("appointment_id")
-		if err := c.BindJSON(&appointment); err != nil {
+		if err := c.BindJSON(&change); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		if appointment.Doctor_id != nil {
-			err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": appointment.Doctor_id}).Decode(&doctor)
-			defer cancel()
+		filter := bson.M{"appointment_id": appoinmentId}
+		if err := appointmentCollection.FindOne(ctx, filter).Decode(&appointment); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message:Appointment was not found"})
+			return
+		}
+
+		if change.Doctor_id != nil && (appointment.Doctor_id == nil || *change.Doctor_id != *appointment.Doctor_id) {
+			if appointment.Status != nil && *appointment.Status == appointmentCancelled {
+				c.JSON(http.StatusConflict, gin.H{"error": "a cancelled appointment cannot be changed"})
+				return
+			}
+			err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": change.Doctor_id}).Decode(&doctor)
+			if err != nil {
+				msg := fmt.Sprintf("message:Doctor not found")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				return
+			}
+
+			conflict, err := rescheduleAppointment(ctx, appointment, appointment.Appointment_Date, appointmentMinutes(appointment), *change.Doctor_id, nil)
 			if err != nil {
-				msg := fmt.Sprintf("message:Appointment was not found")
+				msg := fmt.Sprintf("appointment update failed")
 				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 				return
 			}
-			updateObj = append(updateObj, bson.E{"appointment", appointment.Doctor_id})
+			if conflict != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": conflict.Message, "conflict": conflict})
+				return
+			}
+			if err := appointmentCollection.FindOne(ctx, filter).Decode(&appointment); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the appointment"})
+				return
+			}
 		}
 
-		appointment.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		updateObj = append(updateObj, bson.E{"updated_at", appointment.Updated_at})
+		c.JSON(http.StatusOK, appointment)
+	}
+}
 
-		upsert := true
+const defaultAppointmentMinutes = 30
 
-		filter := bson.M{"appointment_id": appoinmentId}
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+// bookingMu serialises the check-then-insert of bookings, so two requests
+// cannot both take the last free slot of a doctor or resource.
+var bookingMu sync.Mutex
+
+// bookingConflict explains why an appointment could not be booked.
+type bookingConflict struct {
+	Message        string   `json:"message"`
+	Appointment_id string   `json:"Appointment_id,omitempty"`
+	Resource_ids   []string `json:"resource_ids,omitempty"`
+}
+
+// bookAppointment reserves the doctor and every requested resource for the
+// appointment's time together: either all are free and the appointment is
+// stored, or nothing is and the conflict is returned.
+func bookAppointment(ctx context.Context, appointment *models.Appointment) (*mongo.InsertOneResult, *bookingConflict, error) {
+	bookingMu.Lock()
+	defer bookingMu.Unlock()
+
+	minutes := occupiedMinutes(*appointment)
+	end := appointment.Appointment_Date.Add(time.Duration(minutes) * time.Minute)
+
+	reason, err := unavailableReason(ctx, appointment.Doctor_id, appointment.Appointment_Date, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	if reason != "" {
+		return nil, &bookingConflict{Message: reason}, nil
+	}
+
+	if appointment.Doctor_id != nil {
+		clash, err := findDoctorConflict(ctx, *appointment.Doctor_id, appointment.Appointment_Date, minutes)
+		if err != nil {
+			return nil, nil, err
+		}
+		if clash != nil {
+			return nil, &bookingConflict{Message: "the doctor already has an appointment at this time", Appointment_id: clash.Appointment_id}, nil
+		}
+	}
+
+	if len(appointment.Resource_ids) > 0 {
+		unavailable, err := unavailableResources(ctx, appointment.Resource_ids, appointment.Appointment_Date, minutes)
+		if err != nil {
+			return nil, nil, err
 		}
+		if len(unavailable) > 0 {
+			return nil, &bookingConflict{Message: "some resources are not available at this time", Resource_ids: unavailable}, nil
+		}
+	}
+
+	result, err := insertAppointment(ctx, appointment)
+	return result, nil, err
+}
+
+// rescheduleAppointment moves an existing appointment, holding its resources,
+// under the same all-or-nothing rule as bookAppointment. minutes is the new
+// duration, the buffer after it is kept. extra is merged into the update.
+// The scheduling system is told of the move (S13).
+func rescheduleAppointment(ctx context.Context, appointment models.Appointment, start time.Time, minutes int, doctorId string, extra bson.M) (*bookingConflict, error) {
+	bookingMu.Lock()
+	defer bookingMu.Unlock()
+
+	conflict, err := moveConflict(ctx, appointment, start, minutes, doctorId, appointment.Appointment_id)
+	if err != nil || conflict != nil {
+		return conflict, err
+	}
+	return nil, moveAppointment(ctx, appointment, start, minutes, doctorId, extra)
+}
 
-		result, err := appointmentCollection.UpdateOne(
-			ctx,
-			filter,
-			bson.D{
-				{"$st", updateObj},
-			},
-			&opt,
-		)
+// moveConflict checks whether the appointment could move to start for minutes
+// with doctorId, ignoring the appointments in excludeIds. The caller holds
+// bookingMu.
+func moveConflict(ctx context.Context, appointment models.Appointment, start time.Time, minutes int, doctorId string, excludeIds ...string) (*bookingConflict, error) {
+	minutes += appointment.Buffer_minutes
 
+	reason, err := unavailableReason(ctx, &doctorId, start, start.Add(time.Duration(minutes)*time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		return &bookingConflict{Message: reason}, nil
+	}
+
+	clash, err := findDoctorConflict(ctx, doctorId, start, minutes, excludeIds...)
+	if err != nil {
+		return nil, err
+	}
+	if clash != nil {
+		return &bookingConflict{Message: "the doctor already has an appointment at this time", Appointment_id: clash.Appointment_id}, nil
+	}
+
+	if len(appointment.Resource_ids) > 0 {
+		unavailable, err := unavailableResources(ctx, appointment.Resource_ids, start, minutes, excludeIds...)
 		if err != nil {
-			msg := fmt.Sprintf("appointment update failed")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
+			return nil, err
 		}
+		if len(unavailable) > 0 {
+			return &bookingConflict{Message: "some resources are not available at this time", Resource_ids: unavailable}, nil
+		}
+	}
+	return nil, nil
+}
 
-		defer cancel()
-		c.JSON(http.StatusOK, result)
+// moveAppointment writes a move checked by moveConflict and queues its S13.
+// The caller holds bookingMu.
+func moveAppointment(ctx context.Context, appointment models.Appointment, start time.Time, minutes int, doctorId string, extra bson.M) error {
+	update := bson.M{
+		"appointment_date": start.UTC(),
+		"duration_minutes": minutes,
+		"doctor_id":        doctorId,
+		"updated_at":       helper.Now(),
+	}
+	for key, value := range extra {
+		update[key] = value
+	}
+
+	_, err := appointmentCollection.UpdateOne(ctx, bson.M{"appointment_id": appointment.Appointment_id}, bson.M{"$set": update})
+	if err != nil {
+		return err
+	}
+
+	var moved models.Appointment
+	if err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": appointment.Appointment_id}).Decode(&moved); err == nil {
+		queueAppointmentMessage(ctx, moved, "S13")
+	}
+	return nil
+}
+
+// queueCancellationMessages tells the scheduling system about appointments
+// that were just cancelled. Every path that cancels appointments calls it.
+func queueCancellationMessages(ctx context.Context, appointmentIds []string) {
+	if len(appointmentIds) == 0 {
+		return
+	}
+	cursor, err := appointmentCollection.Find(ctx, bson.M{"appointment_id": bson.M{"$in": appointmentIds}})
+	if err != nil {
+		log.Printf("hl7: %v", err)
+		return
 	}
+	var cancelled []models.Appointment
+	if err = cursor.All(ctx, &cancelled); err != nil {
+		log.Printf("hl7: %v", err)
+		return
+	}
+	for _, appointment := range cancelled {
+		queueAppointmentMessage(ctx, appointment, "S15")
+	}
+}
+
+const appointmentScheduled = "SCHEDULED"
+const appointmentCancelled = "CANCELLED"
+
+// insertAppointment fills in the bookkeeping fields of a new appointment and
+// stores it. Every path that books an appointment goes through here.
+func insertAppointment(ctx context.Context, appointment *models.Appointment) (*mongo.InsertOneResult, error) {
+	appointment.Created_at = helper.Now()
+	appointment.Updated_at = helper.Now()
+	appointment.Appointment_Date = appointment.Appointment_Date.UTC()
+	// the consultation room is opened separately, never with the booking
+	appointment.Teleconsult = nil
+
+	if appointment.Duration_minutes == 0 {
+		appointment.Duration_minutes = defaultAppointmentMinutes
+	}
+	if appointment.Status == nil {
+		status := appointmentScheduled
+		appointment.Status = &status
+	}
+
+	appointment.ID = primitive.NewObjectID()
+	appointment.Appointment_id = appointment.ID.Hex()
+
+	return appointmentCollection.InsertOne(ctx, appointment)
+}
+
+func appointmentMinutes(appointment models.Appointment) int {
+	if appointment.Duration_minutes == 0 {
+		return defaultAppointmentMinutes
+	}
+	return appointment.Duration_minutes
+}
+
+// occupiedMinutes is how long the appointment keeps its doctor and resources
+// busy: the visit itself and the buffer after it.
+func occupiedMinutes(appointment models.Appointment) int {
+	return appointmentMinutes(appointment) + appointment.Buffer_minutes
+}
+
+// findDoctorConflict returns a scheduled appointment of the doctor that
+// overlaps [start, start+minutes), ignoring excludeIds. It returns nil when
+// the slot is free.
+func findDoctorConflict(ctx context.Context, doctorId string, start time.Time, minutes int, excludeIds ...string) (*models.Appointment, error) {
+	end := start.Add(time.Duration(minutes) * time.Minute)
+
+	filter := bson.M{
+		"doctor_id": doctorId,
+		"status":    bson.M{"$ne": appointmentCancelled},
+		"appointment_date": bson.M{
+			"$gt": start.Add(-24 * time.Hour),
+			"$lt": end,
+		},
+	}
+	if len(excludeIds) > 0 {
+		filter["appointment_id"] = bson.M{"$nin": excludeIds}
+	}
+
+	cursor, err := appointmentCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []models.Appointment
+	if err = cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	for _, other := range candidates {
+		otherEnd := other.Appointment_Date.Add(time.Duration(occupiedMinutes(other)) * time.Minute)
+		if other.Appointment_Date.Before(end) && otherEnd.After(start) {
+			return &other, nil
+		}
+	}
+	return nil, nil
+}
+
+// localizeAppointment adds the facility-local rendering of the appointment
+// time next to the stored UTC instant.
+func localizeAppointment(appointment *models.Appointment) {
+	appointment.Date_local = helper.FormatLocal(appointment.Appointment_Date)
+	appointment.Time_zone = helper.FacilityLocation().String()
 }
@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// feeds cover this much history so recent cancellations still reach calendars
+const calendarFeedHistory = 90 * 24 * time.Hour
+
+const icsContentType = "text/calendar; charset=utf-8"
+
+func CreateDoctorCalendarToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		doctorId := c.Param("doctor_id")
+		issueCalendarToken(c, doctorCollection, bson.M{"doctor_id": doctorId}, "/doctors/"+doctorId+"/calendar.ics")
+	}
+}
+
+func CreatePatientCalendarToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		patientId := c.Param("patient_id")
+		issueCalendarToken(c, patientCollection, bson.M{"patient_id": patientId}, "/patients/"+patientId+"/calendar.ics")
+	}
+}
+
+// issueCalendarToken replaces the feed token, which also revokes any
+// previously shared subscription URL.
+func issueCalendarToken(c *gin.Context, collection *mongo.Collection, filter bson.M, path string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	token, err := helper.NewFeedToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "calendar token was not created"})
+		return
+	}
+
+	updatedAt := helper.Now()
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"calendar_token": token, "updated_at": updatedAt}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "calendar token was not created"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"calendar_url": path + "?token=" + token})
+}
+
+func GetDoctorCalendar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		doctorId := c.Param("doctor_id")
+		var doctor models.Doctor
+
+		err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": doctorId}).Decode(&doctor)
+		if err != nil || !validFeedToken(doctor.Calendar_token, c.Query("token")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "calendar not found"})
+			return
+		}
+
+		appointments, err := feedAppointments(ctx, bson.M{"doctor_id": doctorId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
+			return
+		}
+
+		var events []helper.CalendarEvent
+		for _, appointment := range appointments {
+			events = append(events, appointmentEvent(appointment, "Patient appointment"))
+		}
+
+		c.Data(http.StatusOK, icsContentType, helper.BuildCalendar(*doctor.Name, "PUBLISH", events))
+	}
+}
+
+func GetPatientCalendar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		patientId := c.Param("patient_id")
+		var patient models.Patient
+
+		err := patientCollection.FindOne(ctx, bson.M{"patient_id": patientId}).Decode(&patient)
+		if err != nil || !validFeedToken(patient.Calendar_token, c.Query("token")) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "calendar not found"})
+			return
+		}
+
+		appointments, err := feedAppointments(ctx, bson.M{"patient_id": patientId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
+			return
+		}
+
+		names := doctorNames(ctx, appointments)
+		var events []helper.CalendarEvent
+		for _, appointment := range appointments {
+			events = append(events, appointmentEvent(appointment, "Appointment with "+names[doctorIdOf(appointment)]))
+		}
+
+		c.Data(http.StatusOK, icsContentType, helper.BuildCalendar("My appointments", "PUBLISH", events))
+	}
+}
+
+func GetAppointmentICS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		appointmentId := c.Param("appointment_id")
+		var appointment models.Appointment
+
+		err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": appointmentId}).Decode(&appointment)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "appointment not found"})
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="appointment-`+appointmentId+`.ics"`)
+		c.Data(http.StatusOK, icsContentType, appointmentCalendar(ctx, appointment))
+	}
+}
+
+func validFeedToken(stored *string, given string) bool {
+	return stored != nil && given != "" && subtle.ConstantTimeCompare([]byte(*stored), []byte(given)) == 1
+}
+
+func feedAppointments(ctx context.Context, filter bson.M) ([]models.Appointment, error) {
+	filter["appointment_date"] = bson.M{"$gte": time.Now().Add(-calendarFeedHistory)}
+
+	opts := options.Find().SetSort(bson.M{"appointment_date": 1})
+	cursor, err := appointmentCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var appointments []models.Appointment
+	err = cursor.All(ctx, &appointments)
+	return appointments, err
+}
+
+func doctorIdOf(appointment models.Appointment) string {
+	if appointment.Doctor_id == nil {
+		return ""
+	}
+	return *appointment.Doctor_id
+}
+
+// doctorNames maps the doctor ids used by the appointments to display names.
+func doctorNames(ctx context.Context, appointments []models.Appointment) map[string]string {
+	names := map[string]string{"": "your doctor"}
+	for _, appointment := range appointments {
+		id := doctorIdOf(appointment)
+		if _, ok := names[id]; ok {
+			continue
+		}
+		names[id] = "your doctor"
+
+		var doctor models.Doctor
+		if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": id}).Decode(&doctor); err == nil && doctor.Name != nil {
+			names[id] = *doctor.Name
+		}
+	}
+	return names
+}
+
+func appointmentEvent(appointment models.Appointment, summary string) helper.CalendarEvent {
+	event := helper.CalendarEvent{
+		UID:         appointment.Appointment_id + "@golang-hospital-management",
+		Summary:     summary,
+		Description: "Appointment reference: " + appointment.Appointment_id,
+		Start:       appointment.Appointment_Date,
+		End:         appointment.Appointment_Date.Add(time.Duration(appointmentMinutes(appointment)) * time.Minute),
+		Modified:    appointment.Updated_at,
+	}
+	if appointment.Status != nil && *appointment.Status == appointmentCancelled {
+		event.Cancelled = true
+		// calendar clients only apply a cancellation with a higher sequence
+		event.Sequence = 1
+	}
+	return event
+}
+
+func appointmentCalendar(ctx context.Context, appointment models.Appointment) []byte {
+	names := doctorNames(ctx, []models.Appointment{appointment})
+	event := appointmentEvent(appointment, "Appointment with "+names[doctorIdOf(appointment)])
+	return helper.BuildCalendar("", "PUBLISH", []helper.CalendarEvent{event})
+}
+
+// sendAppointmentConfirmation queues the confirmation message for a newly
+// booked appointment with its .ics file attached.
+func sendAppointmentConfirmation(ctx context.Context, appointment models.Appointment) {
+	if appointment.Patient_id == nil {
+		return
+	}
+
+	var patient models.Patient
+	if err := patientCollection.FindOne(ctx, bson.M{"patient_id": appointment.Patient_id}).Decode(&patient); err != nil {
+		log.Printf("notifications: patient %s of appointment %s not found", *appointment.Patient_id, appointment.Appointment_id)
+		return
+	}
+
+	names := doctorNames(ctx, []models.Appointment{appointment})
+	data := helper.ConfirmationData{
+		Patient_name:   *patient.First_name,
+		Doctor_name:    names[doctorIdOf(appointment)],
+		Appointment_at: helper.InFacility(appointment.Appointment_Date).Format("Mon 2 Jan 2006 15:04 MST"),
+		Appointment_id: appointment.Appointment_id,
+	}
+	if appointmentType := appointmentTypeOf(ctx, appointment); appointmentType != nil {
+		data.Appointment_type = *appointmentType.Name
+		if appointmentType.Prep_instructions != nil {
+			data.Prep_instructions = *appointmentType.Prep_instructions
+		}
+	}
+	attachment := &helper.Attachment{
+		Name:         "appointment-" + appointment.Appointment_id + ".ics",
+		Content_type: "text/calendar; charset=utf-8; method=PUBLISH",
+		Content:      appointmentCalendar(ctx, appointment),
+	}
+	queuePatientMessage(ctx, patient, "confirmation", "", appointment, data, attachment)
+}
@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"context"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var diagnosisCollection *mongo.Collection = database.OpenCollection(database.Client, "diagnosis")
+
+const (
+	diagnosisActive         = "ACTIVE"
+	diagnosisResolved       = "RESOLVED"
+	diagnosisEnteredInError = "ENTERED_IN_ERROR"
+)
+
+// CreateDiagnosis records a coded diagnosis of a patient, at one of their
+// appointments when appointment_id is given. The code must exist in the
+// current version of its system, ICD10 by default, or in the version given,
+// and its display is copied from there.
+func CreateDiagnosis() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var diagnosis models.Diagnosis
+		if err := c.BindJSON(&diagnosis); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(diagnosis); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if diagnosis.System == nil {
+			system := helper.ICD10
+			diagnosis.System = &system
+		}
+
+		count, err := patientCollection.CountDocuments(ctx, bson.M{"patient_id": diagnosis.Patient_id})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "patient not found"})
+			return
+		}
+		if diagnosis.Appointment_id != nil {
+			var appointment models.Appointment
+			err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": diagnosis.Appointment_id}).Decode(&appointment)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "message:Appointment was not found"})
+				return
+			}
+			if appointment.Patient_id == nil || *appointment.Patient_id != *diagnosis.Patient_id {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the appointment is not the patient's"})
+				return
+			}
+		} else if diagnosis.Primary {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only the diagnosis of an appointment can be primary"})
+			return
+		}
+
+		concept, status, msg := findConcept(ctx, *diagnosis.System, diagnosis.Version, *diagnosis.Code)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		diagnosis.Code = &concept.Code
+		diagnosis.Version = concept.Version
+		diagnosis.Display = concept.Display
+
+		if diagnosis.Certainty == nil {
+			certainty := "CONFIRMED"
+			diagnosis.Certainty = &certainty
+		}
+		diagnosis.Status = diagnosisActive
+		diagnosis.Resolved_at = nil
+		diagnosis.Recorded_by = c.GetString("uid")
+		diagnosis.Created_at = helper.Now()
+		diagnosis.Updated_at = helper.Now()
+		diagnosis.ID = primitive.NewObjectID()
+		diagnosis.Diagnosis_id = diagnosis.ID.Hex()
+
+		if diagnosis.Primary {
+			if err := clearPrimaryDiagnosis(ctx, *diagnosis.Appointment_id, diagnosis.Diagnosis_id); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while updating the appointment's diagnoses"})
+				return
+			}
+		}
+		if _, insertErr := diagnosisCollection.InsertOne(ctx, diagnosis); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "diagnosis was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, diagnosis)
+	}
+}
+
+func GetDiagnosis() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var diagnosis models.Diagnosis
+		if err := diagnosisCollection.FindOne(ctx, bson.M{"diagnosis_id": c.Param("diagnosis_id")}).Decode(&diagnosis); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "diagnosis not found"})
+			return
+		}
+		c.JSON(http.StatusOK, diagnosis)
+	}
+}
+
+// UpdateDiagnosis edits a diagnosis. A new code is taken from the current
+// version of the diagnosis's system; an unchanged code keeps the version it
+// was recorded in. Diagnoses entered in error cannot change any more.
+func UpdateDiagnosis() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var change models.DiagnosisChange
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(change); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var diagnosis models.Diagnosis
+		if err := diagnosisCollection.FindOne(ctx, bson.M{"diagnosis_id": c.Param("diagnosis_id")}).Decode(&diagnosis); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "diagnosis not found"})
+			return
+		}
+		if diagnosis.Status == diagnosisEnteredInError {
+			c.JSON(http.StatusConflict, gin.H{"error": "a diagnosis entered in error cannot be changed"})
+			return
+		}
+
+		set := bson.M{}
+		if change.Code != nil {
+			code, _ := helper.NormalizeCode(*diagnosis.System, *change.Code)
+			if code != *diagnosis.Code {
+				concept, status, msg := findConcept(ctx, *diagnosis.System, "", code)
+				if msg != "" {
+					c.JSON(status, gin.H{"error": msg})
+					return
+				}
+				set["code"], set["version"], set["display"] = concept.Code, concept.Version, concept.Display
+			}
+		}
+		if change.Certainty != nil {
+			set["certainty"] = change.Certainty
+		}
+		if change.Onset_date != nil {
+			set["onset_date"] = change.Onset_date
+		}
+		if change.Notes != nil {
+			set["notes"] = change.Notes
+		}
+		if change.Status != nil && *change.Status != diagnosis.Status {
+			set["status"] = *change.Status
+			if *change.Status == diagnosisResolved {
+				set["resolved_at"] = helper.Now()
+			} else {
+				set["resolved_at"] = nil
+			}
+		}
+
+		primary := diagnosis.Primary
+		if change.Primary != nil {
+			primary = *change.Primary
+		}
+		if change.Status != nil && *change.Status == diagnosisEnteredInError {
+			primary = false
+		}
+		if primary && diagnosis.Appointment_id == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only the diagnosis of an appointment can be primary"})
+			return
+		}
+		if primary != diagnosis.Primary {
+			set["primary"] = primary
+			if primary {
+				if err := clearPrimaryDiagnosis(ctx, *diagnosis.Appointment_id, diagnosis.Diagnosis_id); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while updating the appointment's diagnoses"})
+					return
+				}
+			}
+		}
+		set["updated_at"] = helper.Now()
+
+		err := diagnosisCollection.FindOneAndUpdate(ctx,
+			bson.M{"diagnosis_id": diagnosis.Diagnosis_id},
+			bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&diagnosis)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "diagnosis update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, diagnosis)
+	}
+}
+
+// GetPatientDiagnoses lists a patient's diagnoses, newest first, without
+// those entered in error unless status asks for them.
+func GetPatientDiagnoses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := bson.M{"patient_id": c.Param("patient_id"), "status": bson.M{"$ne": diagnosisEnteredInError}}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		listDiagnoses(c, filter)
+	}
+}
+
+// GetAppointmentDiagnoses lists the diagnoses made at an appointment, the
+// primary one first.
+func GetAppointmentDiagnoses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listDiagnoses(c, bson.M{"appointment_id": c.Param("appointment_id"), "status": bson.M{"$ne": diagnosisEnteredInError}})
+	}
+}
+
+// GetPrescriptionDiagnoses lists the diagnoses a prescription treats.
+func GetPrescriptionDiagnoses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var prescription models.Prescription
+		if err := prescriptionCollection.FindOne(ctx, bson.M{"prescription_id": c.Param("prescription_id")}).Decode(&prescription); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "prescription not found"})
+			return
+		}
+		ids := prescription.Diagnosis_ids
+		if ids == nil {
+			ids = []string{}
+		}
+		listDiagnoses(c, bson.M{"diagnosis_id": bson.M{"$in": ids}})
+	}
+}
+
+func listDiagnoses(c *gin.Context, filter bson.M) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "primary", Value: -1}, {Key: "created_at", Value: -1}})
+	cursor, err := diagnosisCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the diagnoses"})
+		return
+	}
+	diagnoses := []models.Diagnosis{}
+	if err = cursor.All(ctx, &diagnoses); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the diagnoses"})
+		return
+	}
+	c.JSON(http.StatusOK, diagnoses)
+}
+
+// clearPrimaryDiagnosis unmarks the primary diagnosis of an appointment
+// before another one takes its place.
+func clearPrimaryDiagnosis(ctx context.Context, appointmentId string, diagnosisId string) error {
+	_, err := diagnosisCollection.UpdateMany(ctx,
+		bson.M{"appointment_id": appointmentId, "primary": true, "diagnosis_id": bson.M{"$ne": diagnosisId}},
+		bson.M{"$set": bson.M{"primary": false, "updated_at": helper.Now()}},
+	)
+	return err
+}
+
+// checkPrescriptionDiagnoses checks the diagnoses a prescription treats are
+// the patient's and still stand. A non-empty message is the problem.
+func checkPrescriptionDiagnoses(ctx context.Context, patientId string, diagnosisIds []string) (string, error) {
+	if len(diagnosisIds) == 0 {
+		return "", nil
+	}
+	cursor, err := diagnosisCollection.Find(ctx, bson.M{"diagnosis_id": bson.M{"$in": diagnosisIds}})
+	if err != nil {
+		return "", err
+	}
+	var diagnoses []models.Diagnosis
+	if err = cursor.All(ctx, &diagnoses); err != nil {
+		return "", err
+	}
+	found := map[string]models.Diagnosis{}
+	for _, diagnosis := range diagnoses {
+		found[diagnosis.Diagnosis_id] = diagnosis
+	}
+	for _, id := range diagnosisIds {
+		diagnosis, ok := found[id]
+		if !ok {
+			return "diagnosis " + id + " not found", nil
+		}
+		if *diagnosis.Patient_id != patientId {
+			return "diagnosis " + id + " is not the patient's", nil
+		}
+		if diagnosis.Status == diagnosisEnteredInError {
+			return "diagnosis " + id + " was entered in error", nil
+		}
+	}
+	return "", nil
+}
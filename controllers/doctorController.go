@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
 	"golang-hospital-management/models"
 	"log"
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -102,8 +104,8 @@ func CreateDoctor() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
-		doctor.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		doctor.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		doctor.Created_at = helper.Now()
+		doctor.Updated_at = helper.Now()
 		doctor.ID = primitive.NewObjectID()
 		doctor.Doctor_id = doctor.ID.Hex()
 
@@ -157,7 +159,7 @@ func UpdateDoctor() gin.HandlerFunc {
 
 		}
 
-		doctor.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		doctor.Updated_at = helper.Now()
 		updateObj = append(updateObj, bson.E{"updated_at", doctor.Updated_at})
 
 		upsert := true
@@ -184,3 +186,84 @@ func UpdateDoctor() gin.HandlerFunc {
 		c.JSON(http.StatusOK, result)
 	}
 }
+
+// DoctorLogin signs a doctor in with the credentials set by the
+// doctorcredentials command. The token it returns carries the DOCTOR role,
+// which is what lets a caller sign encounters, decide refills or join a
+// teleconsult as the doctor.
+func DoctorLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var login models.DoctorLogin
+		if err := c.BindJSON(&login); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(login); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var doctor models.Doctor
+		err := doctorCollection.FindOne(ctx, bson.M{"email": strings.ToLower(*login.Email)}).Decode(&doctor)
+		if err != nil || doctor.Password == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login or password is incorrect"})
+			return
+		}
+		if ok, msg := VerifyPassword(*login.Password, *doctor.Password); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		token, refreshToken, err := helper.GenerateAllTokens(*doctor.Email, *doctor.Name, "", doctor.Doctor_id, helper.RoleDoctor)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while signing in"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"doctor": doctor, "token": token, "refresh_token": refreshToken})
+	}
+}
+
+// SetDoctorCredentials gives a doctor the email and password they sign in
+// with. It is used by the doctorcredentials command, so only whoever runs
+// the service can make a doctor account.
+func SetDoctorCredentials(ctx context.Context, doctorId string, email string, password string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if err := validate.Var(email, "required,email"); err != nil {
+		return fmt.Errorf("invalid email: %v", err)
+	}
+	if len(password) < 8 {
+		return fmt.Errorf("the password needs at least 8 characters")
+	}
+
+	count, err := doctorCollection.CountDocuments(ctx, bson.M{"email": email, "doctor_id": bson.M{"$ne": doctorId}})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("another doctor signs in with %s", email)
+	}
+
+	result, err := doctorCollection.UpdateOne(ctx,
+		bson.M{"doctor_id": doctorId},
+		bson.M{"$set": bson.M{"email": email, "password": HashPassword(password), "updated_at": helper.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("doctor %s not found", doctorId)
+	}
+	return nil
+}
+
+// signedInDoctor is the doctor_id of the caller when they signed in through
+// DoctorLogin. Patients' tokens never name a doctor.
+func signedInDoctor(c *gin.Context) (string, bool) {
+	if c.GetString("role") != helper.RoleDoctor {
+		return "", false
+	}
+	return c.GetString("uid"), true
+}
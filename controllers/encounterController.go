@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var encounterCollection *mongo.Collection = database.OpenCollection(database.Client, "encounter")
+
+const (
+	encounterDraft  = "DRAFT"
+	encounterSigned = "SIGNED"
+)
+
+// EncounterDetail is an encounter with the prescriptions written from it.
+type EncounterDetail struct {
+	models.Encounter
+	Prescriptions []models.Prescription `json:"prescriptions"`
+}
+
+// CreateEncounter opens the draft note of an appointment, for the
+// appointment's doctor and patient. An appointment has one encounter.
+func CreateEncounter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var encounter models.Encounter
+		if err := c.BindJSON(&encounter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(encounter); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if msg := checkDiagnoses(encounter.Diagnoses); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		var appointment models.Appointment
+		if err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": encounter.Appointment_id}).Decode(&appointment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message:Appointment was not found"})
+			return
+		}
+		if appointment.Doctor_id == nil || appointment.Patient_id == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "an encounter needs both a doctor and a patient on the appointment"})
+			return
+		}
+		if appointment.Status != nil && *appointment.Status == appointmentCancelled {
+			c.JSON(http.StatusConflict, gin.H{"error": "the appointment is cancelled"})
+			return
+		}
+
+		count, err := encounterCollection.CountDocuments(ctx, bson.M{"appointment_id": encounter.Appointment_id})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the appointment"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the appointment already has an encounter"})
+			return
+		}
+
+		numberOrders(encounter.Orders)
+		encounter.Patient_id = *appointment.Patient_id
+		encounter.Doctor_id = *appointment.Doctor_id
+		encounter.Status = encounterDraft
+		encounter.Signed_by, encounter.Signed_at = nil, nil
+		encounter.Addenda = []models.EncounterAddendum{}
+		encounter.Created_at = helper.Now()
+		encounter.Updated_at = helper.Now()
+		encounter.ID = primitive.NewObjectID()
+		encounter.Encounter_id = encounter.ID.Hex()
+
+		if _, insertErr := encounterCollection.InsertOne(ctx, encounter); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "encounter was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, encounter)
+	}
+}
+
+// GetEncounter returns the encounter with the prescriptions written from it.
+func GetEncounter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var encounter models.Encounter
+		if err := encounterCollection.FindOne(ctx, bson.M{"encounter_id": c.Param("encounter_id")}).Decode(&encounter); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "encounter not found"})
+			return
+		}
+
+		cursor, err := prescriptionCollection.Find(ctx, bson.M{"encounter_id": encounter.Encounter_id}, options.Find().SetSort(bson.M{"created_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the prescription"})
+			return
+		}
+		detail := EncounterDetail{Encounter: encounter, Prescriptions: []models.Prescription{}}
+		if err = cursor.All(ctx, &detail.Prescriptions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the prescription"})
+			return
+		}
+		for i := range detail.Prescriptions {
+			normalizePrescription(&detail.Prescriptions[i])
+		}
+		c.JSON(http.StatusOK, detail)
+	}
+}
+
+// GetPatientEncounters is the patient's encounter history, newest first,
+// filtered by doctor_id and status.
+func GetPatientEncounters() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"patient_id": c.Param("patient_id")}
+		for _, key := range []string{"doctor_id", "status"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		cursor, err := encounterCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the encounters"})
+			return
+		}
+		encounters := []models.Encounter{}
+		if err = cursor.All(ctx, &encounters); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the encounters"})
+			return
+		}
+		c.JSON(http.StatusOK, encounters)
+	}
+}
+
+// UpdateEncounter edits a draft as its doctor. Signed encounters are refused.
+func UpdateEncounter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var change models.EncounterChange
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(change); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if msg := checkDiagnoses(change.Diagnoses); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		var encounter models.Encounter
+		if err := encounterCollection.FindOne(ctx, bson.M{"encounter_id": c.Param("encounter_id")}).Decode(&encounter); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "encounter not found"})
+			return
+		}
+		if doctorId, ok := signedInDoctor(c); !ok || doctorId != encounter.Doctor_id {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the encounter's doctor can edit it"})
+			return
+		}
+
+		set := bson.M{}
+		if change.Chief_complaint != nil {
+			set["chief_complaint"] = change.Chief_complaint
+		}
+		if change.Subjective != nil {
+			set["subjective"] = change.Subjective
+		}
+		if change.Objective != nil {
+			set["objective"] = change.Objective
+		}
+		if change.Assessment != nil {
+			set["assessment"] = change.Assessment
+		}
+		if change.Plan != nil {
+			set["plan"] = change.Plan
+		}
+		if change.Diagnoses != nil {
+			set["diagnoses"] = change.Diagnoses
+		}
+		if change.Orders != nil {
+			numberOrders(change.Orders)
+			set["orders"] = change.Orders
+		}
+		moveEncounter(c, set)
+	}
+}
+
+// SignEncounter signs the draft as the signed-in doctor, who must be the
+// encounter's doctor. After that it is read-only.
+func SignEncounter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		doctorId, ok := signedInDoctor(c)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "sign in as a doctor to sign an encounter"})
+			return
+		}
+
+		var encounter models.Encounter
+		if err := encounterCollection.FindOne(ctx, bson.M{"encounter_id": c.Param("encounter_id")}).Decode(&encounter); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "encounter not found"})
+			return
+		}
+		if doctorId != encounter.Doctor_id {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the encounter's doctor can sign it"})
+			return
+		}
+		if encounter.Chief_complaint == nil || *encounter.Chief_complaint == "" || encounter.Assessment == nil || *encounter.Assessment == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "an encounter needs a chief complaint and an assessment to be signed"})
+			return
+		}
+
+		moveEncounter(c, bson.M{"status": encounterSigned, "signed_by": doctorId, "signed_at": helper.Now()})
+	}
+}
+
+// AddEncounterAddendum adds a note by the signed-in doctor to a signed
+// encounter; drafts are edited instead.
+func AddEncounterAddendum() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		doctorId, ok := signedInDoctor(c)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "sign in as a doctor to add an addendum"})
+			return
+		}
+
+		var addendum models.EncounterAddendum
+		if err := c.BindJSON(&addendum); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(addendum); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		addendum.Author_id = &doctorId
+		addendum.Addendum_id = primitive.NewObjectID().Hex()
+		addendum.Created_at = helper.Now()
+
+		var encounter models.Encounter
+		err := encounterCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"encounter_id": c.Param("encounter_id"), "status": encounterSigned},
+			bson.M{"$push": bson.M{"addenda": addendum}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&encounter)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "encounter not found or not signed; edit a draft instead"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "addendum was not added"})
+			return
+		}
+		c.JSON(http.StatusOK, encounter)
+	}
+}
+
+// moveEncounter applies set to the encounter while it is a draft and writes
+// the response.
+func moveEncounter(c *gin.Context, set bson.M) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	set["updated_at"] = helper.Now()
+
+	var encounter models.Encounter
+	err := encounterCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"encounter_id": c.Param("encounter_id"), "status": encounterDraft},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&encounter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusConflict, gin.H{"error": "encounter not found or already signed; add an addendum instead"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "encounter update failed"})
+		return
+	}
+	c.JSON(http.StatusOK, encounter)
+}
+
+func checkDiagnoses(diagnoses []models.EncounterDiagnosis) string {
+	primary := 0
+	for _, diagnosis := range diagnoses {
+		if diagnosis.Primary {
+			primary++
+		}
+	}
+	if primary > 1 {
+		return "only one diagnosis can be primary"
+	}
+	return ""
+}
+
+func numberOrders(orders []models.EncounterOrder) {
+	for i := range orders {
+		orders[i].Order_id = strconv.Itoa(i + 1)
+	}
+}
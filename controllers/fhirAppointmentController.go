@@ -0,0 +1,426 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fhirAppointmentStatuses maps appointment statuses to FHIR's.
+var fhirAppointmentStatuses = map[string]string{
+	appointmentScheduled: "booked",
+	appointmentCancelled: "cancelled",
+	"NEEDS_RESCHEDULE":   "pending",
+}
+
+// fhirResourceTypes maps a resource's kind to the FHIR type it is shown as.
+var fhirResourceTypes = map[string]string{"ROOM": "Location", "EQUIPMENT": "Device"}
+
+func ReadFhirAppointment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var appointment models.Appointment
+		if err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": c.Param("id")}).Decode(&appointment); err != nil {
+			fhirError(c, http.StatusNotFound, "Appointment/"+c.Param("id")+" not found")
+			return
+		}
+		kinds, err := resourceKinds(ctx, appointment.Resource_ids)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while reading the resources")
+			return
+		}
+		fhirJSON(c, http.StatusOK, fhirAppointment(appointment, kinds))
+	}
+}
+
+// SearchFhirAppointments searches appointments by _id, patient,
+// practitioner, date and status, earliest first.
+func SearchFhirAppointments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		count, offset, ok := fhirSearchPage(c, "Appointment")
+		if !ok {
+			return
+		}
+		conditions := []bson.M{}
+		if value := c.Query("_id"); value != "" {
+			conditions = append(conditions, bson.M{"appointment_id": bson.M{"$in": strings.Split(value, ",")}})
+		}
+		if value := c.Query("patient"); value != "" {
+			conditions = append(conditions, bson.M{"patient_id": fhirReferenceId(value, "Patient")})
+		}
+		if value := c.Query("practitioner"); value != "" {
+			conditions = append(conditions, bson.M{"doctor_id": fhirReferenceId(value, "Practitioner")})
+		}
+		if values := c.QueryArray("date"); len(values) > 0 {
+			condition, err := fhirDateFilter("appointment_date", values)
+			if err != nil {
+				fhirError(c, http.StatusBadRequest, "date: "+err.Error())
+				return
+			}
+			conditions = append(conditions, condition)
+		}
+		if value := c.Query("status"); value != "" {
+			conditions = append(conditions, fhirAnyOf("status", value, fhirAppointmentStatuses))
+		}
+
+		filter := bson.M{}
+		if len(conditions) > 0 {
+			filter["$and"] = conditions
+		}
+		total, err := appointmentCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the appointments")
+			return
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "appointment_date", Value: 1}, {Key: "_id", Value: 1}}).SetSkip(offset).SetLimit(count)
+		cursor, err := appointmentCollection.Find(ctx, filter, opts)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the appointments")
+			return
+		}
+		var appointments []models.Appointment
+		if err = cursor.All(ctx, &appointments); err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the appointments")
+			return
+		}
+
+		resourceIds := []string{}
+		for _, appointment := range appointments {
+			resourceIds = append(resourceIds, appointment.Resource_ids...)
+		}
+		kinds, err := resourceKinds(ctx, resourceIds)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while reading the resources")
+			return
+		}
+		entries := []models.FhirBundleEntry{}
+		for _, appointment := range appointments {
+			entries = append(entries, fhirEntry(c, "Appointment", appointment.Appointment_id, fhirAppointment(appointment, kinds)))
+		}
+		fhirSearchset(c, total, count, offset, entries)
+	}
+}
+
+// CreateFhirAppointment books an appointment as CreateAppointment does. The
+// Patient, Practitioner, Location and Device participants are the patient,
+// doctor and resources; the invoice is referenced in supportingInformation.
+func CreateFhirAppointment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirAppointment
+		if !fhirBind(c, &resource, "Appointment", "") {
+			return
+		}
+		if resource.Status != "" && resource.Status != "booked" && resource.Status != "proposed" && resource.Status != "pending" {
+			fhirError(c, http.StatusUnprocessableEntity, "new appointments are booked, proposed or pending")
+			return
+		}
+
+		var appointment models.Appointment
+		start, minutes, msg := fhirAppointmentTime(resource)
+		if msg != "" {
+			fhirError(c, http.StatusBadRequest, msg)
+			return
+		}
+		appointment.Appointment_Date = start
+		appointment.Duration_minutes = minutes
+		if resource.Appointment_type != nil && len(resource.Appointment_type.Coding) > 0 {
+			typeId := resource.Appointment_type.Coding[0].Code
+			appointment.Type_id = &typeId
+		}
+		patientId, doctorId, resourceIds, msg := fhirParticipants(resource)
+		if msg != "" {
+			fhirError(c, http.StatusBadRequest, msg)
+			return
+		}
+		appointment.Patient_id, appointment.Doctor_id, appointment.Resource_ids = patientId, doctorId, resourceIds
+		for _, reference := range resource.Supporting_information {
+			if invoiceId := fhirReference(&reference, "Invoice"); invoiceId != "" {
+				appointment.Invoice_id = &invoiceId
+			}
+		}
+
+		if validationErr := validate.Struct(appointment); validationErr != nil {
+			fhirError(c, http.StatusBadRequest, validationErr.Error())
+			return
+		}
+		if patientId != nil {
+			count, err := patientCollection.CountDocuments(ctx, bson.M{"patient_id": patientId})
+			if err != nil || count == 0 {
+				fhirError(c, http.StatusBadRequest, "Patient/"+*patientId+" not found")
+				return
+			}
+		}
+		if doctorId != nil {
+			count, err := doctorCollection.CountDocuments(ctx, bson.M{"doctor_id": doctorId})
+			if err != nil || count == 0 {
+				fhirError(c, http.StatusBadRequest, "Practitioner/"+*doctorId+" not found")
+				return
+			}
+		}
+
+		if _, _, status, msg := bookNewAppointment(ctx, &appointment); msg != "" {
+			fhirError(c, status, msg)
+			return
+		}
+		kinds, _ := resourceKinds(ctx, appointment.Resource_ids)
+		fhirCreated(c, "Appointment", appointment.Appointment_id, fhirAppointment(appointment, kinds))
+	}
+}
+
+// UpdateFhirAppointment cancels an appointment, or moves it to another time,
+// length or doctor under the booking rules. The patient and resources of an
+// appointment are not changed here.
+func UpdateFhirAppointment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirAppointment
+		if !fhirBind(c, &resource, "Appointment", c.Param("id")) {
+			return
+		}
+		var appointment models.Appointment
+		if err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": c.Param("id")}).Decode(&appointment); err != nil {
+			fhirError(c, http.StatusNotFound, "Appointment/"+c.Param("id")+" not found")
+			return
+		}
+		current := appointmentScheduled
+		if appointment.Status != nil {
+			current = *appointment.Status
+		}
+
+		patientId, doctorId, resourceIds, msg := fhirParticipants(resource)
+		if msg != "" {
+			fhirError(c, http.StatusBadRequest, msg)
+			return
+		}
+		if patientId != nil && (appointment.Patient_id == nil || *patientId != *appointment.Patient_id) {
+			fhirError(c, http.StatusUnprocessableEntity, "the patient of an appointment cannot be changed")
+			return
+		}
+		if !sameIds(resourceIds, appointment.Resource_ids) {
+			fhirError(c, http.StatusUnprocessableEntity, "the resources of an appointment cannot be changed")
+			return
+		}
+
+		trigger := "S14"
+		switch {
+		case resource.Status == "cancelled":
+			if current == appointmentCancelled {
+				break
+			}
+			_, err := appointmentCollection.UpdateOne(ctx, bson.M{"appointment_id": appointment.Appointment_id}, bson.M{
+				"$set": bson.M{"status": appointmentCancelled, "updated_at": helper.Now()},
+			})
+			if err != nil {
+				fhirError(c, http.StatusInternalServerError, "appointment update failed")
+				return
+			}
+			trigger = "S15"
+		case current == appointmentCancelled:
+			fhirError(c, http.StatusUnprocessableEntity, "a cancelled appointment cannot be booked again")
+			return
+		default:
+			start, minutes, msg := fhirAppointmentTime(resource)
+			if msg != "" {
+				fhirError(c, http.StatusBadRequest, msg)
+				return
+			}
+			if doctorId == nil {
+				doctorId = appointment.Doctor_id
+			}
+			if doctorId == nil {
+				fhirError(c, http.StatusUnprocessableEntity, "a Practitioner participant is needed to move the appointment")
+				return
+			}
+			if start.Equal(appointment.Appointment_Date) && minutes == appointmentMinutes(appointment) &&
+				appointment.Doctor_id != nil && *doctorId == *appointment.Doctor_id {
+				trigger = ""
+				break
+			}
+			count, err := doctorCollection.CountDocuments(ctx, bson.M{"doctor_id": doctorId})
+			if err != nil || count == 0 {
+				fhirError(c, http.StatusBadRequest, "Practitioner/"+*doctorId+" not found")
+				return
+			}
+			conflict, err := rescheduleAppointment(ctx, appointment, start, minutes, *doctorId, nil)
+			if err != nil {
+				fhirError(c, http.StatusInternalServerError, "appointment update failed")
+				return
+			}
+			if conflict != nil {
+				fhirError(c, http.StatusConflict, conflict.Message)
+				return
+			}
+			// rescheduleAppointment has sent the S13
+			trigger = ""
+		}
+
+		if err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": appointment.Appointment_id}).Decode(&appointment); err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while reading the appointment")
+			return
+		}
+		if trigger != "" && current != appointmentCancelled {
+			queueAppointmentMessage(ctx, appointment, trigger)
+		}
+		kinds, err := resourceKinds(ctx, appointment.Resource_ids)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while reading the resources")
+			return
+		}
+		fhirJSON(c, http.StatusOK, fhirAppointment(appointment, kinds))
+	}
+}
+
+func fhirAppointment(appointment models.Appointment, kinds map[string]string) models.FhirAppointment {
+	minutes := appointmentMinutes(appointment)
+	status := appointmentScheduled
+	if appointment.Status != nil {
+		status = *appointment.Status
+	}
+	resource := models.FhirAppointment{
+		Resource_type:    "Appointment",
+		Id:               appointment.Appointment_id,
+		Meta:             fhirMeta(appointment.Updated_at),
+		Identifier:       []models.FhirIdentifier{{Use: "usual", System: fhirSystemBase + "appointment", Value: appointment.Appointment_id}},
+		Status:           fhirAppointmentStatuses[status],
+		Start:            helper.FhirInstant(appointment.Appointment_Date),
+		End:              helper.FhirInstant(appointment.Appointment_Date.Add(time.Duration(minutes) * time.Minute)),
+		Minutes_duration: minutes,
+		Created:          helper.FhirInstant(appointment.Created_at),
+		Participant:      []models.FhirAppointmentParticipant{},
+	}
+	if appointment.Type_id != nil {
+		resource.Appointment_type = &models.FhirCodeableConcept{Coding: []models.FhirCoding{{System: fhirSystemBase + "appointment-type", Code: *appointment.Type_id}}}
+	}
+	if appointment.Invoice_id != nil {
+		resource.Supporting_information = []models.FhirReference{{Reference: "Invoice/" + *appointment.Invoice_id}}
+	}
+	if appointment.Patient_id != nil {
+		resource.Participant = append(resource.Participant, models.FhirAppointmentParticipant{
+			Actor: models.FhirReference{Reference: "Patient/" + *appointment.Patient_id}, Status: "accepted",
+		})
+	}
+	if appointment.Doctor_id != nil {
+		resource.Participant = append(resource.Participant, models.FhirAppointmentParticipant{
+			Actor: models.FhirReference{Reference: "Practitioner/" + *appointment.Doctor_id}, Status: "accepted",
+		})
+	}
+	for _, resourceId := range appointment.Resource_ids {
+		resourceType, ok := fhirResourceTypes[kinds[resourceId]]
+		if !ok {
+			resourceType = "Location"
+		}
+		resource.Participant = append(resource.Participant, models.FhirAppointmentParticipant{
+			Actor: models.FhirReference{Reference: resourceType + "/" + resourceId}, Status: "accepted",
+		})
+	}
+	return resource
+}
+
+// fhirAppointmentTime reads the start and the length, from minutesDuration
+// or else the end.
+func fhirAppointmentTime(resource models.FhirAppointment) (time.Time, int, string) {
+	if resource.Start == "" {
+		return time.Time{}, 0, "start is required"
+	}
+	start, err := fhirTime(resource.Start)
+	if err != nil {
+		return time.Time{}, 0, "start: " + err.Error()
+	}
+	minutes := resource.Minutes_duration
+	if minutes == 0 && resource.End != "" {
+		end, err := fhirTime(resource.End)
+		if err != nil {
+			return time.Time{}, 0, "end: " + err.Error()
+		}
+		minutes = int(end.Sub(start) / time.Minute)
+		if minutes <= 0 {
+			return time.Time{}, 0, "end must be after start"
+		}
+	}
+	if minutes == 0 {
+		minutes = defaultAppointmentMinutes
+	}
+	return start, minutes, ""
+}
+
+// fhirParticipants reads the patient, doctor and resources of the
+// participants.
+func fhirParticipants(resource models.FhirAppointment) (*string, *string, []string, string) {
+	var patientId, doctorId *string
+	resourceIds := []string{}
+	for _, participant := range resource.Participant {
+		parts := strings.Split(participant.Actor.Reference, "/")
+		if len(parts) < 2 {
+			return nil, nil, nil, fmt.Sprintf("participant reference %q is not Type/id", participant.Actor.Reference)
+		}
+		id := parts[len(parts)-1]
+		switch parts[len(parts)-2] {
+		case "Patient":
+			patientId = &id
+		case "Practitioner":
+			doctorId = &id
+		case "Location", "Device":
+			resourceIds = append(resourceIds, id)
+		default:
+			return nil, nil, nil, fmt.Sprintf("%s participants are not supported", parts[len(parts)-2])
+		}
+	}
+	return patientId, doctorId, resourceIds, ""
+}
+
+// resourceKinds maps resource ids to their kind.
+func resourceKinds(ctx context.Context, resourceIds []string) (map[string]string, error) {
+	kinds := map[string]string{}
+	if len(resourceIds) == 0 {
+		return kinds, nil
+	}
+	cursor, err := resourceCollection.Find(ctx, bson.M{"resource_id": bson.M{"$in": resourceIds}})
+	if err != nil {
+		return nil, err
+	}
+	var resources []models.Resource
+	if err = cursor.All(ctx, &resources); err != nil {
+		return nil, err
+	}
+	for _, resource := range resources {
+		if resource.Kind != nil {
+			kinds[resource.Resource_id] = *resource.Kind
+		}
+	}
+	return kinds, nil
+}
+
+// sameIds reports whether two lists hold the same ids in any order.
+func sameIds(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,366 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const fhirContentType = "application/fhir+json; charset=utf-8"
+
+// fhirSystemBase prefixes the identifier and code systems of this server;
+// fhirExtensionBase the extensions it defines.
+const (
+	fhirSystemBase    = "urn:hms:"
+	fhirExtensionBase = "urn:hms:fhir:extension:"
+)
+
+const (
+	fhirDefaultCount = 50
+	fhirMaxCount     = 200
+)
+
+// fhirResultParams are accepted by every search besides its own parameters.
+var fhirResultParams = []string{"_count", "_offset", "_format"}
+
+// fhirResources are the resource types served, with their search
+// parameters. The CapabilityStatement is written from it and searches
+// reject parameters it does not list.
+var fhirResources = []models.FhirCapabilityResource{
+	{Type: "Patient", Search_param: []models.FhirSearchParam{
+		{Name: "_id", Type: "token"},
+		{Name: "name", Type: "string"},
+		{Name: "family", Type: "string"},
+		{Name: "given", Type: "string"},
+		{Name: "identifier", Type: "token"},
+		{Name: "birthdate", Type: "date"},
+		{Name: "email", Type: "token"},
+		{Name: "phone", Type: "token"},
+		{Name: "general-practitioner", Type: "reference"},
+	}},
+	{Type: "Practitioner", Search_param: []models.FhirSearchParam{
+		{Name: "_id", Type: "token"},
+		{Name: "name", Type: "string"},
+		{Name: "identifier", Type: "token"},
+	}},
+	{Type: "Appointment", Search_param: []models.FhirSearchParam{
+		{Name: "_id", Type: "token"},
+		{Name: "patient", Type: "reference"},
+		{Name: "practitioner", Type: "reference"},
+		{Name: "date", Type: "date"},
+		{Name: "status", Type: "token"},
+	}},
+	{Type: "MedicationRequest", Search_param: []models.FhirSearchParam{
+		{Name: "_id", Type: "token"},
+		{Name: "patient", Type: "reference"},
+		{Name: "requester", Type: "reference"},
+		{Name: "status", Type: "token"},
+		{Name: "authoredon", Type: "date"},
+		{Name: "code", Type: "token"},
+	}},
+	{Type: "Invoice", Search_param: []models.FhirSearchParam{
+		{Name: "_id", Type: "token"},
+		{Name: "patient", Type: "reference"},
+		{Name: "status", Type: "token"},
+		{Name: "date", Type: "date"},
+	}},
+}
+
+// FhirMetadata is the CapabilityStatement of the facade.
+func FhirMetadata() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resources := make([]models.FhirCapabilityResource, len(fhirResources))
+		for i, resource := range fhirResources {
+			resource.Interaction = []models.FhirInteraction{{Code: "read"}, {Code: "search-type"}, {Code: "create"}, {Code: "update"}}
+			if resource.Type == "Patient" {
+				resource.Operation = []models.FhirOperation{{Name: "export", Definition: fhirPatientExportDefinition}}
+			}
+			resources[i] = resource
+		}
+		fhirJSON(c, http.StatusOK, models.FhirCapabilityStatement{
+			Resource_type: "CapabilityStatement",
+			Status:        "active",
+			Date:          helper.FhirInstant(helper.Now()),
+			Kind:          "instance",
+			Software:      models.FhirSoftware{Name: "golang-hospital-management"},
+			Fhir_version:  "4.0.1",
+			Format:        []string{"json"},
+			Rest: []models.FhirCapabilityRest{{
+				Mode:      "server",
+				Resource:  resources,
+				Operation: []models.FhirOperation{{Name: "export", Definition: fhirExportDefinition}},
+			}},
+		})
+	}
+}
+
+func fhirJSON(c *gin.Context, status int, body interface{}) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		status, raw = http.StatusInternalServerError, []byte(`{"resourceType":"OperationOutcome","issue":[{"severity":"error","code":"exception"}]}`)
+	}
+	c.Data(status, fhirContentType, raw)
+}
+
+// fhirError writes an OperationOutcome for a failed request, its issue code
+// following the status.
+func fhirError(c *gin.Context, status int, msg string) {
+	code := "processing"
+	switch status {
+	case http.StatusBadRequest:
+		code = "invalid"
+	case http.StatusNotFound:
+		code = "not-found"
+	case http.StatusConflict:
+		code = "conflict"
+	case http.StatusUnprocessableEntity:
+		code = "business-rule"
+	case http.StatusTooManyRequests:
+		code = "throttled"
+	case http.StatusInternalServerError:
+		code = "exception"
+	}
+	fhirJSON(c, status, models.FhirOperationOutcome{
+		Resource_type: "OperationOutcome",
+		Issue:         []models.FhirIssue{{Severity: "error", Code: code, Diagnostics: msg}},
+	})
+}
+
+// fhirBase is the URL the facade is served under: FHIR_BASE_URL, or the
+// request's own host.
+func fhirBase(c *gin.Context) string {
+	if base := os.Getenv("FHIR_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + "/fhir"
+}
+
+func fhirURL(c *gin.Context, resourceType string, id string) string {
+	return fhirBase(c) + "/" + resourceType + "/" + id
+}
+
+// fhirCreated writes a created resource with its location.
+func fhirCreated(c *gin.Context, resourceType string, id string, resource interface{}) {
+	c.Header("Location", fhirURL(c, resourceType, id))
+	fhirJSON(c, http.StatusCreated, resource)
+}
+
+// fhirBind reads a resource from the body and checks its type, and on update
+// that its id is the one in the URL. On failure the response has been
+// written.
+func fhirBind(c *gin.Context, resource interface{}, resourceType string, id string) bool {
+	if err := c.ShouldBindJSON(resource); err != nil {
+		fhirError(c, http.StatusBadRequest, err.Error())
+		return false
+	}
+	var header struct {
+		Resource_type string `json:"resourceType"`
+		Id            string `json:"id"`
+	}
+	raw, _ := json.Marshal(resource)
+	json.Unmarshal(raw, &header)
+	if header.Resource_type != resourceType {
+		fhirError(c, http.StatusBadRequest, fmt.Sprintf("the body must be a %s resource", resourceType))
+		return false
+	}
+	if id != "" && header.Id != "" && header.Id != id {
+		fhirError(c, http.StatusBadRequest, "the id of the resource does not match the URL")
+		return false
+	}
+	return true
+}
+
+// fhirSearchPage checks the query against the resource's search parameters
+// and reads the page asked for. On failure the response has been written.
+func fhirSearchPage(c *gin.Context, resourceType string) (int64, int64, bool) {
+	known := map[string]bool{}
+	for _, name := range fhirResultParams {
+		known[name] = true
+	}
+	for _, resource := range fhirResources {
+		if resource.Type == resourceType {
+			for _, param := range resource.Search_param {
+				known[param.Name] = true
+			}
+		}
+	}
+	for name := range c.Request.URL.Query() {
+		// modifiers such as name:exact are not supported either
+		if !known[name] {
+			fhirError(c, http.StatusBadRequest, fmt.Sprintf("unknown search parameter %q", name))
+			return 0, 0, false
+		}
+	}
+
+	count, offset := int64(fhirDefaultCount), int64(0)
+	if value := c.Query("_count"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			fhirError(c, http.StatusBadRequest, "_count must be a number")
+			return 0, 0, false
+		}
+		count = parsed
+		if count > fhirMaxCount {
+			count = fhirMaxCount
+		}
+	}
+	if value := c.Query("_offset"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			fhirError(c, http.StatusBadRequest, "_offset must be a number")
+			return 0, 0, false
+		}
+		offset = parsed
+	}
+	return count, offset, true
+}
+
+// fhirSearchset writes a page of matches as a searchset Bundle with links to
+// this page and its neighbours.
+func fhirSearchset(c *gin.Context, total int64, count int64, offset int64, entries []models.FhirBundleEntry) {
+	link := func(relation string, offset int64) models.FhirBundleLink {
+		query := c.Request.URL.Query()
+		query.Set("_count", strconv.FormatInt(count, 10))
+		query.Set("_offset", strconv.FormatInt(offset, 10))
+		return models.FhirBundleLink{Relation: relation, Url: fhirBase(c) + strings.TrimPrefix(c.Request.URL.Path, "/fhir") + "?" + query.Encode()}
+	}
+	bundle := models.FhirBundle{
+		Resource_type: "Bundle",
+		Type:          "searchset",
+		Total:         total,
+		Link:          []models.FhirBundleLink{link("self", offset)},
+		Entry:         entries,
+	}
+	if count > 0 && offset+count < total {
+		bundle.Link = append(bundle.Link, link("next", offset+count))
+	}
+	if offset > 0 {
+		previous := offset - count
+		if previous < 0 {
+			previous = 0
+		}
+		bundle.Link = append(bundle.Link, link("previous", previous))
+	}
+	if bundle.Entry == nil {
+		bundle.Entry = []models.FhirBundleEntry{}
+	}
+	fhirJSON(c, http.StatusOK, bundle)
+}
+
+func fhirEntry(c *gin.Context, resourceType string, id string, resource interface{}) models.FhirBundleEntry {
+	return models.FhirBundleEntry{Full_url: fhirURL(c, resourceType, id), Resource: resource, Search: models.FhirBundleSearch{Mode: "match"}}
+}
+
+// fhirDateFilter turns the values of a date parameter into a condition on
+// key. Repeated values must all hold, so ge and lt make a range.
+func fhirDateFilter(key string, values []string) (bson.M, error) {
+	conditions := []bson.M{}
+	for _, value := range values {
+		prefix, date := helper.FhirPrefix(value)
+		start, end, err := helper.ParseFhirDate(date)
+		if err != nil {
+			return nil, err
+		}
+		var condition bson.M
+		switch prefix {
+		case "eq":
+			condition = bson.M{key: bson.M{"$gte": start, "$lt": end}}
+		case "ne":
+			condition = bson.M{key: bson.M{"$not": bson.M{"$gte": start, "$lt": end}}}
+		case "gt", "sa":
+			condition = bson.M{key: bson.M{"$gte": end}}
+		case "ge":
+			condition = bson.M{key: bson.M{"$gte": start}}
+		case "lt", "eb":
+			condition = bson.M{key: bson.M{"$lt": start}}
+		case "le":
+			condition = bson.M{key: bson.M{"$lt": end}}
+		}
+		conditions = append(conditions, condition)
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+// fhirReferenceId is the id of a reference search value, given as an id,
+// Type/id or an absolute URL; empty when it names another type.
+func fhirReferenceId(value string, resourceType string) string {
+	parts := strings.Split(strings.TrimRight(value, "/"), "/")
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	if parts[len(parts)-2] != resourceType {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+// fhirReference is the id in a reference to the resource type, or empty.
+func fhirReference(reference *models.FhirReference, resourceType string) string {
+	if reference == nil || !strings.Contains(reference.Reference, "/") {
+		return ""
+	}
+	return fhirReferenceId(reference.Reference, resourceType)
+}
+
+// fhirToken splits a token search value into its system and code.
+func fhirToken(value string) (string, string) {
+	if i := strings.Index(value, "|"); i >= 0 {
+		return value[:i], value[i+1:]
+	}
+	return "", value
+}
+
+// fhirStartsWith matches string search values: case-insensitive, from the
+// start of the value.
+func fhirStartsWith(value string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value), Options: "i"}
+}
+
+// fhirAnyOf matches a comma-separated token value against key, through the
+// code table when one is given.
+func fhirAnyOf(key string, value string, codes map[string]string) bson.M {
+	matches := []string{}
+	for _, code := range strings.Split(value, ",") {
+		for ours, theirs := range codes {
+			if theirs == code {
+				matches = append(matches, ours)
+			}
+		}
+		if codes == nil {
+			matches = append(matches, code)
+		}
+	}
+	return bson.M{key: bson.M{"$in": matches}}
+}
+
+func fhirMeta(updatedAt time.Time) *models.FhirMeta {
+	return &models.FhirMeta{Last_updated: helper.FhirInstant(updatedAt)}
+}
+
+// fhirTime reads a dateTime element; without an offset it is facility time.
+func fhirTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, helper.FacilityLocation()); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid dateTime %q", value)
+}
@@ -0,0 +1,647 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var fhirExportCollection *mongo.Collection = database.OpenCollection(database.Client, "fhirExport")
+
+const (
+	fhirExportDefinition        = "http://hl7.org/fhir/uv/bulkdata/OperationDefinition/export"
+	fhirPatientExportDefinition = "http://hl7.org/fhir/uv/bulkdata/OperationDefinition/patient-export"
+	fhirNDJSONContentType       = "application/fhir+ndjson"
+)
+
+const (
+	exportSystem  = "SYSTEM"
+	exportPatient = "PATIENT"
+)
+
+const (
+	exportQueued    = "QUEUED"
+	exportRunning   = "RUNNING"
+	exportCompleted = "COMPLETED"
+	exportFailed    = "FAILED"
+	exportCancelled = "CANCELLED"
+	exportExpired   = "EXPIRED"
+)
+
+const (
+	// exportHeartbeat is how many resources are written between progress
+	// updates; a RUNNING export not updated for exportStaleAfter was lost
+	// with its process and is run again.
+	exportHeartbeat  = 1000
+	exportStaleAfter = 10 * time.Minute
+	exportTimeout    = 2 * time.Hour
+	exportRetryAfter = "10"
+)
+
+var errExportCancelled = errors.New("the export was cancelled")
+
+// fhirExportFormats are the accepted values of _outputFormat.
+var fhirExportFormats = map[string]bool{fhirNDJSONContentType: true, "application/ndjson": true, "ndjson": true}
+
+// fhirExportType writes one resource type of an export. Compartment selects
+// the type's resources of the Patient compartment; types without one, such
+// as Practitioner, are left out of a patient-level export.
+type fhirExportType struct {
+	resourceType string
+	compartment  bson.M
+	write        func(ctx context.Context, filter bson.M, w *ndjsonWriter) error
+}
+
+// fhirExportTypes are exported in this order.
+var fhirExportTypes = []fhirExportType{
+	{"Patient", bson.M{}, exportFhirPatients},
+	{"Practitioner", nil, exportFhirPractitioners},
+	{"Appointment", bson.M{"patient_id": bson.M{"$ne": nil}}, exportFhirAppointments},
+	{"MedicationRequest", bson.M{"patient_id": bson.M{"$ne": nil}}, exportFhirMedicationRequests},
+	{"Invoice", bson.M{}, exportFhirInvoices},
+}
+
+// ExportFhirData kicks off a system-level export of every resource type.
+func ExportFhirData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kickOffFhirExport(c, exportSystem)
+	}
+}
+
+// ExportFhirPatientData kicks off an export of the Patient compartment: the
+// patients and the resources about them.
+func ExportFhirPatientData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kickOffFhirExport(c, exportPatient)
+	}
+}
+
+// kickOffFhirExport queues an export and answers 202 with the URL of its
+// status, as the Bulk Data async pattern asks. Each user runs one export at
+// a time.
+func kickOffFhirExport(c *gin.Context, level string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if !strings.Contains(c.GetHeader("Prefer"), "respond-async") {
+		fhirError(c, http.StatusBadRequest, "a bulk export needs the header Prefer: respond-async")
+		return
+	}
+	for name := range c.Request.URL.Query() {
+		if name != "_outputFormat" && name != "_since" && name != "_type" {
+			fhirError(c, http.StatusBadRequest, fmt.Sprintf("export parameter %q is not supported", name))
+			return
+		}
+	}
+	if format := c.Query("_outputFormat"); format != "" && !fhirExportFormats[format] {
+		fhirError(c, http.StatusBadRequest, "_outputFormat must be "+fhirNDJSONContentType)
+		return
+	}
+
+	export := models.FhirExport{Level: level}
+	if value := c.Query("_since"); value != "" {
+		since, err := fhirTime(value)
+		if err != nil {
+			fhirError(c, http.StatusBadRequest, "_since: "+err.Error())
+			return
+		}
+		export.Since = &since
+	}
+	requested := map[string]bool{}
+	for _, value := range c.QueryArray("_type") {
+		for _, resourceType := range strings.Split(value, ",") {
+			requested[strings.TrimSpace(resourceType)] = true
+		}
+	}
+	for _, exportType := range fhirExportTypes {
+		if level == exportPatient && exportType.compartment == nil {
+			if requested[exportType.resourceType] {
+				fhirError(c, http.StatusBadRequest, exportType.resourceType+" is not in the Patient compartment")
+				return
+			}
+			continue
+		}
+		if len(requested) == 0 || requested[exportType.resourceType] {
+			export.Types = append(export.Types, exportType.resourceType)
+			delete(requested, exportType.resourceType)
+		}
+	}
+	for resourceType := range requested {
+		fhirError(c, http.StatusBadRequest, fmt.Sprintf("resource type %q cannot be exported", resourceType))
+		return
+	}
+
+	export.Requested_by = c.GetString("uid")
+	inProgress, err := fhirExportCollection.CountDocuments(ctx, bson.M{
+		"requested_by": export.Requested_by,
+		"status":       bson.M{"$in": []string{exportQueued, exportRunning}},
+	})
+	if err != nil {
+		fhirError(c, http.StatusInternalServerError, "error occured while checking the exports")
+		return
+	}
+	if inProgress > 0 {
+		c.Header("Retry-After", exportRetryAfter)
+		fhirError(c, http.StatusTooManyRequests, "an export of yours is still in progress")
+		return
+	}
+
+	export.Request_url = fhirBase(c) + strings.TrimPrefix(c.Request.URL.Path, "/fhir")
+	if c.Request.URL.RawQuery != "" {
+		export.Request_url += "?" + c.Request.URL.RawQuery
+	}
+	export.Status = exportQueued
+	export.Output = []models.FhirExportOutput{}
+	export.Created_at = helper.Now()
+	export.Updated_at = helper.Now()
+	export.ID = primitive.NewObjectID()
+	export.Export_id = export.ID.Hex()
+	if _, err := fhirExportCollection.InsertOne(ctx, export); err != nil {
+		fhirError(c, http.StatusInternalServerError, "the export was not queued")
+		return
+	}
+
+	c.Header("Content-Location", fhirBase(c)+"/$export-status/"+export.Export_id)
+	c.Status(http.StatusAccepted)
+}
+
+// GetFhirExportStatus answers 202 while an export runs, then its manifest;
+// a failed export is a 500 with the reason.
+func GetFhirExportStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		export, ok := findFhirExport(ctx, c)
+		if !ok {
+			return
+		}
+		switch export.Status {
+		case exportQueued, exportRunning:
+			progress := strings.ToLower(export.Status)
+			if export.Progress != "" {
+				progress = export.Progress
+			}
+			c.Header("X-Progress", progress)
+			c.Header("Retry-After", exportRetryAfter)
+			c.Status(http.StatusAccepted)
+			return
+		case exportFailed:
+			fhirError(c, http.StatusInternalServerError, export.Error)
+			return
+		}
+
+		manifest := models.FhirExportManifest{
+			Transaction_time:      helper.FhirInstant(*export.Transaction_time),
+			Request:               export.Request_url,
+			Requires_access_token: true,
+			Output:                []models.FhirExportFile{},
+			Error:                 []models.FhirExportFile{},
+		}
+		for _, output := range export.Output {
+			manifest.Output = append(manifest.Output, models.FhirExportFile{
+				Type:  output.Type,
+				Url:   fhirBase(c) + "/$export-file/" + export.Export_id + "/" + output.File,
+				Count: output.Count,
+			})
+		}
+		c.Header("Expires", export.Completed_at.Add(fhirExportRetention()).Format(http.TimeFormat))
+		c.JSON(http.StatusOK, manifest)
+	}
+}
+
+// CancelFhirExport stops an export, or deletes a completed one, with its
+// files.
+func CancelFhirExport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		export, ok := findFhirExport(ctx, c)
+		if !ok {
+			return
+		}
+		completedAt := helper.Now()
+		_, err := fhirExportCollection.UpdateOne(ctx,
+			bson.M{"export_id": export.Export_id},
+			bson.D{{Key: "$set", Value: bson.D{
+				{Key: "status", Value: exportCancelled},
+				{Key: "completed_at", Value: completedAt},
+				{Key: "updated_at", Value: completedAt},
+			}}},
+		)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "the export was not cancelled")
+			return
+		}
+		// a running export notices the cancellation and removes what it
+		// writes after this
+		removeFhirExportFiles(export.Export_id)
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// GetFhirExportFile downloads one NDJSON file of a completed export.
+func GetFhirExportFile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		export, ok := findFhirExport(ctx, c)
+		if !ok {
+			return
+		}
+		if export.Status == exportCompleted {
+			for _, output := range export.Output {
+				// only the files listed are served, never a path of the request
+				if output.File == c.Param("file") {
+					c.Header("Content-Type", fhirNDJSONContentType)
+					c.File(filepath.Join(fhirExportDir(), export.Export_id, output.File))
+					return
+				}
+			}
+		}
+		fhirError(c, http.StatusNotFound, "the file "+c.Param("file")+" is not part of the export")
+	}
+}
+
+// findFhirExport reads the caller's export of the URL; cancelled and expired
+// exports are gone. On failure the response has been written.
+func findFhirExport(ctx context.Context, c *gin.Context) (models.FhirExport, bool) {
+	var export models.FhirExport
+	err := fhirExportCollection.FindOne(ctx, bson.M{
+		"export_id":    c.Param("export_id"),
+		"requested_by": c.GetString("uid"),
+		"status":       bson.M{"$nin": []string{exportCancelled, exportExpired}},
+	}).Decode(&export)
+	if err != nil {
+		fhirError(c, http.StatusNotFound, "export "+c.Param("export_id")+" not found")
+		return export, false
+	}
+	return export, true
+}
+
+// runFhirExports is the background job behind the exports: it expires old
+// exports, then runs the oldest queued one, or one whose process was lost.
+func runFhirExports(ctx context.Context) {
+	expireFhirExports(ctx)
+
+	now := helper.Now()
+	var export models.FhirExport
+	err := fhirExportCollection.FindOneAndUpdate(ctx,
+		bson.M{"$or": []bson.M{
+			{"status": exportQueued},
+			{"status": exportRunning, "updated_at": bson.M{"$lt": now.Add(-exportStaleAfter)}},
+		}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: exportRunning},
+			{Key: "progress", Value: ""},
+			{Key: "output", Value: []models.FhirExportOutput{}},
+			{Key: "transaction_time", Value: now},
+			{Key: "updated_at", Value: now},
+		}}},
+		options.FindOneAndUpdate().SetSort(bson.M{"created_at": 1}).SetReturnDocument(options.After),
+	).Decode(&export)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("fhir export: %v", err)
+		}
+		return
+	}
+
+	// an export outlasts the interval the scheduler gives a run, so it has
+	// a deadline of its own
+	exportCtx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	err = writeFhirExport(exportCtx, &export)
+	finishedAt := helper.Now()
+	update := bson.D{{Key: "completed_at", Value: finishedAt}, {Key: "updated_at", Value: finishedAt}, {Key: "progress", Value: ""}}
+	switch {
+	case err == errExportCancelled:
+		removeFhirExportFiles(export.Export_id)
+		return
+	case err != nil:
+		log.Printf("fhir export %s: %v", export.Export_id, err)
+		removeFhirExportFiles(export.Export_id)
+		update = append(update, bson.E{Key: "status", Value: exportFailed}, bson.E{Key: "error", Value: err.Error()})
+	default:
+		update = append(update, bson.E{Key: "status", Value: exportCompleted}, bson.E{Key: "output", Value: export.Output})
+	}
+	result, err := fhirExportCollection.UpdateOne(exportCtx,
+		bson.M{"export_id": export.Export_id, "status": exportRunning},
+		bson.D{{Key: "$set", Value: update}},
+	)
+	if err != nil {
+		log.Printf("fhir export %s: %v", export.Export_id, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		removeFhirExportFiles(export.Export_id)
+	}
+}
+
+// writeFhirExport writes an NDJSON file for each type of the export,
+// recording each in its output as it is done.
+func writeFhirExport(ctx context.Context, export *models.FhirExport) error {
+	dir := filepath.Join(fhirExportDir(), export.Export_id)
+	// a rerun starts over
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+
+	for _, exportType := range fhirExportTypes {
+		if !containsString(export.Types, exportType.resourceType) {
+			continue
+		}
+		filter := bson.M{}
+		if export.Level == exportPatient {
+			for key, value := range exportType.compartment {
+				filter[key] = value
+			}
+		}
+		if export.Since != nil {
+			filter["updated_at"] = bson.M{"$gte": *export.Since}
+		}
+
+		output := models.FhirExportOutput{Type: exportType.resourceType, File: exportType.resourceType + ".ndjson"}
+		file, err := os.Create(filepath.Join(dir, output.File))
+		if err != nil {
+			return err
+		}
+		w := newNDJSONWriter(ctx, export.Export_id, exportType.resourceType, file)
+		err = exportType.write(ctx, filter, w)
+		if err == nil {
+			err = w.buffer.Flush()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+
+		output.Count = w.count
+		export.Output = append(export.Output, output)
+		if err := touchFhirExport(ctx, export.Export_id, bson.E{Key: "output", Value: export.Output}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ndjsonWriter writes resources one per line, reporting progress every
+// exportHeartbeat resources.
+type ndjsonWriter struct {
+	ctx          context.Context
+	exportId     string
+	resourceType string
+	buffer       *bufio.Writer
+	encoder      *json.Encoder
+	count        int64
+}
+
+func newNDJSONWriter(ctx context.Context, exportId string, resourceType string, file *os.File) *ndjsonWriter {
+	buffer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	return &ndjsonWriter{ctx: ctx, exportId: exportId, resourceType: resourceType, buffer: buffer, encoder: encoder}
+}
+
+func (w *ndjsonWriter) write(resource interface{}) error {
+	if err := w.encoder.Encode(resource); err != nil {
+		return err
+	}
+	w.count++
+	if w.count%exportHeartbeat == 0 {
+		progress := fmt.Sprintf("%s: %d resources", w.resourceType, w.count)
+		return touchFhirExport(w.ctx, w.exportId, bson.E{Key: "progress", Value: progress})
+	}
+	return nil
+}
+
+// touchFhirExport records progress on a running export; it fails with
+// errExportCancelled once the export is no longer running.
+func touchFhirExport(ctx context.Context, exportId string, set bson.E) error {
+	result, err := fhirExportCollection.UpdateOne(ctx,
+		bson.M{"export_id": exportId, "status": exportRunning},
+		bson.D{{Key: "$set", Value: bson.D{set, {Key: "updated_at", Value: helper.Now()}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errExportCancelled
+	}
+	return nil
+}
+
+func exportFhirPatients(ctx context.Context, filter bson.M, w *ndjsonWriter) error {
+	cursor, err := patientCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var patient models.Patient
+		if err := cursor.Decode(&patient); err != nil {
+			return err
+		}
+		if err := w.write(fhirPatient(patient)); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func exportFhirPractitioners(ctx context.Context, filter bson.M, w *ndjsonWriter) error {
+	cursor, err := doctorCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doctor models.Doctor
+		if err := cursor.Decode(&doctor); err != nil {
+			return err
+		}
+		if err := w.write(fhirPractitioner(doctor)); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func exportFhirAppointments(ctx context.Context, filter bson.M, w *ndjsonWriter) error {
+	// rooms and equipment are few; their kinds are read once
+	resourceIds, err := resourceCollection.Distinct(ctx, "resource_id", bson.M{})
+	if err != nil {
+		return err
+	}
+	ids := []string{}
+	for _, id := range resourceIds {
+		if id, ok := id.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	kinds, err := resourceKinds(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := appointmentCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var appointment models.Appointment
+		if err := cursor.Decode(&appointment); err != nil {
+			return err
+		}
+		if err := w.write(fhirAppointment(appointment, kinds)); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func exportFhirMedicationRequests(ctx context.Context, filter bson.M, w *ndjsonWriter) error {
+	cursor, err := prescriptionCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var prescription models.Prescription
+		if err := cursor.Decode(&prescription); err != nil {
+			return err
+		}
+		normalizePrescription(&prescription)
+		for _, item := range prescription.Items {
+			if err := w.write(fhirMedicationRequest(prescription, item)); err != nil {
+				return err
+			}
+		}
+	}
+	return cursor.Err()
+}
+
+// exportFhirInvoices reads invoices in batches so each batch looks up the
+// patients of its appointments at once.
+func exportFhirInvoices(ctx context.Context, filter bson.M, w *ndjsonWriter) error {
+	cursor, err := invoiceCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	flush := func(invoices []models.Invoice) error {
+		patients, err := invoicePatients(ctx, invoices)
+		if err != nil {
+			return err
+		}
+		for _, invoice := range invoices {
+			if err := w.write(fhirInvoice(invoice, patients)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	batch := []models.Invoice{}
+	for cursor.Next(ctx) {
+		var invoice models.Invoice
+		if err := cursor.Decode(&invoice); err != nil {
+			return err
+		}
+		batch = append(batch, invoice)
+		if len(batch) == exportHeartbeat {
+			if err := flush(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return flush(batch)
+}
+
+// expireFhirExports removes the files of exports finished longer ago than
+// the retention.
+func expireFhirExports(ctx context.Context) {
+	cursor, err := fhirExportCollection.Find(ctx, bson.M{
+		"status":       bson.M{"$in": []string{exportCompleted, exportFailed, exportCancelled}},
+		"completed_at": bson.M{"$lt": helper.Now().Add(-fhirExportRetention())},
+	})
+	if err != nil {
+		log.Printf("fhir export: %v", err)
+		return
+	}
+	var expired []models.FhirExport
+	if err = cursor.All(ctx, &expired); err != nil {
+		log.Printf("fhir export: %v", err)
+		return
+	}
+	for _, export := range expired {
+		removeFhirExportFiles(export.Export_id)
+		_, err := fhirExportCollection.UpdateOne(ctx,
+			bson.M{"export_id": export.Export_id},
+			bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: exportExpired}, {Key: "updated_at", Value: helper.Now()}}}},
+		)
+		if err != nil {
+			log.Printf("fhir export %s: %v", export.Export_id, err)
+		}
+	}
+}
+
+func removeFhirExportFiles(exportId string) {
+	if err := os.RemoveAll(filepath.Join(fhirExportDir(), exportId)); err != nil {
+		log.Printf("fhir export %s: %v", exportId, err)
+	}
+}
+
+// fhirExportDir is where export files are written, FHIR_EXPORT_DIR or
+// ./exports.
+func fhirExportDir() string {
+	if dir := os.Getenv("FHIR_EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return "exports"
+}
+
+// fhirExportRetention reads FHIR_EXPORT_RETENTION (e.g. "24h"), how long the
+// files of an export are kept.
+func fhirExportRetention() time.Duration {
+	raw := os.Getenv("FHIR_EXPORT_RETENTION")
+	if raw == "" {
+		return 24 * time.Hour
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("fhir export: ignoring invalid retention %q", raw)
+		return 24 * time.Hour
+	}
+	return d
+}
@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"context"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// An invoice has no patient of its own; the subject is the patient of the
+// invoiced appointment, which travels as an extension with the payment
+// method.
+const (
+	fhirInvoiceAppointmentExtension = fhirExtensionBase + "invoice-appointment"
+	fhirPaymentMethodExtension      = fhirExtensionBase + "payment-method"
+)
+
+// fhirInvoiceStatuses maps payment statuses to FHIR's.
+var fhirInvoiceStatuses = map[string]string{"PENDING": "issued", "PAID": "balanced"}
+
+func ReadFhirInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var invoice models.Invoice
+		if err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": c.Param("id")}).Decode(&invoice); err != nil {
+			fhirError(c, http.StatusNotFound, "Invoice/"+c.Param("id")+" not found")
+			return
+		}
+		patients, err := invoicePatients(ctx, []models.Invoice{invoice})
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while reading the appointment")
+			return
+		}
+		fhirJSON(c, http.StatusOK, fhirInvoice(invoice, patients))
+	}
+}
+
+// SearchFhirInvoices searches invoices by _id, patient, status and date,
+// oldest first.
+func SearchFhirInvoices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		count, offset, ok := fhirSearchPage(c, "Invoice")
+		if !ok {
+			return
+		}
+		conditions := []bson.M{}
+		if value := c.Query("_id"); value != "" {
+			conditions = append(conditions, bson.M{"invoice_id": bson.M{"$in": strings.Split(value, ",")}})
+		}
+		if value := c.Query("patient"); value != "" {
+			appointmentIds, err := appointmentCollection.Distinct(ctx, "appointment_id", bson.M{"patient_id": fhirReferenceId(value, "Patient")})
+			if err != nil {
+				fhirError(c, http.StatusInternalServerError, "error occured while reading the appointments")
+				return
+			}
+			conditions = append(conditions, bson.M{"appointment_id": bson.M{"$in": appointmentIds}})
+		}
+		if value := c.Query("status"); value != "" {
+			conditions = append(conditions, fhirAnyOf("payment_status", value, fhirInvoiceStatuses))
+		}
+		if values := c.QueryArray("date"); len(values) > 0 {
+			condition, err := fhirDateFilter("created_at", values)
+			if err != nil {
+				fhirError(c, http.StatusBadRequest, "date: "+err.Error())
+				return
+			}
+			conditions = append(conditions, condition)
+		}
+
+		filter := bson.M{}
+		if len(conditions) > 0 {
+			filter["$and"] = conditions
+		}
+		total, err := invoiceCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the invoices")
+			return
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).SetSkip(offset).SetLimit(count)
+		cursor, err := invoiceCollection.Find(ctx, filter, opts)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the invoices")
+			return
+		}
+		var invoices []models.Invoice
+		if err = cursor.All(ctx, &invoices); err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the invoices")
+			return
+		}
+
+		patients, err := invoicePatients(ctx, invoices)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while reading the appointments")
+			return
+		}
+		entries := []models.FhirBundleEntry{}
+		for _, invoice := range invoices {
+			entries = append(entries, fhirEntry(c, "Invoice", invoice.Invoice_id, fhirInvoice(invoice, patients)))
+		}
+		fhirSearchset(c, total, count, offset, entries)
+	}
+}
+
+// CreateFhirInvoice bills an appointment as CreateInvoice does; without a
+// totalGross the appointment's type prices it.
+func CreateFhirInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirInvoice
+		if !fhirBind(c, &resource, "Invoice", "") {
+			return
+		}
+		invoice, msg := fhirInvoiceChange(resource)
+		if msg != "" {
+			fhirError(c, http.StatusBadRequest, msg)
+			return
+		}
+		if invoice.Appointment_id == "" {
+			fhirError(c, http.StatusUnprocessableEntity, "the extension "+fhirInvoiceAppointmentExtension+" must reference the invoiced appointment")
+			return
+		}
+		if resource.Total_gross != nil {
+			invoice.Amount = resource.Total_gross.Value
+		}
+
+		if status, msg := prepareInvoice(ctx, &invoice); msg != "" {
+			fhirError(c, status, msg)
+			return
+		}
+		if _, err := invoiceCollection.InsertOne(ctx, invoice); err != nil {
+			fhirError(c, http.StatusInternalServerError, "invoice item was not created")
+			return
+		}
+		patients, err := invoicePatients(ctx, []models.Invoice{invoice})
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while reading the appointment")
+			return
+		}
+		fhirCreated(c, "Invoice", invoice.Invoice_id, fhirInvoice(invoice, patients))
+	}
+}
+
+// UpdateFhirInvoice records a payment: the status and the payment method
+// are all that can change once an invoice is issued.
+func UpdateFhirInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirInvoice
+		if !fhirBind(c, &resource, "Invoice", c.Param("id")) {
+			return
+		}
+		change, msg := fhirInvoiceChange(resource)
+		if msg != "" {
+			fhirError(c, http.StatusBadRequest, msg)
+			return
+		}
+
+		var invoice models.Invoice
+		if err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": c.Param("id")}).Decode(&invoice); err != nil {
+			fhirError(c, http.StatusNotFound, "Invoice/"+c.Param("id")+" not found")
+			return
+		}
+		if change.Appointment_id != "" && change.Appointment_id != invoice.Appointment_id {
+			fhirError(c, http.StatusUnprocessableEntity, "the appointment of an invoice cannot be changed")
+			return
+		}
+		invoice.Payment_status = change.Payment_status
+		if change.Payment_method != nil {
+			invoice.Payment_method = change.Payment_method
+		}
+		invoice.Updated_at = helper.Now()
+		if err := validate.Struct(invoice); err != nil {
+			fhirError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		update := bson.D{
+			{Key: "payment_status", Value: invoice.Payment_status},
+			{Key: "payment_method", Value: invoice.Payment_method},
+			{Key: "updated_at", Value: invoice.Updated_at},
+		}
+		if _, err := invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoice.Invoice_id}, bson.D{{Key: "$set", Value: update}}); err != nil {
+			fhirError(c, http.StatusInternalServerError, "invoice item update failed")
+			return
+		}
+		patients, err := invoicePatients(ctx, []models.Invoice{invoice})
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while reading the appointment")
+			return
+		}
+		fhirJSON(c, http.StatusOK, fhirInvoice(invoice, patients))
+	}
+}
+
+// invoicePatients maps the appointments of the invoices to their patients.
+func invoicePatients(ctx context.Context, invoices []models.Invoice) (map[string]string, error) {
+	appointmentIds := []string{}
+	for _, invoice := range invoices {
+		appointmentIds = append(appointmentIds, invoice.Appointment_id)
+	}
+	patients := map[string]string{}
+	cursor, err := appointmentCollection.Find(ctx, bson.M{"appointment_id": bson.M{"$in": appointmentIds}})
+	if err != nil {
+		return nil, err
+	}
+	var appointments []models.Appointment
+	if err = cursor.All(ctx, &appointments); err != nil {
+		return nil, err
+	}
+	for _, appointment := range appointments {
+		if appointment.Patient_id != nil {
+			patients[appointment.Appointment_id] = *appointment.Patient_id
+		}
+	}
+	return patients, nil
+}
+
+// invoiceCurrency is the currency amounts are billed in, CURRENCY or USD.
+func invoiceCurrency() string {
+	if currency := os.Getenv("CURRENCY"); currency != "" {
+		return currency
+	}
+	return "USD"
+}
+
+func fhirInvoice(invoice models.Invoice, patients map[string]string) models.FhirInvoice {
+	resource := models.FhirInvoice{
+		Resource_type: "Invoice",
+		Id:            invoice.Invoice_id,
+		Meta:          fhirMeta(invoice.Updated_at),
+		Identifier:    []models.FhirIdentifier{{Use: "usual", System: fhirSystemBase + "invoice", Value: invoice.Invoice_id}},
+		Date:          helper.FhirInstant(invoice.Created_at),
+		Extension: []models.FhirExtension{{
+			Url:             fhirInvoiceAppointmentExtension,
+			Value_reference: &models.FhirReference{Reference: "Appointment/" + invoice.Appointment_id},
+		}},
+	}
+	if invoice.Payment_status != nil {
+		resource.Status = fhirInvoiceStatuses[*invoice.Payment_status]
+	}
+	if patientId, ok := patients[invoice.Appointment_id]; ok {
+		resource.Subject = &models.FhirReference{Reference: "Patient/" + patientId}
+	}
+	if invoice.Payment_method != nil && *invoice.Payment_method != "" {
+		resource.Extension = append(resource.Extension, models.FhirExtension{Url: fhirPaymentMethodExtension, Value_code: invoice.Payment_method})
+	}
+	if invoice.Amount != nil {
+		resource.Total_net = &models.FhirMoney{Value: invoice.Amount, Currency: invoiceCurrency()}
+		resource.Total_gross = &models.FhirMoney{Value: invoice.Amount, Currency: invoiceCurrency()}
+	}
+	if !invoice.Payment_due_date.IsZero() {
+		resource.Payment_terms = "due " + helper.FhirDate(invoice.Payment_due_date)
+	}
+	return resource
+}
+
+// fhirInvoiceChange reads the appointment, status and payment method of a
+// resource.
+func fhirInvoiceChange(resource models.FhirInvoice) (models.Invoice, string) {
+	var invoice models.Invoice
+	for _, extension := range resource.Extension {
+		switch {
+		case extension.Url == fhirInvoiceAppointmentExtension && extension.Value_reference != nil:
+			invoice.Appointment_id = fhirReference(extension.Value_reference, "Appointment")
+		case extension.Url == fhirPaymentMethodExtension && extension.Value_code != nil:
+			method := strings.ToUpper(*extension.Value_code)
+			invoice.Payment_method = &method
+		}
+	}
+	if resource.Status != "" {
+		for ours, theirs := range fhirInvoiceStatuses {
+			if theirs == resource.Status {
+				status := ours
+				invoice.Payment_status = &status
+			}
+		}
+		if invoice.Payment_status == nil {
+			return invoice, "status must be issued or balanced"
+		}
+	}
+	if resource.Total_gross != nil && resource.Total_gross.Currency != "" && resource.Total_gross.Currency != invoiceCurrency() {
+		return invoice, "amounts are billed in " + invoiceCurrency()
+	}
+	return invoice, ""
+}
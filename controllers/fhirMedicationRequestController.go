@@ -0,0 +1,505 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A MedicationRequest is one item of a prescription, with the id
+// <prescription_id>-<item_id>; the prescription is its group identifier.
+// Strength, form and the override reason have no element and travel as
+// extensions.
+const (
+	fhirStrengthExtension = fhirExtensionBase + "medication-strength"
+	fhirFormExtension     = fhirExtensionBase + "medication-form"
+	fhirOverrideExtension = fhirExtensionBase + "override-reason"
+)
+
+// fhirMedicationStatuses maps prescription statuses to FHIR's.
+var fhirMedicationStatuses = map[string]string{
+	prescriptionActive:    "active",
+	prescriptionPartial:   "active",
+	prescriptionCompleted: "completed",
+	prescriptionExpired:   "stopped",
+	prescriptionCancelled: "cancelled",
+}
+
+func ReadFhirMedicationRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		prescription, item, ok := findFhirMedicationRequest(ctx, c)
+		if !ok {
+			return
+		}
+		fhirJSON(c, http.StatusOK, fhirMedicationRequest(prescription, *item))
+	}
+}
+
+// SearchFhirMedicationRequests searches prescription items by _id, patient,
+// requester, status, authoredon and code (the formulary drug), oldest
+// first.
+func SearchFhirMedicationRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		count, offset, ok := fhirSearchPage(c, "MedicationRequest")
+		if !ok {
+			return
+		}
+		conditions := []bson.M{}
+		ids := map[string]bool{}
+		if value := c.Query("_id"); value != "" {
+			prescriptionIds := []string{}
+			for _, id := range strings.Split(value, ",") {
+				ids[id] = true
+				prescriptionId, _ := splitMedicationRequestId(id)
+				prescriptionIds = append(prescriptionIds, prescriptionId)
+			}
+			conditions = append(conditions, bson.M{"prescription_id": bson.M{"$in": prescriptionIds}})
+		}
+		if value := c.Query("patient"); value != "" {
+			conditions = append(conditions, bson.M{"patient_id": fhirReferenceId(value, "Patient")})
+		}
+		if value := c.Query("requester"); value != "" {
+			conditions = append(conditions, bson.M{"doctor_id": fhirReferenceId(value, "Practitioner")})
+		}
+		if values := c.QueryArray("authoredon"); len(values) > 0 {
+			condition, err := fhirDateFilter("created_at", values)
+			if err != nil {
+				fhirError(c, http.StatusBadRequest, "authoredon: "+err.Error())
+				return
+			}
+			conditions = append(conditions, condition)
+		}
+		codes := map[string]bool{}
+		if value := c.Query("code"); value != "" {
+			for _, token := range strings.Split(value, ",") {
+				_, code := fhirToken(token)
+				codes[code] = true
+			}
+		}
+		statuses := map[string]bool{}
+		if value := c.Query("status"); value != "" {
+			for _, status := range strings.Split(value, ",") {
+				statuses[status] = true
+			}
+		}
+
+		filter := bson.M{}
+		if len(conditions) > 0 {
+			filter["$and"] = conditions
+		}
+		cursor, err := prescriptionCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the prescriptions")
+			return
+		}
+		var prescriptions []models.Prescription
+		if err = cursor.All(ctx, &prescriptions); err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the prescriptions")
+			return
+		}
+
+		// status is derived and code is per item, so both are matched after
+		// the prescriptions are read and split into items
+		matches := []models.FhirMedicationRequest{}
+		for _, prescription := range prescriptions {
+			normalizePrescription(&prescription)
+			if len(statuses) > 0 && !statuses[fhirMedicationStatuses[prescription.Status]] {
+				continue
+			}
+			for _, item := range prescription.Items {
+				if len(codes) > 0 && (item.Drug_code == nil || !codes[*item.Drug_code]) {
+					continue
+				}
+				resource := fhirMedicationRequest(prescription, item)
+				if len(ids) > 0 && !ids[resource.Id] && !ids[prescription.Prescription_id] {
+					continue
+				}
+				matches = append(matches, resource)
+			}
+		}
+
+		total := int64(len(matches))
+		entries := []models.FhirBundleEntry{}
+		for i := offset; i < total && i < offset+count; i++ {
+			entries = append(entries, fhirEntry(c, "MedicationRequest", matches[i].Id, matches[i]))
+		}
+		fhirSearchset(c, total, count, offset, entries)
+	}
+}
+
+// CreateFhirMedicationRequest prescribes one medication, as a prescription
+// of one item, through the checks of CreatePrescription.
+func CreateFhirMedicationRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirMedicationRequest
+		if !fhirBind(c, &resource, "MedicationRequest", "") {
+			return
+		}
+		if resource.Status != "" && resource.Status != "active" {
+			fhirError(c, http.StatusUnprocessableEntity, "new medication requests are active")
+			return
+		}
+		if resource.Intent != "" && resource.Intent != "order" {
+			fhirError(c, http.StatusUnprocessableEntity, "only orders are supported")
+			return
+		}
+
+		item, msg := fhirMedicationItem(resource)
+		if msg != "" {
+			fhirError(c, http.StatusBadRequest, msg)
+			return
+		}
+		prescription := models.Prescription{Items: []models.MedicationItem{item}}
+		if patientId := fhirReference(&resource.Subject, "Patient"); patientId != "" {
+			prescription.Patient_id = &patientId
+		}
+		if doctorId := fhirReference(resource.Requester, "Practitioner"); doctorId != "" {
+			prescription.Doctor_id = &doctorId
+		}
+		if encounterId := fhirReference(resource.Encounter, "Encounter"); encounterId != "" {
+			prescription.Encounter_id = &encounterId
+		}
+		if len(resource.Note) > 0 {
+			prescription.Notes = &resource.Note[0].Text
+		}
+		var err error
+		if prescription.Start_Date, prescription.End_Date, err = fhirValidity(resource); err != nil {
+			fhirError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		prescription.Override_reason = fhirExtensionString(resource.Extension, fhirOverrideExtension)
+
+		if status, msg := createPrescription(ctx, &prescription); msg != "" {
+			fhirPrescriptionError(c, status, msg, prescription.Warnings)
+			return
+		}
+		resource = fhirMedicationRequest(prescription, prescription.Items[0])
+		fhirCreated(c, "MedicationRequest", resource.Id, resource)
+	}
+}
+
+// UpdateFhirMedicationRequest changes one item of a prescription through the
+// checks of UpdatePrescription. Cancelling cancels the prescription, so it
+// is only done for a prescription of one item; the other statuses follow the
+// dispensing and cannot be set.
+func UpdateFhirMedicationRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirMedicationRequest
+		if !fhirBind(c, &resource, "MedicationRequest", c.Param("id")) {
+			return
+		}
+		prescription, item, ok := findFhirMedicationRequest(ctx, c)
+		if !ok {
+			return
+		}
+		current := fhirMedicationStatuses[prescription.Status]
+		if patientId := fhirReference(&resource.Subject, "Patient"); patientId != *prescription.Patient_id {
+			fhirError(c, http.StatusUnprocessableEntity, "the subject of a medication request cannot be changed")
+			return
+		}
+
+		var change models.PrescriptionChange
+		switch {
+		case resource.Status == "cancelled" && current != "cancelled":
+			if len(prescription.Items) > 1 {
+				fhirError(c, http.StatusUnprocessableEntity, "the prescription has other items; cancel it as a whole")
+				return
+			}
+			status := prescriptionCancelled
+			change.Status = &status
+		case resource.Status != current:
+			fhirError(c, http.StatusUnprocessableEntity, fmt.Sprintf("status %s is set by dispensing", resource.Status))
+			return
+		default:
+			updated, msg := fhirMedicationItem(resource)
+			if msg != "" {
+				fhirError(c, http.StatusBadRequest, msg)
+				return
+			}
+			if !sameMedicationItem(*item, updated) {
+				change.Items = append([]models.MedicationItem{}, prescription.Items...)
+				for i := range change.Items {
+					if change.Items[i].Item_id == item.Item_id {
+						change.Items[i] = updated
+					}
+				}
+			}
+			if len(resource.Note) > 0 && (prescription.Notes == nil || *prescription.Notes != resource.Note[0].Text) {
+				change.Notes = &resource.Note[0].Text
+			}
+			start, end, err := fhirValidity(resource)
+			if err != nil {
+				fhirError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			change.Start_Date, change.End_Date = start, end
+			change.Override_reason = fhirExtensionString(resource.Extension, fhirOverrideExtension)
+		}
+
+		prescription, status, msg := updatePrescription(ctx, prescription.Prescription_id, change)
+		if msg != "" {
+			fhirPrescriptionError(c, status, msg, prescription.Warnings)
+			return
+		}
+		for _, updated := range prescription.Items {
+			if updated.Item_id == item.Item_id {
+				fhirJSON(c, http.StatusOK, fhirMedicationRequest(prescription, updated))
+				return
+			}
+		}
+		fhirError(c, http.StatusInternalServerError, "the item was not found after the update")
+	}
+}
+
+// findFhirMedicationRequest reads the prescription and item of the id in the
+// URL. On failure the response has been written.
+func findFhirMedicationRequest(ctx context.Context, c *gin.Context) (models.Prescription, *models.MedicationItem, bool) {
+	var prescription models.Prescription
+	prescriptionId, itemId := splitMedicationRequestId(c.Param("id"))
+	if err := prescriptionCollection.FindOne(ctx, bson.M{"prescription_id": prescriptionId}).Decode(&prescription); err != nil {
+		fhirError(c, http.StatusNotFound, "MedicationRequest/"+c.Param("id")+" not found")
+		return prescription, nil, false
+	}
+	normalizePrescription(&prescription)
+	for i := range prescription.Items {
+		if prescription.Items[i].Item_id == itemId {
+			return prescription, &prescription.Items[i], true
+		}
+	}
+	fhirError(c, http.StatusNotFound, "MedicationRequest/"+c.Param("id")+" not found")
+	return prescription, nil, false
+}
+
+func splitMedicationRequestId(id string) (string, string) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 {
+		return id, ""
+	}
+	return id[:i], id[i+1:]
+}
+
+// fhirPrescriptionError writes a failed create or update; the warnings that
+// blocked a prescription are issues of their own.
+func fhirPrescriptionError(c *gin.Context, status int, msg string, warnings []models.ClinicalWarning) {
+	if status != http.StatusUnprocessableEntity {
+		fhirError(c, status, msg)
+		return
+	}
+	outcome := models.FhirOperationOutcome{
+		Resource_type: "OperationOutcome",
+		Issue:         []models.FhirIssue{{Severity: "error", Code: "business-rule", Diagnostics: msg + " (extension " + fhirOverrideExtension + ")"}},
+	}
+	for _, warning := range warnings {
+		outcome.Issue = append(outcome.Issue, models.FhirIssue{Severity: "warning", Code: "business-rule", Diagnostics: warning.Message})
+	}
+	fhirJSON(c, status, outcome)
+}
+
+func fhirMedicationRequest(prescription models.Prescription, item models.MedicationItem) models.FhirMedicationRequest {
+	id := prescription.Prescription_id + "-" + item.Item_id
+	resource := models.FhirMedicationRequest{
+		Resource_type:    "MedicationRequest",
+		Id:               id,
+		Meta:             fhirMeta(prescription.Updated_at),
+		Identifier:       []models.FhirIdentifier{{Use: "usual", System: fhirSystemBase + "medication-request", Value: id}},
+		Group_identifier: &models.FhirIdentifier{System: fhirSystemBase + "prescription", Value: prescription.Prescription_id},
+		Status:           fhirMedicationStatuses[prescription.Status],
+		Intent:           "order",
+		Authored_on:      helper.FhirInstant(prescription.Created_at),
+	}
+	if prescription.Patient_id != nil {
+		resource.Subject = models.FhirReference{Reference: "Patient/" + *prescription.Patient_id}
+	}
+	if prescription.Doctor_id != nil {
+		resource.Requester = &models.FhirReference{Reference: "Practitioner/" + *prescription.Doctor_id}
+	}
+	if prescription.Encounter_id != nil {
+		resource.Encounter = &models.FhirReference{Reference: "Encounter/" + *prescription.Encounter_id}
+	}
+	if prescription.Notes != nil {
+		resource.Note = []models.FhirAnnotation{{Text: *prescription.Notes}}
+	}
+
+	medication := &models.FhirCodeableConcept{}
+	if item.Drug_code != nil {
+		coding := models.FhirCoding{System: fhirSystemBase + "formulary", Code: *item.Drug_code}
+		if item.Drug_name != nil {
+			coding.Display = *item.Drug_name
+		}
+		medication.Coding = []models.FhirCoding{coding}
+	}
+	medication.Text = strings.TrimSpace(strings.Join([]string{hl7Text(item.Drug_name), hl7Text(item.Strength), strings.ToLower(hl7Text(item.Form))}, " "))
+	resource.Medication_codeable_concept = medication
+	if item.Strength != nil {
+		resource.Extension = append(resource.Extension, models.FhirExtension{Url: fhirStrengthExtension, Value_string: item.Strength})
+	}
+	if item.Form != nil {
+		resource.Extension = append(resource.Extension, models.FhirExtension{Url: fhirFormExtension, Value_code: item.Form})
+	}
+	if prescription.Override_reason != nil {
+		resource.Extension = append(resource.Extension, models.FhirExtension{Url: fhirOverrideExtension, Value_string: prescription.Override_reason})
+	}
+
+	asNeeded := item.As_needed
+	dosage := models.FhirDosage{
+		Text:                strings.TrimSpace(hl7Text(item.Frequency) + " " + strings.ToLower(hl7Text(item.Route))),
+		Patient_instruction: hl7Text(item.Instructions),
+		As_needed_boolean:   &asNeeded,
+	}
+	if item.Frequency != nil {
+		dosage.Timing = &models.FhirTiming{Code: &models.FhirCodeableConcept{Text: *item.Frequency}}
+	}
+	if item.Route != nil {
+		dosage.Route = &models.FhirCodeableConcept{Coding: []models.FhirCoding{{System: fhirSystemBase + "route", Code: *item.Route}}}
+	}
+	resource.Dosage_instruction = []models.FhirDosage{dosage}
+
+	dispense := &models.FhirDispenseRequest{Number_of_repeats_allowed: item.Refills}
+	if item.Quantity > 0 {
+		quantity := item.Quantity
+		dispense.Quantity = &models.FhirQuantity{Value: &quantity, Unit: hl7Text(item.Quantity_unit)}
+	}
+	if item.Duration_days > 0 {
+		days := float64(item.Duration_days)
+		dispense.Expected_supply_duration = &models.FhirQuantity{Value: &days, Unit: "days", System: "http://unitsofmeasure.org", Code: "d"}
+	}
+	if prescription.Start_Date != nil || prescription.End_Date != nil {
+		dispense.Validity_period = &models.FhirPeriod{}
+		if prescription.Start_Date != nil {
+			dispense.Validity_period.Start = helper.FhirInstant(*prescription.Start_Date)
+		}
+		if prescription.End_Date != nil {
+			dispense.Validity_period.End = helper.FhirInstant(*prescription.End_Date)
+		}
+	}
+	resource.Dispense_request = dispense
+	return resource
+}
+
+// fhirMedicationItem reads the prescription item of a resource: the drug from
+// the medication coding, its strength and form from the extensions, and the
+// first dosage instruction.
+func fhirMedicationItem(resource models.FhirMedicationRequest) (models.MedicationItem, string) {
+	var item models.MedicationItem
+	if resource.Medication_codeable_concept == nil || len(resource.Medication_codeable_concept.Coding) == 0 {
+		return item, "medicationCodeableConcept must code the formulary drug"
+	}
+	code := resource.Medication_codeable_concept.Coding[0].Code
+	item.Drug_code = &code
+	item.Strength = fhirExtensionString(resource.Extension, fhirStrengthExtension)
+	for _, extension := range resource.Extension {
+		if extension.Url == fhirFormExtension && extension.Value_code != nil {
+			form := strings.ToUpper(*extension.Value_code)
+			item.Form = &form
+		}
+	}
+
+	if len(resource.Dosage_instruction) > 0 {
+		dosage := resource.Dosage_instruction[0]
+		if dosage.Timing != nil && dosage.Timing.Code != nil {
+			frequency := dosage.Timing.Code.Text
+			if frequency == "" && len(dosage.Timing.Code.Coding) > 0 {
+				frequency = dosage.Timing.Code.Coding[0].Code
+			}
+			item.Frequency = &frequency
+		}
+		if dosage.Route != nil && len(dosage.Route.Coding) > 0 {
+			route := strings.ToUpper(dosage.Route.Coding[0].Code)
+			item.Route = &route
+		}
+		if dosage.As_needed_boolean != nil {
+			item.As_needed = *dosage.As_needed_boolean
+		}
+		if dosage.Patient_instruction != "" {
+			instructions := dosage.Patient_instruction
+			item.Instructions = &instructions
+		}
+	}
+
+	if dispense := resource.Dispense_request; dispense != nil {
+		item.Refills = dispense.Number_of_repeats_allowed
+		if dispense.Quantity != nil && dispense.Quantity.Value != nil {
+			item.Quantity = *dispense.Quantity.Value
+			if dispense.Quantity.Unit != "" {
+				unit := dispense.Quantity.Unit
+				item.Quantity_unit = &unit
+			}
+		}
+		if supply := dispense.Expected_supply_duration; supply != nil && supply.Value != nil {
+			if supply.Code != "" && supply.Code != "d" {
+				return item, "expectedSupplyDuration must be given in days"
+			}
+			item.Duration_days = int(*supply.Value)
+		}
+	}
+	return item, ""
+}
+
+// fhirValidity reads the prescription dates from the validity period.
+func fhirValidity(resource models.FhirMedicationRequest) (*time.Time, *time.Time, error) {
+	if resource.Dispense_request == nil || resource.Dispense_request.Validity_period == nil {
+		return nil, nil, nil
+	}
+	var start, end *time.Time
+	period := resource.Dispense_request.Validity_period
+	if period.Start != "" {
+		t, err := fhirTime(period.Start)
+		if err != nil {
+			return nil, nil, fmt.Errorf("validityPeriod.start: %v", err)
+		}
+		start = &t
+	}
+	if period.End != "" {
+		t, err := fhirTime(period.End)
+		if err != nil {
+			return nil, nil, fmt.Errorf("validityPeriod.end: %v", err)
+		}
+		end = &t
+	}
+	return start, end, nil
+}
+
+func fhirExtensionString(extensions []models.FhirExtension, url string) *string {
+	for _, extension := range extensions {
+		if extension.Url == url && extension.Value_string != nil {
+			return extension.Value_string
+		}
+	}
+	return nil
+}
+
+// sameMedicationItem reports whether an update leaves the prescribed item as
+// it is, so unchanged items of a dispensed prescription are not replaced.
+func sameMedicationItem(a models.MedicationItem, b models.MedicationItem) bool {
+	return hl7Text(a.Drug_code) == hl7Text(b.Drug_code) &&
+		hl7Text(a.Strength) == hl7Text(b.Strength) &&
+		hl7Text(a.Form) == hl7Text(b.Form) &&
+		hl7Text(a.Route) == hl7Text(b.Route) &&
+		hl7Text(a.Frequency) == hl7Text(b.Frequency) &&
+		hl7Text(a.Instructions) == hl7Text(b.Instructions) &&
+		hl7Text(a.Quantity_unit) == hl7Text(b.Quantity_unit) &&
+		a.As_needed == b.As_needed &&
+		a.Duration_days == b.Duration_days &&
+		a.Quantity == b.Quantity &&
+		a.Refills == b.Refills
+}
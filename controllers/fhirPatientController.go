@@ -0,0 +1,477 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func ReadFhirPatient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var patient models.Patient
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": c.Param("id")}).Decode(&patient); err != nil {
+			fhirError(c, http.StatusNotFound, "Patient/"+c.Param("id")+" not found")
+			return
+		}
+		fhirJSON(c, http.StatusOK, fhirPatient(patient))
+	}
+}
+
+// SearchFhirPatients searches patients by _id, name (given or family, from
+// the start), family, given, identifier, birthdate, email, phone and
+// general-practitioner.
+func SearchFhirPatients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		count, offset, ok := fhirSearchPage(c, "Patient")
+		if !ok {
+			return
+		}
+		conditions := []bson.M{}
+		if value := c.Query("_id"); value != "" {
+			conditions = append(conditions, bson.M{"patient_id": bson.M{"$in": strings.Split(value, ",")}})
+		}
+		if value := c.Query("name"); value != "" {
+			conditions = append(conditions, bson.M{"$or": []bson.M{
+				{"first_name": fhirStartsWith(value)},
+				{"last_name": fhirStartsWith(value)},
+			}})
+		}
+		if value := c.Query("family"); value != "" {
+			conditions = append(conditions, bson.M{"last_name": fhirStartsWith(value)})
+		}
+		if value := c.Query("given"); value != "" {
+			conditions = append(conditions, bson.M{"first_name": fhirStartsWith(value)})
+		}
+		if value := c.Query("identifier"); value != "" {
+			system, code := fhirToken(value)
+			switch system {
+			case fhirSystemBase + "patient":
+				conditions = append(conditions, bson.M{"patient_id": code})
+			case "":
+				conditions = append(conditions, bson.M{"$or": []bson.M{{"patient_id": code}, {"identifiers.value": code}}})
+			default:
+				conditions = append(conditions, bson.M{"identifiers": bson.M{"$elemMatch": bson.M{"system": system, "value": code}}})
+			}
+		}
+		if values := c.QueryArray("birthdate"); len(values) > 0 {
+			condition, err := fhirDateFilter("date_of_birth", values)
+			if err != nil {
+				fhirError(c, http.StatusBadRequest, "birthdate: "+err.Error())
+				return
+			}
+			conditions = append(conditions, condition)
+		}
+		if value := c.Query("email"); value != "" {
+			conditions = append(conditions, bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"}})
+		}
+		if value := c.Query("phone"); value != "" {
+			conditions = append(conditions, bson.M{"phone": value})
+		}
+		if value := c.Query("general-practitioner"); value != "" {
+			conditions = append(conditions, bson.M{"doctor_id": fhirReferenceId(value, "Practitioner")})
+		}
+
+		filter := bson.M{}
+		if len(conditions) > 0 {
+			filter["$and"] = conditions
+		}
+		total, err := patientCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the patients")
+			return
+		}
+		opts := options.Find().SetSort(bson.M{"_id": 1}).SetSkip(offset).SetLimit(count)
+		cursor, err := patientCollection.Find(ctx, filter, opts)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the patients")
+			return
+		}
+		var patients []models.Patient
+		if err = cursor.All(ctx, &patients); err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the patients")
+			return
+		}
+		entries := []models.FhirBundleEntry{}
+		for _, patient := range patients {
+			entries = append(entries, fhirEntry(c, "Patient", patient.Patient_id, fhirPatient(patient)))
+		}
+		fhirSearchset(c, total, count, offset, entries)
+	}
+}
+
+// CreateFhirPatient registers a patient through the checks of SignUp. The
+// patient has no password yet and signs in after a reset.
+func CreateFhirPatient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirPatient
+		if !fhirBind(c, &resource, "Patient", "") {
+			return
+		}
+		var patient models.Patient
+		if status, msg := applyFhirPatient(ctx, resource, &patient); msg != "" {
+			fhirError(c, status, msg)
+			return
+		}
+		password, err := randomPassword()
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while registering the patient")
+			return
+		}
+		patient.Password = &password
+		if status, msg := checkPatient(ctx, patient, ""); msg != "" {
+			fhirError(c, status, msg)
+			return
+		}
+		hashed := HashPassword(password)
+		patient.Password = &hashed
+		patient.Created_at = helper.Now()
+		patient.Updated_at = helper.Now()
+		patient.ID = primitive.NewObjectID()
+		patient.Patient_id = patient.ID.Hex()
+
+		if _, err := patientCollection.InsertOne(ctx, patient); err != nil {
+			fhirError(c, http.StatusInternalServerError, "patient was not created")
+			return
+		}
+		fhirCreated(c, "Patient", patient.Patient_id, fhirPatient(patient))
+	}
+}
+
+// UpdateFhirPatient replaces the patient's demographics with the resource.
+func UpdateFhirPatient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirPatient
+		if !fhirBind(c, &resource, "Patient", c.Param("id")) {
+			return
+		}
+		var patient models.Patient
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": c.Param("id")}).Decode(&patient); err != nil {
+			fhirError(c, http.StatusNotFound, "Patient/"+c.Param("id")+" not found")
+			return
+		}
+		if status, msg := applyFhirPatient(ctx, resource, &patient); msg != "" {
+			fhirError(c, status, msg)
+			return
+		}
+		if status, msg := checkPatient(ctx, patient, patient.Patient_id); msg != "" {
+			fhirError(c, status, msg)
+			return
+		}
+		patient.Updated_at = helper.Now()
+
+		_, err := patientCollection.UpdateOne(ctx, bson.M{"patient_id": patient.Patient_id}, bson.M{"$set": bson.M{
+			"first_name":    patient.First_name,
+			"last_name":     patient.Last_name,
+			"email":         patient.Email,
+			"phone":         patient.Phone,
+			"date_of_birth": patient.Date_of_birth,
+			"identifiers":   patient.Identifiers,
+			"doctor_id":     patient.Doctor_id,
+			"updated_at":    patient.Updated_at,
+		}})
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "patient update failed")
+			return
+		}
+		fhirJSON(c, http.StatusOK, fhirPatient(patient))
+	}
+}
+
+func fhirPatient(patient models.Patient) models.FhirPatient {
+	active := true
+	resource := models.FhirPatient{
+		Resource_type: "Patient",
+		Id:            patient.Patient_id,
+		Meta:          fhirMeta(patient.Updated_at),
+		Active:        &active,
+		Identifier:    []models.FhirIdentifier{{Use: "usual", System: fhirSystemBase + "patient", Value: patient.Patient_id}},
+	}
+	for _, identifier := range patient.Identifiers {
+		resource.Identifier = append(resource.Identifier, models.FhirIdentifier{System: *identifier.System, Value: *identifier.Value})
+	}
+	name := models.FhirHumanName{Use: "official"}
+	if patient.Last_name != nil {
+		name.Family = *patient.Last_name
+	}
+	if patient.First_name != nil {
+		name.Given = []string{*patient.First_name}
+	}
+	resource.Name = []models.FhirHumanName{name}
+	if patient.Phone != nil {
+		resource.Telecom = append(resource.Telecom, models.FhirContactPoint{System: "phone", Value: *patient.Phone})
+	}
+	if patient.Email != nil {
+		resource.Telecom = append(resource.Telecom, models.FhirContactPoint{System: "email", Value: *patient.Email})
+	}
+	if patient.Date_of_birth != nil {
+		resource.Birth_date = helper.FhirDate(*patient.Date_of_birth)
+	}
+	if patient.Doctor_id != "" {
+		resource.General_practitioner = []models.FhirReference{{Reference: "Practitioner/" + patient.Doctor_id}}
+	}
+	return resource
+}
+
+// applyFhirPatient copies the resource onto the patient: the official name
+// (or the first), the first phone and email, the birth date, the other
+// systems' identifiers and the general practitioner. A non-empty message is
+// the problem, with its status.
+func applyFhirPatient(ctx context.Context, resource models.FhirPatient, patient *models.Patient) (int, string) {
+	if len(resource.Name) > 0 {
+		name := resource.Name[0]
+		for _, candidate := range resource.Name {
+			if candidate.Use == "official" {
+				name = candidate
+			}
+		}
+		family, given := name.Family, strings.Join(name.Given, " ")
+		patient.Last_name, patient.First_name = &family, &given
+	}
+	patient.Phone, patient.Email = nil, nil
+	for _, telecom := range resource.Telecom {
+		value := telecom.Value
+		if telecom.System == "phone" && patient.Phone == nil {
+			patient.Phone = &value
+		}
+		if telecom.System == "email" && patient.Email == nil {
+			patient.Email = &value
+		}
+	}
+	patient.Date_of_birth = nil
+	if resource.Birth_date != "" {
+		birth, err := time.ParseInLocation("2006-01-02", resource.Birth_date, helper.FacilityLocation())
+		if err != nil {
+			return http.StatusBadRequest, "birthDate must be a full date"
+		}
+		birth = birth.UTC()
+		patient.Date_of_birth = &birth
+	}
+	patient.Identifiers = []models.PatientIdentifier{}
+	for _, identifier := range resource.Identifier {
+		if identifier.System == fhirSystemBase+"patient" {
+			continue
+		}
+		if identifier.System == "" || identifier.Value == "" {
+			return http.StatusBadRequest, "identifiers need a system and a value"
+		}
+		patient.Identifiers = appendIdentifier(patient.Identifiers, identifier.System, identifier.Value)
+	}
+	patient.Doctor_id = ""
+	if len(resource.General_practitioner) > 0 {
+		doctorId := fhirReference(&resource.General_practitioner[0], "Practitioner")
+		if doctorId == "" {
+			return http.StatusBadRequest, "generalPractitioner must reference a Practitioner"
+		}
+		count, err := doctorCollection.CountDocuments(ctx, bson.M{"doctor_id": doctorId})
+		if err != nil {
+			return http.StatusInternalServerError, "error occured while reading the practitioner"
+		}
+		if count == 0 {
+			return http.StatusBadRequest, fmt.Sprintf("Practitioner/%s not found", doctorId)
+		}
+		patient.Doctor_id = doctorId
+	}
+	return 0, ""
+}
+
+func ReadFhirPractitioner() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var doctor models.Doctor
+		if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": c.Param("id")}).Decode(&doctor); err != nil {
+			fhirError(c, http.StatusNotFound, "Practitioner/"+c.Param("id")+" not found")
+			return
+		}
+		fhirJSON(c, http.StatusOK, fhirPractitioner(doctor))
+	}
+}
+
+// SearchFhirPractitioners searches doctors by _id, name (any word, from its
+// start) and identifier.
+func SearchFhirPractitioners() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		count, offset, ok := fhirSearchPage(c, "Practitioner")
+		if !ok {
+			return
+		}
+		conditions := []bson.M{}
+		if value := c.Query("_id"); value != "" {
+			conditions = append(conditions, bson.M{"doctor_id": bson.M{"$in": strings.Split(value, ",")}})
+		}
+		if value := c.Query("name"); value != "" {
+			conditions = append(conditions, bson.M{"name": primitive.Regex{Pattern: `(^|\s)` + regexp.QuoteMeta(value), Options: "i"}})
+		}
+		if value := c.Query("identifier"); value != "" {
+			system, code := fhirToken(value)
+			if system != "" && system != fhirSystemBase+"practitioner" {
+				code = ""
+			}
+			conditions = append(conditions, bson.M{"doctor_id": code})
+		}
+
+		filter := bson.M{}
+		if len(conditions) > 0 {
+			filter["$and"] = conditions
+		}
+		total, err := doctorCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the practitioners")
+			return
+		}
+		opts := options.Find().SetSort(bson.M{"_id": 1}).SetSkip(offset).SetLimit(count)
+		cursor, err := doctorCollection.Find(ctx, filter, opts)
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the practitioners")
+			return
+		}
+		var doctors []models.Doctor
+		if err = cursor.All(ctx, &doctors); err != nil {
+			fhirError(c, http.StatusInternalServerError, "error occured while searching the practitioners")
+			return
+		}
+		entries := []models.FhirBundleEntry{}
+		for _, doctor := range doctors {
+			entries = append(entries, fhirEntry(c, "Practitioner", doctor.Doctor_id, fhirPractitioner(doctor)))
+		}
+		fhirSearchset(c, total, count, offset, entries)
+	}
+}
+
+func CreateFhirPractitioner() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirPractitioner
+		if !fhirBind(c, &resource, "Practitioner", "") {
+			return
+		}
+		var doctor models.Doctor
+		applyFhirPractitioner(resource, &doctor)
+		if validationErr := validate.Struct(doctor); validationErr != nil {
+			fhirError(c, http.StatusBadRequest, validationErr.Error())
+			return
+		}
+		doctor.Created_at = helper.Now()
+		doctor.Updated_at = helper.Now()
+		doctor.ID = primitive.NewObjectID()
+		doctor.Doctor_id = doctor.ID.Hex()
+
+		if _, err := doctorCollection.InsertOne(ctx, doctor); err != nil {
+			fhirError(c, http.StatusInternalServerError, "doctor was not created")
+			return
+		}
+		fhirCreated(c, "Practitioner", doctor.Doctor_id, fhirPractitioner(doctor))
+	}
+}
+
+func UpdateFhirPractitioner() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.FhirPractitioner
+		if !fhirBind(c, &resource, "Practitioner", c.Param("id")) {
+			return
+		}
+		var doctor models.Doctor
+		if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": c.Param("id")}).Decode(&doctor); err != nil {
+			fhirError(c, http.StatusNotFound, "Practitioner/"+c.Param("id")+" not found")
+			return
+		}
+		applyFhirPractitioner(resource, &doctor)
+		if validationErr := validate.Struct(doctor); validationErr != nil {
+			fhirError(c, http.StatusBadRequest, validationErr.Error())
+			return
+		}
+		doctor.Updated_at = helper.Now()
+
+		err := doctorCollection.FindOneAndUpdate(ctx, bson.M{"doctor_id": doctor.Doctor_id}, bson.M{"$set": bson.M{
+			"name":       doctor.Name,
+			"speciality": doctor.Speciality,
+			"updated_at": doctor.Updated_at,
+		}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doctor)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			fhirError(c, http.StatusNotFound, "Practitioner/"+c.Param("id")+" not found")
+			return
+		}
+		if err != nil {
+			fhirError(c, http.StatusInternalServerError, "Doctor update failed")
+			return
+		}
+		fhirJSON(c, http.StatusOK, fhirPractitioner(doctor))
+	}
+}
+
+// fhirPractitioner presents a doctor. The doctor's one name field is the
+// name's text, the speciality its qualification.
+func fhirPractitioner(doctor models.Doctor) models.FhirPractitioner {
+	active := true
+	resource := models.FhirPractitioner{
+		Resource_type: "Practitioner",
+		Id:            doctor.Doctor_id,
+		Meta:          fhirMeta(doctor.Updated_at),
+		Active:        &active,
+		Identifier:    []models.FhirIdentifier{{Use: "usual", System: fhirSystemBase + "practitioner", Value: doctor.Doctor_id}},
+	}
+	if doctor.Name != nil {
+		resource.Name = []models.FhirHumanName{{Text: *doctor.Name}}
+	}
+	if doctor.Speciality != nil {
+		resource.Qualification = []models.FhirQualification{{Code: models.FhirCodeableConcept{Text: *doctor.Speciality}}}
+	}
+	return resource
+}
+
+// applyFhirPractitioner copies the name (its text, or given and family
+// names) and the first qualification onto the doctor.
+func applyFhirPractitioner(resource models.FhirPractitioner, doctor *models.Doctor) {
+	if len(resource.Name) > 0 {
+		name := resource.Name[0]
+		text := name.Text
+		if text == "" {
+			text = strings.TrimSpace(strings.Join(append(name.Given, name.Family), " "))
+		}
+		doctor.Name = &text
+	}
+	if len(resource.Qualification) > 0 {
+		code := resource.Qualification[0].Code
+		speciality := code.Text
+		if speciality == "" && len(code.Coding) > 0 {
+			speciality = code.Coding[0].Display
+			if speciality == "" {
+				speciality = code.Coding[0].Code
+			}
+		}
+		doctor.Speciality = &speciality
+	}
+}
@@ -0,0 +1,353 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var formularyCollection *mongo.Collection = database.OpenCollection(database.Client, "formulary")
+
+const (
+	matchPrefix = "PREFIX"
+	matchFuzzy  = "FUZZY"
+)
+
+// FormularyMatch is one search result with how it matched the query.
+type FormularyMatch struct {
+	models.Drug
+	Match    string `json:"match"`
+	Distance int    `json:"distance"`
+}
+
+// FormularyImport reports what an import did. Rejected records are skipped,
+// the rest of the dataset is still imported.
+type FormularyImport struct {
+	Imported int                  `json:"imported"`
+	Updated  int                  `json:"updated"`
+	Rejected []FormularyRejection `json:"rejected"`
+}
+
+type FormularyRejection struct {
+	Line  int    `json:"line"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// SearchFormulary is the typeahead search. Names starting with the query come
+// first; when there are too few, names within a typo or two are added.
+func SearchFormulary() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		query := helper.SearchName(c.Query("q"))
+		if len([]rune(query)) < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q must have at least 2 characters"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 || limit > 50 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+			return
+		}
+
+		cursor, err := formularyCollection.Find(ctx, bson.M{
+			"active":       true,
+			"search_names": bson.M{"$regex": "(^| )" + regexp.QuoteMeta(query)},
+		}, options.Find().SetLimit(int64(limit)).SetSort(bson.M{"generic_name": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while searching the formulary"})
+			return
+		}
+		var found []models.Drug
+		if err = cursor.All(ctx, &found); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while searching the formulary"})
+			return
+		}
+
+		matches := []FormularyMatch{}
+		seen := map[string]bool{}
+		for _, drug := range found {
+			matches = append(matches, FormularyMatch{Drug: drug, Match: matchPrefix})
+			seen[drug.Drug_id] = true
+		}
+
+		if len(matches) < limit && len([]rune(query)) >= 3 {
+			fuzzy, err := fuzzyFormularyMatches(ctx, query, seen)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while searching the formulary"})
+				return
+			}
+			for _, match := range fuzzy {
+				if len(matches) >= limit {
+					break
+				}
+				matches = append(matches, match)
+			}
+		}
+		c.JSON(http.StatusOK, matches)
+	}
+}
+
+// fuzzyFormularyMatches scans the active formulary for names within the
+// allowed number of edits of the query, closest first.
+func fuzzyFormularyMatches(ctx context.Context, query string, skip map[string]bool) ([]FormularyMatch, error) {
+	allowed := 1
+	if len([]rune(query)) > 5 {
+		allowed = 2
+	}
+
+	cursor, err := formularyCollection.Find(ctx, bson.M{"active": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var matches []FormularyMatch
+	for cursor.Next(ctx) {
+		var drug models.Drug
+		if err := cursor.Decode(&drug); err != nil {
+			return nil, err
+		}
+		if skip[drug.Drug_id] {
+			continue
+		}
+		best := allowed + 1
+		for _, name := range drug.Search_names {
+			if d := helper.PrefixDistance(query, name); d < best {
+				best = d
+			}
+		}
+		if best <= allowed {
+			matches = append(matches, FormularyMatch{Drug: drug, Match: matchFuzzy, Distance: best})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return *matches[i].Generic_name < *matches[j].Generic_name
+	})
+	return matches, nil
+}
+
+func GetDrug() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var drug models.Drug
+		if err := formularyCollection.FindOne(ctx, bson.M{"drug_id": c.Param("drug_id")}).Decode(&drug); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "drug not found in the formulary"})
+			return
+		}
+		c.JSON(http.StatusOK, drug)
+	}
+}
+
+// UploadFormulary imports a drug dataset sent as described by datasetUpload.
+func UploadFormulary() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 300*time.Second)
+		defer cancel()
+
+		body, format, ok := datasetUpload(c)
+		if !ok {
+			return
+		}
+		defer body.Close()
+
+		records, err := helper.ParseFormulary(body, format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := ImportFormulary(ctx, records)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "formulary import failed: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// datasetUpload finds a dataset sent as the "file" form field, its format
+// from the file name, or as the request body with a text/csv or
+// application/json content type. On failure the response has been written.
+func datasetUpload(c *gin.Context) (io.ReadCloser, string, bool) {
+	switch {
+	case strings.HasPrefix(c.ContentType(), "multipart/"):
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the dataset must be sent as the file field"})
+			return nil, "", false
+		}
+		opened, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, "", false
+		}
+		return opened, strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), "."), true
+	case c.ContentType() == "text/csv":
+		return c.Request.Body, "csv", true
+	case c.ContentType() == "application/json":
+		return c.Request.Body, "json", true
+	}
+	return c.Request.Body, "", true
+}
+
+// ImportFormulary adds or updates the records, matched on their code. It is
+// used by the upload endpoint and the formularyimport command.
+func ImportFormulary(ctx context.Context, records []helper.FormularyRecord) (FormularyImport, error) {
+	result := FormularyImport{Rejected: []FormularyRejection{}}
+
+	_, err := formularyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "drug_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "search_names", Value: 1}}},
+	})
+	if err != nil {
+		return result, err
+	}
+
+	now := helper.Now()
+	for _, record := range records {
+		drug := drugFromRecord(record)
+		if validationErr := validate.Struct(drug); validationErr != nil {
+			result.Rejected = append(result.Rejected, FormularyRejection{Line: record.Line, Code: record.Code, Error: validationErr.Error()})
+			continue
+		}
+
+		update, err := formularyCollection.UpdateOne(ctx, bson.M{"drug_id": drug.Drug_id}, bson.M{
+			"$set": bson.M{
+				"generic_name": drug.Generic_name,
+				"brand_names":  drug.Brand_names,
+				"atc_code":     drug.Atc_code,
+				"forms":        drug.Forms,
+				"strengths":    drug.Strengths,
+				"schedule":     drug.Schedule,
+				"search_names": drug.Search_names,
+				"active":       true,
+				"updated_at":   now,
+			},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+		}, options.Update().SetUpsert(true))
+		if err != nil {
+			return result, fmt.Errorf("line %d: %v", record.Line, err)
+		}
+		if update.UpsertedCount > 0 {
+			result.Imported++
+		} else {
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+func drugFromRecord(record helper.FormularyRecord) models.Drug {
+	drug := models.Drug{
+		Drug_id:      record.Code,
+		Brand_names:  record.Brand_names,
+		Forms:        record.Forms,
+		Strengths:    record.Strengths,
+		Search_names: []string{},
+	}
+	if drug.Brand_names == nil {
+		drug.Brand_names = []string{}
+	}
+	if record.Generic_name != "" {
+		drug.Generic_name = &record.Generic_name
+		drug.Search_names = append(drug.Search_names, helper.SearchName(record.Generic_name))
+	}
+	for _, brand := range record.Brand_names {
+		drug.Search_names = append(drug.Search_names, helper.SearchName(brand))
+	}
+	if record.Atc_code != "" {
+		atc := strings.ToUpper(record.Atc_code)
+		drug.Atc_code = &atc
+	}
+	schedule := "NONE"
+	if record.Schedule != "" {
+		schedule = record.Schedule
+	}
+	drug.Schedule = &schedule
+	return drug
+}
+
+// checkFormularyItems validates prescription items against the formulary and
+// fills in each item's drug name and schedule. A non-empty message is a
+// problem with the request.
+func checkFormularyItems(ctx context.Context, items []models.MedicationItem) (string, error) {
+	for i := range items {
+		item := &items[i]
+
+		var drug models.Drug
+		err := formularyCollection.FindOne(ctx, bson.M{"drug_id": item.Drug_code}).Decode(&drug)
+		if err == mongo.ErrNoDocuments || (err == nil && !drug.Active) {
+			return fmt.Sprintf("item %d: %s is not in the formulary", i+1, *item.Drug_code), nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if !containsString(drug.Forms, *item.Form) {
+			return fmt.Sprintf("item %d: %s is available as %s", i+1, *drug.Generic_name, strings.Join(drug.Forms, ", ")), nil
+		}
+		strengthListed := false
+		for _, strength := range drug.Strengths {
+			if helper.NormalizeStrength(strength) == helper.NormalizeStrength(*item.Strength) {
+				strengthListed = true
+				break
+			}
+		}
+		if !strengthListed {
+			return fmt.Sprintf("item %d: %s comes in %s", i+1, *drug.Generic_name, strings.Join(drug.Strengths, ", ")), nil
+		}
+
+		item.Drug_name = drug.Generic_name
+		item.Schedule = drug.Schedule
+	}
+	return "", nil
+}
+
+// checkFormularyCode checks a drug code, when given, is in the formulary.
+func checkFormularyCode(ctx context.Context, drugCode *string) string {
+	if drugCode == nil {
+		return ""
+	}
+	count, err := formularyCollection.CountDocuments(ctx, bson.M{"drug_id": drugCode})
+	if err != nil || count == 0 {
+		return *drugCode + " is not in the formulary"
+	}
+	return ""
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
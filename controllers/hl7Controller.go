@@ -0,0 +1,921 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var hl7MessageCollection *mongo.Collection = database.OpenCollection(database.Client, "hl7Message")
+var admissionCollection *mongo.Collection = database.OpenCollection(database.Client, "admission")
+
+const (
+	hl7Inbound  = "IN"
+	hl7Outbound = "OUT"
+
+	hl7Received  = "RECEIVED"
+	hl7Processed = "PROCESSED"
+	hl7Error     = "ERROR"
+	hl7Rejected  = "REJECTED"
+	hl7Duplicate = "DUPLICATE"
+
+	hl7Pending  = "PENDING"
+	hl7Retrying = "RETRYING"
+	hl7Sent     = "SENT"
+	hl7Failed   = "FAILED"
+
+	maxHL7Attempts = 5
+
+	admissionAdmitted   = "ADMITTED"
+	admissionDischarged = "DISCHARGED"
+)
+
+// hl7IdleTimeout closes listener connections that stay silent this long.
+const hl7IdleTimeout = 10 * time.Minute
+
+// hl7Mu serialises inbound processing, so two messages about a new patient
+// arriving on different connections cannot both create them.
+var hl7Mu sync.Mutex
+
+// hl7Reject is a message the interface does not take at all, answered with
+// AR rather than AE.
+type hl7Reject struct {
+	text string
+}
+
+func (r hl7Reject) Error() string {
+	return r.text
+}
+
+// patientClasses maps PV1-2 to the admission's patient class.
+var patientClasses = map[string]string{
+	"I": "INPATIENT",
+	"O": "OUTPATIENT",
+	"E": "EMERGENCY",
+	"P": "PREADMIT",
+	"R": "RECURRING",
+	"B": "OBSTETRICS",
+}
+
+// StartHL7Listener accepts MLLP connections on HL7_LISTEN_ADDR (e.g.
+// ":2575") until ctx is done. Without the variable the listener is off.
+func StartHL7Listener(ctx context.Context) {
+	addr := os.Getenv("HL7_LISTEN_ADDR")
+	if addr == "" {
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("hl7: %v", err)
+		return
+	}
+	log.Printf("hl7: listening on %s", addr)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("hl7: %v", err)
+				}
+				return
+			}
+			go serveHL7(conn)
+		}
+	}()
+}
+
+// serveHL7 answers each message of a connection with its acknowledgement.
+func serveHL7(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(hl7IdleTimeout))
+		raw, err := helper.ReadMLLP(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Printf("hl7: %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		ack, _ := receiveHL7(raw, conn.RemoteAddr().String(), nil)
+		conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		if err = helper.WriteMLLP(conn, ack); err != nil {
+			log.Printf("hl7: %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// receiveHL7 logs, processes and acknowledges one inbound message. A message
+// whose control id was already processed for the same sender is acknowledged
+// again without being applied twice, unless it is a replay.
+func receiveHL7(raw []byte, peer string, replayOf *string) ([]byte, models.HL7Message) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	hl7Mu.Lock()
+	defer hl7Mu.Unlock()
+
+	entry := models.HL7Message{
+		ID:         primitive.NewObjectID(),
+		Direction:  hl7Inbound,
+		Peer:       peer,
+		Raw:        string(raw),
+		Status:     hl7Received,
+		Replay_of:  replayOf,
+		Created_at: helper.Now(),
+		Updated_at: helper.Now(),
+	}
+	entry.Message_id = entry.ID.Hex()
+
+	msg, parseErr := helper.ParseHL7(raw)
+	if parseErr != nil {
+		ack := helper.BuildHL7Ack(nil, "AR", parseErr.Error())
+		entry.Status, entry.Error = hl7Rejected, parseErr.Error()
+		entry.Ack_code, entry.Ack = "AR", string(ack)
+		if _, err := hl7MessageCollection.InsertOne(ctx, entry); err != nil {
+			log.Printf("hl7: %v", err)
+		}
+		return ack, entry
+	}
+	code, trigger := msg.Type()
+	msh := msg.Segment("MSH")
+	entry.Message_type = code + "^" + trigger
+	entry.Control_id = msg.ControlId()
+	entry.Sender = msh.Component(3, 1) + "^" + msh.Component(4, 1)
+
+	if replayOf == nil && entry.Control_id != "" {
+		var previous models.HL7Message
+		err := hl7MessageCollection.FindOne(ctx, bson.M{
+			"direction":  hl7Inbound,
+			"sender":     entry.Sender,
+			"control_id": entry.Control_id,
+			"status":     hl7Processed,
+		}).Decode(&previous)
+		if err == nil {
+			ack := helper.BuildHL7Ack(msg, "AA", "")
+			entry.Status, entry.Error = hl7Duplicate, "already processed as "+previous.Message_id
+			entry.Ack_code, entry.Ack = "AA", string(ack)
+			entry.Patient_id, entry.Reference_id = previous.Patient_id, previous.Reference_id
+			if _, err := hl7MessageCollection.InsertOne(ctx, entry); err != nil {
+				log.Printf("hl7: %v", err)
+			}
+			return ack, entry
+		}
+	}
+
+	if _, err := hl7MessageCollection.InsertOne(ctx, entry); err != nil {
+		// without the log entry the message cannot be replayed, so the
+		// sender is asked to send it again
+		log.Printf("hl7: %v", err)
+		return helper.BuildHL7Ack(msg, "AE", "message could not be logged"), entry
+	}
+
+	var processErr error
+	switch {
+	case code == "ADT" && (trigger == "A01" || trigger == "A03" || trigger == "A04" || trigger == "A08"):
+		entry.Patient_id, entry.Reference_id, processErr = processADT(ctx, msg, trigger, entry)
+	case code == "ORU" && trigger == "R01":
+		entry.Patient_id, entry.Reference_id, processErr = processORU(ctx, msg, entry)
+	default:
+		processErr = hl7Reject{fmt.Sprintf("message type %s is not supported", entry.Message_type)}
+	}
+
+	ackCode := "AA"
+	var reject hl7Reject
+	switch {
+	case errors.As(processErr, &reject):
+		ackCode, entry.Status, entry.Error = "AR", hl7Rejected, processErr.Error()
+	case processErr != nil:
+		ackCode, entry.Status, entry.Error = "AE", hl7Error, processErr.Error()
+	default:
+		entry.Status, entry.Error = hl7Processed, ""
+	}
+	ack := helper.BuildHL7Ack(msg, ackCode, entry.Error)
+	entry.Ack_code, entry.Ack = ackCode, string(ack)
+	entry.Updated_at = helper.Now()
+
+	_, err := hl7MessageCollection.UpdateOne(ctx, bson.M{"message_id": entry.Message_id}, bson.M{"$set": bson.M{
+		"status":       entry.Status,
+		"error":        entry.Error,
+		"ack_code":     entry.Ack_code,
+		"ack":          entry.Ack,
+		"patient_id":   entry.Patient_id,
+		"reference_id": entry.Reference_id,
+		"updated_at":   entry.Updated_at,
+	}})
+	if err != nil {
+		log.Printf("hl7: %v", err)
+	}
+	return ack, entry
+}
+
+// processADT applies an admit (A01), discharge (A03), registration (A04) or
+// patient update (A08). It returns the patient and the admission touched.
+func processADT(ctx context.Context, msg *helper.HL7Message, trigger string, entry models.HL7Message) (string, string, error) {
+	patient, err := upsertHL7Patient(ctx, msg, trigger != "A03")
+	if err != nil {
+		return "", "", err
+	}
+
+	var admission *models.Admission
+	switch trigger {
+	case "A01":
+		admission, err = admitHL7(ctx, msg, patient, entry.Message_id)
+	case "A03":
+		admission, err = dischargeHL7(ctx, msg, patient)
+	case "A08":
+		admission, err = transferHL7(ctx, msg, patient)
+	}
+	if err != nil || admission == nil {
+		return patient.Patient_id, "", err
+	}
+	return patient.Patient_id, admission.Admission_id, nil
+}
+
+// upsertHL7Patient finds the patient of PID-3 and brings them up to date
+// with the message, or registers them when allowed. An identifier assigned
+// by this facility is our Patient_id; any other is matched against the
+// patient's identifiers. Both paths go through the checks of SignUp.
+func upsertHL7Patient(ctx context.Context, msg *helper.HL7Message, allowCreate bool) (models.Patient, error) {
+	var patient models.Patient
+	if !msg.Has("PID") {
+		return patient, errors.New("PID segment is missing")
+	}
+	pid := msg.Segment("PID")
+	_, facility := helper.HL7Facility()
+	msh := msg.Segment("MSH")
+	defaultSystem := msh.Component(4, 1)
+	if defaultSystem == "" {
+		defaultSystem = msh.Component(3, 1)
+	}
+
+	ownId := ""
+	identifiers := []models.PatientIdentifier{}
+	// PID-2 and PID-4 are retained for older senders that still fill them
+	for _, field := range []int{3, 2, 4} {
+		for _, rep := range pid.Repetitions(field) {
+			value := msg.RepComponent(rep, 1)
+			system := msg.RepComponent(rep, 4)
+			if value == "" {
+				continue
+			}
+			if strings.EqualFold(system, facility) {
+				ownId = value
+				continue
+			}
+			if system == "" {
+				system = defaultSystem
+			}
+			identifiers = appendIdentifier(identifiers, system, value)
+		}
+	}
+	if ownId == "" && len(identifiers) == 0 {
+		return patient, errors.New("PID-3 has no patient identifier")
+	}
+
+	found := false
+	if ownId != "" {
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": ownId}).Decode(&patient); err != nil {
+			return patient, fmt.Errorf("patient %s not found", ownId)
+		}
+		found = true
+	} else {
+		for _, identifier := range identifiers {
+			err := patientCollection.FindOne(ctx, bson.M{"identifiers": bson.M{"$elemMatch": bson.M{
+				"system": identifier.System,
+				"value":  identifier.Value,
+			}}}).Decode(&patient)
+			if err == nil {
+				found = true
+				break
+			}
+			if err != mongo.ErrNoDocuments {
+				return patient, errors.New("error occured while looking up the patient")
+			}
+		}
+	}
+	if !found && !allowCreate {
+		return patient, errors.New("patient not found")
+	}
+
+	if family := hl7Value(pid.Component(5, 1)); family != "" {
+		patient.Last_name = &family
+	}
+	if given := hl7Value(pid.Component(5, 2)); given != "" {
+		patient.First_name = &given
+	}
+	if birth := hl7Value(pid.Field(7)); birth != "" {
+		dob, err := helper.ParseHL7Time(birth)
+		if err != nil {
+			return patient, fmt.Errorf("PID-7: %v", err)
+		}
+		patient.Date_of_birth = &dob
+	}
+	phone, email := "", ""
+	for _, field := range []int{13, 14} {
+		for _, rep := range pid.Repetitions(field) {
+			if address := hl7Value(msg.RepComponent(rep, 4)); address != "" && email == "" {
+				email = address
+			}
+			number := hl7Value(msg.RepComponent(rep, 1))
+			if number == "" {
+				number = hl7Value(msg.RepComponent(rep, 12))
+			}
+			if number != "" && phone == "" && msg.RepComponent(rep, 2) != "NET" {
+				phone = number
+			}
+		}
+	}
+	if phone != "" {
+		patient.Phone = &phone
+	}
+	if email != "" {
+		patient.Email = &email
+	}
+	for _, identifier := range identifiers {
+		patient.Identifiers = appendIdentifier(patient.Identifiers, *identifier.System, *identifier.Value)
+	}
+	patient.Updated_at = helper.Now()
+
+	if found {
+		if _, msg := checkPatient(ctx, patient, patient.Patient_id); msg != "" {
+			return patient, errors.New(msg)
+		}
+		_, err := patientCollection.UpdateOne(ctx, bson.M{"patient_id": patient.Patient_id}, bson.M{"$set": bson.M{
+			"first_name":    patient.First_name,
+			"last_name":     patient.Last_name,
+			"email":         patient.Email,
+			"phone":         patient.Phone,
+			"date_of_birth": patient.Date_of_birth,
+			"identifiers":   patient.Identifiers,
+			"updated_at":    patient.Updated_at,
+		}})
+		if err != nil {
+			return patient, errors.New("patient update failed")
+		}
+		return patient, nil
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		return patient, errors.New("error occured while registering the patient")
+	}
+	patient.Password = &password
+	if _, msg := checkPatient(ctx, patient, ""); msg != "" {
+		return patient, errors.New(msg)
+	}
+	hashed := HashPassword(password)
+	patient.Password = &hashed
+	patient.Created_at = helper.Now()
+	patient.ID = primitive.NewObjectID()
+	patient.Patient_id = patient.ID.Hex()
+	if _, err := patientCollection.InsertOne(ctx, patient); err != nil {
+		return patient, errors.New("patient was not created")
+	}
+	return patient, nil
+}
+
+// admitHL7 opens the admission of PV1-19, or updates it when the HIS sends
+// the admit again. The attending doctor, when known here, becomes the
+// patient's doctor, who is the one alerted about deterioration.
+func admitHL7(ctx context.Context, msg *helper.HL7Message, patient models.Patient, messageId string) (*models.Admission, error) {
+	if !msg.Has("PV1") {
+		return nil, errors.New("PV1 segment is missing")
+	}
+	pv1 := msg.Segment("PV1")
+
+	admission := models.Admission{Patient_id: patient.Patient_id}
+	visit := pv1.Component(19, 1)
+	if visit != "" {
+		admission.Visit_number = &visit
+		err := admissionCollection.FindOne(ctx, bson.M{"patient_id": patient.Patient_id, "visit_number": visit}).Decode(&admission)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, errors.New("error occured while reading the admission")
+		}
+	}
+	if admission.Admission_id == "" {
+		admission.ID = primitive.NewObjectID()
+		admission.Admission_id = admission.ID.Hex()
+		admission.Created_at = helper.Now()
+	}
+
+	class := pv1.Field(2)
+	admission.Patient_class = class
+	if mapped, ok := patientClasses[strings.ToUpper(class)]; ok {
+		admission.Patient_class = mapped
+	}
+	if admission.Patient_class == "" {
+		admission.Patient_class = patientClasses["I"]
+	}
+	setHL7Location(msg, &admission)
+	if err := setHL7Attending(ctx, msg, &admission); err != nil {
+		return nil, err
+	}
+	admission.Admitted_at = hl7EventTime(msg, 44)
+	admission.Status = admissionAdmitted
+	admission.Discharged_at = nil
+	admission.Source_message_id = messageId
+	admission.Updated_at = helper.Now()
+
+	_, err := admissionCollection.ReplaceOne(ctx, bson.M{"admission_id": admission.Admission_id}, admission, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, errors.New("admission was not saved")
+	}
+	if admission.Doctor_id != nil {
+		_, err = patientCollection.UpdateOne(ctx, bson.M{"patient_id": patient.Patient_id}, bson.M{"$set": bson.M{"doctor_id": admission.Doctor_id}})
+		if err != nil {
+			return nil, errors.New("admission was saved but the patient's doctor was not updated")
+		}
+	}
+	return &admission, nil
+}
+
+// dischargeHL7 closes the admission of PV1-19, or the patient's open one
+// when the HIS leaves the visit number out.
+func dischargeHL7(ctx context.Context, msg *helper.HL7Message, patient models.Patient) (*models.Admission, error) {
+	admission, err := findHL7Admission(ctx, msg, patient)
+	if err != nil {
+		return nil, err
+	}
+	if admission == nil {
+		return nil, errors.New("the patient has no open admission")
+	}
+	dischargedAt := hl7EventTime(msg, 45)
+	admission.Status = admissionDischarged
+	admission.Discharged_at = &dischargedAt
+	admission.Updated_at = helper.Now()
+	_, err = admissionCollection.UpdateOne(ctx, bson.M{"admission_id": admission.Admission_id}, bson.M{"$set": bson.M{
+		"status":        admission.Status,
+		"discharged_at": admission.Discharged_at,
+		"updated_at":    admission.Updated_at,
+	}})
+	if err != nil {
+		return nil, errors.New("admission update failed")
+	}
+	return admission, nil
+}
+
+// transferHL7 applies the location and attending of an A08 to the open
+// admission, if there is one.
+func transferHL7(ctx context.Context, msg *helper.HL7Message, patient models.Patient) (*models.Admission, error) {
+	if !msg.Has("PV1") {
+		return nil, nil
+	}
+	admission, err := findHL7Admission(ctx, msg, patient)
+	if err != nil || admission == nil {
+		return nil, err
+	}
+	setHL7Location(msg, admission)
+	if err = setHL7Attending(ctx, msg, admission); err != nil {
+		return nil, err
+	}
+	admission.Updated_at = helper.Now()
+	_, err = admissionCollection.UpdateOne(ctx, bson.M{"admission_id": admission.Admission_id}, bson.M{"$set": bson.M{
+		"ward":           admission.Ward,
+		"room":           admission.Room,
+		"bed":            admission.Bed,
+		"doctor_id":      admission.Doctor_id,
+		"attending_name": admission.Attending_name,
+		"updated_at":     admission.Updated_at,
+	}})
+	if err != nil {
+		return nil, errors.New("admission update failed")
+	}
+	return admission, nil
+}
+
+// findHL7Admission is the open admission of PV1-19, or the patient's latest
+// open one without a visit number; nil when there is none.
+func findHL7Admission(ctx context.Context, msg *helper.HL7Message, patient models.Patient) (*models.Admission, error) {
+	filter := bson.M{"patient_id": patient.Patient_id, "status": admissionAdmitted}
+	if visit := msg.Segment("PV1").Component(19, 1); visit != "" {
+		filter["visit_number"] = visit
+	}
+	var admission models.Admission
+	err := admissionCollection.FindOne(ctx, filter, options.FindOne().SetSort(bson.M{"admitted_at": -1})).Decode(&admission)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("error occured while reading the admission")
+	}
+	return &admission, nil
+}
+
+// setHL7Location copies PV1-3 (point of care^room^bed) when it is sent.
+func setHL7Location(msg *helper.HL7Message, admission *models.Admission) {
+	pv1 := msg.Segment("PV1")
+	if pv1.Field(3) == "" {
+		return
+	}
+	ward, room, bed := hl7Value(pv1.Component(3, 1)), hl7Value(pv1.Component(3, 2)), hl7Value(pv1.Component(3, 3))
+	admission.Ward, admission.Room, admission.Bed = optionalString(ward), optionalString(room), optionalString(bed)
+}
+
+// setHL7Attending copies PV1-7. The id links a doctor of ours when it is
+// one; the name is kept either way.
+func setHL7Attending(ctx context.Context, msg *helper.HL7Message, admission *models.Admission) error {
+	pv1 := msg.Segment("PV1")
+	if pv1.Field(7) == "" {
+		return nil
+	}
+	name := strings.TrimSpace(pv1.Component(7, 3) + " " + pv1.Component(7, 2))
+	admission.Attending_name = optionalString(name)
+	admission.Doctor_id = nil
+	if id := pv1.Component(7, 1); id != "" {
+		var doctor models.Doctor
+		err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": id}).Decode(&doctor)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return errors.New("error occured while reading the doctor")
+		}
+		if err == nil {
+			admission.Doctor_id = &doctor.Doctor_id
+			if admission.Attending_name == nil {
+				admission.Attending_name = doctor.Name
+			}
+		}
+	}
+	return nil
+}
+
+// hl7EventTime is the PV1 time field given, else when the event occurred
+// (EVN-6) or was recorded (EVN-2), else now.
+func hl7EventTime(msg *helper.HL7Message, pv1Field int) time.Time {
+	evn := msg.Segment("EVN")
+	for _, value := range []string{msg.Segment("PV1").Field(pv1Field), evn.Field(6), evn.Field(2)} {
+		if t, err := helper.ParseHL7Time(value); err == nil {
+			return t
+		}
+	}
+	return helper.Now()
+}
+
+// processORU enters the results of an ORU^R01. OBR-2 is the order id we
+// sent as placer; each OBX is matched to the order's test by its code
+// (OBX-3), falling back to OBR-4 for a single-result panel. Every result is
+// tried, and the problems are reported together.
+func processORU(ctx context.Context, msg *helper.HL7Message, entry models.HL7Message) (string, string, error) {
+	performedBy := "HL7:" + entry.Sender
+	patientId := ""
+	orderIds := []string{}
+	problems := []string{}
+
+	var order *models.LabOrder
+	var obr helper.HL7Segment
+	observations := []helper.HL7Segment{}
+	notes := map[int][]string{}
+
+	flush := func() {
+		if order == nil {
+			return
+		}
+		for i, obx := range observations {
+			if problem := enterHL7Result(ctx, msg, obr, obx, len(observations) == 1, strings.Join(notes[i], "\n"), order, performedBy); problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+	}
+
+	for _, segment := range msg.Segments {
+		switch segment.Fields[0] {
+		case "OBR":
+			flush()
+			order, obr, observations, notes = nil, segment, []helper.HL7Segment{}, map[int][]string{}
+			orderId := segment.Component(2, 1)
+			if orderId == "" {
+				orderId = msg.Segment("ORC").Component(2, 1)
+			}
+			var found models.LabOrder
+			if err := labOrderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&found); err != nil {
+				problems = append(problems, fmt.Sprintf("OBR-2: lab order %q not found", orderId))
+				continue
+			}
+			order = &found
+			patientId = *found.Patient_id
+			orderIds = append(orderIds, found.Order_id)
+		case "OBX":
+			observations = append(observations, segment)
+		case "NTE":
+			if len(observations) > 0 {
+				i := len(observations) - 1
+				notes[i] = append(notes[i], segment.Field(3))
+			}
+		}
+	}
+	flush()
+
+	if len(orderIds) == 0 && len(problems) == 0 {
+		return "", "", errors.New("OBR segment is missing")
+	}
+	if len(problems) > 0 {
+		return patientId, strings.Join(orderIds, ","), errors.New(strings.Join(problems, "; "))
+	}
+	return patientId, strings.Join(orderIds, ","), nil
+}
+
+// enterHL7Result enters or corrects the result of one OBX; OBX-11 P is
+// preliminary, F final and C a correction. Statuses that carry no result
+// yet are skipped. It returns the problem, if any.
+func enterHL7Result(ctx context.Context, msg *helper.HL7Message, obr helper.HL7Segment, obx helper.HL7Segment, single bool, note string, order *models.LabOrder, performedBy string) string {
+	code := strings.ToUpper(obx.Component(3, 1))
+	var line *models.LabOrderTest
+	for i := range order.Tests {
+		if *order.Tests[i].Test_code == code {
+			line = &order.Tests[i]
+		}
+	}
+	if line == nil && single {
+		panel := strings.ToUpper(obr.Component(4, 1))
+		for i := range order.Tests {
+			if *order.Tests[i].Test_code == panel {
+				line = &order.Tests[i]
+			}
+		}
+	}
+	if line == nil {
+		return fmt.Sprintf("OBX-3: %s is not a test of order %s", code, order.Order_id)
+	}
+
+	status := strings.ToUpper(obx.Field(11))
+	switch status {
+	case "P":
+		status = resultPreliminary
+	case "F", "":
+		status = resultFinal
+	case "C":
+		status = resultCorrected
+	default:
+		return ""
+	}
+
+	var test models.LabTest
+	if err := labTestCollection.FindOne(ctx, bson.M{"code": line.Test_code}).Decode(&test); err != nil {
+		return "error occured while reading the lab catalog"
+	}
+	if unit := obx.Component(6, 1); unit != "" && test.Unit != nil && !strings.EqualFold(unit, *test.Unit) {
+		return fmt.Sprintf("OBX-6: %s is reported in %s, expected %s", code, unit, *test.Unit)
+	}
+
+	var numeric *float64
+	var text *string
+	value := obx.Field(5)
+	if valueType := strings.ToUpper(obx.Field(2)); valueType == "CE" || valueType == "CWE" || valueType == "CNE" {
+		if label := obx.Component(5, 2); label != "" {
+			value = label
+		} else {
+			value = obx.Component(5, 1)
+		}
+	}
+	if *test.Result_type == resultNumeric {
+		trimmed := strings.TrimSpace(value)
+		// a comparator (<5, >1000) is kept in the comment, as the value
+		// itself is stored as a number
+		if comparator := strings.TrimLeft(trimmed, "<>="); comparator != trimmed {
+			note = strings.TrimSpace("reported as " + trimmed + "\n" + note)
+			trimmed = comparator
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(trimmed), 64)
+		if err != nil {
+			return fmt.Sprintf("OBX-5: %s value %q is not a number", code, value)
+		}
+		numeric = &parsed
+	} else {
+		text = &value
+	}
+	comment := optionalString(note)
+
+	if line.Result_id != nil && line.Result_status != nil && *line.Result_status != resultPreliminary {
+		var current models.LabResult
+		if err := labResultCollection.FindOne(ctx, bson.M{"result_id": line.Result_id}).Decode(&current); err != nil {
+			return "error occured while reading the lab result"
+		}
+		if sameLabValue(current, numeric, text) {
+			return ""
+		}
+		reason := "corrected by the laboratory"
+		_, _, problem := correctLabResult(ctx, *line.Result_id, models.LabResultCorrection{
+			Value_numeric: numeric,
+			Value_text:    text,
+			Comment:       comment,
+			Reason:        &reason,
+		}, performedBy)
+		return problem
+	}
+	if status == resultCorrected {
+		status = resultFinal
+	}
+
+	receivedAt := helper.Now()
+	for _, value := range []string{obr.Field(14), obr.Field(7)} {
+		if t, err := helper.ParseHL7Time(value); err == nil {
+			receivedAt = t
+			break
+		}
+	}
+	result, _, problem := enterLabResult(ctx, order.Order_id, models.LabResultEntry{
+		Line_id:       &line.Line_id,
+		Value_numeric: numeric,
+		Value_text:    text,
+		Comment:       comment,
+		Status:        &status,
+	}, performedBy, &receivedAt)
+	if problem == "" {
+		line.Result_id, line.Result_status = &result.Result_id, &result.Status
+	}
+	return problem
+}
+
+// sameLabValue reports whether a result already has the value, so a final
+// result resent unchanged is not recorded as a correction.
+func sameLabValue(result models.LabResult, numeric *float64, text *string) bool {
+	if numeric != nil {
+		return result.Value_numeric != nil && *result.Value_numeric == *numeric
+	}
+	return text != nil && result.Value_text != nil && *result.Value_text == *text
+}
+
+// GetHL7Messages lists the message log, newest first, filtered by direction,
+// status, message_type, control_id and patient_id.
+func GetHL7Messages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"direction", "status", "message_type", "control_id", "patient_id"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(200)
+		cursor, err := hl7MessageCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the messages"})
+			return
+		}
+		messages := []models.HL7Message{}
+		if err = cursor.All(ctx, &messages); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the messages"})
+			return
+		}
+		c.JSON(http.StatusOK, messages)
+	}
+}
+
+func GetHL7Message() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var message models.HL7Message
+		if err := hl7MessageCollection.FindOne(ctx, bson.M{"message_id": c.Param("message_id")}).Decode(&message); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		c.JSON(http.StatusOK, message)
+	}
+}
+
+// ReplayHL7Message processes an inbound message again, or queues an
+// outbound one to be sent again. Either way the replay is a new log entry
+// pointing at the original.
+func ReplayHL7Message() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var original models.HL7Message
+		if err := hl7MessageCollection.FindOne(ctx, bson.M{"message_id": c.Param("message_id")}).Decode(&original); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+
+		if original.Direction == hl7Inbound {
+			_, replay := receiveHL7([]byte(original.Raw), "replay by "+c.GetString("uid"), &original.Message_id)
+			c.JSON(http.StatusOK, replay)
+			return
+		}
+
+		replay := original
+		replay.ID = primitive.NewObjectID()
+		replay.Message_id = replay.ID.Hex()
+		replay.Status = hl7Pending
+		replay.Error, replay.Ack_code, replay.Ack = "", "", ""
+		replay.Attempts = 0
+		replay.Next_attempt_at = helper.Now()
+		replay.Replay_of = &original.Message_id
+		replay.Created_at = helper.Now()
+		replay.Updated_at = helper.Now()
+		if _, err := hl7MessageCollection.InsertOne(ctx, replay); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "message was not queued"})
+			return
+		}
+		c.JSON(http.StatusOK, replay)
+	}
+}
+
+// ReceiveHL7Message takes one message as the raw request body, for senders
+// that post over HTTP instead of MLLP, and answers with the acknowledgement.
+func ReceiveHL7Message() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, err := io.ReadAll(io.LimitReader(c.Request.Body, 4<<20))
+		if err != nil || len(raw) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the body must be an HL7 v2 message"})
+			return
+		}
+		ack, _ := receiveHL7(raw, c.ClientIP(), nil)
+		c.Data(http.StatusOK, "application/hl7-v2", ack)
+	}
+}
+
+// GetAdmissions lists admissions, latest first, filtered by patient_id,
+// status and ward.
+func GetAdmissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"patient_id", "status", "ward"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		cursor, err := admissionCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"admitted_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the admissions"})
+			return
+		}
+		admissions := []models.Admission{}
+		if err = cursor.All(ctx, &admissions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the admissions"})
+			return
+		}
+		c.JSON(http.StatusOK, admissions)
+	}
+}
+
+func GetAdmission() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var admission models.Admission
+		if err := admissionCollection.FindOne(ctx, bson.M{"admission_id": c.Param("admission_id")}).Decode(&admission); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "admission not found"})
+			return
+		}
+		c.JSON(http.StatusOK, admission)
+	}
+}
+
+// appendIdentifier adds an identifier unless the patient already has it.
+func appendIdentifier(identifiers []models.PatientIdentifier, system string, value string) []models.PatientIdentifier {
+	for _, identifier := range identifiers {
+		if *identifier.System == system && *identifier.Value == value {
+			return identifiers
+		}
+	}
+	return append(identifiers, models.PatientIdentifier{System: &system, Value: &value})
+}
+
+// hl7Value drops the "" some senders use to mean "clear this field"; the
+// interface never clears what the patient entered themselves.
+func hl7Value(value string) string {
+	if value == `""` {
+		return ""
+	}
+	return value
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,301 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// hl7SendTimeout bounds one delivery attempt, acknowledgement included.
+const hl7SendTimeout = 30 * time.Second
+
+// labPriorities maps an order's priority to OBR-5.
+var labPriorities = map[string]string{"ROUTINE": "R", "URGENT": "A", "STAT": "S"}
+
+// hl7Destination is where outbound messages go: HL7_OUTBOUND_ADDR, and the
+// receiving application and facility of HL7_OUTBOUND_APPLICATION and
+// HL7_OUTBOUND_FACILITY. Without an address nothing is queued.
+func hl7Destination() (addr string, application string, facility string) {
+	return os.Getenv("HL7_OUTBOUND_ADDR"), os.Getenv("HL7_OUTBOUND_APPLICATION"), os.Getenv("HL7_OUTBOUND_FACILITY")
+}
+
+// queueLabOrderMessage tells the laboratory system about a new order (NW) or
+// a cancelled one (CA), as OML^O21, or ORM^O01 when HL7_ORDER_MESSAGE is
+// ORM.
+func queueLabOrderMessage(ctx context.Context, order models.LabOrder, control string) {
+	addr, application, facility := hl7Destination()
+	if addr == "" {
+		return
+	}
+	patient, ok := hl7Patient(ctx, *order.Patient_id)
+	if !ok {
+		return
+	}
+	ordering := hl7Doctor(ctx, *order.Doctor_id)
+	_, ownFacility := helper.HL7Facility()
+
+	controlId := primitive.NewObjectID().Hex()
+	var b *helper.HL7Builder
+	if strings.EqualFold(os.Getenv("HL7_ORDER_MESSAGE"), "ORM") {
+		b = helper.NewHL7Builder("ORM", "O01", "ORM_O01", controlId, application, facility)
+	} else {
+		b = helper.NewHL7Builder("OML", "O21", "OML_O21", controlId, application, facility)
+	}
+	b.Add("PID", hl7PID(patient)...)
+	b.Add("PV1", hl7PV1(ctx, patient)...)
+
+	placer := helper.HL7Components(order.Order_id, "", ownFacility)
+	for i, line := range order.Tests {
+		b.Add("ORC",
+			control, placer, "", placer, "", "", "",
+			"", helper.FormatHL7Time(order.Created_at), "", "", ordering,
+		)
+		b.Add("OBR",
+			strconv.Itoa(i+1), placer, "", helper.HL7Components(*line.Test_code, *line.Test_name, "L"),
+			labPriorities[*order.Priority], "", helper.FormatHL7Time(order.Created_at), "", "", "",
+			"", "", helper.HL7Escape(hl7Text(order.Clinical_notes)), "", "", ordering,
+		)
+	}
+	queueHL7Message(ctx, b.Bytes(), controlId, patient.Patient_id, order.Order_id)
+}
+
+// queueAppointmentMessage tells the scheduling system about a booked (S12),
+// rescheduled (S13), changed (S14) or cancelled (S15) appointment.
+func queueAppointmentMessage(ctx context.Context, appointment models.Appointment, trigger string) {
+	addr, application, facility := hl7Destination()
+	if addr == "" || appointment.Patient_id == nil {
+		return
+	}
+	patient, ok := hl7Patient(ctx, *appointment.Patient_id)
+	if !ok {
+		return
+	}
+	_, ownFacility := helper.HL7Facility()
+
+	minutes := appointmentMinutes(appointment)
+	start := appointment.Appointment_Date
+	end := start.Add(time.Duration(minutes) * time.Minute)
+	status := "Booked"
+	if appointment.Status != nil && *appointment.Status == appointmentCancelled {
+		status = "Cancelled"
+	}
+	appointmentType := "ROUTINE"
+	if appointment.Type_id != nil {
+		appointmentType = *appointment.Type_id
+	}
+	id := helper.HL7Components(appointment.Appointment_id, ownFacility)
+
+	controlId := primitive.NewObjectID().Hex()
+	b := helper.NewHL7Builder("SIU", trigger, "SIU_S12", controlId, application, facility)
+	b.Add("SCH",
+		id, id, "", "", "", "", "", helper.HL7Components(appointmentType),
+		strconv.Itoa(minutes), "min",
+		"^^"+helper.HL7Components(strconv.Itoa(minutes)+"M")+"^"+helper.FormatHL7Time(start)+"^"+helper.FormatHL7Time(end),
+		"", "", "", "", "", "", "", "", "", "", "", "", "", status,
+	)
+	b.Add("PID", hl7PID(patient)...)
+	b.Add("RGS", "1", "A")
+	if appointment.Doctor_id != nil {
+		b.Add("AIP",
+			"1", "A", hl7Doctor(ctx, *appointment.Doctor_id), "", "",
+			helper.FormatHL7Time(start), "", "", strconv.Itoa(minutes), "min", "", status,
+		)
+	}
+	for i, resourceId := range appointment.Resource_ids {
+		b.Add("AIL",
+			strconv.Itoa(i+1), "A", helper.HL7Components(resourceId), "", "",
+			helper.FormatHL7Time(start), "", "", strconv.Itoa(minutes), "min", "", status,
+		)
+	}
+	queueHL7Message(ctx, b.Bytes(), controlId, patient.Patient_id, appointment.Appointment_id)
+}
+
+// queueHL7Message puts a message in the outbox. A failure is logged; the
+// change that triggered the message stands.
+func queueHL7Message(ctx context.Context, raw []byte, controlId string, patientId string, referenceId string) {
+	addr, _, _ := hl7Destination()
+	msg, err := helper.ParseHL7(raw)
+	if err != nil {
+		log.Printf("hl7: %v", err)
+		return
+	}
+	code, trigger := msg.Type()
+	message := models.HL7Message{
+		ID:              primitive.NewObjectID(),
+		Direction:       hl7Outbound,
+		Message_type:    code + "^" + trigger,
+		Control_id:      controlId,
+		Peer:            addr,
+		Raw:             string(raw),
+		Status:          hl7Pending,
+		Next_attempt_at: helper.Now(),
+		Patient_id:      patientId,
+		Reference_id:    referenceId,
+		Created_at:      helper.Now(),
+		Updated_at:      helper.Now(),
+	}
+	message.Message_id = message.ID.Hex()
+	if _, err := hl7MessageCollection.InsertOne(ctx, message); err != nil {
+		log.Printf("hl7: %v", err)
+	}
+}
+
+// deliverHL7Messages sends what is due in the HL7 outbox, oldest first so
+// the receiver sees changes in order. Connection failures are retried with
+// exponential backoff until maxHL7Attempts; a message the receiver rejects
+// (AE or AR) is not sent again.
+func deliverHL7Messages(ctx context.Context) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1}).SetLimit(100)
+	cursor, err := hl7MessageCollection.Find(ctx, bson.M{
+		"direction":       hl7Outbound,
+		"status":          bson.M{"$in": []string{hl7Pending, hl7Retrying}},
+		"next_attempt_at": bson.M{"$lte": time.Now()},
+	}, opts)
+	if err != nil {
+		log.Printf("hl7: %v", err)
+		return
+	}
+
+	var due []models.HL7Message
+	if err = cursor.All(ctx, &due); err != nil {
+		log.Printf("hl7: %v", err)
+		return
+	}
+
+	for _, m := range due {
+		ack, sendErr := helper.SendMLLP(m.Peer, []byte(m.Raw), hl7SendTimeout)
+
+		updatedAt := helper.Now()
+		update := bson.M{"updated_at": updatedAt, "attempts": m.Attempts + 1}
+		if sendErr == nil {
+			update["ack"] = string(ack)
+			code, text := hl7AckCode(ack)
+			update["ack_code"] = code
+			if code == "AA" || code == "CA" {
+				update["status"] = hl7Sent
+				update["error"] = ""
+			} else {
+				update["status"] = hl7Failed
+				update["error"] = fmt.Sprintf("%s: %s", code, text)
+			}
+		} else {
+			update["error"] = sendErr.Error()
+			if m.Attempts+1 >= maxHL7Attempts {
+				update["status"] = hl7Failed
+			} else {
+				update["status"] = hl7Retrying
+				update["next_attempt_at"] = updatedAt.Add(helper.Backoff(m.Attempts+1, time.Minute, time.Hour))
+			}
+		}
+
+		_, err := hl7MessageCollection.UpdateOne(ctx, bson.M{"message_id": m.Message_id}, bson.M{"$set": update})
+		if err != nil {
+			log.Printf("hl7: %v", err)
+		}
+	}
+}
+
+// hl7AckCode reads MSA-1 and the error text of an acknowledgement.
+func hl7AckCode(ack []byte) (string, string) {
+	msg, err := helper.ParseHL7(ack)
+	if err != nil || !msg.Has("MSA") {
+		return "AR", "the acknowledgement could not be read"
+	}
+	msa := msg.Segment("MSA")
+	text := msa.Field(3)
+	if text == "" {
+		text = msg.Segment("ERR").Field(8)
+	}
+	return strings.ToUpper(msa.Field(1)), text
+}
+
+// hl7PID writes the patient's PID fields: our id under our facility's
+// authority first, then the other systems' identifiers.
+func hl7PID(patient models.Patient) []string {
+	_, facility := helper.HL7Facility()
+	ids := []string{helper.HL7Components(patient.Patient_id, "", "", facility, "MR")}
+	for _, identifier := range patient.Identifiers {
+		ids = append(ids, helper.HL7Components(*identifier.Value, "", "", *identifier.System))
+	}
+	birth := ""
+	if patient.Date_of_birth != nil {
+		birth = helper.InFacility(*patient.Date_of_birth).Format("20060102")
+	}
+	contacts := []string{}
+	if patient.Phone != nil {
+		contacts = append(contacts, helper.HL7Components(*patient.Phone, "PRN", "PH"))
+	}
+	if patient.Email != nil {
+		contacts = append(contacts, helper.HL7Components("", "NET", "Internet", *patient.Email))
+	}
+	return []string{
+		"1", "", strings.Join(ids, "~"), "",
+		helper.HL7Components(hl7Text(patient.Last_name), hl7Text(patient.First_name)),
+		"", birth, "", "", "", "", "", strings.Join(contacts, "~"),
+	}
+}
+
+// hl7PV1 writes the patient's current visit: the open admission's class and
+// location, or an outpatient visit.
+func hl7PV1(ctx context.Context, patient models.Patient) []string {
+	var admission models.Admission
+	err := admissionCollection.FindOne(ctx,
+		bson.M{"patient_id": patient.Patient_id, "status": admissionAdmitted},
+		options.FindOne().SetSort(bson.M{"admitted_at": -1}),
+	).Decode(&admission)
+	if err != nil {
+		return []string{"1", "O"}
+	}
+	class := "I"
+	for code, name := range patientClasses {
+		if name == admission.Patient_class {
+			class = code
+		}
+	}
+	visit := ""
+	if admission.Visit_number != nil {
+		visit = helper.HL7Components(*admission.Visit_number)
+	}
+	return []string{
+		"1", class, helper.HL7Components(hl7Text(admission.Ward), hl7Text(admission.Room), hl7Text(admission.Bed)),
+		"", "", "", "", "", "", "", "", "", "", "", "", "", "", "", visit,
+	}
+}
+
+// hl7Patient reads the patient a message is about; a missing patient is
+// logged and the message left out.
+func hl7Patient(ctx context.Context, patientId string) (models.Patient, bool) {
+	var patient models.Patient
+	if err := patientCollection.FindOne(ctx, bson.M{"patient_id": patientId}).Decode(&patient); err != nil {
+		log.Printf("hl7: patient %s: %v", patientId, err)
+		return patient, false
+	}
+	return patient, true
+}
+
+// hl7Doctor writes a doctor as an XCN: id^name. Our doctors have one name
+// field, which goes in the family name.
+func hl7Doctor(ctx context.Context, doctorId string) string {
+	var doctor models.Doctor
+	if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": doctorId}).Decode(&doctor); err != nil {
+		return helper.HL7Components(doctorId)
+	}
+	return helper.HL7Components(doctorId, hl7Text(doctor.Name))
+}
+
+func hl7Text(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
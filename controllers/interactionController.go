@@ -0,0 +1,447 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var interactionCollection *mongo.Collection = database.OpenCollection(database.Client, "drugInteraction")
+
+const (
+	warningInteraction = "INTERACTION"
+	warningDuplicate   = "DUPLICATE"
+	warningAllergy     = "ALLERGY"
+	warningNoAllergies = "ALLERGIES_NOT_RECORDED"
+)
+
+var severityRank = map[string]int{"MINOR": 1, "MODERATE": 2, "MAJOR": 3, "CONTRAINDICATED": 4}
+
+// prescribedDrug is one line item with its formulary entry. Prescription_id
+// is empty for items of the prescription being checked.
+type prescribedDrug struct {
+	Item            models.MedicationItem
+	Drug            models.Drug
+	Prescription_id string
+}
+
+// CheckPrescription runs the prescribing checks on a draft without saving it.
+func CheckPrescription() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var prescription models.Prescription
+		if err := c.BindJSON(&prescription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(prescription); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		problem, err := checkFormularyItems(ctx, prescription.Items)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the formulary"})
+			return
+		}
+		if problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+			return
+		}
+		numberItems(prescription.Items)
+
+		warnings, err := checkPrescriptionSafety(ctx, prescription)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the prescription"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"warnings": warnings, "blocked": blockingWarnings(warnings)})
+	}
+}
+
+// GetDrugInteractions lists the table rows naming the drug or one of its
+// ATC classes.
+func GetDrugInteractions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var drug models.Drug
+		if err := formularyCollection.FindOne(ctx, bson.M{"drug_id": c.Param("drug_id")}).Decode(&drug); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "drug not found in the formulary"})
+			return
+		}
+
+		keys := drugKeys(drug)
+		cursor, err := interactionCollection.Find(ctx, bson.M{"$or": bson.A{
+			bson.M{"drug_a": bson.M{"$in": keys}},
+			bson.M{"drug_b": bson.M{"$in": keys}},
+		}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing interactions"})
+			return
+		}
+		interactions := []models.DrugInteraction{}
+		if err = cursor.All(ctx, &interactions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing interactions"})
+			return
+		}
+		c.JSON(http.StatusOK, interactions)
+	}
+}
+
+// UploadInteractions imports an interaction table the way UploadFormulary
+// imports drugs.
+func UploadInteractions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 300*time.Second)
+		defer cancel()
+
+		body, format, ok := datasetUpload(c)
+		if !ok {
+			return
+		}
+		defer body.Close()
+
+		records, err := helper.ParseInteractions(body, format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := ImportInteractions(ctx, records)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "interaction import failed: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// ImportInteractions adds or updates table rows, matched on the pair of
+// sides in either order.
+func ImportInteractions(ctx context.Context, records []helper.InteractionRecord) (FormularyImport, error) {
+	result := FormularyImport{Rejected: []FormularyRejection{}}
+
+	_, err := interactionCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "drug_a", Value: 1}, {Key: "drug_b", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "drug_b", Value: 1}}},
+	})
+	if err != nil {
+		return result, err
+	}
+
+	now := helper.Now()
+	for _, record := range records {
+		// one stored order per pair, so "a,b" and "b,a" are the same row
+		a, b := record.Drug_a, record.Drug_b
+		if b < a {
+			a, b = b, a
+		}
+		interaction := models.DrugInteraction{Drug_a: a, Drug_b: b, Severity: record.Severity}
+		if record.Description != "" {
+			interaction.Description = &record.Description
+		}
+		if record.Management != "" {
+			interaction.Management = &record.Management
+		}
+		if validationErr := validate.Struct(interaction); validationErr != nil {
+			result.Rejected = append(result.Rejected, FormularyRejection{Line: record.Line, Code: a + "/" + b, Error: validationErr.Error()})
+			continue
+		}
+
+		update, err := interactionCollection.UpdateOne(ctx, bson.M{"drug_a": a, "drug_b": b}, bson.M{
+			"$set": bson.M{
+				"severity":    interaction.Severity,
+				"description": interaction.Description,
+				"management":  interaction.Management,
+				"updated_at":  now,
+			},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+		}, options.Update().SetUpsert(true))
+		if err != nil {
+			return result, fmt.Errorf("line %d: %v", record.Line, err)
+		}
+		if update.UpsertedCount > 0 {
+			result.Imported++
+		} else {
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+// checkPrescriptionSafety checks the prescription's items against each other,
+// against the patient's other prescriptions active over the same period and
+// against the patient's recorded allergies. Warnings come most severe first.
+func checkPrescriptionSafety(ctx context.Context, prescription models.Prescription) ([]models.ClinicalWarning, error) {
+	warnings := []models.ClinicalWarning{}
+
+	current, err := prescribedDrugs(ctx, []models.Prescription{prescription})
+	if err != nil {
+		return nil, err
+	}
+	for i := range current {
+		current[i].Prescription_id = ""
+	}
+	if len(current) == 0 {
+		return warnings, nil
+	}
+
+	others, err := activePrescriptions(ctx, *prescription.Patient_id, prescription.Prescription_id, prescription.Start_Date, prescription.End_Date)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := prescribedDrugs(ctx, others)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, drug := range append(append([]prescribedDrug{}, current...), existing...) {
+		keys = append(keys, drugKeys(drug.Drug)...)
+	}
+	cursor, err := interactionCollection.Find(ctx, bson.M{"drug_a": bson.M{"$in": keys}, "drug_b": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	var interactions []models.DrugInteraction
+	if err = cursor.All(ctx, &interactions); err != nil {
+		return nil, err
+	}
+
+	pairs := [][2]prescribedDrug{}
+	for i := range current {
+		for j := i + 1; j < len(current); j++ {
+			pairs = append(pairs, [2]prescribedDrug{current[i], current[j]})
+		}
+		for _, other := range existing {
+			pairs = append(pairs, [2]prescribedDrug{current[i], other})
+		}
+	}
+	for _, pair := range pairs {
+		warnings = append(warnings, pairWarnings(pair[0], pair[1], interactions)...)
+	}
+
+	allergyWarnings, err := checkAllergies(ctx, *prescription.Patient_id, current)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, allergyWarnings...)
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return severityRank[warnings[i].Severity] > severityRank[warnings[j].Severity]
+	})
+	return warnings, nil
+}
+
+func pairWarnings(first prescribedDrug, second prescribedDrug, interactions []models.DrugInteraction) []models.ClinicalWarning {
+	var warnings []models.ClinicalWarning
+	itemIds := []string{first.Item.Item_id}
+	if second.Prescription_id == "" {
+		itemIds = append(itemIds, second.Item.Item_id)
+	}
+	codes := []string{first.Drug.Drug_id, second.Drug.Drug_id}
+
+	if first.Drug.Drug_id == second.Drug.Drug_id {
+		message := *first.Drug.Generic_name + " is prescribed twice"
+		if second.Prescription_id != "" {
+			message = *first.Drug.Generic_name + " is already on an active prescription"
+		}
+		warnings = append(warnings, models.ClinicalWarning{
+			Kind:                  warningDuplicate,
+			Severity:              "MODERATE",
+			Item_ids:              itemIds,
+			Drug_codes:            codes,
+			Other_prescription_id: second.Prescription_id,
+			Message:               message,
+		})
+	}
+
+	firstKeys, secondKeys := drugKeys(first.Drug), drugKeys(second.Drug)
+	for _, interaction := range interactions {
+		forward := containsString(firstKeys, interaction.Drug_a) && containsString(secondKeys, interaction.Drug_b)
+		backward := containsString(firstKeys, interaction.Drug_b) && containsString(secondKeys, interaction.Drug_a)
+		if !forward && !backward {
+			continue
+		}
+		warning := models.ClinicalWarning{
+			Kind:                  warningInteraction,
+			Severity:              interaction.Severity,
+			Item_ids:              itemIds,
+			Drug_codes:            codes,
+			Other_prescription_id: second.Prescription_id,
+			Message:               fmt.Sprintf("%s with %s: %s", *first.Drug.Generic_name, *second.Drug.Generic_name, *interaction.Description),
+		}
+		if interaction.Management != nil {
+			warning.Management = *interaction.Management
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
+
+func checkAllergies(ctx context.Context, patientId string, current []prescribedDrug) ([]models.ClinicalWarning, error) {
+	cursor, err := allergyCollection.Find(ctx, bson.M{"patient_id": patientId, "status": bson.M{"$nin": inactiveAllergy}})
+	if err != nil {
+		return nil, err
+	}
+	var allergies []models.Allergy
+	if err = cursor.All(ctx, &allergies); err != nil {
+		return nil, err
+	}
+
+	var warnings []models.ClinicalWarning
+	if len(allergies) == 0 {
+		var patient models.Patient
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": patientId}).Decode(&patient); err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if patient.Allergy_assessment == nil || !patient.Allergy_assessment.No_known_allergies {
+			warnings = append(warnings, models.ClinicalWarning{
+				Kind:       warningNoAllergies,
+				Severity:   "MINOR",
+				Item_ids:   []string{},
+				Drug_codes: []string{},
+				Message:    "the patient's allergies have not been recorded",
+			})
+		}
+	}
+	for _, allergy := range allergies {
+		for _, prescribed := range current {
+			if !allergyMatches(allergy, prescribed.Drug) {
+				continue
+			}
+			severity := "MAJOR"
+			if allergy.Severity != nil && *allergy.Severity == "SEVERE" {
+				severity = "CONTRAINDICATED"
+			}
+			warnings = append(warnings, models.ClinicalWarning{
+				Kind:       warningAllergy,
+				Severity:   severity,
+				Item_ids:   []string{prescribed.Item.Item_id},
+				Drug_codes: []string{prescribed.Drug.Drug_id},
+				Allergy_id: allergy.Allergy_id,
+				Message:    fmt.Sprintf("the patient is allergic to %s and %s may contain or be related to it", *allergy.Substance, *prescribed.Drug.Generic_name),
+			})
+		}
+	}
+	return warnings, nil
+}
+
+func allergyMatches(allergy models.Allergy, drug models.Drug) bool {
+	if allergy.Drug_code != nil && *allergy.Drug_code == drug.Drug_id {
+		return true
+	}
+	if allergy.Atc_class != nil && drug.Atc_code != nil && strings.HasPrefix(strings.ToUpper(*drug.Atc_code), strings.ToUpper(*allergy.Atc_class)) {
+		return true
+	}
+	substance := helper.SearchName(*allergy.Substance)
+	for _, name := range drug.Search_names {
+		if strings.Contains(" "+name+" ", " "+substance+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// activePrescriptions returns the patient's prescriptions, other than
+// excludeId, still in force and running at some point of [start, end]. An
+// open start means from now, an open end means indefinitely.
+func activePrescriptions(ctx context.Context, patientId string, excludeId string, start *time.Time, end *time.Time) ([]models.Prescription, error) {
+	from := helper.Now()
+	if start != nil {
+		from = *start
+	}
+	conditions := bson.A{
+		bson.M{"$or": bson.A{bson.M{"end_date": nil}, bson.M{"end_date": bson.M{"$gte": from}}}},
+	}
+	if end != nil {
+		conditions = append(conditions, bson.M{"$or": bson.A{bson.M{"start_date": nil}, bson.M{"start_date": bson.M{"$lte": *end}}}})
+	}
+
+	cursor, err := prescriptionCollection.Find(ctx, bson.M{
+		"patient_id":      patientId,
+		"prescription_id": bson.M{"$ne": excludeId},
+		"status":          bson.M{"$nin": bson.A{prescriptionCancelled, prescriptionCompleted, prescriptionExpired}},
+		"$and":            conditions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var prescriptions []models.Prescription
+	err = cursor.All(ctx, &prescriptions)
+	return prescriptions, err
+}
+
+// prescribedDrugs pairs the coded items of the prescriptions with their
+// formulary entries. Legacy free-text items are skipped.
+func prescribedDrugs(ctx context.Context, prescriptions []models.Prescription) ([]prescribedDrug, error) {
+	var codes []string
+	for _, prescription := range prescriptions {
+		for _, item := range prescription.Items {
+			if item.Drug_code != nil {
+				codes = append(codes, *item.Drug_code)
+			}
+		}
+	}
+	if len(codes) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := formularyCollection.Find(ctx, bson.M{"drug_id": bson.M{"$in": codes}})
+	if err != nil {
+		return nil, err
+	}
+	var drugs []models.Drug
+	if err = cursor.All(ctx, &drugs); err != nil {
+		return nil, err
+	}
+	byCode := map[string]models.Drug{}
+	for _, drug := range drugs {
+		byCode[drug.Drug_id] = drug
+	}
+
+	var prescribed []prescribedDrug
+	for _, prescription := range prescriptions {
+		for _, item := range prescription.Items {
+			if item.Drug_code == nil {
+				continue
+			}
+			if drug, ok := byCode[*item.Drug_code]; ok {
+				prescribed = append(prescribed, prescribedDrug{Item: item, Drug: drug, Prescription_id: prescription.Prescription_id})
+			}
+		}
+	}
+	return prescribed, nil
+}
+
+func drugKeys(drug models.Drug) []string {
+	atc := ""
+	if drug.Atc_code != nil {
+		atc = *drug.Atc_code
+	}
+	return helper.DrugKeys(drug.Drug_id, atc)
+}
+
+func blockingWarnings(warnings []models.ClinicalWarning) bool {
+	for _, warning := range warnings {
+		if severityRank[warning.Severity] >= severityRank["MAJOR"] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,761 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var stockItemCollection *mongo.Collection = database.OpenCollection(database.Client, "stockItem")
+var stockLocationCollection *mongo.Collection = database.OpenCollection(database.Client, "stockLocation")
+var stockMovementCollection *mongo.Collection = database.OpenCollection(database.Client, "stockMovement")
+
+const (
+	movementReceipt     = "RECEIPT"
+	movementTransferOut = "TRANSFER_OUT"
+	movementTransferIn  = "TRANSFER_IN"
+	movementAdjustment  = "ADJUSTMENT"
+	movementDispense    = "DISPENSE"
+	movementReversal    = "REVERSAL"
+)
+
+// stockMu serialises reading the ledger and appending to it, so two requests
+// cannot take the same stock. Dispensing takes dispenseMu first.
+var stockMu sync.Mutex
+
+// errInsufficientStock is returned by pickStock when the location does not
+// hold enough usable stock.
+var errInsufficientStock = errors.New("insufficient stock")
+
+// ReorderAlert is a stock item whose usable stock has fallen to its reorder
+// level.
+type ReorderAlert struct {
+	models.StockItem
+	On_hand float64 `json:"on_hand"`
+}
+
+func CreateStockItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var item models.StockItem
+		if err := c.BindJSON(&item); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(item); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := stockItemCollection.CountDocuments(ctx, bson.M{"code": item.Code})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the code"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "a stock item with this code already exists"})
+			return
+		}
+		if *item.Kind == "DRUG" {
+			if msg := checkFormularyCode(ctx, item.Drug_code); msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			count, err := stockItemCollection.CountDocuments(ctx, bson.M{"drug_code": item.Drug_code})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the drug"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": *item.Drug_code + " is already a stock item"})
+				return
+			}
+		} else {
+			item.Drug_code = nil
+		}
+
+		if item.Active == nil {
+			active := true
+			item.Active = &active
+		}
+		item.Created_at = helper.Now()
+		item.Updated_at = helper.Now()
+		item.ID = primitive.NewObjectID()
+		item.Item_id = item.ID.Hex()
+
+		if _, insertErr := stockItemCollection.InsertOne(ctx, item); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "stock item was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+// GetStockItems lists stock items, filtered by kind, drug_code and active.
+func GetStockItems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"kind", "drug_code"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		if active := c.Query("active"); active != "" {
+			filter["active"] = active == "true"
+		}
+
+		cursor, err := stockItemCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the stock items"})
+			return
+		}
+		items := []models.StockItem{}
+		if err = cursor.All(ctx, &items); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the stock items"})
+			return
+		}
+		c.JSON(http.StatusOK, items)
+	}
+}
+
+// GetStockItem returns the item with its stock by location and batch.
+func GetStockItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var item models.StockItem
+		if err := stockItemCollection.FindOne(ctx, bson.M{"item_id": c.Param("item_id")}).Decode(&item); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "stock item not found"})
+			return
+		}
+		balances, err := stockBalances(ctx, bson.M{"item_id": item.Item_id})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"item": item, "stock": balances})
+	}
+}
+
+func UpdateStockItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var change models.StockItemChange
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(change); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+		if change.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: change.Name})
+		}
+		if change.Unit != nil {
+			updateObj = append(updateObj, bson.E{Key: "unit", Value: change.Unit})
+		}
+		if change.Reorder_level != nil {
+			updateObj = append(updateObj, bson.E{Key: "reorder_level", Value: change.Reorder_level})
+		}
+		if change.Reorder_quantity != nil {
+			updateObj = append(updateObj, bson.E{Key: "reorder_quantity", Value: change.Reorder_quantity})
+		}
+		if change.Active != nil {
+			updateObj = append(updateObj, bson.E{Key: "active", Value: change.Active})
+		}
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: helper.Now()})
+
+		var item models.StockItem
+		err := stockItemCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"item_id": c.Param("item_id")},
+			bson.D{{Key: "$set", Value: updateObj}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&item)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "stock item not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "stock item update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+func CreateStockLocation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var location models.StockLocation
+		if err := c.BindJSON(&location); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(location); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := stockLocationCollection.CountDocuments(ctx, bson.M{"code": location.Code})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the code"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "a stock location with this code already exists"})
+			return
+		}
+
+		location.Created_at = helper.Now()
+		location.Updated_at = helper.Now()
+		location.ID = primitive.NewObjectID()
+		location.Location_id = location.ID.Hex()
+
+		if _, insertErr := stockLocationCollection.InsertOne(ctx, location); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "stock location was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, location)
+	}
+}
+
+func GetStockLocations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		cursor, err := stockLocationCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the stock locations"})
+			return
+		}
+		locations := []models.StockLocation{}
+		if err = cursor.All(ctx, &locations); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the stock locations"})
+			return
+		}
+		c.JSON(http.StatusOK, locations)
+	}
+}
+
+// ReceiveGoods puts a delivery into stock at one location. A batch received
+// again must carry the expiry date it was first received with.
+func ReceiveGoods() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var receipt models.GoodsReceipt
+		if err := c.BindJSON(&receipt); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(receipt); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		movements, msg, err := receiptMovements(ctx, receipt, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the receipt"})
+			return
+		}
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		stockMu.Lock()
+		defer stockMu.Unlock()
+		if err := appendMovements(ctx, movements); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "goods receipt was not recorded"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"receipt_id": movements[0].Reference, "movements": movements})
+	}
+}
+
+// receiptMovements checks a goods receipt and turns it into ledger entries.
+// A non-empty message is a problem with the receipt.
+func receiptMovements(ctx context.Context, receipt models.GoodsReceipt, recordedBy string) ([]models.StockMovement, string, error) {
+	if _, msg, err := stockLocation(ctx, *receipt.Location_id); msg != "" || err != nil {
+		return nil, msg, err
+	}
+
+	reference := primitive.NewObjectID().Hex()
+	if receipt.Reference != nil {
+		reference = *receipt.Reference
+	}
+	var movements []models.StockMovement
+	received := map[string]time.Time{}
+	for i, line := range receipt.Lines {
+		item, msg, err := activeStockItem(ctx, line.Item_id)
+		if msg != "" || err != nil {
+			return nil, fmt.Sprintf("line %d: %s", i+1, msg), err
+		}
+		expiry := line.Expiry_date.UTC().Truncate(24 * time.Hour)
+		if !expiry.After(helper.Now()) {
+			return nil, fmt.Sprintf("line %d: batch %s has already expired", i+1, line.Batch), nil
+		}
+		known, ok := received[item.Item_id+"\x00"+line.Batch]
+		if !ok {
+			known, ok, err = batchExpiry(ctx, item.Item_id, line.Batch)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		if ok && !known.Equal(expiry) {
+			return nil, fmt.Sprintf("line %d: batch %s expires on %s", i+1, line.Batch, known.Format("2006-01-02")), nil
+		}
+		received[item.Item_id+"\x00"+line.Batch] = expiry
+
+		movement := newMovement(item.Item_id, *receipt.Location_id, line.Batch, expiry, line.Quantity, movementReceipt, reference, recordedBy)
+		movement.Note = receipt.Supplier
+		movements = append(movements, movement)
+	}
+	return movements, "", nil
+}
+
+// TransferStock moves stock between two locations as a pair of ledger
+// entries per batch.
+func TransferStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var transfer models.StockTransfer
+		if err := c.BindJSON(&transfer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(transfer); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		for _, locationId := range []string{transfer.From_location_id, transfer.To_location_id} {
+			if _, msg, err := stockLocation(ctx, locationId); msg != "" || err != nil {
+				stockProblem(c, msg, err)
+				return
+			}
+		}
+		if _, msg, err := activeStockItem(ctx, transfer.Item_id); msg != "" || err != nil {
+			stockProblem(c, msg, err)
+			return
+		}
+
+		stockMu.Lock()
+		defer stockMu.Unlock()
+
+		// an expired batch named explicitly may still move, to quarantine it
+		picks, available, err := pickStock(ctx, transfer.Item_id, transfer.From_location_id, transfer.Batch, transfer.Quantity, transfer.Batch != nil, nil)
+		if errors.Is(err, errInsufficientStock) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("only %g available at the source location", available)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+			return
+		}
+
+		reference := primitive.NewObjectID().Hex()
+		recordedBy := c.GetString("uid")
+		var movements []models.StockMovement
+		for _, pick := range picks {
+			out := newMovement(transfer.Item_id, transfer.From_location_id, pick.Batch, pick.Expiry_date, -pick.On_hand, movementTransferOut, reference, recordedBy)
+			in := newMovement(transfer.Item_id, transfer.To_location_id, pick.Batch, pick.Expiry_date, pick.On_hand, movementTransferIn, reference, recordedBy)
+			out.Reason, in.Reason = transfer.Reason, transfer.Reason
+			movements = append(movements, out, in)
+		}
+		if err := appendMovements(ctx, movements); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "transfer was not recorded"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"transfer_id": reference, "movements": movements})
+	}
+}
+
+// AdjustStock corrects the stock of a batch already known at the location,
+// for instance after a count or when stock is damaged.
+func AdjustStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var adjustment models.StockAdjustment
+		if err := c.BindJSON(&adjustment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(adjustment); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if _, msg, err := stockLocation(ctx, adjustment.Location_id); msg != "" || err != nil {
+			stockProblem(c, msg, err)
+			return
+		}
+
+		stockMu.Lock()
+		defer stockMu.Unlock()
+
+		balances, err := stockBalances(ctx, bson.M{"item_id": adjustment.Item_id, "location_id": adjustment.Location_id, "batch": adjustment.Batch})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+			return
+		}
+		expiry, known, err := batchExpiry(ctx, adjustment.Item_id, adjustment.Batch)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+			return
+		}
+		if !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "batch " + adjustment.Batch + " was never received; receive new batches with a goods receipt"})
+			return
+		}
+		onHand := 0.0
+		if len(balances) > 0 {
+			onHand = balances[0].On_hand
+		}
+		if onHand+adjustment.Quantity < -quantityTolerance {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("only %g of batch %s is at the location", onHand, adjustment.Batch)})
+			return
+		}
+
+		movement := newMovement(adjustment.Item_id, adjustment.Location_id, adjustment.Batch, expiry, adjustment.Quantity, movementAdjustment, primitive.NewObjectID().Hex(), c.GetString("uid"))
+		movement.Reason = adjustment.Reason
+		movement.Note = adjustment.Note
+		if err := appendMovements(ctx, []models.StockMovement{movement}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "adjustment was not recorded"})
+			return
+		}
+		c.JSON(http.StatusOK, movement)
+	}
+}
+
+// GetStockBalances reports on-hand stock by item, location and batch,
+// filtered by item_id and location_id, worked out from the ledger.
+func GetStockBalances() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"item_id", "location_id"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		balances, err := stockBalances(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+			return
+		}
+		c.JSON(http.StatusOK, balances)
+	}
+}
+
+// GetStockMovements lists the ledger, filtered by item_id, location_id,
+// batch, kind and reference.
+func GetStockMovements() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"item_id", "location_id", "batch", "kind", "reference"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		cursor, err := stockMovementCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "recorded_at", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the stock movements"})
+			return
+		}
+		movements := []models.StockMovement{}
+		if err = cursor.All(ctx, &movements); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the stock movements"})
+			return
+		}
+		c.JSON(http.StatusOK, movements)
+	}
+}
+
+// GetReorderAlerts lists the active items whose unexpired stock, across all
+// locations, is at or below their reorder level.
+func GetReorderAlerts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		alerts, err := reorderAlerts(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+			return
+		}
+		c.JSON(http.StatusOK, alerts)
+	}
+}
+
+func reorderAlerts(ctx context.Context) ([]ReorderAlert, error) {
+	cursor, err := stockItemCollection.Find(ctx, bson.M{"active": true, "reorder_level": bson.M{"$gt": 0}}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var items []models.StockItem
+	if err = cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	balances, err := stockBalances(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	usable := map[string]float64{}
+	for _, balance := range balances {
+		if !balance.Expired {
+			usable[balance.Item_id] += balance.On_hand
+		}
+	}
+	alerts := []ReorderAlert{}
+	for _, item := range items {
+		if usable[item.Item_id] <= item.Reorder_level {
+			alerts = append(alerts, ReorderAlert{StockItem: item, On_hand: usable[item.Item_id]})
+		}
+	}
+	return alerts, nil
+}
+
+// GetExpiringStock lists the batches in stock that have expired or expire
+// within the given number of days, 90 by default, soonest first.
+func GetExpiringStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		days, err := strconv.Atoi(c.DefaultQuery("days", "90"))
+		if err != nil || days < 0 || days > 3650 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 0 and 3650"})
+			return
+		}
+		filter := bson.M{"expiry_date": bson.M{"$lte": helper.Now().AddDate(0, 0, days)}}
+		if locationId := c.Query("location_id"); locationId != "" {
+			filter["location_id"] = locationId
+		}
+
+		balances, err := stockBalances(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+			return
+		}
+		c.JSON(http.StatusOK, balances)
+	}
+}
+
+// stockBalances adds up the ledger entries matching filter by item, location
+// and batch, leaving out batches with nothing on hand. Batches are ordered
+// by expiry.
+func stockBalances(ctx context.Context, filter bson.M) ([]models.StockBalance, error) {
+	cursor, err := stockMovementCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         bson.M{"item_id": "$item_id", "location_id": "$location_id", "batch": "$batch"},
+			"expiry_date": bson.M{"$max": "$expiry_date"},
+			"on_hand":     bson.M{"$sum": "$quantity"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Key struct {
+			Item_id     string
+			Location_id string
+			Batch       string
+		} `bson:"_id"`
+		Expiry_date time.Time
+		On_hand     float64
+	}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	now := helper.Now()
+	balances := []models.StockBalance{}
+	for _, group := range groups {
+		if group.On_hand < quantityTolerance {
+			continue
+		}
+		balances = append(balances, models.StockBalance{
+			Item_id:     group.Key.Item_id,
+			Location_id: group.Key.Location_id,
+			Batch:       group.Key.Batch,
+			Expiry_date: group.Expiry_date,
+			On_hand:     group.On_hand,
+			Expired:     !group.Expiry_date.After(now),
+		})
+	}
+	sort.SliceStable(balances, func(i, j int) bool {
+		if !balances[i].Expiry_date.Equal(balances[j].Expiry_date) {
+			return balances[i].Expiry_date.Before(balances[j].Expiry_date)
+		}
+		return balances[i].Batch < balances[j].Batch
+	})
+	return balances, nil
+}
+
+// pickStock chooses the batches to take quantity from at a location, first
+// expiry first out, or from the named batch. Expired batches are skipped
+// unless allowExpired. pending holds what the caller has already picked but
+// not yet written, keyed by batch. Each pick's On_hand is the amount taken;
+// on errInsufficientStock the usable amount is returned. stockMu must be
+// held.
+func pickStock(ctx context.Context, itemId string, locationId string, batch *string, quantity float64, allowExpired bool, pending map[string]float64) ([]models.StockBalance, float64, error) {
+	filter := bson.M{"item_id": itemId, "location_id": locationId}
+	if batch != nil {
+		filter["batch"] = *batch
+	}
+	balances, err := stockBalances(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var picks []models.StockBalance
+	available, remaining := 0.0, quantity
+	for _, balance := range balances {
+		if balance.Expired && !allowExpired {
+			continue
+		}
+		usable := balance.On_hand - pending[balance.Batch]
+		if usable < quantityTolerance {
+			continue
+		}
+		available += usable
+		if remaining < quantityTolerance {
+			continue
+		}
+		take := usable
+		if remaining < take {
+			take = remaining
+		}
+		pick := balance
+		pick.On_hand = take
+		picks = append(picks, pick)
+		remaining -= take
+	}
+	if remaining >= quantityTolerance {
+		return nil, available, errInsufficientStock
+	}
+	return picks, available, nil
+}
+
+func newMovement(itemId string, locationId string, batch string, expiry time.Time, quantity float64, kind string, reference string, recordedBy string) models.StockMovement {
+	movement := models.StockMovement{
+		ID:          primitive.NewObjectID(),
+		Item_id:     itemId,
+		Location_id: locationId,
+		Batch:       batch,
+		Expiry_date: expiry,
+		Quantity:    quantity,
+		Kind:        kind,
+		Reference:   reference,
+		Recorded_by: recordedBy,
+		Recorded_at: helper.Now(),
+	}
+	movement.Movement_id = movement.ID.Hex()
+	return movement
+}
+
+// appendMovements writes entries to the ledger. Nothing ever updates or
+// deletes them.
+func appendMovements(ctx context.Context, movements []models.StockMovement) error {
+	if len(movements) == 0 {
+		return nil
+	}
+	records := make([]interface{}, len(movements))
+	for i := range movements {
+		records[i] = movements[i]
+	}
+	_, err := stockMovementCollection.InsertMany(ctx, records)
+	return err
+}
+
+// reverseMovements appends the opposite of entries whose operation failed
+// half way, keeping the ledger append-only.
+func reverseMovements(ctx context.Context, movements []models.StockMovement) error {
+	var reversals []models.StockMovement
+	for _, movement := range movements {
+		reversal := newMovement(movement.Item_id, movement.Location_id, movement.Batch, movement.Expiry_date, -movement.Quantity, movementReversal, movement.Movement_id, movement.Recorded_by)
+		reversals = append(reversals, reversal)
+	}
+	return appendMovements(ctx, reversals)
+}
+
+// batchExpiry returns the expiry date a batch of the item was received with.
+func batchExpiry(ctx context.Context, itemId string, batch string) (time.Time, bool, error) {
+	var movement models.StockMovement
+	err := stockMovementCollection.FindOne(ctx, bson.M{"item_id": itemId, "batch": batch}).Decode(&movement)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return movement.Expiry_date, true, nil
+}
+
+// activeStockItem and stockLocation load what a stock operation names; a
+// non-empty message is a problem with the request.
+func activeStockItem(ctx context.Context, itemId string) (models.StockItem, string, error) {
+	var item models.StockItem
+	err := stockItemCollection.FindOne(ctx, bson.M{"item_id": itemId}).Decode(&item)
+	if err == mongo.ErrNoDocuments || (err == nil && (item.Active == nil || !*item.Active)) {
+		return item, "stock item " + itemId + " not found or inactive", nil
+	}
+	return item, "", err
+}
+
+func stockLocation(ctx context.Context, locationId string) (models.StockLocation, string, error) {
+	var location models.StockLocation
+	err := stockLocationCollection.FindOne(ctx, bson.M{"location_id": locationId}).Decode(&location)
+	if err == mongo.ErrNoDocuments {
+		return location, "stock location " + locationId + " not found", nil
+	}
+	return location, "", err
+}
+
+func stockProblem(c *gin.Context, msg string, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
 	"golang-hospital-management/models"
 	"log"
 	"net/http"
@@ -17,13 +18,16 @@ import (
 )
 
 type InvoiceViewFormat struct {
-	Invoice_id       string
-	Payment_method   string
-	Appointment_id   string
-	Payment_status   *string
-	Payment_due      interface{}
-	Prescription_id  string
-	Payment_due_date time.Time
+	Invoice_id             string
+	Payment_method         string
+	Appointment_id         string
+	Payment_status         *string
+	Payment_due            interface{}
+	Amount                 *float64
+	Prescription_id        string
+	Payment_due_date       time.Time
+	Payment_due_date_local string
+	Time_zone              string
 }
 
 var invoiceCollection *mongo.Collection = database.OpenCollection(database.Client, "invoice")
@@ -64,6 +68,8 @@ func GetInvoice() gin.HandlerFunc {
 		var allAppointment string = invoice.Appointment_id
 		invoiceView.Appointment_id = invoice.Appointment_id
 		invoiceView.Payment_due_date = invoice.Payment_due_date
+		invoiceView.Payment_due_date_local = helper.FormatLocal(invoice.Payment_due_date)
+		invoiceView.Time_zone = helper.FacilityLocation().String()
 
 		invoiceView.Payment_method = "null"
 		if invoice.Payment_method != nil {
@@ -73,6 +79,7 @@ func GetInvoice() gin.HandlerFunc {
 		invoiceView.Invoice_id = invoice.Invoice_id
 		invoiceView.Payment_status = *&invoice.Payment_status
 		invoiceView.Payment_due = invoice.Payment_due_date
+		invoiceView.Amount = invoice.Amount
 
 		invoiceView.Prescription_id = allAppointment
 
@@ -90,29 +97,9 @@ func CreateInvoice() gin.HandlerFunc {
 			return
 		}
 
-		var appointment models.Appointment
-
-		err := appointmentCollection.FindOne(ctx, bson.M{"order_id": invoice.Appointment_id}).Decode(&appointment)
 		defer cancel()
-		if err != nil {
-			msg := fmt.Sprintf("message: Appointment was not found")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-		status := "PENDING"
-		if invoice.Payment_status == nil {
-			invoice.Payment_status = &status
-		}
-
-		invoice.Payment_due_date, _ = time.Parse(time.RFC3339, time.Now().AddDate(0, 0, 1).Format(time.RFC3339))
-		invoice.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		invoice.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		invoice.ID = primitive.NewObjectID()
-		invoice.Invoice_id = invoice.ID.Hex()
-
-		validationErr := validate.Struct(invoice)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		if status, msg := prepareInvoice(ctx, &invoice); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
 			return
 		}
 
@@ -152,7 +139,7 @@ func UpdateInvoice() gin.HandlerFunc {
 			updateObj = append(updateObj, bson.E{"payment_status", invoice.Payment_status})
 		}
 
-		invoice.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		invoice.Updated_at = helper.Now()
 		updateObj = append(updateObj, bson.E{"updated_at", invoice.Updated_at})
 
 		upsert := true
@@ -184,3 +171,41 @@ func UpdateInvoice() gin.HandlerFunc {
 		c.JSON(http.StatusOK, result)
 	}
 }
+
+// prepareInvoice fills in and checks a new invoice before CreateInvoice or
+// the FHIR Invoice create stores it: the amount defaults to the fee of the
+// appointment's type, the status to PENDING and payment is due the next day.
+// A non-empty message is the problem, with its status.
+func prepareInvoice(ctx context.Context, invoice *models.Invoice) (int, string) {
+	var appointment models.Appointment
+
+	err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": invoice.Appointment_id}).Decode(&appointment)
+	if err != nil {
+		msg := fmt.Sprintf("message: Appointment was not found")
+		return http.StatusInternalServerError, msg
+	}
+
+	// the appointment's type prices the visit unless an amount is given
+	if invoice.Amount == nil {
+		if appointmentType := appointmentTypeOf(ctx, appointment); appointmentType != nil {
+			invoice.Amount = appointmentType.Default_fee
+		}
+	}
+
+	status := "PENDING"
+	if invoice.Payment_status == nil {
+		invoice.Payment_status = &status
+	}
+
+	invoice.Payment_due_date = helper.Now().AddDate(0, 0, 1)
+	invoice.Created_at = helper.Now()
+	invoice.Updated_at = helper.Now()
+	invoice.ID = primitive.NewObjectID()
+	invoice.Invoice_id = invoice.ID.Hex()
+
+	validationErr := validate.Struct(invoice)
+	if validationErr != nil {
+		return http.StatusBadRequest, validationErr.Error()
+	}
+	return 0, ""
+}
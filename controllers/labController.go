@@ -0,0 +1,780 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var labOrderCollection *mongo.Collection = database.OpenCollection(database.Client, "labOrder")
+var labResultCollection *mongo.Collection = database.OpenCollection(database.Client, "labResult")
+
+const (
+	labOrdered   = "ORDERED"
+	labCollected = "COLLECTED"
+	labReceived  = "RECEIVED"
+	labPartial   = "PARTIALLY_RESULTED"
+	labCompleted = "COMPLETED"
+	labCancelled = "CANCELLED"
+
+	specimenPending   = "PENDING"
+	specimenCollected = "COLLECTED"
+	specimenReceived  = "RECEIVED"
+
+	resultPreliminary = "PRELIMINARY"
+	resultFinal       = "FINAL"
+	resultCorrected   = "CORRECTED"
+)
+
+// releasedResults are the statuses a doctor reviews.
+var releasedResults = []string{resultFinal, resultCorrected}
+
+// labMu serialises changes to lab orders, whose specimens and result status
+// are read, changed and written back.
+var labMu sync.Mutex
+
+// LabOrderDetail is an order with the results entered for it.
+type LabOrderDetail struct {
+	models.LabOrder
+	Results []models.LabResult `json:"results"`
+}
+
+// CreateLabOrder orders tests for a patient. Each test must be active in the
+// catalog; one specimen is expected per specimen type the tests need.
+func CreateLabOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var order models.LabOrder
+		if err := c.BindJSON(&order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(order); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := patientCollection.CountDocuments(ctx, bson.M{"patient_id": order.Patient_id})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "patient not found"})
+			return
+		}
+		count, err = doctorCollection.CountDocuments(ctx, bson.M{"doctor_id": order.Doctor_id})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message:Doctor not found"})
+			return
+		}
+		if order.Encounter_id != nil {
+			var encounter models.Encounter
+			if err := encounterCollection.FindOne(ctx, bson.M{"encounter_id": order.Encounter_id}).Decode(&encounter); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "encounter not found"})
+				return
+			}
+			if encounter.Patient_id != *order.Patient_id {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the encounter is not the patient's"})
+				return
+			}
+			if order.Appointment_id == nil {
+				order.Appointment_id = encounter.Appointment_id
+			} else if *order.Appointment_id != *encounter.Appointment_id {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the encounter belongs to another appointment"})
+				return
+			}
+		}
+		if order.Appointment_id != nil {
+			var appointment models.Appointment
+			if err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": order.Appointment_id}).Decode(&appointment); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "message:Appointment was not found"})
+				return
+			}
+			if appointment.Patient_id == nil || *appointment.Patient_id != *order.Patient_id {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the appointment is not the patient's"})
+				return
+			}
+		}
+
+		seen := map[string]bool{}
+		order.Specimens = []models.Specimen{}
+		for i := range order.Tests {
+			line := &order.Tests[i]
+			code := strings.ToUpper(strings.TrimSpace(*line.Test_code))
+			line.Test_code = &code
+			if seen[*line.Test_code] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("test %s is ordered more than once", *line.Test_code)})
+				return
+			}
+			seen[*line.Test_code] = true
+
+			var test models.LabTest
+			err := labTestCollection.FindOne(ctx, bson.M{"code": line.Test_code, "active": true}).Decode(&test)
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("test %s is not in the lab catalog", *line.Test_code)})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the lab catalog"})
+				return
+			}
+			line.Line_id = strconv.Itoa(i + 1)
+			line.Test_name = test.Name
+			line.Specimen_type = test.Specimen_type
+			line.Result_id, line.Result_status = nil, nil
+			if !seen["specimen:"+*test.Specimen_type] {
+				seen["specimen:"+*test.Specimen_type] = true
+				order.Specimens = append(order.Specimens, models.Specimen{
+					Specimen_id:   primitive.NewObjectID().Hex(),
+					Specimen_type: *test.Specimen_type,
+					Status:        specimenPending,
+				})
+			}
+		}
+
+		if order.Priority == nil {
+			priority := "ROUTINE"
+			order.Priority = &priority
+		}
+		order.Status = labOrdered
+		order.Cancel_reason = nil
+		order.Created_at = helper.Now()
+		order.Updated_at = helper.Now()
+		order.ID = primitive.NewObjectID()
+		order.Order_id = order.ID.Hex()
+
+		if _, insertErr := labOrderCollection.InsertOne(ctx, order); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "lab order was not created"})
+			return
+		}
+		queueLabOrderMessage(ctx, order, "NW")
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// GetLabOrders lists lab orders, newest first, filtered by patient_id,
+// doctor_id, appointment_id, encounter_id, status and priority.
+func GetLabOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"patient_id", "doctor_id", "appointment_id", "encounter_id", "status", "priority"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		cursor, err := labOrderCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab orders"})
+			return
+		}
+		orders := []models.LabOrder{}
+		if err = cursor.All(ctx, &orders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab orders"})
+			return
+		}
+		c.JSON(http.StatusOK, orders)
+	}
+}
+
+// GetLabOrder returns the order with its results.
+func GetLabOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var order models.LabOrder
+		if err := labOrderCollection.FindOne(ctx, bson.M{"order_id": c.Param("order_id")}).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "lab order not found"})
+			return
+		}
+		cursor, err := labResultCollection.Find(ctx, bson.M{"order_id": order.Order_id})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab results"})
+			return
+		}
+		detail := LabOrderDetail{LabOrder: order, Results: []models.LabResult{}}
+		if err = cursor.All(ctx, &detail.Results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab results"})
+			return
+		}
+		sort.Slice(detail.Results, func(i, j int) bool {
+			a, _ := strconv.Atoi(detail.Results[i].Line_id)
+			b, _ := strconv.Atoi(detail.Results[j].Line_id)
+			return a < b
+		})
+		c.JSON(http.StatusOK, detail)
+	}
+}
+
+// CollectSpecimens records that the order's pending specimens were taken.
+func CollectSpecimens() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		moveSpecimens(c, specimenPending, specimenCollected)
+	}
+}
+
+// ReceiveSpecimens records that collected specimens arrived in the
+// laboratory, after which their tests can be resulted.
+func ReceiveSpecimens() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		moveSpecimens(c, specimenCollected, specimenReceived)
+	}
+}
+
+// CancelLabOrder cancels an order before any result is entered.
+func CancelLabOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var cancellation models.LabOrderCancellation
+		if err := c.BindJSON(&cancellation); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(cancellation); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		labMu.Lock()
+		defer labMu.Unlock()
+
+		var order models.LabOrder
+		err := labOrderCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"order_id": c.Param("order_id"), "status": bson.M{"$in": []string{labOrdered, labCollected, labReceived}}},
+			bson.M{"$set": bson.M{"status": labCancelled, "cancel_reason": cancellation.Reason, "updated_at": helper.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&order)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "lab order not found, already resulted or cancelled"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "lab order update failed"})
+			return
+		}
+		queueLabOrderMessage(ctx, order, "CA")
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// EnterLabResult records the result of one test of the order. The test's
+// specimen must have been received. A preliminary result is replaced by the
+// next entry; a final one can only be corrected.
+func EnterLabResult() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.LabResultEntry
+		if err := c.BindJSON(&entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(entry); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		result, status, msg := enterLabResult(ctx, c.Param("order_id"), entry, c.GetString("uid"), nil)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetLabResult() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var result models.LabResult
+		if err := labResultCollection.FindOne(ctx, bson.M{"result_id": c.Param("result_id")}).Decode(&result); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "lab result not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// CorrectLabResult changes a released result. The replaced value is kept as
+// a revision and the result goes back to the ordering doctor for review.
+func CorrectLabResult() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var correction models.LabResultCorrection
+		if err := c.BindJSON(&correction); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(correction); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		result, status, msg := correctLabResult(ctx, c.Param("result_id"), correction, c.GetString("uid"))
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// ReviewLabResult marks a released result as seen by the ordering doctor,
+// who must be the one signed in.
+func ReviewLabResult() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		doctorId, ok := signedInDoctor(c)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "sign in as a doctor to review a result"})
+			return
+		}
+
+		var result models.LabResult
+		if err := labResultCollection.FindOne(ctx, bson.M{"result_id": c.Param("result_id")}).Decode(&result); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "lab result not found"})
+			return
+		}
+		if doctorId != result.Doctor_id {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the ordering doctor can review the result"})
+			return
+		}
+
+		err := labResultCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"result_id": result.Result_id, "status": bson.M{"$in": releasedResults}, "reviewed_at": nil},
+			bson.M{"$set": bson.M{"reviewed_by": doctorId, "reviewed_at": helper.Now(), "updated_at": helper.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&result)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the result is preliminary or already reviewed"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "lab result update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetUnreviewedLabResults is the doctor's inbox: released results of their
+// orders not yet reviewed, critical and abnormal ones first, then oldest
+// first.
+func GetUnreviewedLabResults() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"doctor_id": c.Param("doctor_id"), "status": bson.M{"$in": releasedResults}, "reviewed_at": nil}
+		cursor, err := labResultCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"resulted_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab results"})
+			return
+		}
+		results := []models.LabResult{}
+		if err = cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab results"})
+			return
+		}
+		sort.SliceStable(results, func(i, j int) bool {
+			return flagRank(results[i].Flag) > flagRank(results[j].Flag)
+		})
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// GetPatientLabResults is the patient's results, newest first, filtered by
+// test_code and status.
+func GetPatientLabResults() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"patient_id": c.Param("patient_id")}
+		for _, key := range []string{"test_code", "status"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		cursor, err := labResultCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"resulted_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab results"})
+			return
+		}
+		results := []models.LabResult{}
+		if err = cursor.All(ctx, &results); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab results"})
+			return
+		}
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// enterLabResult checks a value against the test's catalog entry, flags it
+// against the reference range and stores it on the order's test line. Both
+// EnterLabResult and inbound HL7 ORU messages use it. When receivedAt is given
+// the test's specimen is marked received then if it was not, as a result
+// from an analyzer shows the laboratory has it. A non-empty message is the
+// problem, with its status.
+func enterLabResult(ctx context.Context, orderId string, entry models.LabResultEntry, performedBy string, receivedAt *time.Time) (models.LabResult, int, string) {
+	labMu.Lock()
+	defer labMu.Unlock()
+
+	var order models.LabOrder
+	if err := labOrderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order); err != nil {
+		return models.LabResult{}, http.StatusNotFound, "lab order not found"
+	}
+	if order.Status == labCancelled {
+		return models.LabResult{}, http.StatusConflict, "the lab order is cancelled"
+	}
+	line := findLabLine(&order, *entry.Line_id)
+	if line == nil {
+		return models.LabResult{}, http.StatusBadRequest, "the order has no test " + *entry.Line_id
+	}
+	specimen := findSpecimen(&order, *line.Specimen_type)
+	if specimen != nil && specimen.Status != specimenReceived && receivedAt != nil {
+		specimen.Status = specimenReceived
+		specimen.Received_by, specimen.Received_at = &performedBy, receivedAt
+	}
+	if specimen == nil || specimen.Status != specimenReceived {
+		return models.LabResult{}, http.StatusConflict, fmt.Sprintf("the %s specimen has not been received", *line.Specimen_type)
+	}
+
+	var test models.LabTest
+	if err := labTestCollection.FindOne(ctx, bson.M{"code": line.Test_code}).Decode(&test); err != nil {
+		return models.LabResult{}, http.StatusInternalServerError, "error occured while reading the lab catalog"
+	}
+	if msg := checkLabValue(test, entry.Value_numeric, entry.Value_text); msg != "" {
+		return models.LabResult{}, http.StatusBadRequest, msg
+	}
+
+	result := models.LabResult{Revisions: []models.LabResultRevision{}}
+	if line.Result_id != nil {
+		if err := labResultCollection.FindOne(ctx, bson.M{"result_id": line.Result_id}).Decode(&result); err != nil {
+			return models.LabResult{}, http.StatusInternalServerError, "error occured while reading the lab result"
+		}
+		if result.Status != resultPreliminary {
+			return models.LabResult{}, http.StatusConflict, "the result has been released; correct it instead"
+		}
+	} else {
+		result.ID = primitive.NewObjectID()
+		result.Result_id = result.ID.Hex()
+		result.Created_at = helper.Now()
+	}
+	result.Order_id = order.Order_id
+	result.Line_id = line.Line_id
+	result.Patient_id = *order.Patient_id
+	result.Doctor_id = *order.Doctor_id
+	result.Test_code = *test.Code
+	result.Test_name = *test.Name
+	result.Comment = entry.Comment
+	result.Status = *entry.Status
+	result.Performed_by = performedBy
+	result.Resulted_at = helper.Now()
+	result.Updated_at = helper.Now()
+	setLabValue(&result, test, entry.Value_numeric, entry.Value_text)
+
+	if _, err := labResultCollection.ReplaceOne(ctx, bson.M{"result_id": result.Result_id}, result, options.Replace().SetUpsert(true)); err != nil {
+		return models.LabResult{}, http.StatusInternalServerError, "lab result was not saved"
+	}
+	line.Result_id = &result.Result_id
+	line.Result_status = &result.Status
+	if err := saveLabOrder(ctx, &order); err != nil {
+		return models.LabResult{}, http.StatusInternalServerError, "lab result was saved but the order was not updated"
+	}
+	return result, 0, ""
+}
+
+// correctLabResult replaces a final result's value, keeps the old one as a
+// revision and clears the review, so the doctor sees the result again. It
+// backs CorrectLabResult and corrected results in inbound HL7 ORU messages.
+func correctLabResult(ctx context.Context, resultId string, correction models.LabResultCorrection, correctedBy string) (models.LabResult, int, string) {
+	labMu.Lock()
+	defer labMu.Unlock()
+
+	var result models.LabResult
+	if err := labResultCollection.FindOne(ctx, bson.M{"result_id": resultId}).Decode(&result); err != nil {
+		return result, http.StatusNotFound, "lab result not found"
+	}
+	if result.Status == resultPreliminary {
+		return result, http.StatusConflict, "preliminary results are entered again, not corrected"
+	}
+	var test models.LabTest
+	if err := labTestCollection.FindOne(ctx, bson.M{"code": result.Test_code}).Decode(&test); err != nil {
+		return result, http.StatusInternalServerError, "error occured while reading the lab catalog"
+	}
+	if msg := checkLabValue(test, correction.Value_numeric, correction.Value_text); msg != "" {
+		return result, http.StatusBadRequest, msg
+	}
+
+	result.Revisions = append(result.Revisions, models.LabResultRevision{
+		Value_numeric: result.Value_numeric,
+		Value_text:    result.Value_text,
+		Flag:          result.Flag,
+		Status:        result.Status,
+		Resulted_at:   result.Resulted_at,
+		Replaced_by:   correctedBy,
+		Replaced_at:   helper.Now(),
+		Reason:        *correction.Reason,
+	})
+	setLabValue(&result, test, correction.Value_numeric, correction.Value_text)
+	if correction.Comment != nil {
+		result.Comment = correction.Comment
+	}
+	result.Status = resultCorrected
+	result.Performed_by = correctedBy
+	result.Resulted_at = helper.Now()
+	result.Reviewed_by, result.Reviewed_at = nil, nil
+	result.Updated_at = helper.Now()
+
+	if _, err := labResultCollection.ReplaceOne(ctx, bson.M{"result_id": result.Result_id}, result); err != nil {
+		return result, http.StatusInternalServerError, "lab result was not corrected"
+	}
+	_, err := labOrderCollection.UpdateOne(ctx,
+		bson.M{"order_id": result.Order_id, "tests.line_id": result.Line_id},
+		bson.M{"$set": bson.M{"tests.$.result_status": resultCorrected, "updated_at": helper.Now()}},
+	)
+	if err != nil {
+		return result, http.StatusInternalServerError, "lab result was corrected but the order was not updated"
+	}
+	return result, 0, ""
+}
+
+// moveSpecimens moves the order's specimens of the requested types from one
+// step to the next and writes the response.
+func moveSpecimens(c *gin.Context, from string, to string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var event models.SpecimenEvent
+	if err := c.BindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if validationErr := validate.Struct(event); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return
+	}
+	at := helper.Now()
+	if event.At != nil {
+		at = event.At.UTC().Truncate(time.Second)
+	}
+	if at.After(helper.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at cannot be in the future"})
+		return
+	}
+
+	labMu.Lock()
+	defer labMu.Unlock()
+
+	var order models.LabOrder
+	if err := labOrderCollection.FindOne(ctx, bson.M{"order_id": c.Param("order_id")}).Decode(&order); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "lab order not found"})
+		return
+	}
+	if order.Status == labCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "the lab order is cancelled"})
+		return
+	}
+
+	types := event.Specimen_types
+	if len(types) == 0 {
+		for _, specimen := range order.Specimens {
+			if specimen.Status == from {
+				types = append(types, specimen.Specimen_type)
+			}
+		}
+	}
+	if len(types) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("the order has no %s specimen", from)})
+		return
+	}
+	by := c.GetString("uid")
+	for _, specimenType := range types {
+		specimen := findSpecimen(&order, specimenType)
+		if specimen == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("the order needs no %s specimen", specimenType)})
+			return
+		}
+		if specimen.Status != from {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("the %s specimen is %s", specimenType, specimen.Status)})
+			return
+		}
+		specimen.Status = to
+		if to == specimenCollected {
+			specimen.Collected_by, specimen.Collected_at = &by, &at
+			if event.Label != nil {
+				specimen.Label = event.Label
+			}
+		} else {
+			if specimen.Collected_at != nil && at.Before(*specimen.Collected_at) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("the %s specimen cannot be received before it was collected", specimenType)})
+				return
+			}
+			specimen.Received_by, specimen.Received_at = &by, &at
+		}
+	}
+
+	if err := saveLabOrder(ctx, &order); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "lab order update failed"})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// saveLabOrder writes back the specimens and tests of the order with its
+// derived status. The caller holds labMu.
+func saveLabOrder(ctx context.Context, order *models.LabOrder) error {
+	order.Status = deriveLabOrderStatus(*order)
+	order.Updated_at = helper.Now()
+	_, err := labOrderCollection.UpdateOne(ctx, bson.M{"order_id": order.Order_id}, bson.M{"$set": bson.M{
+		"specimens":  order.Specimens,
+		"tests":      order.Tests,
+		"status":     order.Status,
+		"updated_at": order.Updated_at,
+	}})
+	return err
+}
+
+// deriveLabOrderStatus is the status the order's specimens and results put
+// it in. An order is COLLECTED and RECEIVED only once all its specimens are.
+func deriveLabOrderStatus(order models.LabOrder) string {
+	if order.Status == labCancelled {
+		return labCancelled
+	}
+	resulted, released := 0, 0
+	for _, line := range order.Tests {
+		if line.Result_status != nil {
+			resulted++
+			if *line.Result_status != resultPreliminary {
+				released++
+			}
+		}
+	}
+	switch {
+	case released == len(order.Tests):
+		return labCompleted
+	case resulted > 0:
+		return labPartial
+	}
+
+	collected, received := 0, 0
+	for _, specimen := range order.Specimens {
+		switch specimen.Status {
+		case specimenReceived:
+			received++
+			collected++
+		case specimenCollected:
+			collected++
+		}
+	}
+	switch {
+	case received == len(order.Specimens):
+		return labReceived
+	case collected == len(order.Specimens):
+		return labCollected
+	}
+	return labOrdered
+}
+
+// checkLabValue checks the value fits the test: numeric tests need a number,
+// text tests need text and no number.
+func checkLabValue(test models.LabTest, numeric *float64, text *string) string {
+	if *test.Result_type == resultNumeric && numeric == nil {
+		return fmt.Sprintf("%s needs a numeric value", *test.Code)
+	}
+	if *test.Result_type == resultText && (text == nil || *text == "" || numeric != nil) {
+		return fmt.Sprintf("%s needs a text value", *test.Code)
+	}
+	return ""
+}
+
+// setLabValue sets the value of the result with the catalog unit, range and
+// the flag against it.
+func setLabValue(result *models.LabResult, test models.LabTest, numeric *float64, text *string) {
+	result.Value_numeric = numeric
+	result.Value_text = text
+	result.Unit = test.Unit
+	result.Reference_low = test.Reference_low
+	result.Reference_high = test.Reference_high
+	result.Flag = nil
+	if numeric != nil {
+		result.Flag = labFlag(test, *numeric)
+	}
+}
+
+func findLabLine(order *models.LabOrder, lineId string) *models.LabOrderTest {
+	for i := range order.Tests {
+		if order.Tests[i].Line_id == lineId {
+			return &order.Tests[i]
+		}
+	}
+	return nil
+}
+
+func findSpecimen(order *models.LabOrder, specimenType string) *models.Specimen {
+	for i := range order.Specimens {
+		if order.Specimens[i].Specimen_type == specimenType {
+			return &order.Specimens[i]
+		}
+	}
+	return nil
+}
+
+// flagRank orders results for attention: critical, then abnormal, then the
+// rest.
+func flagRank(flag *string) int {
+	if flag == nil {
+		return 0
+	}
+	switch *flag {
+	case helper.FlagCriticalLow, helper.FlagCriticalHigh:
+		return 2
+	case helper.FlagLow, helper.FlagHigh:
+		return 1
+	}
+	return 0
+}
+
+// recentLabResults is the patient's latest released results, for the
+// patient record.
+func recentLabResults(ctx context.Context, patientId string, limit int64) ([]models.LabResult, error) {
+	cursor, err := labResultCollection.Find(ctx,
+		bson.M{"patient_id": patientId, "status": bson.M{"$in": releasedResults}},
+		options.Find().SetSort(bson.M{"resulted_at": -1}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	results := []models.LabResult{}
+	err = cursor.All(ctx, &results)
+	return results, err
+}
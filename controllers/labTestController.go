@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var labTestCollection *mongo.Collection = database.OpenCollection(database.Client, "labTest")
+
+const (
+	resultNumeric = "NUMERIC"
+	resultText    = "TEXT"
+)
+
+func CreateLabTest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var test models.LabTest
+		if err := c.BindJSON(&test); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(test); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		code := strings.ToUpper(strings.TrimSpace(*test.Code))
+		test.Code = &code
+		if msg := checkLabRanges(test); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		count, err := labTestCollection.CountDocuments(ctx, bson.M{"code": test.Code})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the code"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "a lab test with this code already exists"})
+			return
+		}
+
+		if test.Active == nil {
+			active := true
+			test.Active = &active
+		}
+		test.Created_at = helper.Now()
+		test.Updated_at = helper.Now()
+		test.ID = primitive.NewObjectID()
+		test.Test_id = test.ID.Hex()
+
+		if _, insertErr := labTestCollection.InsertOne(ctx, test); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "lab test was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, test)
+	}
+}
+
+// GetLabTests lists the catalog, filtered by specimen_type and active.
+func GetLabTests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if specimenType := c.Query("specimen_type"); specimenType != "" {
+			filter["specimen_type"] = specimenType
+		}
+		if active := c.Query("active"); active != "" {
+			filter["active"] = active == "true"
+		}
+		cursor, err := labTestCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"code": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab tests"})
+			return
+		}
+		tests := []models.LabTest{}
+		if err = cursor.All(ctx, &tests); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab tests"})
+			return
+		}
+		c.JSON(http.StatusOK, tests)
+	}
+}
+
+func UpdateLabTest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var change models.LabTestChange
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(change); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var test models.LabTest
+		if err := labTestCollection.FindOne(ctx, bson.M{"test_id": c.Param("test_id")}).Decode(&test); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "lab test not found"})
+			return
+		}
+
+		var updateObj primitive.D
+		if change.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: change.Name})
+		}
+		if change.Unit != nil {
+			test.Unit = change.Unit
+			updateObj = append(updateObj, bson.E{Key: "unit", Value: change.Unit})
+		}
+		if change.Reference_low != nil {
+			test.Reference_low = change.Reference_low
+			updateObj = append(updateObj, bson.E{Key: "reference_low", Value: change.Reference_low})
+		}
+		if change.Reference_high != nil {
+			test.Reference_high = change.Reference_high
+			updateObj = append(updateObj, bson.E{Key: "reference_high", Value: change.Reference_high})
+		}
+		if change.Critical_low != nil {
+			test.Critical_low = change.Critical_low
+			updateObj = append(updateObj, bson.E{Key: "critical_low", Value: change.Critical_low})
+		}
+		if change.Critical_high != nil {
+			test.Critical_high = change.Critical_high
+			updateObj = append(updateObj, bson.E{Key: "critical_high", Value: change.Critical_high})
+		}
+		if change.Turnaround_hours != nil {
+			updateObj = append(updateObj, bson.E{Key: "turnaround_hours", Value: change.Turnaround_hours})
+		}
+		if change.Active != nil {
+			updateObj = append(updateObj, bson.E{Key: "active", Value: change.Active})
+		}
+		if msg := checkLabRanges(test); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: helper.Now()})
+
+		err := labTestCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"test_id": test.Test_id},
+			bson.D{{Key: "$set", Value: updateObj}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&test)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "lab test update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, test)
+	}
+}
+
+// checkLabRanges checks the ranges of a catalog entry: only numeric tests
+// have them, and each low bound is below its high bound.
+func checkLabRanges(test models.LabTest) string {
+	bounds := []*float64{test.Reference_low, test.Reference_high, test.Critical_low, test.Critical_high}
+	if *test.Result_type == resultText {
+		for _, bound := range bounds {
+			if bound != nil {
+				return "text results have no reference range"
+			}
+		}
+		return ""
+	}
+	if test.Reference_low != nil && test.Reference_high != nil && *test.Reference_low >= *test.Reference_high {
+		return "reference_low must be below reference_high"
+	}
+	if test.Critical_low != nil && test.Critical_high != nil && *test.Critical_low >= *test.Critical_high {
+		return "critical_low must be below critical_high"
+	}
+	if test.Critical_low != nil && test.Reference_low != nil && *test.Critical_low > *test.Reference_low {
+		return "critical_low cannot be above reference_low"
+	}
+	if test.Critical_high != nil && test.Reference_high != nil && *test.Critical_high < *test.Reference_high {
+		return "critical_high cannot be below reference_high"
+	}
+	return ""
+}
+
+// labFlag grades a numeric result against the catalog ranges, or nil when
+// the test has none.
+func labFlag(test models.LabTest, value float64) *string {
+	if test.Reference_low == nil && test.Reference_high == nil && test.Critical_low == nil && test.Critical_high == nil {
+		return nil
+	}
+	flag := helper.FlagNormal
+	switch {
+	case test.Critical_low != nil && value < *test.Critical_low:
+		flag = helper.FlagCriticalLow
+	case test.Critical_high != nil && value > *test.Critical_high:
+		flag = helper.FlagCriticalHigh
+	case test.Reference_low != nil && value < *test.Reference_low:
+		flag = helper.FlagLow
+	case test.Reference_high != nil && value > *test.Reference_high:
+		flag = helper.FlagHigh
+	}
+	return &flag
+}
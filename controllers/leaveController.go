@@ -0,0 +1,646 @@
+package controller
+
+import (
+	"context"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var leaveCollection *mongo.Collection = database.OpenCollection(database.Client, "doctorLeave")
+var holidayCollection *mongo.Collection = database.OpenCollection(database.Client, "holiday")
+
+const (
+	leaveActive    = "ACTIVE"
+	leaveWithdrawn = "WITHDRAWN"
+
+	appointmentNeedsReschedule = "NEEDS_RESCHEDULE"
+
+	// how many alternatives a patient is offered, per kind
+	proposalsPerKind = 3
+)
+
+// RecordDoctorLeave stores the leave and flags every appointment of the
+// doctor inside it for rescheduling.
+func RecordDoctorLeave() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var leave models.DoctorLeave
+		var doctor models.Doctor
+
+		if err := c.BindJSON(&leave); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(leave)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		leave.Doctor_id = c.Param("doctor_id")
+		if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": leave.Doctor_id}).Decode(&doctor); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message:Doctor not found"})
+			return
+		}
+
+		start, end := leave.Start_Date.UTC(), leave.End_Date.UTC()
+		leave.Start_Date, leave.End_Date = &start, &end
+		leave.Status = leaveActive
+		leave.Created_at = helper.Now()
+		leave.Updated_at = helper.Now()
+		leave.ID = primitive.NewObjectID()
+		leave.Leave_id = leave.ID.Hex()
+
+		if _, insertErr := leaveCollection.InsertOne(ctx, leave); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "leave was not recorded"})
+			return
+		}
+
+		affected, err := scheduledAppointments(ctx, bson.M{
+			"doctor_id":        leave.Doctor_id,
+			"appointment_date": bson.M{"$gt": start.Add(-24 * time.Hour), "$lt": end},
+		}, start, end)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
+			return
+		}
+
+		reason := "the doctor is on leave"
+		if leave.Reason != nil {
+			reason += ": " + *leave.Reason
+		}
+		leave.Affected = flagForReschedule(ctx, affected, models.Reschedule{Reason: reason, Leave_id: leave.Leave_id}, end)
+
+		_, err = leaveCollection.UpdateOne(ctx, bson.M{"leave_id": leave.Leave_id}, bson.M{"$set": bson.M{"affected": leave.Affected}})
+		if err != nil {
+			log.Printf("leave: %v", err)
+		}
+
+		c.JSON(http.StatusOK, leave)
+	}
+}
+
+func GetDoctorLeave() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.M{"start_date": -1})
+		result, err := leaveCollection.Find(ctx, bson.M{"doctor_id": c.Param("doctor_id")}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing leave"})
+			return
+		}
+
+		allLeave := []models.DoctorLeave{}
+		if err = result.All(ctx, &allLeave); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing leave"})
+			return
+		}
+		c.JSON(http.StatusOK, allLeave)
+	}
+}
+
+// WithdrawDoctorLeave cancels the leave and puts the appointments it flagged,
+// and that nobody has resolved yet, back on the schedule.
+func WithdrawDoctorLeave() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		leaveId := c.Param("leave_id")
+		result, err := leaveCollection.UpdateOne(
+			ctx,
+			bson.M{"leave_id": leaveId, "doctor_id": c.Param("doctor_id"), "status": leaveActive},
+			bson.M{"$set": bson.M{"status": leaveWithdrawn, "updated_at": helper.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "leave update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "active leave not found"})
+			return
+		}
+
+		restored, err := restoreFlaggedAppointments(ctx, bson.M{"reschedule.leave_id": leaveId}, "the doctor's leave was withdrawn")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "appointment update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"leave_id": leaveId, "restored": restored})
+	}
+}
+
+// CreateHoliday closes the facility for a day and flags that day's
+// appointments for rescheduling.
+func CreateHoliday() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var holiday models.Holiday
+
+		if err := c.BindJSON(&holiday); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(holiday)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := holidayCollection.CountDocuments(ctx, bson.M{"date": holiday.Date})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the holiday calendar"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "this date is already a holiday"})
+			return
+		}
+
+		holiday.Created_at = helper.Now()
+		holiday.Updated_at = helper.Now()
+		holiday.ID = primitive.NewObjectID()
+		holiday.Holiday_id = holiday.ID.Hex()
+
+		if _, insertErr := holidayCollection.InsertOne(ctx, holiday); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "holiday was not created"})
+			return
+		}
+
+		day, _ := time.ParseInLocation("2006-01-02", *holiday.Date, helper.FacilityLocation())
+		start, end := day.UTC(), day.AddDate(0, 0, 1).UTC()
+
+		affected, err := scheduledAppointments(ctx, bson.M{
+			"appointment_date": bson.M{"$gte": start, "$lt": end},
+		}, start, end)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
+			return
+		}
+
+		holiday.Affected = flagForReschedule(ctx, affected, models.Reschedule{Reason: "the facility is closed for " + *holiday.Name, Holiday_id: holiday.Holiday_id}, end)
+
+		_, err = holidayCollection.UpdateOne(ctx, bson.M{"holiday_id": holiday.Holiday_id}, bson.M{"$set": bson.M{"affected": holiday.Affected}})
+		if err != nil {
+			log.Printf("holiday: %v", err)
+		}
+
+		c.JSON(http.StatusOK, holiday)
+	}
+}
+
+func GetHolidays() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if year := c.Query("year"); year != "" {
+			filter["date"] = bson.M{"$regex": "^" + year + "-"}
+		}
+
+		result, err := holidayCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"date": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing holidays"})
+			return
+		}
+
+		allHolidays := []models.Holiday{}
+		if err = result.All(ctx, &allHolidays); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing holidays"})
+			return
+		}
+		c.JSON(http.StatusOK, allHolidays)
+	}
+}
+
+func DeleteHoliday() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		holidayId := c.Param("holiday_id")
+		result, err := holidayCollection.DeleteOne(ctx, bson.M{"holiday_id": holidayId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "holiday was not deleted"})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "holiday not found"})
+			return
+		}
+
+		restored, err := restoreFlaggedAppointments(ctx, bson.M{"reschedule.holiday_id": holidayId}, "the holiday was cancelled")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "appointment update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"holiday_id": holidayId, "restored": restored})
+	}
+}
+
+// GetRescheduleWorklist lists the appointments still waiting for a new slot.
+func GetRescheduleWorklist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"status": appointmentNeedsReschedule}
+		if doctorId := c.Query("doctor_id"); doctorId != "" {
+			filter["doctor_id"] = doctorId
+		}
+
+		result, err := appointmentCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"appointment_date": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
+			return
+		}
+
+		appointments := []models.Appointment{}
+		if err = result.All(ctx, &appointments); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
+			return
+		}
+		for i := range appointments {
+			localizeAppointment(&appointments[i])
+		}
+		c.JSON(http.StatusOK, appointments)
+	}
+}
+
+// ResolveReschedule moves a flagged appointment to a new slot, with the same
+// or another doctor, or cancels it.
+func ResolveReschedule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var decision models.RescheduleDecision
+
+		if err := c.BindJSON(&decision); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(decision)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var appointment models.Appointment
+		err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": c.Param("appointment_id"), "status": appointmentNeedsReschedule}).Decode(&appointment)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no appointment waiting to be rescheduled with this id"})
+			return
+		}
+
+		now := helper.Now()
+		if decision.Cancel {
+			_, err = appointmentCollection.UpdateOne(ctx, bson.M{"appointment_id": appointment.Appointment_id}, bson.M{"$set": bson.M{
+				"status":                 appointmentCancelled,
+				"reschedule.resolved_at": now,
+				"reschedule.resolution":  "CANCELLED",
+				"updated_at":             now,
+			}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "appointment update failed"})
+				return
+			}
+			queueCancellationMessages(ctx, []string{appointment.Appointment_id})
+			c.JSON(http.StatusOK, gin.H{"Appointment_id": appointment.Appointment_id, "status": appointmentCancelled})
+			return
+		}
+
+		doctorId := doctorIdOf(appointment)
+		if decision.Doctor_id != nil {
+			var doctor models.Doctor
+			if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": decision.Doctor_id}).Decode(&doctor); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "message:Doctor not found"})
+				return
+			}
+			doctorId = *decision.Doctor_id
+		}
+
+		conflict, err := rescheduleAppointment(ctx, appointment, *decision.Appointment_Date, appointmentMinutes(appointment), doctorId, bson.M{
+			"status":                 appointmentScheduled,
+			"reschedule.resolved_at": now,
+			"reschedule.resolution":  "RESCHEDULED",
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "appointment update failed"})
+			return
+		}
+		if conflict != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": conflict.Message, "conflict": conflict})
+			return
+		}
+
+		appointment.Appointment_Date = decision.Appointment_Date.UTC()
+		appointment.Doctor_id = &doctorId
+		sendAppointmentConfirmation(ctx, appointment)
+
+		c.JSON(http.StatusOK, gin.H{"Appointment_id": appointment.Appointment_id, "status": appointmentScheduled, "Appointment_date": appointment.Appointment_Date})
+	}
+}
+
+// scheduledAppointments returns the scheduled appointments matching filter
+// that overlap [start, end).
+func scheduledAppointments(ctx context.Context, filter bson.M, start time.Time, end time.Time) ([]models.Appointment, error) {
+	filter["status"] = bson.M{"$nin": []string{appointmentCancelled, appointmentNeedsReschedule}}
+
+	cursor, err := appointmentCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.Appointment
+	if err = cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	var overlapping []models.Appointment
+	for _, appointment := range candidates {
+		appointmentEnd := appointment.Appointment_Date.Add(time.Duration(appointmentMinutes(appointment)) * time.Minute)
+		if appointment.Appointment_Date.Before(end) && appointmentEnd.After(start) {
+			overlapping = append(overlapping, appointment)
+		}
+	}
+	return overlapping, nil
+}
+
+// flagForReschedule marks each appointment as needing a new slot, attaches
+// proposed alternatives from "after" onwards and tells the patient. It returns
+// how many were flagged.
+func flagForReschedule(ctx context.Context, appointments []models.Appointment, reschedule models.Reschedule, after time.Time) int {
+	flagged := 0
+	for _, appointment := range appointments {
+		flag := reschedule
+		flag.Raised_at = helper.Now()
+		flag.Proposals = proposeSlots(ctx, appointment, after)
+
+		_, err := appointmentCollection.UpdateOne(ctx, bson.M{"appointment_id": appointment.Appointment_id}, bson.M{"$set": bson.M{
+			"status":     appointmentNeedsReschedule,
+			"reschedule": flag,
+			"updated_at": helper.Now(),
+		}})
+		if err != nil {
+			log.Printf("reschedule: %v", err)
+			continue
+		}
+		flagged++
+
+		notifyReschedule(ctx, appointment, flag)
+	}
+	return flagged
+}
+
+// proposeSlots offers the same doctor's first free slots after the
+// unavailability, then the earliest slots of other doctors with the same
+// speciality.
+func proposeSlots(ctx context.Context, appointment models.Appointment, after time.Time) []models.SlotProposal {
+	proposals := []models.SlotProposal{}
+	if appointment.Doctor_id == nil {
+		return proposals
+	}
+
+	now := helper.Now()
+	if after.Before(now) {
+		after = now
+	}
+	minutes := occupiedMinutes(appointment)
+
+	var doctor models.Doctor
+	if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": appointment.Doctor_id}).Decode(&doctor); err != nil {
+		return proposals
+	}
+
+	slots, err := findFreeSlots(ctx, doctor.Doctor_id, after, after.AddDate(0, 0, 30), minutes, proposalsPerKind)
+	if err != nil {
+		log.Printf("reschedule: %v", err)
+	}
+	for _, slot := range slots {
+		proposals = append(proposals, slotProposal(doctor, slot))
+	}
+
+	if doctor.Speciality == nil {
+		return proposals
+	}
+
+	// colleagues can usually see the patient close to the original time
+	from := appointment.Appointment_Date.Add(-24 * time.Hour)
+	if from.Before(now) {
+		from = now
+	}
+
+	cursor, err := doctorCollection.Find(ctx, bson.M{
+		"speciality": doctor.Speciality,
+		"doctor_id":  bson.M{"$ne": doctor.Doctor_id},
+	}, options.Find().SetLimit(10))
+	if err != nil {
+		log.Printf("reschedule: %v", err)
+		return proposals
+	}
+	var colleagues []models.Doctor
+	if err = cursor.All(ctx, &colleagues); err != nil {
+		log.Printf("reschedule: %v", err)
+		return proposals
+	}
+
+	offered := 0
+	for _, colleague := range colleagues {
+		slots, err := findFreeSlots(ctx, colleague.Doctor_id, from, from.AddDate(0, 0, 14), minutes, 1)
+		if err != nil || len(slots) == 0 {
+			continue
+		}
+		proposals = append(proposals, slotProposal(colleague, slots[0]))
+		offered++
+		if offered >= proposalsPerKind {
+			break
+		}
+	}
+	return proposals
+}
+
+func slotProposal(doctor models.Doctor, slot time.Time) models.SlotProposal {
+	name := ""
+	if doctor.Name != nil {
+		name = *doctor.Name
+	}
+	return models.SlotProposal{
+		Doctor_id:        doctor.Doctor_id,
+		Doctor_name:      name,
+		Appointment_Date: slot,
+		Date_local:       helper.FormatLocal(slot),
+	}
+}
+
+func notifyReschedule(ctx context.Context, appointment models.Appointment, reschedule models.Reschedule) {
+	if appointment.Patient_id == nil {
+		return
+	}
+
+	var patient models.Patient
+	if err := patientCollection.FindOne(ctx, bson.M{"patient_id": appointment.Patient_id}).Decode(&patient); err != nil {
+		log.Printf("notifications: patient %s of appointment %s not found", *appointment.Patient_id, appointment.Appointment_id)
+		return
+	}
+
+	names := doctorNames(ctx, []models.Appointment{appointment})
+	data := helper.RescheduleData{
+		Patient_name:   *patient.First_name,
+		Doctor_name:    names[doctorIdOf(appointment)],
+		Appointment_at: helper.InFacility(appointment.Appointment_Date).Format("Mon 2 Jan 2006 15:04 MST"),
+		Appointment_id: appointment.Appointment_id,
+		Reason:         reschedule.Reason,
+	}
+	for _, proposal := range reschedule.Proposals {
+		data.Proposals = append(data.Proposals, helper.InFacility(proposal.Appointment_Date).Format("Mon 2 Jan 15:04")+" with "+proposal.Doctor_name)
+	}
+	queuePatientMessage(ctx, patient, "reschedule", "", appointment, data, nil)
+}
+
+// restoreFlaggedAppointments puts unresolved flagged appointments matching
+// filter back on the schedule when whatever flagged them is withdrawn, and
+// tells each patient why. An appointment that still cannot go ahead, because
+// of other leave, a holiday or a clash booked in the meantime, stays flagged
+// with the reason that now applies.
+func restoreFlaggedAppointments(ctx context.Context, filter bson.M, why string) (int64, error) {
+	filter["status"] = appointmentNeedsReschedule
+
+	cursor, err := appointmentCollection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var flagged []models.Appointment
+	if err = cursor.All(ctx, &flagged); err != nil {
+		return 0, err
+	}
+
+	var restored []models.Appointment
+	bookingMu.Lock()
+	for _, appointment := range flagged {
+		ok, err := restoreFlaggedAppointment(ctx, appointment)
+		if err != nil {
+			bookingMu.Unlock()
+			return 0, err
+		}
+		if ok {
+			status := appointmentScheduled
+			appointment.Status = &status
+			restored = append(restored, appointment)
+		}
+	}
+	bookingMu.Unlock()
+
+	for _, appointment := range restored {
+		queueAppointmentMessage(ctx, appointment, "S13")
+		notifyRestored(ctx, appointment, why)
+	}
+	return int64(len(restored)), nil
+}
+
+// restoreFlaggedAppointment re-runs the booking checks for one flagged
+// appointment and either schedules it again or points its flag at whatever
+// still stands in its way. The caller holds bookingMu.
+func restoreFlaggedAppointment(ctx context.Context, appointment models.Appointment) (bool, error) {
+	minutes := appointmentMinutes(appointment)
+	conflict, err := moveConflict(ctx, appointment, appointment.Appointment_Date, minutes, doctorIdOf(appointment), appointment.Appointment_id)
+	if err != nil {
+		return false, err
+	}
+
+	now := helper.Now()
+	filter := bson.M{"appointment_id": appointment.Appointment_id, "status": appointmentNeedsReschedule}
+	if conflict != nil {
+		end := appointment.Appointment_Date.Add(time.Duration(minutes+appointment.Buffer_minutes) * time.Minute)
+		leaveId, holidayId, err := flagSource(ctx, doctorIdOf(appointment), appointment.Appointment_Date, end)
+		if err != nil {
+			return false, err
+		}
+		_, err = appointmentCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+			"reschedule.reason":     conflict.Message,
+			"reschedule.leave_id":   leaveId,
+			"reschedule.holiday_id": holidayId,
+			"updated_at":            now,
+		}})
+		return false, err
+	}
+
+	result, err := appointmentCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"status":                 appointmentScheduled,
+		"reschedule.resolved_at": now,
+		"reschedule.resolution":  "RESTORED",
+		"updated_at":             now,
+	}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// flagSource finds the holiday or leave that keeps the doctor from working in
+// [start, end), so withdrawing it later restores the appointment. Both are ""
+// when only a clash remains.
+func flagSource(ctx context.Context, doctorId string, start time.Time, end time.Time) (string, string, error) {
+	var holiday models.Holiday
+	err := holidayCollection.FindOne(ctx, bson.M{"date": bson.M{
+		"$gte": helper.InFacility(start).Format("2006-01-02"),
+		"$lte": helper.InFacility(end.Add(-time.Nanosecond)).Format("2006-01-02"),
+	}}).Decode(&holiday)
+	if err == nil {
+		return "", holiday.Holiday_id, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", "", err
+	}
+
+	leaves, err := doctorLeaves(ctx, doctorId, start, end)
+	if err != nil {
+		return "", "", err
+	}
+	if len(leaves) > 0 {
+		return leaves[0].Leave_id, "", nil
+	}
+	return "", "", nil
+}
+
+// notifyRestored tells the patient a flagged appointment goes ahead after all.
+func notifyRestored(ctx context.Context, appointment models.Appointment, why string) {
+	if appointment.Patient_id == nil {
+		return
+	}
+
+	var patient models.Patient
+	if err := patientCollection.FindOne(ctx, bson.M{"patient_id": appointment.Patient_id}).Decode(&patient); err != nil {
+		log.Printf("notifications: patient %s of appointment %s not found", *appointment.Patient_id, appointment.Appointment_id)
+		return
+	}
+
+	names := doctorNames(ctx, []models.Appointment{appointment})
+	data := helper.RescheduleData{
+		Patient_name:   *patient.First_name,
+		Doctor_name:    names[doctorIdOf(appointment)],
+		Appointment_at: helper.InFacility(appointment.Appointment_Date).Format("Mon 2 Jan 2006 15:04 MST"),
+		Appointment_id: appointment.Appointment_id,
+		Reason:         why,
+	}
+	queuePatientMessage(ctx, patient, "restored", "", appointment, data, nil)
+}
@@ -0,0 +1,340 @@
+package controller
+
+import (
+	"context"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var notificationCollection *mongo.Collection = database.OpenCollection(database.Client, "notification")
+
+const (
+	notificationPending  = "PENDING"
+	notificationRetrying = "RETRYING"
+	notificationSent     = "SENT"
+	notificationFailed   = "FAILED"
+
+	maxNotificationAttempts = 5
+)
+
+func GetNotifications() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"patient_id", "appointment_id", "status", "channel"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+
+		opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(500)
+		result, err := notificationCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing notifications"})
+			return
+		}
+
+		allNotifications := []models.Notification{}
+		if err = result.All(ctx, &allNotifications); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing notifications"})
+			return
+		}
+		c.JSON(http.StatusOK, allNotifications)
+	}
+}
+
+func UpdateNotificationPreferences() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		patientId := c.Param("patient_id")
+		var preferences models.NotificationPreferences
+
+		if err := c.BindJSON(&preferences); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(preferences)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		for _, channel := range preferences.Notification_channels {
+			if channel == helper.ChannelWebhook && preferences.Webhook_url == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "webhook_url is required for the WEBHOOK channel"})
+				return
+			}
+		}
+
+		updatedAt := helper.Now()
+		result, err := patientCollection.UpdateOne(
+			ctx,
+			bson.M{"patient_id": patientId},
+			bson.M{"$set": bson.M{
+				"notification_channels": preferences.Notification_channels,
+				"webhook_url":           preferences.Webhook_url,
+				"updated_at":            updatedAt,
+			}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "notification preferences update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// StartBackgroundJobs registers the server's periodic jobs and starts them.
+func StartBackgroundJobs(ctx context.Context) {
+	scheduler := helper.NewScheduler()
+	scheduler.Every(time.Minute, "appointment-reminders", queueAppointmentReminders)
+	scheduler.Every(30*time.Second, "notification-delivery", deliverPendingNotifications)
+	scheduler.Every(15*time.Second, "hl7-delivery", deliverHL7Messages)
+	scheduler.Every(10*time.Second, "fhir-export", runFhirExports)
+	scheduler.Start(ctx)
+}
+
+type reminderOffset struct {
+	label  string
+	offset time.Duration
+}
+
+// reminderOffsets reads REMINDER_OFFSETS (e.g. "24h,2h"), smallest first.
+func reminderOffsets() []reminderOffset {
+	raw := os.Getenv("REMINDER_OFFSETS")
+	if raw == "" {
+		raw = "24h,2h"
+	}
+
+	var offsets []reminderOffset
+	for _, label := range strings.Split(raw, ",") {
+		label = strings.TrimSpace(label)
+		d, err := time.ParseDuration(label)
+		if err != nil || d <= 0 {
+			log.Printf("reminders: ignoring invalid offset %q", label)
+			continue
+		}
+		offsets = append(offsets, reminderOffset{label: label, offset: d})
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i].offset < offsets[j].offset })
+	return offsets
+}
+
+// queueAppointmentReminders adds reminder notifications for upcoming
+// appointments. An appointment gets the reminder of the smallest offset that
+// still covers it, so one booked an hour ahead does not also get the 24h one.
+func queueAppointmentReminders(ctx context.Context) {
+	offsets := reminderOffsets()
+	if len(offsets) == 0 {
+		return
+	}
+
+	now := time.Now()
+	cursor, err := appointmentCollection.Find(ctx, bson.M{
+		"status":           bson.M{"$nin": []string{appointmentCancelled, appointmentNeedsReschedule}},
+		"appointment_date": bson.M{"$gt": now, "$lte": now.Add(offsets[len(offsets)-1].offset)},
+	})
+	if err != nil {
+		log.Printf("reminders: %v", err)
+		return
+	}
+
+	var upcoming []models.Appointment
+	if err = cursor.All(ctx, &upcoming); err != nil {
+		log.Printf("reminders: %v", err)
+		return
+	}
+
+	for _, appointment := range upcoming {
+		if appointment.Patient_id == nil {
+			continue
+		}
+
+		remaining := appointment.Appointment_Date.Sub(now)
+		var reminder reminderOffset
+		for _, o := range offsets {
+			if remaining <= o.offset {
+				reminder = o
+				break
+			}
+		}
+
+		// keyed by the appointment's time too, so a moved appointment is
+		// reminded of again
+		count, err := notificationCollection.CountDocuments(ctx, bson.M{
+			"appointment_id": appointment.Appointment_id,
+			"appointment_at": appointment.Appointment_Date,
+			"reminder":       reminder.label,
+		})
+		if err != nil || count > 0 {
+			continue
+		}
+
+		var patient models.Patient
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": appointment.Patient_id}).Decode(&patient); err != nil {
+			log.Printf("reminders: patient %s of appointment %s not found", *appointment.Patient_id, appointment.Appointment_id)
+			continue
+		}
+
+		doctorName := "your doctor"
+		if appointment.Doctor_id != nil {
+			var doctor models.Doctor
+			if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": appointment.Doctor_id}).Decode(&doctor); err == nil && doctor.Name != nil {
+				doctorName = *doctor.Name
+			}
+		}
+
+		data := helper.ReminderData{
+			Patient_name:    *patient.First_name,
+			Doctor_name:     doctorName,
+			Appointment_at:  helper.InFacility(appointment.Appointment_Date).Format("Mon 2 Jan 2006 15:04 MST"),
+			Appointment_id:  appointment.Appointment_id,
+			Hours_remaining: int(remaining.Hours() + 0.5),
+		}
+		queuePatientMessage(ctx, patient, "reminder", reminder.label, appointment, data, nil)
+	}
+}
+
+// queuePatientMessage renders a message about the appointment for each of the
+// patient's preferred channels and adds it to the outbox.
+func queuePatientMessage(ctx context.Context, patient models.Patient, kind string, reminder string, appointment models.Appointment, data interface{}, attachment *helper.Attachment) {
+	channels := patient.Notification_channels
+	if len(channels) == 0 {
+		channels = []string{helper.ChannelEmail}
+	}
+
+	for _, channel := range channels {
+		recipient := patientRecipient(patient, channel)
+		if recipient == "" {
+			log.Printf("notifications: patient %s has no address for %s", patient.Patient_id, channel)
+			continue
+		}
+
+		subject, body, err := helper.RenderMessage(kind, channel, data)
+		if err != nil {
+			log.Printf("notifications: %v", err)
+			continue
+		}
+
+		now := helper.Now()
+		notification := models.Notification{
+			ID:              primitive.NewObjectID(),
+			Kind:            kind,
+			Reminder:        reminder,
+			Appointment_id:  appointment.Appointment_id,
+			Appointment_at:  appointment.Appointment_Date,
+			Patient_id:      patient.Patient_id,
+			Channel:         channel,
+			Recipient:       recipient,
+			Subject:         subject,
+			Body:            body,
+			Status:          notificationPending,
+			Next_attempt_at: now,
+			Created_at:      now,
+			Updated_at:      now,
+		}
+		notification.Notification_id = notification.ID.Hex()
+		if attachment != nil && channel != helper.ChannelSMS {
+			notification.Attachment_name = attachment.Name
+			notification.Attachment_type = attachment.Content_type
+			notification.Attachment = attachment.Content
+		}
+
+		if _, err := notificationCollection.InsertOne(ctx, notification); err != nil {
+			log.Printf("notifications: %v", err)
+		}
+	}
+}
+
+func patientRecipient(patient models.Patient, channel string) string {
+	var address *string
+	switch channel {
+	case helper.ChannelEmail:
+		address = patient.Email
+	case helper.ChannelSMS:
+		address = patient.Phone
+	case helper.ChannelWebhook:
+		address = patient.Webhook_url
+	}
+	if address == nil {
+		return ""
+	}
+	return *address
+}
+
+// deliverPendingNotifications sends what is due in the outbox. Failures are
+// retried with exponential backoff until maxNotificationAttempts.
+func deliverPendingNotifications(ctx context.Context) {
+	now := time.Now()
+	opts := options.Find().SetSort(bson.M{"next_attempt_at": 1}).SetLimit(100)
+	cursor, err := notificationCollection.Find(ctx, bson.M{
+		"status":          bson.M{"$in": []string{notificationPending, notificationRetrying}},
+		"next_attempt_at": bson.M{"$lte": now},
+	}, opts)
+	if err != nil {
+		log.Printf("notifications: %v", err)
+		return
+	}
+
+	var due []models.Notification
+	if err = cursor.All(ctx, &due); err != nil {
+		log.Printf("notifications: %v", err)
+		return
+	}
+
+	for _, n := range due {
+		message := helper.Notification{
+			Channel:   n.Channel,
+			Recipient: n.Recipient,
+			Subject:   n.Subject,
+			Body:      n.Body,
+		}
+		if n.Attachment_name != "" {
+			message.Attachment = &helper.Attachment{Name: n.Attachment_name, Content_type: n.Attachment_type, Content: n.Attachment}
+		}
+		sendErr := helper.NewNotifier(n.Channel).Send(message)
+
+		updatedAt := helper.Now()
+		update := bson.M{"updated_at": updatedAt, "attempts": n.Attempts + 1}
+		if sendErr == nil {
+			update["status"] = notificationSent
+			update["sent_at"] = updatedAt
+			update["last_error"] = ""
+		} else {
+			update["last_error"] = sendErr.Error()
+			if n.Attempts+1 >= maxNotificationAttempts {
+				update["status"] = notificationFailed
+			} else {
+				update["status"] = notificationRetrying
+				update["next_attempt_at"] = updatedAt.Add(helper.Backoff(n.Attempts+1, time.Minute, time.Hour))
+			}
+		}
+
+		_, err := notificationCollection.UpdateOne(ctx, bson.M{"notification_id": n.Notification_id}, bson.M{"$set": update})
+		if err != nil {
+			log.Printf("notifications: %v", err)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var observationCollection *mongo.Collection = database.OpenCollection(database.Client, "observationSet")
+var escalationAlertCollection *mongo.Collection = database.OpenCollection(database.Client, "escalationAlert")
+
+// escalationAlerts carries new alerts to the doctors' open alert streams,
+// by doctor_id.
+var escalationAlerts = helper.NewBroadcaster()
+
+const (
+	alertOpen         = "OPEN"
+	alertAcknowledged = "ACKNOWLEDGED"
+)
+
+// RecordObservations stores a set of bedside observations with its NEWS2
+// score and writes the readings to the vitals series. When the set puts the
+// patient at a higher risk than the previous one, an escalation alert is
+// raised to the patient's doctor.
+func RecordObservations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.ObservationEntry
+		if err := c.BindJSON(&entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(entry); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var patient models.Patient
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": c.Param("patient_id")}).Decode(&patient); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message:Patient was not found"})
+			return
+		}
+
+		measuredAt := helper.Now()
+		if entry.Measured_at != nil {
+			measuredAt = entry.Measured_at.UTC().Truncate(time.Second)
+		}
+		if measuredAt.After(helper.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "measured_at cannot be in the future"})
+			return
+		}
+
+		temperatureUnit := ""
+		if entry.Temperature_unit != nil {
+			temperatureUnit = *entry.Temperature_unit
+		}
+		readings := []struct {
+			vitalType string
+			value     *float64
+			unit      string
+		}{
+			{helper.VitalRespiratory, entry.Respiratory_rate, ""},
+			{helper.VitalSpO2, entry.Spo2, ""},
+			{helper.VitalSystolic, entry.Systolic, ""},
+			{helper.VitalDiastolic, entry.Diastolic, ""},
+			{helper.VitalPulse, entry.Pulse, ""},
+			{helper.VitalTemperature, entry.Temperature, temperatureUnit},
+		}
+		age := patientAge(patient, measuredAt)
+		values := map[string]float64{}
+		var vitals []models.Vital
+		for _, reading := range readings {
+			if reading.value == nil {
+				continue
+			}
+			value, err := helper.NormalizeVital(reading.vitalType, *reading.value, reading.unit)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			values[reading.vitalType] = value
+			vitals = append(vitals, newVital(reading.vitalType, value, age))
+		}
+		if diastolic, ok := values[helper.VitalDiastolic]; ok && values[helper.VitalSystolic] <= diastolic {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "systolic pressure must be above diastolic"})
+			return
+		}
+
+		set := models.ObservationSet{
+			Patient_id:          patient.Patient_id,
+			Respiratory_rate:    values[helper.VitalRespiratory],
+			Spo2:                values[helper.VitalSpO2],
+			Spo2_scale:          1,
+			Supplemental_oxygen: *entry.Supplemental_oxygen,
+			Oxygen_flow:         entry.Oxygen_flow,
+			Systolic:            values[helper.VitalSystolic],
+			Diastolic:           entry.Diastolic,
+			Pulse:               values[helper.VitalPulse],
+			Consciousness:       *entry.Consciousness,
+			Temperature:         values[helper.VitalTemperature],
+			Measured_at:         measuredAt,
+			Recorded_by:         c.GetString("uid"),
+		}
+		if entry.Spo2_scale != nil {
+			set.Spo2_scale = *entry.Spo2_scale
+		}
+		if set.Oxygen_flow != nil && !set.Supplemental_oxygen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "oxygen_flow is only given for patients on supplemental oxygen"})
+			return
+		}
+
+		score, err := helper.ComputeNews2(helper.News2Observations{
+			Respiratory_rate:    set.Respiratory_rate,
+			Spo2:                set.Spo2,
+			Spo2_scale:          set.Spo2_scale,
+			Supplemental_oxygen: set.Supplemental_oxygen,
+			Systolic:            set.Systolic,
+			Pulse:               set.Pulse,
+			Consciousness:       set.Consciousness,
+			Temperature:         set.Temperature,
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		set.News2 = models.News2Score{Total: score.Total, Risk: score.Risk, Response: score.Response}
+		for _, component := range score.Components {
+			set.News2.Components = append(set.News2.Components, models.News2Component{Parameter: component.Parameter, Value: component.Value, Score: component.Score})
+		}
+
+		var previous models.ObservationSet
+		err = observationCollection.FindOne(ctx,
+			bson.M{"patient_id": patient.Patient_id, "measured_at": bson.M{"$lte": measuredAt}},
+			options.FindOne().SetSort(bson.M{"measured_at": -1}),
+		).Decode(&previous)
+		if err != nil && err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the previous observations"})
+			return
+		}
+		previousRisk := helper.RiskLow
+		if err == nil {
+			previousRisk = previous.News2.Risk
+		}
+
+		set.Created_at = helper.Now()
+		set.ID = primitive.NewObjectID()
+		set.Set_id = set.ID.Hex()
+
+		var alert *models.EscalationAlert
+		if helper.RiskRank(set.News2.Risk) > helper.RiskRank(previousRisk) {
+			alert = escalationAlert(patient, set)
+			set.Alert_id = &alert.Alert_id
+		}
+
+		if _, insertErr := observationCollection.InsertOne(ctx, set); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "observations were not recorded"})
+			return
+		}
+		if insertErr := insertVitals(ctx, vitals, patient.Patient_id, set.Set_id, measuredAt, set.Recorded_by); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "observations were recorded but not added to the vitals"})
+			return
+		}
+		if alert != nil {
+			if _, insertErr := escalationAlertCollection.InsertOne(ctx, alert); insertErr != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "observations were recorded but the escalation alert was not raised"})
+				return
+			}
+			if message, err := json.Marshal(alert); err == nil {
+				escalationAlerts.Publish(alert.Doctor_id, message)
+			}
+		}
+		c.JSON(http.StatusOK, set)
+	}
+}
+
+// GetPatientObservations lists the patient's observation sets, newest first,
+// between from and to (RFC 3339 times or facility dates), by default the
+// last 3 days.
+func GetPatientObservations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		to := helper.Now().Add(time.Second)
+		if value := c.Query("to"); value != "" {
+			parsed, err := vitalsTime(value, true)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid to %q", value)})
+				return
+			}
+			to = parsed
+		}
+		from := to.AddDate(0, 0, -3)
+		if value := c.Query("from"); value != "" {
+			parsed, err := vitalsTime(value, false)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid from %q", value)})
+				return
+			}
+			from = parsed
+		}
+
+		filter := bson.M{"patient_id": c.Param("patient_id"), "measured_at": bson.M{"$gte": from, "$lt": to}}
+		cursor, err := observationCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"measured_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the observations"})
+			return
+		}
+		sets := []models.ObservationSet{}
+		if err = cursor.All(ctx, &sets); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the observations"})
+			return
+		}
+		c.JSON(http.StatusOK, sets)
+	}
+}
+
+// GetEscalationAlerts lists alerts, newest first, filtered by doctor_id,
+// patient_id and status.
+func GetEscalationAlerts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"doctor_id", "patient_id", "status"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		cursor, err := escalationAlertCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the alerts"})
+			return
+		}
+		alerts := []models.EscalationAlert{}
+		if err = cursor.All(ctx, &alerts); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the alerts"})
+			return
+		}
+		c.JSON(http.StatusOK, alerts)
+	}
+}
+
+// AcknowledgeEscalationAlert records that the signed-in doctor has taken up
+// an open alert. Any doctor may acknowledge, so a covering colleague can
+// respond.
+func AcknowledgeEscalationAlert() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		doctorId, ok := signedInDoctor(c)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "sign in as a doctor to acknowledge an alert"})
+			return
+		}
+
+		var acknowledgement models.AlertAcknowledgement
+		if err := c.ShouldBindJSON(&acknowledgement); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(acknowledgement); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var alert models.EscalationAlert
+		err := escalationAlertCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"alert_id": c.Param("alert_id"), "status": alertOpen},
+			bson.M{"$set": bson.M{
+				"status":          alertAcknowledged,
+				"acknowledged_by": doctorId,
+				"acknowledged_at": helper.Now(),
+				"note":            acknowledgement.Note,
+				"updated_at":      helper.Now(),
+			}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&alert)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "alert not found or already acknowledged"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "alert update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, alert)
+	}
+}
+
+// StreamEscalationAlerts is a Server-Sent Events stream of the alerts raised
+// to a doctor. It sends the open alerts at once, then each new one.
+func StreamEscalationAlerts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		doctorId := c.Param("doctor_id")
+		updates, unsubscribe := escalationAlerts.Subscribe(doctorId)
+		defer unsubscribe()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		var open []models.EscalationAlert
+		cursor, err := escalationAlertCollection.Find(ctx, bson.M{"doctor_id": doctorId, "status": alertOpen}, options.Find().SetSort(bson.M{"created_at": 1}))
+		if err == nil {
+			err = cursor.All(ctx, &open)
+		}
+		cancel()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the alerts"})
+			return
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		for _, alert := range open {
+			if message, err := json.Marshal(alert); err == nil {
+				c.SSEvent("alert", string(message))
+			}
+		}
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(20 * time.Second)
+		defer heartbeat.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case message := <-updates:
+				c.SSEvent("alert", string(message))
+			case <-heartbeat.C:
+				// keeps proxies from closing an idle stream
+				c.SSEvent("ping", "")
+			}
+			return true
+		})
+	}
+}
+
+// escalationAlert is the alert a set raises, addressed to the patient's
+// assigned doctor; it is unaddressed when the patient has none.
+func escalationAlert(patient models.Patient, set models.ObservationSet) *models.EscalationAlert {
+	alert := &models.EscalationAlert{
+		ID:         primitive.NewObjectID(),
+		Patient_id: patient.Patient_id,
+		Doctor_id:  patient.Doctor_id,
+		Set_id:     set.Set_id,
+		Risk:       set.News2.Risk,
+		Score:      set.News2.Total,
+		Reasons:    []string{},
+		Response:   set.News2.Response,
+		Status:     alertOpen,
+		Created_at: helper.Now(),
+		Updated_at: helper.Now(),
+	}
+	alert.Alert_id = alert.ID.Hex()
+
+	switch {
+	case set.News2.Total >= 7:
+		alert.Reasons = append(alert.Reasons, fmt.Sprintf("NEWS2 total %d is 7 or more", set.News2.Total))
+	case set.News2.Total >= 5:
+		alert.Reasons = append(alert.Reasons, fmt.Sprintf("NEWS2 total %d is 5 or more", set.News2.Total))
+	}
+	for _, component := range set.News2.Components {
+		if component.Score == 3 {
+			alert.Reasons = append(alert.Reasons, fmt.Sprintf("%s of %s scores 3", component.Parameter, component.Value))
+		}
+	}
+	return alert
+}
@@ -2,6 +2,8 @@ package controller
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"golang-hospital-management/database"
 	helper "golang-hospital-management/helpers"
@@ -73,14 +75,27 @@ func GetPatient() gin.HandlerFunc {
 		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing patient"})
+			return
+		}
+
+		allergies, err := patientAllergies(ctx, patient, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the allergies"})
+			return
 		}
-		c.JSON(http.StatusOK, patient)
+		results, err := recentLabResults(ctx, patient.Patient_id, 20)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the lab results"})
+			return
+		}
+		c.JSON(http.StatusOK, PatientDetail{Patient: patient, PatientAllergies: allergies, Lab_results: results})
 	}
 }
 
 func SignUp() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		var patient models.Patient
 
 		//convert the JSON data coming from postman to something that golang understands
@@ -88,52 +103,26 @@ func SignUp() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		//validate the data based on user struct
-
-		validationErr := validate.Struct(patient)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		if status, msg := checkPatient(ctx, patient, ""); msg != "" {
+			c.JSON(status, gin.H{"error": msg})
 			return
 		}
-		//you'll check if the email has already been used by another user
 
-		count, err := patientCollection.CountDocuments(ctx, bson.M{"email": patient.Email})
-		defer cancel()
-		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking for the email"})
-			return
-		}
 		//hash password
 
 		password := HashPassword(*patient.Password)
 		patient.Password = &password
 
-		//you'll also check if the phone no. has already been used by another user
-
-		count, err = patientCollection.CountDocuments(ctx, bson.M{"phone": patient.Phone})
-		defer cancel()
-		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking for the phone number"})
-			return
-		}
-
-		if count > 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "this email or phone number already exsits"})
-			return
-		}
-
 		//create some extra details for the user object - created_at, updated_at, ID
 
-		patient.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		patient.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		patient.Created_at = helper.Now()
+		patient.Updated_at = helper.Now()
 		patient.ID = primitive.NewObjectID()
 		patient.Patient_id = patient.ID.Hex()
 
 		//generate token and refersh token (generate all tokens function from helper)
 
-		token, refreshToken, _ := helper.GenerateAllTokens(*patient.Email, *patient.First_name, *patient.Last_name, patient.Patient_id)
+		token, refreshToken, _ := helper.GenerateAllTokens(*patient.Email, *patient.First_name, *patient.Last_name, patient.Patient_id, helper.RolePatient)
 		patient.Token = &token
 		patient.Refresh_Token = &refreshToken
 		//if all ok, then you insert this new user into the user collection
@@ -185,7 +174,7 @@ func Login() gin.HandlerFunc {
 
 		//if all goes well, then you'll generate tokens
 
-		token, refreshToken, _ := helper.GenerateAllTokens(*foundPatient.Email, *foundPatient.First_name, *foundPatient.Last_name, foundPatient.Patient_id)
+		token, refreshToken, _ := helper.GenerateAllTokens(*foundPatient.Email, *foundPatient.First_name, *foundPatient.Last_name, foundPatient.Patient_id, helper.RolePatient)
 
 		//update tokens - token and refersh token
 		helper.UpdateAllTokens(token, refreshToken, foundPatient.Patient_id)
@@ -204,6 +193,16 @@ func HashPassword(password string) string {
 	return string(bytes)
 }
 
+// randomPassword is the password of a patient registered by another system
+// rather than signing up; they sign in after a password reset.
+func randomPassword() (string, error) {
+	secret := make([]byte, 18)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
 func VerifyPassword(patientPassword string, providedPassword string) (bool, string) {
 
 	err := bcrypt.CompareHashAndPassword([]byte(providedPassword), []byte(patientPassword))
@@ -216,3 +215,29 @@ func VerifyPassword(patientPassword string, providedPassword string) (bool, stri
 	}
 	return check, msg
 }
+
+// checkPatient validates a patient before it is stored, whether it comes from
+// a sign-up or from the HIS, and checks its email and phone are not another
+// patient's. excludeId is the patient being updated, if any. A non-empty
+// message is the problem, with the status to answer it with.
+func checkPatient(ctx context.Context, patient models.Patient, excludeId string) (int, string) {
+	if validationErr := validate.Struct(patient); validationErr != nil {
+		return http.StatusBadRequest, validationErr.Error()
+	}
+	if patient.Date_of_birth != nil && patient.Date_of_birth.After(helper.Now()) {
+		return http.StatusBadRequest, "date_of_birth cannot be in the future"
+	}
+
+	filter := bson.M{"$or": []bson.M{{"email": patient.Email}, {"phone": patient.Phone}}}
+	if excludeId != "" {
+		filter["patient_id"] = bson.M{"$ne": excludeId}
+	}
+	count, err := patientCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return http.StatusInternalServerError, "error occured while checking for the email and phone number"
+	}
+	if count > 0 {
+		return http.StatusInternalServerError, "this email or phone number already exsits"
+	}
+	return 0, ""
+}
@@ -0,0 +1,586 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var dispenseCollection *mongo.Collection = database.OpenCollection(database.Client, "dispense")
+var refillRequestCollection *mongo.Collection = database.OpenCollection(database.Client, "refillRequest")
+
+const (
+	refillPending  = "PENDING"
+	refillApproved = "APPROVED"
+	refillDenied   = "DENIED"
+)
+
+// quantityTolerance absorbs float rounding when quantities like 0.1 ml are
+// added up.
+const quantityTolerance = 1e-9
+
+// dispenseMu serialises changes to the dispensing state of prescriptions, so
+// two pharmacists cannot hand out the same fill twice.
+var dispenseMu sync.Mutex
+
+// DispenseQueueEntry is a prescription waiting at the pharmacy with the items
+// that can be dispensed now.
+type DispenseQueueEntry struct {
+	models.Prescription
+	Due_items []string `json:"due_items"`
+}
+
+// GetDispenseQueue lists the prescriptions with something to dispense, oldest
+// first: items never dispensed, partly dispensed fills and approved refills.
+func GetDispenseQueue() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"status": bson.M{"$nin": bson.A{prescriptionCancelled, prescriptionCompleted}}}
+		if patientId := c.Query("patient_id"); patientId != "" {
+			filter["patient_id"] = patientId
+		}
+		cursor, err := prescriptionCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the dispense queue"})
+			return
+		}
+		var prescriptions []models.Prescription
+		if err = cursor.All(ctx, &prescriptions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the dispense queue"})
+			return
+		}
+
+		queue := []DispenseQueueEntry{}
+		for _, prescription := range prescriptions {
+			normalizePrescription(&prescription)
+			if prescription.Status != prescriptionActive && prescription.Status != prescriptionPartial {
+				continue
+			}
+			entry := DispenseQueueEntry{Prescription: prescription, Due_items: []string{}}
+			for _, item := range prescription.Items {
+				if itemDue(item) {
+					entry.Due_items = append(entry.Due_items, item.Item_id)
+				}
+			}
+			if len(entry.Due_items) > 0 {
+				queue = append(queue, entry)
+			}
+		}
+		c.JSON(http.StatusOK, queue)
+	}
+}
+
+// DispensePrescription records medication handed out against the items of a
+// prescription and takes it from the stock of the dispensing location. An
+// item whose fill is complete starts its next fill, using up a refill, once a
+// refill request for it has been approved.
+func DispensePrescription() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request models.DispenseRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		location, msg, err := stockLocation(ctx, request.Location_id)
+		if msg != "" || err != nil {
+			stockProblem(c, msg, err)
+			return
+		}
+		if !location.Dispensing {
+			c.JSON(http.StatusBadRequest, gin.H{"error": *location.Name + " is not a dispensing location"})
+			return
+		}
+
+		dispenseMu.Lock()
+		defer dispenseMu.Unlock()
+		stockMu.Lock()
+		defer stockMu.Unlock()
+
+		var prescription models.Prescription
+		err = prescriptionCollection.FindOne(ctx, bson.M{"prescription_id": c.Param("prescription_id")}).Decode(&prescription)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "prescription not found"})
+			return
+		}
+		normalizePrescription(&prescription)
+		if prescription.Status != prescriptionActive && prescription.Status != prescriptionPartial {
+			c.JSON(http.StatusConflict, gin.H{"error": "the prescription is " + prescription.Status})
+			return
+		}
+
+		now := helper.Now()
+		pharmacistId := c.GetString("uid")
+		dispenses := []models.Dispense{}
+		var movements []models.StockMovement
+		pending := map[string]map[string]float64{}
+		seen := map[string]bool{}
+		for _, line := range request.Items {
+			if seen[line.Item_id] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "item " + line.Item_id + " is listed twice"})
+				return
+			}
+			seen[line.Item_id] = true
+
+			item := findItem(prescription.Items, line.Item_id)
+			if item == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "item " + line.Item_id + " is not on the prescription"})
+				return
+			}
+			if item.Quantity <= 0 || item.Drug_code == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "item " + line.Item_id + " has no quantity to dispense"})
+				return
+			}
+
+			if item.Fills == 0 {
+				item.Fills = 1
+				item.Fill_dispensed = 0
+			} else if fillRemaining(*item) <= 0 {
+				if !item.Refill_due || item.Refills_remaining <= 0 {
+					c.JSON(http.StatusConflict, gin.H{"error": "item " + line.Item_id + " is fully dispensed; another fill needs an approved refill request"})
+					return
+				}
+				item.Fills++
+				item.Fill_dispensed = 0
+				item.Refills_remaining--
+				item.Refill_due = false
+			}
+
+			remaining := fillRemaining(*item)
+			quantity := line.Quantity
+			if quantity == 0 {
+				quantity = remaining
+			}
+			if quantity > remaining+quantityTolerance {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("item %s has %g left in this fill", line.Item_id, remaining)})
+				return
+			}
+			item.Fill_dispensed += quantity
+
+			var stockItem models.StockItem
+			err := stockItemCollection.FindOne(ctx, bson.M{"drug_code": item.Drug_code, "active": true}).Decode(&stockItem)
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusConflict, gin.H{"error": "item " + line.Item_id + ": " + *item.Drug_code + " is not a stock item"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+				return
+			}
+			if pending[stockItem.Item_id] == nil {
+				pending[stockItem.Item_id] = map[string]float64{}
+			}
+			picks, available, err := pickStock(ctx, stockItem.Item_id, location.Location_id, line.Batch, quantity, false, pending[stockItem.Item_id])
+			if errors.Is(err, errInsufficientStock) {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("item %s: only %g in date at %s", line.Item_id, available, *location.Name)})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+				return
+			}
+
+			// a fill taken from several batches is recorded once per batch
+			for _, pick := range picks {
+				pending[stockItem.Item_id][pick.Batch] += pick.On_hand
+
+				dispense := models.Dispense{
+					ID:              primitive.NewObjectID(),
+					Prescription_id: prescription.Prescription_id,
+					Patient_id:      *prescription.Patient_id,
+					Item_id:         item.Item_id,
+					Drug_code:       *item.Drug_code,
+					Fill_number:     item.Fills,
+					Quantity:        pick.On_hand,
+					Location_id:     location.Location_id,
+					Batch:           pick.Batch,
+					Pharmacist_id:   pharmacistId,
+					Notes:           request.Notes,
+					Dispensed_at:    now,
+				}
+				dispense.Dispense_id = dispense.ID.Hex()
+				dispenses = append(dispenses, dispense)
+				movements = append(movements, newMovement(stockItem.Item_id, location.Location_id, pick.Batch, pick.Expiry_date, -pick.On_hand, movementDispense, dispense.Dispense_id, pharmacistId))
+			}
+		}
+
+		records := make([]interface{}, len(dispenses))
+		ids := bson.A{}
+		for i := range dispenses {
+			records[i] = dispenses[i]
+			ids = append(ids, dispenses[i].Dispense_id)
+		}
+		if _, err := dispenseCollection.InsertMany(ctx, records); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "dispensing was not recorded"})
+			return
+		}
+		if err := appendMovements(ctx, movements); err != nil {
+			dispenseCollection.DeleteMany(ctx, bson.M{"dispense_id": bson.M{"$in": ids}})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "dispensing was not recorded"})
+			return
+		}
+
+		prescription.Status = derivePrescriptionStatus(prescription)
+		_, err = prescriptionCollection.UpdateOne(ctx, bson.M{"prescription_id": prescription.Prescription_id}, bson.M{
+			"$set": bson.M{"items": prescription.Items, "status": prescription.Status, "updated_at": now},
+		})
+		if err != nil {
+			reverseMovements(ctx, movements)
+			dispenseCollection.DeleteMany(ctx, bson.M{"dispense_id": bson.M{"$in": ids}})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "prescription update failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"prescription": prescription, "dispenses": dispenses})
+	}
+}
+
+// GetDispenses lists what was dispensed against a prescription.
+func GetDispenses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		cursor, err := dispenseCollection.Find(ctx, bson.M{"prescription_id": c.Param("prescription_id")}, options.Find().SetSort(bson.M{"dispensed_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the dispenses"})
+			return
+		}
+		dispenses := []models.Dispense{}
+		if err = cursor.All(ctx, &dispenses); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the dispenses"})
+			return
+		}
+		c.JSON(http.StatusOK, dispenses)
+	}
+}
+
+// CreateRefillRequest lets the patient ask for another fill of items whose
+// current fill has been collected. Without item_ids every such item is asked
+// for.
+func CreateRefillRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var refill models.RefillRequest
+		if err := c.BindJSON(&refill); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(refill); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var prescription models.Prescription
+		err := prescriptionCollection.FindOne(ctx, bson.M{"prescription_id": c.Param("prescription_id")}).Decode(&prescription)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "prescription not found"})
+			return
+		}
+		normalizePrescription(&prescription)
+		if c.GetString("uid") != *prescription.Patient_id {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the patient of the prescription can ask for a refill"})
+			return
+		}
+		if prescription.Status == prescriptionCancelled || prescription.Status == prescriptionExpired {
+			c.JSON(http.StatusConflict, gin.H{"error": "the prescription is " + prescription.Status + "; a new prescription is needed"})
+			return
+		}
+
+		if len(refill.Item_ids) == 0 {
+			for _, item := range prescription.Items {
+				if item.Fills > 0 && fillRemaining(item) <= 0 && !item.Refill_due {
+					refill.Item_ids = append(refill.Item_ids, item.Item_id)
+				}
+			}
+			if len(refill.Item_ids) == 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "no item of the prescription is ready for a refill"})
+				return
+			}
+		}
+		for _, itemId := range refill.Item_ids {
+			item := findItem(prescription.Items, itemId)
+			switch {
+			case item == nil:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "item " + itemId + " is not on the prescription"})
+				return
+			case item.Refill_due:
+				c.JSON(http.StatusConflict, gin.H{"error": "item " + itemId + " already has a refill approved"})
+				return
+			case item.Fills == 0 || fillRemaining(*item) > 0:
+				c.JSON(http.StatusConflict, gin.H{"error": "item " + itemId + " still has medication to collect"})
+				return
+			}
+		}
+
+		count, err := refillRequestCollection.CountDocuments(ctx, bson.M{"prescription_id": prescription.Prescription_id, "status": refillPending})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the refill requests"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "a refill request for this prescription is already waiting"})
+			return
+		}
+
+		refill.ID = primitive.NewObjectID()
+		refill.Refill_request_id = refill.ID.Hex()
+		refill.Prescription_id = prescription.Prescription_id
+		refill.Patient_id = *prescription.Patient_id
+		refill.Doctor_id = *prescription.Doctor_id
+		refill.Status = refillPending
+		refill.Additional_refills = 0
+		refill.Decided_by, refill.Decided_at, refill.Decision_reason = nil, nil, nil
+		refill.Created_at = helper.Now()
+		refill.Updated_at = helper.Now()
+
+		if _, insertErr := refillRequestCollection.InsertOne(ctx, refill); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "refill request was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, refill)
+	}
+}
+
+// GetRefillRequests lists refill requests, filtered by doctor_id, patient_id,
+// prescription_id and status; a doctor's pending requests are their worklist.
+func GetRefillRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"doctor_id", "patient_id", "prescription_id", "status"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		cursor, err := refillRequestCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the refill requests"})
+			return
+		}
+		refills := []models.RefillRequest{}
+		if err = cursor.All(ctx, &refills); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the refill requests"})
+			return
+		}
+		c.JSON(http.StatusOK, refills)
+	}
+}
+
+// ApproveRefillRequest lets the prescribing doctor release another fill of
+// the requested items. Items with no refills left need additional_refills,
+// and an expired prescription a new end_date.
+func ApproveRefillRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		decision, refill, doctorId, ok := refillDecision(ctx, c)
+		if !ok {
+			return
+		}
+
+		dispenseMu.Lock()
+		defer dispenseMu.Unlock()
+
+		var prescription models.Prescription
+		err := prescriptionCollection.FindOne(ctx, bson.M{"prescription_id": refill.Prescription_id}).Decode(&prescription)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "prescription not found"})
+			return
+		}
+		normalizePrescription(&prescription)
+		if prescription.Status == prescriptionCancelled {
+			c.JSON(http.StatusConflict, gin.H{"error": "the prescription is " + prescription.Status})
+			return
+		}
+		if decision.End_Date != nil {
+			if !decision.End_Date.After(helper.Now()) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must be in the future"})
+				return
+			}
+			if msg := checkPrescriptionDates(prescription.Start_Date, decision.End_Date); msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			prescription.End_Date = decision.End_Date
+		} else if prescription.End_Date != nil && helper.Now().After(*prescription.End_Date) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the prescription has expired; give a new end_date to approve"})
+			return
+		}
+
+		for _, itemId := range refill.Item_ids {
+			item := findItem(prescription.Items, itemId)
+			if item == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "item " + itemId + " is no longer on the prescription"})
+				return
+			}
+			if item.Refills_remaining+decision.Additional_refills < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "item " + itemId + " has no refills left; give additional_refills to approve"})
+				return
+			}
+			item.Refills_remaining += decision.Additional_refills
+			item.Refill_due = true
+		}
+
+		now := helper.Now()
+		updated, err := moveRefillRequest(ctx, refill.Refill_request_id, bson.M{
+			"status":             refillApproved,
+			"additional_refills": decision.Additional_refills,
+			"decided_by":         doctorId,
+			"decided_at":         now,
+			"decision_reason":    decision.Reason,
+		})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the refill request was already answered"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "refill request update failed"})
+			return
+		}
+
+		prescription.Status = derivePrescriptionStatus(prescription)
+		_, err = prescriptionCollection.UpdateOne(ctx, bson.M{"prescription_id": prescription.Prescription_id}, bson.M{
+			"$set": bson.M{"items": prescription.Items, "end_date": prescription.End_Date, "status": prescription.Status, "updated_at": now},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "prescription update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"refill_request": updated, "prescription": prescription})
+	}
+}
+
+func DenyRefillRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		decision, refill, doctorId, ok := refillDecision(ctx, c)
+		if !ok {
+			return
+		}
+		if decision.Reason == nil || *decision.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a reason is required to deny a refill"})
+			return
+		}
+
+		updated, err := moveRefillRequest(ctx, refill.Refill_request_id, bson.M{
+			"status":          refillDenied,
+			"decided_by":      doctorId,
+			"decided_at":      helper.Now(),
+			"decision_reason": decision.Reason,
+		})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the refill request was already answered"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "refill request update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// refillDecision reads the doctor's answer and the pending request it is for,
+// writing the response itself when either is wrong. Only the prescribing
+// doctor, signed in through DoctorLogin, can answer; their id is returned as
+// the one who decided.
+func refillDecision(ctx context.Context, c *gin.Context) (models.RefillDecision, models.RefillRequest, string, bool) {
+	var decision models.RefillDecision
+	var refill models.RefillRequest
+	if err := c.BindJSON(&decision); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return decision, refill, "", false
+	}
+	if validationErr := validate.Struct(decision); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return decision, refill, "", false
+	}
+
+	if err := refillRequestCollection.FindOne(ctx, bson.M{"refill_request_id": c.Param("refill_request_id")}).Decode(&refill); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "refill request not found"})
+		return decision, refill, "", false
+	}
+	if refill.Status != refillPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "the refill request was already " + refill.Status})
+		return decision, refill, "", false
+	}
+	doctorId, ok := signedInDoctor(c)
+	if !ok || doctorId != refill.Doctor_id {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the prescribing doctor can answer a refill request"})
+		return decision, refill, "", false
+	}
+	return decision, refill, doctorId, true
+}
+
+// moveRefillRequest answers a request that is still pending.
+func moveRefillRequest(ctx context.Context, refillRequestId string, set bson.M) (models.RefillRequest, error) {
+	set["updated_at"] = helper.Now()
+
+	var refill models.RefillRequest
+	err := refillRequestCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"refill_request_id": refillRequestId, "status": refillPending},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&refill)
+	return refill, err
+}
+
+func findItem(items []models.MedicationItem, itemId string) *models.MedicationItem {
+	for i := range items {
+		if items[i].Item_id == itemId {
+			return &items[i]
+		}
+	}
+	return nil
+}
+
+// fillRemaining is how much of the item's current fill is still to be
+// handed out; it is the whole quantity before the first fill.
+func fillRemaining(item models.MedicationItem) float64 {
+	if item.Fills == 0 {
+		return item.Quantity
+	}
+	remaining := item.Quantity - item.Fill_dispensed
+	if remaining < quantityTolerance {
+		return 0
+	}
+	return remaining
+}
+
+// itemDue tells whether the pharmacy can dispense the item now.
+func itemDue(item models.MedicationItem) bool {
+	if item.Quantity <= 0 || item.Drug_code == nil {
+		return false
+	}
+	return item.Fills == 0 || fillRemaining(item) > 0 || item.Refill_due
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"fmt"
 	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
 	"golang-hospital-management/models"
-	"log"
 	"net/http"
 	"time"
 
@@ -18,17 +18,43 @@ import (
 
 var prescriptionCollection *mongo.Collection = database.OpenCollection(database.Client, "prescription")
 
+// A prescription is ACTIVE until something is dispensed, then PARTIALLY_DISPENSED
+// until every fill of every item is handed out and it is COMPLETED. Past its
+// end date an unfinished prescription is EXPIRED.
+const (
+	prescriptionActive    = "ACTIVE"
+	prescriptionPartial   = "PARTIALLY_DISPENSED"
+	prescriptionCompleted = "COMPLETED"
+	prescriptionExpired   = "EXPIRED"
+	prescriptionCancelled = "CANCELLED"
+)
+
+// GetPrescriptions lists prescriptions, filtered by patient_id, doctor_id,
+// appointment_id and encounter_id.
 func GetPrescriptions() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		result, err := prescriptionCollection.Find(context.TODO(), bson.M{})
 		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"patient_id", "doctor_id", "appointment_id", "encounter_id"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+
+		result, err := prescriptionCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the prescription"})
+			return
 		}
-		var allPrescriptions []bson.M
+		allPrescriptions := []models.Prescription{}
 		if err = result.All(ctx, &allPrescriptions); err != nil {
-			log.Fatal(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the prescription"})
+			return
+		}
+		for i := range allPrescriptions {
+			normalizePrescription(&allPrescriptions[i])
 		}
 		c.JSON(http.StatusOK, allPrescriptions)
 	}
@@ -37,113 +63,361 @@ func GetPrescriptions() gin.HandlerFunc {
 func GetPrescription() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
 		prescriptionId := c.Param("prescription_id")
 		var prescription models.Prescription
 
-		err := doctorCollection.FindOne(ctx, bson.M{"prescription_id": prescriptionId}).Decode(&prescription)
-		defer cancel()
+		err := prescriptionCollection.FindOne(ctx, bson.M{"prescription_id": prescriptionId}).Decode(&prescription)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the prescription"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "prescription not found"})
+			return
 		}
+		normalizePrescription(&prescription)
 		c.JSON(http.StatusOK, prescription)
 	}
 }
 
 func CreatePrescription() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var prescription models.Prescription
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var prescription models.Prescription
 
 		if err := c.BindJSON(&prescription); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		validationErr := validate.Struct(prescription)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		if status, msg := createPrescription(ctx, &prescription); msg != "" {
+			prescriptionError(c, status, msg, prescription.Warnings)
 			return
 		}
 
-		prescription.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		prescription.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		prescription.ID = primitive.NewObjectID()
-		prescription.Prescription_id = prescription.ID.Hex()
-
-		result, insertErr := prescriptionCollection.InsertOne(ctx, prescription)
-		if insertErr != nil {
-			msg := fmt.Sprintf("prescription was not created")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-		defer cancel()
-		c.JSON(http.StatusOK, result)
-		defer cancel()
+		c.JSON(http.StatusOK, prescription)
 	}
 }
 
-func inTimeSpan(start, end, check time.Time) bool {
-	return start.After(time.Now()) && end.After(start)
-}
-
 func UpdatePrescription() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		var prescription models.Prescription
+		defer cancel()
 
-		if err := c.BindJSON(&prescription); err != nil {
+		var change models.PrescriptionChange
+
+		if err := c.BindJSON(&change); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		prescriptionId := c.Param("prescription_id")
-		filter := bson.M{"prescription_id": prescriptionId}
+		prescription, status, msg := updatePrescription(ctx, c.Param("prescription_id"), change)
+		if msg != "" {
+			prescriptionError(c, status, msg, prescription.Warnings)
+			return
+		}
+		c.JSON(http.StatusOK, prescription)
+	}
+}
 
-		var updateObj primitive.D
+// prescriptionError writes a failed create or update; blocked prescriptions
+// come with the warnings that blocked them.
+func prescriptionError(c *gin.Context, status int, msg string, warnings []models.ClinicalWarning) {
+	if status == http.StatusUnprocessableEntity {
+		c.JSON(status, gin.H{"error": msg, "warnings": warnings})
+		return
+	}
+	c.JSON(status, gin.H{"error": msg})
+}
 
-		if prescription.Start_Date != nil && prescription.End_Date != nil {
-			if !inTimeSpan(*prescription.Start_Date, *prescription.End_Date, time.Now()) {
-				msg := "kindly retype the time"
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-				defer cancel()
-				return
-			}
+// createPrescription checks a new prescription's items, patient, encounter
+// and diagnoses, applies the safety checks and stores it. CreatePrescription
+// and the FHIR MedicationRequest create both use it. A non-empty message is the problem,
+// with its status; a prescription blocked by its warnings has them set.
+func createPrescription(ctx context.Context, prescription *models.Prescription) (int, string) {
+	validationErr := validate.Struct(prescription)
+	if validationErr != nil {
+		return http.StatusBadRequest, validationErr.Error()
+	}
+	if msg := checkPrescriptionDates(prescription.Start_Date, prescription.End_Date); msg != "" {
+		return http.StatusBadRequest, msg
+	}
 
-			updateObj = append(updateObj, bson.E{"start_date", prescription.Start_Date})
-			updateObj = append(updateObj, bson.E{"end_date", prescription.End_Date})
+	problem, err := checkFormularyItems(ctx, prescription.Items)
+	if err != nil {
+		return http.StatusInternalServerError, "error occured while reading the formulary"
+	}
+	if problem != "" {
+		return http.StatusBadRequest, problem
+	}
 
-			if prescription.Drugs != "" {
-				updateObj = append(updateObj, bson.E{"Drugs", prescription.Drugs})
-			}
-			if prescription.Dosage != "" {
-				updateObj = append(updateObj, bson.E{"Dosage", prescription.Dosage})
-			}
+	count, err := patientCollection.CountDocuments(ctx, bson.M{"patient_id": prescription.Patient_id})
+	if err != nil || count == 0 {
+		return http.StatusBadRequest, "patient not found"
+	}
+	count, err = doctorCollection.CountDocuments(ctx, bson.M{"doctor_id": prescription.Doctor_id})
+	if err != nil || count == 0 {
+		return http.StatusBadRequest, "message:Doctor not found"
+	}
+	if prescription.Encounter_id != nil {
+		var encounter models.Encounter
+		err := encounterCollection.FindOne(ctx, bson.M{"encounter_id": prescription.Encounter_id}).Decode(&encounter)
+		if err != nil {
+			return http.StatusBadRequest, "encounter not found"
+		}
+		if encounter.Patient_id != *prescription.Patient_id {
+			return http.StatusBadRequest, "the encounter is not the patient's"
+		}
+		if prescription.Appointment_id == nil {
+			prescription.Appointment_id = encounter.Appointment_id
+		} else if *prescription.Appointment_id != *encounter.Appointment_id {
+			return http.StatusBadRequest, "the encounter belongs to another appointment"
+		}
+	}
+	if prescription.Appointment_id != nil {
+		var appointment models.Appointment
+		err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": prescription.Appointment_id}).Decode(&appointment)
+		if err != nil {
+			return http.StatusBadRequest, "message:Appointment was not found"
+		}
+		if appointment.Patient_id == nil || *appointment.Patient_id != *prescription.Patient_id {
+			return http.StatusBadRequest, "the appointment is not the patient's"
+		}
+	}
+
+	problem, err = checkPrescriptionDiagnoses(ctx, *prescription.Patient_id, prescription.Diagnosis_ids)
+	if err != nil {
+		return http.StatusInternalServerError, "error occured while reading the diagnoses"
+	}
+	if problem != "" {
+		return http.StatusBadRequest, problem
+	}
 
-			prescription.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-			updateObj = append(updateObj, bson.E{"updated_at", prescription.Updated_at})
+	numberItems(prescription.Items)
+	if status, msg := applySafetyChecks(ctx, prescription); msg != "" {
+		return status, msg
+	}
 
-			upsert := true
+	prescription.Status = prescriptionActive
+	prescription.Created_at = helper.Now()
+	prescription.Updated_at = helper.Now()
+	prescription.ID = primitive.NewObjectID()
+	prescription.Prescription_id = prescription.ID.Hex()
 
-			opt := options.UpdateOptions{
-				Upsert: &upsert,
-			}
+	_, insertErr := prescriptionCollection.InsertOne(ctx, prescription)
+	if insertErr != nil {
+		msg := fmt.Sprintf("prescription was not created")
+		return http.StatusInternalServerError, msg
+	}
+
+	if prescription.Appointment_id != nil {
+		appointmentCollection.UpdateOne(ctx, bson.M{"appointment_id": prescription.Appointment_id}, bson.M{
+			"$set": bson.M{"prescription_id": prescription.Prescription_id, "updated_at": helper.Now()},
+		})
+	}
+	return 0, ""
+}
+
+// updatePrescription validates a change to a prescription, re-runs the
+// safety checks when its items or dates move and stores it, for
+// UpdatePrescription and the FHIR MedicationRequest update. A non-empty
+// message is the problem, with its status; for a change blocked by its
+// warnings the returned prescription carries them.
+func updatePrescription(ctx context.Context, prescriptionId string, change models.PrescriptionChange) (models.Prescription, int, string) {
+	var prescription models.Prescription
+
+	validationErr := validate.Struct(change)
+	if validationErr != nil {
+		return prescription, http.StatusBadRequest, validationErr.Error()
+	}
+
+	filter := bson.M{"prescription_id": prescriptionId}
+
+	if err := prescriptionCollection.FindOne(ctx, filter).Decode(&prescription); err != nil {
+		return prescription, http.StatusNotFound, "prescription not found"
+	}
+	if prescription.Status == prescriptionCancelled {
+		return prescription, http.StatusConflict, "a cancelled prescription cannot be changed"
+	}
+
+	var updateObj primitive.D
+
+	startDate, endDate := prescription.Start_Date, prescription.End_Date
+	if change.Start_Date != nil {
+		startDate = change.Start_Date
+		updateObj = append(updateObj, bson.E{Key: "start_date", Value: change.Start_Date})
+	}
+	if change.End_Date != nil {
+		endDate = change.End_Date
+		updateObj = append(updateObj, bson.E{Key: "end_date", Value: change.End_Date})
+	}
+	if msg := checkPrescriptionDates(startDate, endDate); msg != "" {
+		return prescription, http.StatusBadRequest, msg
+	}
 
-			result, err := prescriptionCollection.UpdateOne(
-				ctx,
-				filter,
-				bson.D{
-					{"$set", updateObj},
-				},
-				&opt,
-			)
-
-			if err != nil {
-				msg := "prescription update failed"
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	if change.Items != nil {
+		for _, item := range prescription.Items {
+			if item.Fills > 0 {
+				return prescription, http.StatusConflict, "the items of a dispensed prescription cannot be replaced"
 			}
+		}
+		problem, err := checkFormularyItems(ctx, change.Items)
+		if err != nil {
+			return prescription, http.StatusInternalServerError, "error occured while reading the formulary"
+		}
+		if problem != "" {
+			return prescription, http.StatusBadRequest, problem
+		}
+		numberItems(change.Items)
+		updateObj = append(updateObj, bson.E{Key: "items", Value: change.Items})
+		// the legacy text is superseded by the new items
+		updateObj = append(updateObj, bson.E{Key: "drugs", Value: ""}, bson.E{Key: "dosage", Value: ""})
+	}
 
-			defer cancel()
-			c.JSON(http.StatusOK, result)
+	// new items or dates change what the prescription overlaps with
+	if change.Items != nil || change.Start_Date != nil || change.End_Date != nil {
+		candidate := prescription
+		normalizePrescription(&candidate)
+		candidate.Start_Date, candidate.End_Date = startDate, endDate
+		if change.Items != nil {
+			candidate.Items = change.Items
+		}
+		candidate.Override_reason = change.Override_reason
+		if status, msg := applySafetyChecks(ctx, &candidate); msg != "" {
+			return candidate, status, msg
+		}
+		updateObj = append(updateObj,
+			bson.E{Key: "warnings", Value: candidate.Warnings},
+			bson.E{Key: "override_reason", Value: candidate.Override_reason},
+			bson.E{Key: "overridden_at", Value: candidate.Overridden_at},
+		)
+	}
+	if change.Notes != nil {
+		updateObj = append(updateObj, bson.E{Key: "notes", Value: change.Notes})
+	}
+	if change.Diagnosis_ids != nil {
+		patientId := ""
+		if prescription.Patient_id != nil {
+			patientId = *prescription.Patient_id
+		}
+		problem, err := checkPrescriptionDiagnoses(ctx, patientId, change.Diagnosis_ids)
+		if err != nil {
+			return prescription, http.StatusInternalServerError, "error occured while reading the diagnoses"
+		}
+		if problem != "" {
+			return prescription, http.StatusBadRequest, problem
 		}
+		updateObj = append(updateObj, bson.E{Key: "diagnosis_ids", Value: change.Diagnosis_ids})
+	}
+	if change.Status != nil {
+		status := *change.Status
+		if status == prescriptionActive {
+			// reactivating goes back to what the dispensing says
+			reactivated := prescription
+			reactivated.Status = ""
+			status = derivePrescriptionStatus(reactivated)
+		}
+		updateObj = append(updateObj, bson.E{Key: "status", Value: status})
+	}
+
+	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: helper.Now()})
+
+	err := prescriptionCollection.FindOneAndUpdate(
+		ctx,
+		filter,
+		bson.D{{Key: "$set", Value: updateObj}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&prescription)
+	if err != nil {
+		msg := "prescription update failed"
+		return prescription, http.StatusInternalServerError, msg
+	}
+
+	normalizePrescription(&prescription)
+	return prescription, 0, ""
+}
+
+// applySafetyChecks stores the prescribing warnings on the prescription.
+// Blocking warnings stop it unless the doctor gave an override reason, which
+// is kept with the time of the override.
+func applySafetyChecks(ctx context.Context, prescription *models.Prescription) (int, string) {
+	warnings, err := checkPrescriptionSafety(ctx, *prescription)
+	if err != nil {
+		return http.StatusInternalServerError, "error occured while checking the prescription"
+	}
+	prescription.Warnings = warnings
+
+	if !blockingWarnings(warnings) {
+		prescription.Override_reason = nil
+		prescription.Overridden_at = nil
+		return 0, ""
+	}
+	if prescription.Override_reason == nil {
+		return http.StatusUnprocessableEntity, "the prescription has severe warnings; give an override_reason to prescribe anyway"
+	}
+	now := helper.Now()
+	prescription.Overridden_at = &now
+	return 0, ""
+}
+
+func checkPrescriptionDates(start *time.Time, end *time.Time) string {
+	if start != nil && end != nil && !end.After(*start) {
+		return "end_date must be after start_date"
+	}
+	return ""
+}
+
+// numberItems gives every line item a stable id within its prescription and
+// starts its dispensing state.
+func numberItems(items []models.MedicationItem) {
+	for i := range items {
+		items[i].Item_id = fmt.Sprintf("%d", i+1)
+		items[i].Fills = 0
+		items[i].Fill_dispensed = 0
+		items[i].Refills_remaining = items[i].Refills
+		items[i].Refill_due = false
+	}
+}
+
+// derivePrescriptionStatus works out the status from the dispensing state of
+// the items and the end date. Cancelling is the only status set by hand.
+func derivePrescriptionStatus(prescription models.Prescription) string {
+	if prescription.Status == prescriptionCancelled {
+		return prescriptionCancelled
+	}
+	started, completed := false, len(prescription.Items) > 0
+	for _, item := range prescription.Items {
+		if item.Fills > 0 {
+			started = true
+		}
+		if item.Fills == 0 || fillRemaining(item) > 0 || item.Refills_remaining > 0 || item.Refill_due {
+			completed = false
+		}
+	}
+	switch {
+	case completed:
+		return prescriptionCompleted
+	case prescription.End_Date != nil && helper.Now().After(*prescription.End_Date):
+		return prescriptionExpired
+	case started:
+		return prescriptionPartial
+	}
+	return prescriptionActive
+}
+
+// normalizePrescription presents a prescription written before line items as
+// one item carrying its free text, so readers only see the current contract.
+func normalizePrescription(prescription *models.Prescription) {
+	if len(prescription.Items) == 0 && prescription.Drugs != "" {
+		drugs, dosage := prescription.Drugs, prescription.Dosage
+		prescription.Items = []models.MedicationItem{{
+			Item_id:      "1",
+			Drug_name:    &drugs,
+			Instructions: &dosage,
+		}}
+	}
+	if prescription.Items == nil {
+		prescription.Items = []models.MedicationItem{}
 	}
+	prescription.Status = derivePrescriptionStatus(*prescription)
 }
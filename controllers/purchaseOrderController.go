@@ -0,0 +1,635 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var purchaseOrderCollection *mongo.Collection = database.OpenCollection(database.Client, "purchaseOrder")
+var purchaseOrderCounterCollection *mongo.Collection = database.OpenCollection(database.Client, "purchaseOrderCounter")
+
+const (
+	poDraft             = "DRAFT"
+	poApproved          = "APPROVED"
+	poSent              = "SENT"
+	poPartiallyReceived = "PARTIALLY_RECEIVED"
+	poClosed            = "CLOSED"
+)
+
+const (
+	poManual  = "MANUAL"
+	poReorder = "REORDER"
+)
+
+const (
+	payableUnpaid        = "UNPAID"
+	payablePartiallyPaid = "PARTIALLY_PAID"
+	payablePaid          = "PAID"
+)
+
+// openPurchaseOrder lists the states in which an order is still expected to
+// deliver.
+var openPurchaseOrder = []string{poDraft, poApproved, poSent, poPartiallyReceived}
+
+// OverDeliveryPercent is how far past the ordered quantity a delivery line is
+// accepted without the receiver confirming it.
+var OverDeliveryPercent = overDeliveryPercent()
+
+func overDeliveryPercent() float64 {
+	percent, err := strconv.ParseFloat(os.Getenv("PO_OVER_DELIVERY_PERCENT"), 64)
+	if err != nil || percent < 0 {
+		return 10
+	}
+	return percent
+}
+
+// GetPurchaseOrders lists orders, filtered by supplier_id, status and
+// payment_status, newest first.
+func GetPurchaseOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"supplier_id", "status", "payment_status"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+		cursor, err := purchaseOrderCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the purchase orders"})
+			return
+		}
+		orders := []models.PurchaseOrder{}
+		if err = cursor.All(ctx, &orders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the purchase orders"})
+			return
+		}
+		c.JSON(http.StatusOK, orders)
+	}
+}
+
+func GetPurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var order models.PurchaseOrder
+		if err := purchaseOrderCollection.FindOne(ctx, bson.M{"po_id": c.Param("po_id")}).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "purchase order not found"})
+			return
+		}
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// CreatePurchaseOrder drafts an order entered by hand.
+func CreatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var order models.PurchaseOrder
+		if err := c.BindJSON(&order); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(order); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		order.Source = poManual
+		insertPurchaseOrder(ctx, c, order)
+	}
+}
+
+// CreateReorderPurchaseOrder drafts an order from the reorder alerts, for
+// the alerted items not already on an open order. Each item is ordered in
+// its reorder quantity, or up to twice its reorder level when it has none,
+// at the price last paid to the supplier.
+func CreateReorderPurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request models.ReorderOrder
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		alerts, err := reorderAlerts(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the stock"})
+			return
+		}
+		onOrder, err := itemsOnOrder(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the purchase orders"})
+			return
+		}
+
+		order := models.PurchaseOrder{
+			Supplier_id: request.Supplier_id,
+			Location_id: request.Location_id,
+			Source:      poReorder,
+		}
+		for _, alert := range alerts {
+			if onOrder[alert.Item_id] || (len(request.Item_ids) > 0 && !containsString(request.Item_ids, alert.Item_id)) {
+				continue
+			}
+			quantity := alert.Reorder_quantity
+			if quantity <= 0 {
+				quantity = 2*alert.Reorder_level - alert.On_hand
+			}
+			price, _, err := lastSupplierPrice(ctx, *request.Supplier_id, alert.Item_id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the prices"})
+				return
+			}
+			order.Lines = append(order.Lines, models.PurchaseOrderLine{Item_id: alert.Item_id, Quantity: quantity, Unit_price: price})
+		}
+		if len(order.Lines) == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "no alerted item is left to order"})
+			return
+		}
+		insertPurchaseOrder(ctx, c, order)
+	}
+}
+
+// insertPurchaseOrder checks and numbers a new draft order and saves it,
+// writing the response.
+func insertPurchaseOrder(ctx context.Context, c *gin.Context, order models.PurchaseOrder) {
+	var supplier models.Supplier
+	err := supplierCollection.FindOne(ctx, bson.M{"supplier_id": order.Supplier_id}).Decode(&supplier)
+	if err != nil || supplier.Active == nil || !*supplier.Active {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "supplier not found or inactive"})
+		return
+	}
+	if _, msg, err := stockLocation(ctx, *order.Location_id); msg != "" || err != nil {
+		stockProblem(c, msg, err)
+		return
+	}
+	if msg, err := checkPurchaseOrderLines(ctx, order.Lines); msg != "" || err != nil {
+		stockProblem(c, msg, err)
+		return
+	}
+
+	number, err := nextPurchaseOrderNumber(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "purchase order was not numbered"})
+		return
+	}
+
+	order.Po_number = number
+	order.Status = poDraft
+	order.Total = purchaseOrderTotal(order.Lines)
+	order.Created_by = c.GetString("uid")
+	order.Approved_by, order.Approved_at, order.Sent_at, order.Closed_at, order.Close_reason = nil, nil, nil, nil, nil
+	order.Amount_due = 0
+	order.Amount_paid = 0
+	order.Payment_status = payableUnpaid
+	order.Payments = []models.PoPayment{}
+	order.Created_at = helper.Now()
+	order.Updated_at = helper.Now()
+	order.ID = primitive.NewObjectID()
+	order.Po_id = order.ID.Hex()
+
+	if _, insertErr := purchaseOrderCollection.InsertOne(ctx, order); insertErr != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "purchase order was not created"})
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// UpdatePurchaseOrder edits an order while it is a draft.
+func UpdatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var change models.PurchaseOrderChange
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(change); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		set := bson.M{}
+		if change.Location_id != nil {
+			if _, msg, err := stockLocation(ctx, *change.Location_id); msg != "" || err != nil {
+				stockProblem(c, msg, err)
+				return
+			}
+			set["location_id"] = change.Location_id
+		}
+		if change.Lines != nil {
+			if msg, err := checkPurchaseOrderLines(ctx, change.Lines); msg != "" || err != nil {
+				stockProblem(c, msg, err)
+				return
+			}
+			set["lines"] = change.Lines
+			set["total"] = purchaseOrderTotal(change.Lines)
+		}
+		if change.Notes != nil {
+			set["notes"] = change.Notes
+		}
+		movePurchaseOrder(c, []string{poDraft}, set)
+	}
+}
+
+// ApprovePurchaseOrder approves a draft and keeps its prices in the
+// supplier's price history. If the history cannot be saved the order goes
+// back to draft.
+func ApprovePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		now := helper.Now()
+		approvedBy := c.GetString("uid")
+		order, ok := transitionPurchaseOrder(ctx, c, []string{poDraft}, bson.M{"status": poApproved, "approved_by": approvedBy, "approved_at": now})
+		if !ok {
+			return
+		}
+
+		var prices []interface{}
+		for _, line := range order.Lines {
+			prices = append(prices, models.SupplierPrice{
+				ID:          primitive.NewObjectID(),
+				Supplier_id: *order.Supplier_id,
+				Item_id:     line.Item_id,
+				Unit_price:  line.Unit_price,
+				Po_id:       order.Po_id,
+				Recorded_at: now,
+			})
+		}
+		if len(prices) > 0 {
+			if _, err := supplierPriceCollection.InsertMany(ctx, prices); err != nil {
+				// an approval without its price history is not kept
+				log.Printf("purchase orders: price history of %s: %v", order.Po_id, err)
+				supplierPriceCollection.DeleteMany(ctx, bson.M{"po_id": order.Po_id})
+				purchaseOrderCollection.UpdateOne(ctx,
+					bson.M{"po_id": order.Po_id, "status": poApproved},
+					bson.M{"$set": bson.M{"status": poDraft, "approved_by": nil, "approved_at": nil, "updated_at": helper.Now()}},
+				)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "price history was not saved, the order was not approved"})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// SendPurchaseOrder marks an approved order as sent to the supplier.
+func SendPurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		movePurchaseOrder(c, []string{poApproved}, bson.M{"status": poSent, "sent_at": helper.Now()})
+	}
+}
+
+// ClosePurchaseOrder ends an order that will not be delivered in full, or
+// at all.
+func ClosePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request models.PoClose
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		movePurchaseOrder(c, openPurchaseOrder, bson.M{"status": poClosed, "closed_at": helper.Now(), "close_reason": request.Reason})
+	}
+}
+
+// ReceivePurchaseOrder puts a delivery against a sent order into stock at the
+// order's location. Each line may arrive in several batches. The order is
+// closed once every line has been received in full, or when the receiver
+// closes it short.
+func ReceivePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request models.PoReceipt
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		stockMu.Lock()
+		defer stockMu.Unlock()
+
+		var order models.PurchaseOrder
+		if err := purchaseOrderCollection.FindOne(ctx, bson.M{"po_id": c.Param("po_id")}).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "purchase order not found"})
+			return
+		}
+		if order.Status != poSent && order.Status != poPartiallyReceived {
+			c.JSON(http.StatusConflict, gin.H{"error": "the purchase order is " + order.Status})
+			return
+		}
+		var supplier models.Supplier
+		supplierCollection.FindOne(ctx, bson.M{"supplier_id": order.Supplier_id}).Decode(&supplier)
+
+		receipt := models.GoodsReceipt{Location_id: order.Location_id, Supplier: supplier.Name, Reference: &order.Po_number}
+		arriving := map[string]float64{}
+		for _, line := range request.Lines {
+			ordered := findPurchaseOrderLine(order.Lines, line.Line_id)
+			if ordered == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "line " + line.Line_id + " is not on the purchase order"})
+				return
+			}
+			arriving[line.Line_id] += line.Quantity
+			receipt.Lines = append(receipt.Lines, models.ReceiptLine{
+				Item_id:     ordered.Item_id,
+				Batch:       line.Batch,
+				Expiry_date: line.Expiry_date,
+				Quantity:    line.Quantity,
+			})
+		}
+
+		for i := range order.Lines {
+			line := &order.Lines[i]
+			quantity, ok := arriving[line.Line_id]
+			if !ok {
+				continue
+			}
+			allowed := line.Quantity*(1+OverDeliveryPercent/100) - line.Received
+			if quantity > allowed+quantityTolerance && !request.Accept_over_delivery {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("line %s: %g ordered, %g received so far, %g arriving; set accept_over_delivery to take the excess", line.Line_id, line.Quantity, line.Received, quantity)})
+				return
+			}
+			line.Received += quantity
+		}
+
+		movements, msg, err := receiptMovements(ctx, receipt, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the delivery"})
+			return
+		}
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if err := appendMovements(ctx, movements); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "goods receipt was not recorded"})
+			return
+		}
+
+		now := helper.Now()
+		complete := true
+		for _, line := range order.Lines {
+			if line.Received < line.Quantity-quantityTolerance {
+				complete = false
+			}
+		}
+		order.Amount_due = purchaseOrderReceivedValue(order.Lines)
+		set := bson.M{
+			"lines":          order.Lines,
+			"amount_due":     order.Amount_due,
+			"payment_status": payableStatus(order.Amount_due, order.Amount_paid),
+			"status":         poPartiallyReceived,
+			"updated_at":     now,
+		}
+		switch {
+		case complete:
+			set["status"], set["closed_at"], set["close_reason"] = poClosed, now, "received in full"
+		case request.Close:
+			set["status"], set["closed_at"], set["close_reason"] = poClosed, now, "closed short on delivery"
+		}
+
+		err = purchaseOrderCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"po_id": order.Po_id, "status": order.Status},
+			bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&order)
+		if err != nil {
+			reverseMovements(ctx, movements)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				c.JSON(http.StatusConflict, gin.H{"error": "the purchase order changed while receiving; try again"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "purchase order update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"purchase_order": order, "movements": movements})
+	}
+}
+
+// RecordPoPayment records a payment to the supplier against what has been
+// received on the order.
+func RecordPoPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var payment models.PoPayment
+		if err := c.BindJSON(&payment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(payment); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var order models.PurchaseOrder
+		if err := purchaseOrderCollection.FindOne(ctx, bson.M{"po_id": c.Param("po_id")}).Decode(&order); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "purchase order not found"})
+			return
+		}
+		if order.Amount_paid+payment.Amount > order.Amount_due+quantityTolerance {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("only %.2f is owed for what has been received", order.Amount_due-order.Amount_paid)})
+			return
+		}
+
+		payment.Recorded_by = c.GetString("uid")
+		payment.Paid_at = helper.Now()
+		paid := order.Amount_paid + payment.Amount
+
+		// the amount paid guards against two payments of the same balance
+		err := purchaseOrderCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"po_id": order.Po_id, "amount_paid": order.Amount_paid},
+			bson.M{
+				"$push": bson.M{"payments": payment},
+				"$set": bson.M{
+					"amount_paid":    paid,
+					"payment_status": payableStatus(order.Amount_due, paid),
+					"updated_at":     helper.Now(),
+				},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&order)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the purchase order changed while paying; try again"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "payment was not recorded"})
+			return
+		}
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// movePurchaseOrder applies set to the order when it is in one of the from
+// states and writes the response.
+func movePurchaseOrder(c *gin.Context, from []string, set bson.M) (models.PurchaseOrder, bool) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	order, ok := transitionPurchaseOrder(ctx, c, from, set)
+	if !ok {
+		return order, false
+	}
+	c.JSON(http.StatusOK, order)
+	return order, true
+}
+
+// transitionPurchaseOrder applies set to the order when it is in one of the
+// from states. Only a failure is written to the response, so the caller can
+// finish its work before answering.
+func transitionPurchaseOrder(ctx context.Context, c *gin.Context, from []string, set bson.M) (models.PurchaseOrder, bool) {
+	set["updated_at"] = helper.Now()
+
+	var order models.PurchaseOrder
+	err := purchaseOrderCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"po_id": c.Param("po_id"), "status": bson.M{"$in": from}},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusConflict, gin.H{"error": "purchase order not found or not in a state that allows this"})
+		return order, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "purchase order update failed"})
+		return order, false
+	}
+	return order, true
+}
+
+// checkPurchaseOrderLines checks every line orders a different active stock
+// item, and numbers the lines. A non-empty message is a problem with the
+// request.
+func checkPurchaseOrderLines(ctx context.Context, lines []models.PurchaseOrderLine) (string, error) {
+	seen := map[string]bool{}
+	for i := range lines {
+		line := &lines[i]
+		if seen[line.Item_id] {
+			return fmt.Sprintf("line %d: the item is already on the order", i+1), nil
+		}
+		seen[line.Item_id] = true
+		if _, msg, err := activeStockItem(ctx, line.Item_id); msg != "" || err != nil {
+			return fmt.Sprintf("line %d: %s", i+1, msg), err
+		}
+		line.Line_id = strconv.Itoa(i + 1)
+		line.Received = 0
+	}
+	return "", nil
+}
+
+// itemsOnOrder lists the stock items on open orders.
+func itemsOnOrder(ctx context.Context) (map[string]bool, error) {
+	cursor, err := purchaseOrderCollection.Find(ctx, bson.M{"status": bson.M{"$in": openPurchaseOrder}})
+	if err != nil {
+		return nil, err
+	}
+	var orders []models.PurchaseOrder
+	if err = cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	onOrder := map[string]bool{}
+	for _, order := range orders {
+		for _, line := range order.Lines {
+			onOrder[line.Item_id] = true
+		}
+	}
+	return onOrder, nil
+}
+
+// nextPurchaseOrderNumber hands out order numbers like PO-2024-00042 from a
+// yearly counter.
+func nextPurchaseOrderNumber(ctx context.Context) (string, error) {
+	year := helper.InFacility(helper.Now()).Year()
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := purchaseOrderCounterCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": strconv.Itoa(year)},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return fmt.Sprintf("PO-%d-%05d", year, counter.Seq), err
+}
+
+func findPurchaseOrderLine(lines []models.PurchaseOrderLine, lineId string) *models.PurchaseOrderLine {
+	for i := range lines {
+		if lines[i].Line_id == lineId {
+			return &lines[i]
+		}
+	}
+	return nil
+}
+
+func purchaseOrderTotal(lines []models.PurchaseOrderLine) float64 {
+	total := 0.0
+	for _, line := range lines {
+		total += line.Quantity * line.Unit_price
+	}
+	return total
+}
+
+func purchaseOrderReceivedValue(lines []models.PurchaseOrderLine) float64 {
+	value := 0.0
+	for _, line := range lines {
+		value += line.Received * line.Unit_price
+	}
+	return value
+}
+
+func payableStatus(due float64, paid float64) string {
+	switch {
+	case paid <= 0:
+		return payableUnpaid
+	case paid < due-quantityTolerance:
+		return payablePartiallyPaid
+	}
+	return payablePaid
+}
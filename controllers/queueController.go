@@ -0,0 +1,542 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var queueCollection *mongo.Collection = database.OpenCollection(database.Client, "queue")
+var queueCounterCollection *mongo.Collection = database.OpenCollection(database.Client, "queueCounter")
+
+var queueDisplays = helper.NewBroadcaster()
+
+const (
+	queueWaiting = "WAITING"
+	queueCalled  = "CALLED"
+	queueSkipped = "SKIPPED"
+	queueServed  = "SERVED"
+
+	// used for waiting estimates until a queue has served anyone
+	defaultServiceMinutes = 10
+	serviceSampleSize     = 10
+)
+
+// queueDisplay is what the waiting-room screen shows. It carries token
+// numbers only, never patient details.
+type queueDisplay struct {
+	Queue                   string `json:"queue"`
+	Queue_date              string `json:"queue_date"`
+	Now_serving             []int  `json:"now_serving"`
+	Waiting                 []int  `json:"waiting"`
+	Average_service_minutes int    `json:"average_service_minutes"`
+}
+
+func IssueQueueToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.QueueEntry
+
+		if err := c.BindJSON(&entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(entry)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var patient models.Patient
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": entry.Patient_id}).Decode(&patient); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
+			return
+		}
+		if entry.Doctor_id != nil {
+			var doctor models.Doctor
+			if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": entry.Doctor_id}).Decode(&doctor); err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "message:Doctor not found"})
+				return
+			}
+		}
+
+		entry.Queue_date = queueToday()
+		key := queueKey(entry.Queue_date, entry.Doctor_id, entry.Department)
+
+		token, err := nextQueueToken(ctx, key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "queue token was not issued"})
+			return
+		}
+
+		entry.Token_number = token
+		entry.Status = queueWaiting
+		entry.Issued_at = helper.Now()
+		entry.Created_at = helper.Now()
+		entry.Updated_at = helper.Now()
+		entry.ID = primitive.NewObjectID()
+		entry.Entry_id = entry.ID.Hex()
+
+		if _, insertErr := queueCollection.InsertOne(ctx, entry); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "queue token was not issued"})
+			return
+		}
+
+		publishQueue(ctx, entry.Queue_date, entry.Doctor_id, entry.Department)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+func GetQueue() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		doctorId, department := queueRefFromQuery(c)
+		if doctorId == nil && department == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "doctor_id or department is required"})
+			return
+		}
+
+		date := c.Query("date")
+		if date == "" {
+			date = queueToday()
+		}
+
+		entries, err := queueEntries(ctx, date, doctorId, department, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the queue"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"queue_date":              date,
+			"entries":                 entries,
+			"average_service_minutes": averageServiceMinutes(ctx, doctorId, department),
+		})
+	}
+}
+
+// CallNextQueueToken calls the waiting token with the lowest number.
+func CallNextQueueToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var ref models.QueueRef
+
+		if err := c.BindJSON(&ref); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(ref)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		date := queueToday()
+		filter := queueFilter(date, ref.Doctor_id, ref.Department)
+		filter["status"] = queueWaiting
+
+		now := helper.Now()
+		opts := options.FindOneAndUpdate().
+			SetSort(bson.M{"token_number": 1}).
+			SetReturnDocument(options.After)
+
+		var entry models.QueueEntry
+		err := queueCollection.FindOneAndUpdate(
+			ctx,
+			filter,
+			bson.M{"$set": bson.M{"status": queueCalled, "called_at": now, "updated_at": now}},
+			opts,
+		).Decode(&entry)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "nobody is waiting"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "queue update failed"})
+			return
+		}
+
+		publishQueue(ctx, date, ref.Doctor_id, ref.Department)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+func SkipQueueToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		moveQueueEntry(c, []string{queueWaiting, queueCalled}, bson.M{"status": queueSkipped})
+	}
+}
+
+// RecallQueueToken calls a skipped or already called token again.
+func RecallQueueToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := helper.Now()
+		moveQueueEntry(c, []string{queueSkipped, queueCalled}, bson.M{"status": queueCalled, "called_at": now}, bson.E{Key: "$inc", Value: bson.M{"recalls": 1}})
+	}
+}
+
+// ServeQueueToken marks a called token served. With create_appointment the
+// visit is also booked as an appointment with the doctor, checked and
+// confirmed like any other booking.
+func ServeQueueToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var serve models.QueueServe
+		if err := c.ShouldBindJSON(&serve); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var entry models.QueueEntry
+		if err := queueCollection.FindOne(ctx, bson.M{"entry_id": c.Param("entry_id")}).Decode(&entry); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "queue token not found"})
+			return
+		}
+		if entry.Status != queueCalled {
+			c.JSON(http.StatusConflict, gin.H{"error": "only a called token can be served, this one is " + entry.Status})
+			return
+		}
+		if serve.Create_appointment && entry.Doctor_id == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a department token has no doctor to book the appointment with"})
+			return
+		}
+
+		// claim the token first so two requests cannot both serve it
+		now := helper.Now()
+		err := queueCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"entry_id": entry.Entry_id, "status": queueCalled},
+			bson.M{"$set": bson.M{"status": queueServed, "served_at": now, "updated_at": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&entry)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the token is no longer called"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "queue update failed"})
+			return
+		}
+
+		if serve.Create_appointment {
+			minutes := int(now.Sub(*entry.Called_at).Minutes() + 0.5)
+			if minutes < 5 {
+				minutes = 5
+			}
+			appointment := models.Appointment{
+				Appointment_Date: *entry.Called_at,
+				Duration_minutes: minutes,
+				Invoice_id:       serve.Invoice_id,
+				Doctor_id:        entry.Doctor_id,
+				Patient_id:       entry.Patient_id,
+			}
+			if _, conflict, status, msg := bookNewAppointment(ctx, &appointment); msg != "" {
+				// give the token back so serving can be retried
+				queueCollection.UpdateOne(ctx,
+					bson.M{"entry_id": entry.Entry_id, "status": queueServed},
+					bson.M{"$set": bson.M{"status": queueCalled, "updated_at": helper.Now()}, "$unset": bson.M{"served_at": ""}},
+				)
+				if conflict != nil {
+					c.JSON(status, gin.H{"error": msg, "conflict": conflict})
+					return
+				}
+				c.JSON(status, gin.H{"error": msg})
+				return
+			}
+			_, err := queueCollection.UpdateOne(ctx, bson.M{"entry_id": entry.Entry_id}, bson.M{"$set": bson.M{"appointment_id": appointment.Appointment_id}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "queue update failed"})
+				return
+			}
+			entry.Appointment_id = appointment.Appointment_id
+		}
+
+		publishQueue(ctx, entry.Queue_date, entry.Doctor_id, entry.Department)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// GetQueuePosition tells a patient how many tokens are ahead of theirs and
+// roughly how long they will wait.
+func GetQueuePosition() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.QueueEntry
+		if err := queueCollection.FindOne(ctx, bson.M{"entry_id": c.Param("entry_id")}).Decode(&entry); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "queue token not found"})
+			return
+		}
+
+		ahead := int64(0)
+		if entry.Status == queueWaiting {
+			filter := queueFilter(entry.Queue_date, entry.Doctor_id, entry.Department)
+			filter["status"] = queueWaiting
+			filter["token_number"] = bson.M{"$lt": entry.Token_number}
+
+			count, err := queueCollection.CountDocuments(ctx, filter)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the queue"})
+				return
+			}
+			ahead = count
+		}
+
+		average := averageServiceMinutes(ctx, entry.Doctor_id, entry.Department)
+		c.JSON(http.StatusOK, gin.H{
+			"token_number":           entry.Token_number,
+			"status":                 entry.Status,
+			"ahead":                  ahead,
+			"estimated_wait_minutes": int(ahead) * average,
+		})
+	}
+}
+
+// StreamQueueDisplay is a Server-Sent Events stream for a waiting-room screen.
+// It sends the current state at once and again after every change.
+func StreamQueueDisplay() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		doctorId, department := queueRefFromQuery(c)
+		if doctorId == nil && department == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "doctor_id or department is required"})
+			return
+		}
+
+		date := queueToday()
+		updates, unsubscribe := queueDisplays.Subscribe(queueKey(date, doctorId, department))
+		defer func() { unsubscribe() }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		initial, err := queueDisplayMessage(ctx, date, doctorId, department)
+		cancel()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the queue"})
+			return
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.SSEvent("queue", string(initial))
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(20 * time.Second)
+		defer heartbeat.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case message := <-updates:
+				c.SSEvent("queue", string(message))
+			case <-heartbeat.C:
+				// keeps proxies from closing an idle stream
+				c.SSEvent("ping", "")
+			}
+
+			// at the facility's midnight the screen moves on to the new day
+			if today := queueToday(); today != date {
+				date = today
+				unsubscribe()
+				updates, unsubscribe = queueDisplays.Subscribe(queueKey(date, doctorId, department))
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				message, err := queueDisplayMessage(ctx, date, doctorId, department)
+				cancel()
+				if err != nil {
+					log.Printf("queue: %v", err)
+					return false
+				}
+				c.SSEvent("queue", string(message))
+			}
+			return true
+		})
+	}
+}
+
+func moveQueueEntry(c *gin.Context, from []string, set bson.M, extra ...bson.E) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	set["updated_at"] = helper.Now()
+	update := bson.D{{Key: "$set", Value: set}}
+	update = append(update, extra...)
+
+	var entry models.QueueEntry
+	err := queueCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"entry_id": c.Param("entry_id"), "status": bson.M{"$in": from}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusConflict, gin.H{"error": "queue token not found or not in a state that allows this"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "queue update failed"})
+		return
+	}
+
+	publishQueue(ctx, entry.Queue_date, entry.Doctor_id, entry.Department)
+	c.JSON(http.StatusOK, entry)
+}
+
+func queueToday() string {
+	return helper.InFacility(helper.Now()).Format("2006-01-02")
+}
+
+func queueKey(date string, doctorId *string, department *string) string {
+	if doctorId != nil {
+		return date + "|doctor:" + *doctorId
+	}
+	return date + "|department:" + *department
+}
+
+func queueFilter(date string, doctorId *string, department *string) bson.M {
+	filter := bson.M{"queue_date": date}
+	if doctorId != nil {
+		filter["doctor_id"] = *doctorId
+	} else {
+		filter["department"] = *department
+		filter["doctor_id"] = nil
+	}
+	return filter
+}
+
+func queueRefFromQuery(c *gin.Context) (*string, *string) {
+	var doctorId, department *string
+	if value := c.Query("doctor_id"); value != "" {
+		doctorId = &value
+	} else if value := c.Query("department"); value != "" {
+		department = &value
+	}
+	return doctorId, department
+}
+
+// nextQueueToken hands out token numbers from a per-queue counter, so two
+// receptions issuing at once never get the same number.
+func nextQueueToken(ctx context.Context, key string) (int, error) {
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := queueCounterCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Seq, err
+}
+
+func queueEntries(ctx context.Context, date string, doctorId *string, department *string, statuses []string) ([]models.QueueEntry, error) {
+	filter := queueFilter(date, doctorId, department)
+	if statuses != nil {
+		filter["status"] = bson.M{"$in": statuses}
+	}
+
+	cursor, err := queueCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"token_number": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []models.QueueEntry{}
+	err = cursor.All(ctx, &entries)
+	return entries, err
+}
+
+// averageServiceMinutes averages the call-to-served time of the queue's most
+// recent visits, across days.
+func averageServiceMinutes(ctx context.Context, doctorId *string, department *string) int {
+	filter := bson.M{"status": queueServed}
+	if doctorId != nil {
+		filter["doctor_id"] = *doctorId
+	} else {
+		filter["department"] = *department
+	}
+
+	opts := options.Find().SetSort(bson.M{"served_at": -1}).SetLimit(serviceSampleSize)
+	cursor, err := queueCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return defaultServiceMinutes
+	}
+
+	var served []models.QueueEntry
+	if err = cursor.All(ctx, &served); err != nil {
+		return defaultServiceMinutes
+	}
+
+	var total time.Duration
+	samples := 0
+	for _, entry := range served {
+		if entry.Called_at == nil || entry.Served_at == nil {
+			continue
+		}
+		total += entry.Served_at.Sub(*entry.Called_at)
+		samples++
+	}
+	if samples == 0 {
+		return defaultServiceMinutes
+	}
+
+	average := int((total / time.Duration(samples)).Minutes() + 0.5)
+	if average < 1 {
+		return 1
+	}
+	return average
+}
+
+func queueDisplayMessage(ctx context.Context, date string, doctorId *string, department *string) ([]byte, error) {
+	entries, err := queueEntries(ctx, date, doctorId, department, []string{queueWaiting, queueCalled})
+	if err != nil {
+		return nil, err
+	}
+
+	display := queueDisplay{
+		Queue:                   queueKey(date, doctorId, department),
+		Queue_date:              date,
+		Now_serving:             []int{},
+		Waiting:                 []int{},
+		Average_service_minutes: averageServiceMinutes(ctx, doctorId, department),
+	}
+	for _, entry := range entries {
+		if entry.Status == queueCalled {
+			display.Now_serving = append(display.Now_serving, entry.Token_number)
+		} else {
+			display.Waiting = append(display.Waiting, entry.Token_number)
+		}
+	}
+	return json.Marshal(display)
+}
+
+func publishQueue(ctx context.Context, date string, doctorId *string, department *string) {
+	message, err := queueDisplayMessage(ctx, date, doctorId, department)
+	if err != nil {
+		log.Printf("queue: %v", err)
+		return
+	}
+	queueDisplays.Publish(queueKey(date, doctorId, department), message)
+}
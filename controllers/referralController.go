@@ -0,0 +1,410 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var referralCollection *mongo.Collection = database.OpenCollection(database.Client, "referral")
+
+const (
+	referralSent     = "SENT"
+	referralAccepted = "ACCEPTED"
+	referralDeclined = "DECLINED"
+	referralBooking  = "BOOKING"
+	referralBooked   = "BOOKED"
+	referralClosed   = "CLOSED"
+)
+
+// urgencyRank orders an inbox with the most pressing referrals first.
+var urgencyRank = map[string]int{"EMERGENCY": 0, "URGENT": 1, "ROUTINE": 2}
+
+func CreateReferral() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var referral models.Referral
+
+		if err := c.BindJSON(&referral); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(referral)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := doctorCollection.CountDocuments(ctx, bson.M{"doctor_id": referral.From_doctor_id})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "referring doctor not found"})
+			return
+		}
+
+		if referral.To_doctor_id != nil {
+			var doctor models.Doctor
+			if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": referral.To_doctor_id}).Decode(&doctor); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "receiving doctor not found"})
+				return
+			}
+			if *referral.To_doctor_id == *referral.From_doctor_id {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "a doctor cannot refer a patient to themselves"})
+				return
+			}
+			referral.To_speciality = doctor.Speciality
+		} else {
+			count, err := doctorCollection.CountDocuments(ctx, bson.M{"speciality": referral.To_speciality})
+			if err != nil || count == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "no doctor practises " + *referral.To_speciality})
+				return
+			}
+		}
+
+		count, err = patientCollection.CountDocuments(ctx, bson.M{"patient_id": referral.Patient_id})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "patient not found"})
+			return
+		}
+
+		if len(referral.Prescription_ids) > 0 {
+			count, err := prescriptionCollection.CountDocuments(ctx, bson.M{"prescription_id": bson.M{"$in": referral.Prescription_ids}})
+			if err != nil || int(count) != len(referral.Prescription_ids) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "some attached prescriptions were not found"})
+				return
+			}
+		}
+
+		if referral.Urgency == nil {
+			urgency := "ROUTINE"
+			referral.Urgency = &urgency
+		}
+		if referral.Prescription_ids == nil {
+			referral.Prescription_ids = []string{}
+		}
+		referral.Status = referralSent
+		referral.Created_at = helper.Now()
+		referral.Updated_at = helper.Now()
+		referral.ID = primitive.NewObjectID()
+		referral.Referral_id = referral.ID.Hex()
+
+		if _, insertErr := referralCollection.InsertOne(ctx, referral); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "referral was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, referral)
+	}
+}
+
+func GetReferral() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var referral models.Referral
+		err := referralCollection.FindOne(ctx, bson.M{"referral_id": c.Param("referral_id")}).Decode(&referral)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "referral not found"})
+			return
+		}
+		c.JSON(http.StatusOK, referral)
+	}
+}
+
+// GetReferrals lists referrals by patient_id, from_doctor_id and status.
+func GetReferrals() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		for _, key := range []string{"patient_id", "from_doctor_id", "status"} {
+			if value := c.Query(key); value != "" {
+				filter[key] = value
+			}
+		}
+
+		referrals, err := findReferrals(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing referrals"})
+			return
+		}
+		c.JSON(http.StatusOK, referrals)
+	}
+}
+
+// GetReferralInbox lists the open referrals a doctor can act on: those sent
+// to them and those sent to their speciality that nobody has taken yet. A
+// "status" query lists that state instead.
+func GetReferralInbox() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var doctor models.Doctor
+		if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": c.Param("doctor_id")}).Decode(&doctor); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message:Doctor not found"})
+			return
+		}
+
+		filter := bson.M{
+			"$or": bson.A{
+				bson.M{"to_doctor_id": doctor.Doctor_id},
+				bson.M{"to_doctor_id": nil, "to_speciality": doctor.Speciality},
+			},
+			"status": bson.M{"$in": bson.A{referralSent, referralAccepted}},
+		}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		referrals, err := findReferrals(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing referrals"})
+			return
+		}
+		sort.SliceStable(referrals, func(i, j int) bool {
+			return urgencyRank[*referrals[i].Urgency] < urgencyRank[*referrals[j].Urgency]
+		})
+		c.JSON(http.StatusOK, referrals)
+	}
+}
+
+// AcceptReferral takes on a sent referral as the signed-in doctor. A
+// referral to a speciality can be taken by any doctor who practises it.
+func AcceptReferral() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		doctorId, ok := signedInDoctor(c)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "sign in as a doctor to accept a referral"})
+			return
+		}
+
+		var referral models.Referral
+		if err := referralCollection.FindOne(ctx, bson.M{"referral_id": c.Param("referral_id")}).Decode(&referral); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "referral not found"})
+			return
+		}
+		if referral.To_doctor_id != nil && doctorId != *referral.To_doctor_id {
+			c.JSON(http.StatusForbidden, gin.H{"error": "the referral was sent to another doctor"})
+			return
+		}
+
+		var doctor models.Doctor
+		if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": doctorId}).Decode(&doctor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "message:Doctor not found"})
+			return
+		}
+		if *doctor.Speciality != *referral.To_speciality {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the referral is for " + *referral.To_speciality})
+			return
+		}
+
+		now := helper.Now()
+		moveReferral(c, []string{referralSent}, bson.M{
+			"status":       referralAccepted,
+			"to_doctor_id": doctor.Doctor_id,
+			"accepted_by":  doctor.Doctor_id,
+			"accepted_at":  now,
+		})
+	}
+}
+
+func DeclineReferral() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var decision models.ReferralDecision
+		if err := c.BindJSON(&decision); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if decision.Reason == nil || *decision.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a reason is required to decline a referral"})
+			return
+		}
+		if validationErr := validate.Struct(decision); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		moveReferral(c, []string{referralSent}, bson.M{"status": referralDeclined, "decline_reason": decision.Reason})
+	}
+}
+
+// BookReferral turns an accepted referral into an appointment with the
+// accepting doctor, at the requested time or the first free slot.
+func BookReferral() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var booking models.ReferralBooking
+		if err := c.BindJSON(&booking); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(booking); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var referral models.Referral
+		if err := referralCollection.FindOne(ctx, bson.M{"referral_id": c.Param("referral_id")}).Decode(&referral); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "referral not found"})
+			return
+		}
+		if referral.Status != referralAccepted {
+			c.JSON(http.StatusConflict, gin.H{"error": "only an accepted referral can be booked, this one is " + referral.Status})
+			return
+		}
+
+		// claim the referral so it is booked only once
+		err := referralCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"referral_id": referral.Referral_id, "status": referralAccepted},
+			bson.M{"$set": bson.M{"status": referralBooking, "updated_at": helper.Now()}},
+		).Decode(&referral)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the referral is no longer accepted"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "referral update failed"})
+			return
+		}
+
+		appointment := models.Appointment{
+			Duration_minutes: booking.Duration_minutes,
+			Type_id:          referral.Type_id,
+			Invoice_id:       booking.Invoice_id,
+			Doctor_id:        referral.Accepted_by,
+			Patient_id:       referral.Patient_id,
+			Resource_ids:     booking.Resource_ids,
+		}
+
+		if booking.Appointment_Date != nil {
+			appointment.Appointment_Date = *booking.Appointment_Date
+		} else {
+			probe := appointment
+			problem, err := applyAppointmentType(ctx, &probe)
+			if err != nil {
+				releaseReferral(ctx, referral.Referral_id)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the appointment type"})
+				return
+			}
+			if problem != "" {
+				releaseReferral(ctx, referral.Referral_id)
+				c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+				return
+			}
+			now := helper.Now()
+			slots, err := findFreeSlots(ctx, *referral.Accepted_by, now, now.AddDate(0, 0, 30), occupiedMinutes(probe), 1)
+			if err != nil {
+				releaseReferral(ctx, referral.Referral_id)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the doctor's schedule"})
+				return
+			}
+			if len(slots) == 0 {
+				releaseReferral(ctx, referral.Referral_id)
+				c.JSON(http.StatusConflict, gin.H{"error": "the doctor has no free slot in the next 30 days"})
+				return
+			}
+			appointment.Appointment_Date = slots[0]
+		}
+
+		if _, ok := createAppointment(ctx, c, &appointment); !ok {
+			releaseReferral(ctx, referral.Referral_id)
+			return
+		}
+
+		now := helper.Now()
+		err = referralCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"referral_id": referral.Referral_id, "status": referralBooking},
+			bson.M{"$set": bson.M{"status": referralBooked, "appointment_id": appointment.Appointment_id, "updated_at": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&referral)
+		if err != nil {
+			// the referral did not take the appointment, so it must not stand
+			_, cancelErr := appointmentCollection.UpdateOne(ctx,
+				bson.M{"appointment_id": appointment.Appointment_id},
+				bson.M{"$set": bson.M{"status": appointmentCancelled, "updated_at": now}},
+			)
+			if cancelErr == nil {
+				queueCancellationMessages(ctx, []string{appointment.Appointment_id})
+			}
+			releaseReferral(ctx, referral.Referral_id)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "referral update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, referral)
+	}
+}
+
+// releaseReferral returns a referral claimed by BookReferral to ACCEPTED when
+// the booking does not go through.
+func releaseReferral(ctx context.Context, referralId string) {
+	_, err := referralCollection.UpdateOne(ctx,
+		bson.M{"referral_id": referralId, "status": referralBooking},
+		bson.M{"$set": bson.M{"status": referralAccepted, "updated_at": helper.Now()}},
+	)
+	if err != nil {
+		log.Printf("referral: %v", err)
+	}
+}
+
+func CloseReferral() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		moveReferral(c, []string{referralSent, referralAccepted, referralBooked}, bson.M{"status": referralClosed, "closed_at": helper.Now()})
+	}
+}
+
+func moveReferral(c *gin.Context, from []string, set bson.M) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	set["updated_at"] = helper.Now()
+
+	var referral models.Referral
+	err := referralCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"referral_id": c.Param("referral_id"), "status": bson.M{"$in": from}},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&referral)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusConflict, gin.H{"error": "referral not found or not in a state that allows this"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "referral update failed"})
+		return
+	}
+	c.JSON(http.StatusOK, referral)
+}
+
+func findReferrals(ctx context.Context, filter bson.M) ([]models.Referral, error) {
+	cursor, err := referralCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	referrals := []models.Referral{}
+	err = cursor.All(ctx, &referrals)
+	return referrals, err
+}
@@ -0,0 +1,458 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var resourceCollection *mongo.Collection = database.OpenCollection(database.Client, "resource")
+
+const resourceOutOfService = "OUT_OF_SERVICE"
+
+func GetResources() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if kind := c.Query("kind"); kind != "" {
+			filter["kind"] = kind
+		}
+
+		result, err := resourceCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing resources"})
+			return
+		}
+
+		allResources := []models.Resource{}
+		if err = result.All(ctx, &allResources); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing resources"})
+			return
+		}
+		c.JSON(http.StatusOK, allResources)
+	}
+}
+
+func GetResource() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.Resource
+		err := resourceCollection.FindOne(ctx, bson.M{"resource_id": c.Param("resource_id")}).Decode(&resource)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "resource not found"})
+			return
+		}
+		c.JSON(http.StatusOK, resource)
+	}
+}
+
+func CreateResource() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.Resource
+
+		if err := c.BindJSON(&resource); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(resource)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if err := checkResourceHours(resource.Availability); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if resource.Capacity == 0 {
+			resource.Capacity = 1
+		}
+		if resource.Status == nil {
+			status := "AVAILABLE"
+			resource.Status = &status
+		}
+		resource.Created_at = helper.Now()
+		resource.Updated_at = helper.Now()
+		resource.ID = primitive.NewObjectID()
+		resource.Resource_id = resource.ID.Hex()
+
+		result, insertErr := resourceCollection.InsertOne(ctx, resource)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "resource was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func UpdateResource() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resource models.Resource
+
+		if err := c.BindJSON(&resource); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.StructExcept(resource, "Name", "Kind")
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+
+		if resource.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: resource.Name})
+		}
+		if resource.Location != nil {
+			updateObj = append(updateObj, bson.E{Key: "location", Value: resource.Location})
+		}
+		if resource.Capacity != 0 {
+			updateObj = append(updateObj, bson.E{Key: "capacity", Value: resource.Capacity})
+		}
+		if resource.Status != nil {
+			updateObj = append(updateObj, bson.E{Key: "status", Value: resource.Status})
+		}
+		if resource.Availability != nil {
+			if err := checkResourceHours(resource.Availability); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "availability", Value: resource.Availability})
+		}
+
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: helper.Now()})
+
+		result, err := resourceCollection.UpdateOne(
+			ctx,
+			bson.M{"resource_id": c.Param("resource_id")},
+			bson.D{{Key: "$set", Value: updateObj}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "resource update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetResourceCalendar lists the appointments holding a resource between the
+// facility-local dates from and to (to exclusive).
+func GetResourceCalendar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		from, to, err := localDateRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		appointments, err := resourceAppointments(ctx, c.Param("resource_id"), from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
+			return
+		}
+		for i := range appointments {
+			localizeAppointment(&appointments[i])
+		}
+		c.JSON(http.StatusOK, appointments)
+	}
+}
+
+// GetResourceUtilisation compares booked minutes with the minutes the
+// resource was open, per facility-local day and in total.
+func GetResourceUtilisation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		from, to, err := localDateRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var resource models.Resource
+		err = resourceCollection.FindOne(ctx, bson.M{"resource_id": c.Param("resource_id")}).Decode(&resource)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "resource not found"})
+			return
+		}
+
+		appointments, err := resourceAppointments(ctx, resource.Resource_id, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing appointments"})
+			return
+		}
+
+		type dayUsage struct {
+			Date              string  `json:"date"`
+			Booked_minutes    int     `json:"booked_minutes"`
+			Available_minutes int     `json:"available_minutes"`
+			Utilisation       float64 `json:"utilisation"`
+		}
+
+		var days []dayUsage
+		totalBooked, totalAvailable := 0, 0
+		for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+			next := day.AddDate(0, 0, 1)
+			usage := dayUsage{Date: day.Format("2006-01-02")}
+
+			for _, window := range openWindows(resource, day) {
+				usage.Available_minutes += int(window[1].Sub(window[0]).Minutes()) * resource.Capacity
+			}
+			for _, appointment := range appointments {
+				start := appointment.Appointment_Date
+				end := start.Add(time.Duration(appointmentMinutes(appointment)) * time.Minute)
+				usage.Booked_minutes += overlapMinutes(start, end, day, next)
+			}
+			if usage.Available_minutes > 0 {
+				usage.Utilisation = toFixed(float64(usage.Booked_minutes)/float64(usage.Available_minutes), 2)
+			}
+
+			totalBooked += usage.Booked_minutes
+			totalAvailable += usage.Available_minutes
+			days = append(days, usage)
+		}
+
+		utilisation := 0.0
+		if totalAvailable > 0 {
+			utilisation = toFixed(float64(totalBooked)/float64(totalAvailable), 2)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"resource_id":       resource.Resource_id,
+			"from":              from.Format("2006-01-02"),
+			"to":                to.Format("2006-01-02"),
+			"booked_minutes":    totalBooked,
+			"available_minutes": totalAvailable,
+			"utilisation":       utilisation,
+			"days":              days,
+		})
+	}
+}
+
+// unavailableResources returns the ids among resourceIds that cannot be held
+// for [start, start+minutes): unknown, out of service, closed at that time or
+// already booked up to capacity. excludeIds are appointments to ignore, for
+// moves.
+func unavailableResources(ctx context.Context, resourceIds []string, start time.Time, minutes int, excludeIds ...string) ([]string, error) {
+	end := start.Add(time.Duration(minutes) * time.Minute)
+	var unavailable []string
+
+	for _, id := range resourceIds {
+		var resource models.Resource
+		err := resourceCollection.FindOne(ctx, bson.M{"resource_id": id}).Decode(&resource)
+		if err == mongo.ErrNoDocuments {
+			unavailable = append(unavailable, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if (resource.Status != nil && *resource.Status == resourceOutOfService) || !resourceOpen(resource, start, end) {
+			unavailable = append(unavailable, id)
+			continue
+		}
+
+		holding, err := resourceAppointments(ctx, id, start, end, excludeIds...)
+		if err != nil {
+			return nil, err
+		}
+		if peakHolding(holding, start, end) >= resource.Capacity {
+			unavailable = append(unavailable, id)
+		}
+	}
+	return unavailable, nil
+}
+
+// peakHolding is the most appointments holding a resource at the same moment
+// of [from, to). Appointments that merely follow each other share a unit.
+func peakHolding(appointments []models.Appointment, from time.Time, to time.Time) int {
+	type edge struct {
+		at    time.Time
+		delta int
+	}
+	var edges []edge
+	for _, appointment := range appointments {
+		start := appointment.Appointment_Date
+		end := start.Add(time.Duration(occupiedMinutes(appointment)) * time.Minute)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		edges = append(edges, edge{start, 1}, edge{end, -1})
+	}
+	// an end before a start at the same instant frees the unit first
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at.Equal(edges[j].at) {
+			return edges[i].delta < edges[j].delta
+		}
+		return edges[i].at.Before(edges[j].at)
+	})
+
+	peak, holding := 0, 0
+	for _, e := range edges {
+		holding += e.delta
+		if holding > peak {
+			peak = holding
+		}
+	}
+	return peak
+}
+
+// resourceAppointments returns the scheduled appointments holding the
+// resource that overlap [from, to).
+func resourceAppointments(ctx context.Context, resourceId string, from time.Time, to time.Time, excludeIds ...string) ([]models.Appointment, error) {
+	filter := bson.M{
+		"resource_ids": resourceId,
+		"status":       bson.M{"$ne": appointmentCancelled},
+		"appointment_date": bson.M{
+			"$gt": from.Add(-24 * time.Hour),
+			"$lt": to,
+		},
+	}
+	if len(excludeIds) > 0 {
+		filter["appointment_id"] = bson.M{"$nin": excludeIds}
+	}
+
+	cursor, err := appointmentCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"appointment_date": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []models.Appointment
+	if err = cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	overlapping := []models.Appointment{}
+	for _, appointment := range candidates {
+		end := appointment.Appointment_Date.Add(time.Duration(occupiedMinutes(appointment)) * time.Minute)
+		if appointment.Appointment_Date.Before(to) && end.After(from) {
+			overlapping = append(overlapping, appointment)
+		}
+	}
+	return overlapping, nil
+}
+
+// resourceOpen reports whether [start, end) lies inside one opening window.
+func resourceOpen(resource models.Resource, start time.Time, end time.Time) bool {
+	if len(resource.Availability) == 0 {
+		return true
+	}
+	local := helper.InFacility(start)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, helper.FacilityLocation())
+	for _, window := range openWindows(resource, day) {
+		if !start.Before(window[0]) && !end.After(window[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// openWindows returns the resource's opening windows on the facility-local
+// day starting at midnight "day".
+func openWindows(resource models.Resource, day time.Time) [][2]time.Time {
+	next := day.AddDate(0, 0, 1)
+	if len(resource.Availability) == 0 {
+		return [][2]time.Time{{day, next}}
+	}
+
+	var windows [][2]time.Time
+	for _, hours := range resource.Availability {
+		if time.Weekday(hours.Weekday) != day.Weekday() {
+			continue
+		}
+		opens, _ := time.Parse("15:04", hours.Opens)
+		closes, _ := time.Parse("15:04", hours.Closes)
+		windows = append(windows, [2]time.Time{
+			time.Date(day.Year(), day.Month(), day.Day(), opens.Hour(), opens.Minute(), 0, 0, day.Location()),
+			time.Date(day.Year(), day.Month(), day.Day(), closes.Hour(), closes.Minute(), 0, 0, day.Location()),
+		})
+	}
+	return windows
+}
+
+func checkResourceHours(hours []models.ResourceHours) error {
+	for _, h := range hours {
+		if h.Closes <= h.Opens {
+			return fmt.Errorf("availability on weekday %d closes before it opens", h.Weekday)
+		}
+	}
+	return nil
+}
+
+func overlapMinutes(start time.Time, end time.Time, from time.Time, to time.Time) int {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return int(end.Sub(start).Minutes())
+}
+
+// localDateRange reads the "from" and "to" query dates (YYYY-MM-DD, facility
+// local). It defaults to the seven days starting today.
+func localDateRange(c *gin.Context) (time.Time, time.Time, error) {
+	loc := helper.FacilityLocation()
+	today := helper.InFacility(helper.Now())
+	from := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc)
+
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			return from, from, fmt.Errorf("invalid from date %q", value)
+		}
+		from = parsed
+	}
+
+	to := from.AddDate(0, 0, 7)
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid to date %q", value)
+		}
+		to = parsed
+	}
+
+	if !to.After(from) {
+		return from, to, fmt.Errorf("to must be after from")
+	}
+	if to.Sub(from) > 366*24*time.Hour {
+		return from, to, fmt.Errorf("the range can cover at most a year")
+	}
+	return from, to, nil
+}
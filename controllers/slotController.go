@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const maxSlotsListed = 50
+
+// GetDoctorSlots lists the doctor's free appointment slots from the "from"
+// date (facility local, default today) for "days" days. With "type_id" the
+// slots fit the type's duration and buffer instead of "minutes".
+func GetDoctorSlots() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		doctorId := c.Param("doctor_id")
+		var doctor models.Doctor
+		if err := doctorCollection.FindOne(ctx, bson.M{"doctor_id": doctorId}).Decode(&doctor); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message:Doctor not found"})
+			return
+		}
+
+		from, _, err := localDateRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if now := helper.Now(); from.Before(now) {
+			from = now
+		}
+
+		days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+		if err != nil || days < 1 || days > 60 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 60"})
+			return
+		}
+
+		minutes, err := strconv.Atoi(c.DefaultQuery("minutes", strconv.Itoa(defaultAppointmentMinutes)))
+		if err != nil || minutes < 5 || minutes > 480 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "minutes must be between 5 and 480"})
+			return
+		}
+
+		if typeId := c.Query("type_id"); typeId != "" {
+			probe := models.Appointment{Type_id: &typeId, Doctor_id: &doctorId}
+			problem, err := applyAppointmentType(ctx, &probe)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the appointment type"})
+				return
+			}
+			if problem != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+				return
+			}
+			minutes = occupiedMinutes(probe)
+		}
+
+		slots, err := findFreeSlots(ctx, doctorId, from, from.AddDate(0, 0, days), minutes, maxSlotsListed)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the doctor's schedule"})
+			return
+		}
+
+		proposals := []models.SlotProposal{}
+		for _, slot := range slots {
+			proposals = append(proposals, models.SlotProposal{
+				Doctor_id:        doctorId,
+				Doctor_name:      *doctor.Name,
+				Appointment_Date: slot,
+				Date_local:       helper.FormatLocal(slot),
+			})
+		}
+		c.JSON(http.StatusOK, proposals)
+	}
+}
+
+// findFreeSlots returns up to limit start times in [from, until) within clinic
+// hours when the doctor has no appointment, is not on leave and the facility
+// is open.
+func findFreeSlots(ctx context.Context, doctorId string, from time.Time, until time.Time, minutes int, limit int) ([]time.Time, error) {
+	length := time.Duration(minutes) * time.Minute
+	candidates := helper.CandidateSlots(from, until, length)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := appointmentCollection.Find(ctx, bson.M{
+		"doctor_id":        doctorId,
+		"status":           bson.M{"$ne": appointmentCancelled},
+		"appointment_date": bson.M{"$gt": from.Add(-24 * time.Hour), "$lt": until},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var booked []models.Appointment
+	if err = cursor.All(ctx, &booked); err != nil {
+		return nil, err
+	}
+
+	leaves, err := doctorLeaves(ctx, doctorId, from, until)
+	if err != nil {
+		return nil, err
+	}
+
+	closed, err := holidayDates(ctx, from, until)
+	if err != nil {
+		return nil, err
+	}
+
+	var free []time.Time
+	for _, start := range candidates {
+		end := start.Add(length)
+		if closed[helper.InFacility(start).Format("2006-01-02")] {
+			continue
+		}
+		if overlapsLeave(leaves, start, end) || overlapsAppointment(booked, start, end) {
+			continue
+		}
+		free = append(free, start)
+		if len(free) >= limit {
+			break
+		}
+	}
+	return free, nil
+}
+
+func overlapsLeave(leaves []models.DoctorLeave, start time.Time, end time.Time) bool {
+	for _, leave := range leaves {
+		if leave.Start_Date.Before(end) && leave.End_Date.After(start) {
+			return true
+		}
+	}
+	return false
+}
+
+func overlapsAppointment(appointments []models.Appointment, start time.Time, end time.Time) bool {
+	for _, other := range appointments {
+		otherEnd := other.Appointment_Date.Add(time.Duration(occupiedMinutes(other)) * time.Minute)
+		if other.Appointment_Date.Before(end) && otherEnd.After(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// doctorLeaves returns the doctor's active leave overlapping [from, until).
+func doctorLeaves(ctx context.Context, doctorId string, from time.Time, until time.Time) ([]models.DoctorLeave, error) {
+	cursor, err := leaveCollection.Find(ctx, bson.M{
+		"doctor_id":  doctorId,
+		"status":     leaveActive,
+		"start_date": bson.M{"$lt": until},
+		"end_date":   bson.M{"$gt": from},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var leaves []models.DoctorLeave
+	err = cursor.All(ctx, &leaves)
+	return leaves, err
+}
+
+// holidayDates returns the facility-local dates in [from, until) the facility
+// is closed.
+func holidayDates(ctx context.Context, from time.Time, until time.Time) (map[string]bool, error) {
+	cursor, err := holidayCollection.Find(ctx, bson.M{
+		"date": bson.M{
+			"$gte": helper.InFacility(from).Format("2006-01-02"),
+			"$lte": helper.InFacility(until.Add(-time.Nanosecond)).Format("2006-01-02"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var holidays []models.Holiday
+	if err = cursor.All(ctx, &holidays); err != nil {
+		return nil, err
+	}
+
+	closed := map[string]bool{}
+	for _, holiday := range holidays {
+		closed[*holiday.Date] = true
+	}
+	return closed, nil
+}
+
+// unavailableReason explains why the doctor cannot be booked for
+// [start, end) because of leave or a facility holiday, or returns "".
+func unavailableReason(ctx context.Context, doctorId *string, start time.Time, end time.Time) (string, error) {
+	closed, err := holidayDates(ctx, start, end)
+	if err != nil {
+		return "", err
+	}
+	if len(closed) > 0 {
+		return "the facility is closed for a holiday on this date", nil
+	}
+
+	if doctorId == nil {
+		return "", nil
+	}
+	leaves, err := doctorLeaves(ctx, *doctorId, start, end)
+	if err != nil {
+		return "", err
+	}
+	if len(leaves) > 0 {
+		return "the doctor is on leave at this time", nil
+	}
+	return "", nil
+}
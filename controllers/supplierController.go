@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"context"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var supplierCollection *mongo.Collection = database.OpenCollection(database.Client, "supplier")
+var supplierPriceCollection *mongo.Collection = database.OpenCollection(database.Client, "supplierPrice")
+
+func CreateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var supplier models.Supplier
+		if err := c.BindJSON(&supplier); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(supplier); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := supplierCollection.CountDocuments(ctx, bson.M{"code": supplier.Code})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the code"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "a supplier with this code already exists"})
+			return
+		}
+
+		if supplier.Active == nil {
+			active := true
+			supplier.Active = &active
+		}
+		supplier.Created_at = helper.Now()
+		supplier.Updated_at = helper.Now()
+		supplier.ID = primitive.NewObjectID()
+		supplier.Supplier_id = supplier.ID.Hex()
+
+		if _, insertErr := supplierCollection.InsertOne(ctx, supplier); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "supplier was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, supplier)
+	}
+}
+
+// GetSuppliers lists suppliers, filtered by active.
+func GetSuppliers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if active := c.Query("active"); active != "" {
+			filter["active"] = active == "true"
+		}
+		cursor, err := supplierCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the suppliers"})
+			return
+		}
+		suppliers := []models.Supplier{}
+		if err = cursor.All(ctx, &suppliers); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the suppliers"})
+			return
+		}
+		c.JSON(http.StatusOK, suppliers)
+	}
+}
+
+func GetSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var supplier models.Supplier
+		if err := supplierCollection.FindOne(ctx, bson.M{"supplier_id": c.Param("supplier_id")}).Decode(&supplier); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "supplier not found"})
+			return
+		}
+		c.JSON(http.StatusOK, supplier)
+	}
+}
+
+func UpdateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var change models.SupplierChange
+		if err := c.BindJSON(&change); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(change); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+		if change.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: change.Name})
+		}
+		if change.Contact_name != nil {
+			updateObj = append(updateObj, bson.E{Key: "contact_name", Value: change.Contact_name})
+		}
+		if change.Email != nil {
+			updateObj = append(updateObj, bson.E{Key: "email", Value: change.Email})
+		}
+		if change.Phone != nil {
+			updateObj = append(updateObj, bson.E{Key: "phone", Value: change.Phone})
+		}
+		if change.Address != nil {
+			updateObj = append(updateObj, bson.E{Key: "address", Value: change.Address})
+		}
+		if change.Lead_time_days != nil {
+			updateObj = append(updateObj, bson.E{Key: "lead_time_days", Value: change.Lead_time_days})
+		}
+		if change.Active != nil {
+			updateObj = append(updateObj, bson.E{Key: "active", Value: change.Active})
+		}
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: helper.Now()})
+
+		var supplier models.Supplier
+		err := supplierCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"supplier_id": c.Param("supplier_id")},
+			bson.D{{Key: "$set", Value: updateObj}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&supplier)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "supplier not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "supplier update failed"})
+			return
+		}
+		c.JSON(http.StatusOK, supplier)
+	}
+}
+
+// GetSupplierPrices is the supplier's price history, newest first, filtered
+// by item_id.
+func GetSupplierPrices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"supplier_id": c.Param("supplier_id")}
+		if itemId := c.Query("item_id"); itemId != "" {
+			filter["item_id"] = itemId
+		}
+		cursor, err := supplierPriceCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"recorded_at": -1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the prices"})
+			return
+		}
+		prices := []models.SupplierPrice{}
+		if err = cursor.All(ctx, &prices); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the prices"})
+			return
+		}
+		c.JSON(http.StatusOK, prices)
+	}
+}
+
+// lastSupplierPrice is the price the supplier was last paid for the item,
+// and whether there is one.
+func lastSupplierPrice(ctx context.Context, supplierId string, itemId string) (float64, bool, error) {
+	var price models.SupplierPrice
+	err := supplierPriceCollection.FindOne(ctx,
+		bson.M{"supplier_id": supplierId, "item_id": itemId},
+		options.FindOne().SetSort(bson.M{"recorded_at": -1}),
+	).Decode(&price)
+	if err == mongo.ErrNoDocuments {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return price.Unit_price, true, nil
+}
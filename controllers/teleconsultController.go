@@ -0,0 +1,376 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	teleconsultOpen   = "OPEN"
+	teleconsultClosed = "CLOSED"
+)
+
+// maxSignalBytes bounds one signaling message; SDP offers are a few KB.
+const maxSignalBytes = 64 << 10
+
+// signalProtocol is the WebSocket subprotocol of the signaling endpoint.
+const signalProtocol = "hms-signal"
+
+var signalingHub = helper.NewSignalingHub()
+
+// OpenTeleconsult creates the consultation room of an appointment, or
+// reopens a closed one. Opening an open room returns it unchanged.
+func OpenTeleconsult() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		appointmentId := c.Param("appointment_id")
+		var appointment models.Appointment
+		if err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": appointmentId}).Decode(&appointment); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message:Appointment was not found"})
+			return
+		}
+		if appointment.Status != nil && *appointment.Status != appointmentScheduled {
+			c.JSON(http.StatusConflict, gin.H{"error": "the appointment is " + *appointment.Status})
+			return
+		}
+		if appointment.Doctor_id == nil || appointment.Patient_id == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a teleconsult needs both a doctor and a patient on the appointment"})
+			return
+		}
+		if appointmentType := appointmentTypeOf(ctx, appointment); appointmentType != nil && !appointmentType.Teleconsult {
+			c.JSON(http.StatusBadRequest, gin.H{"error": *appointmentType.Name + " is not a teleconsult appointment type"})
+			return
+		}
+
+		if appointment.Teleconsult != nil && appointment.Teleconsult.Status == teleconsultOpen {
+			c.JSON(http.StatusOK, appointment.Teleconsult)
+			return
+		}
+
+		room := appointment.Teleconsult
+		if room == nil {
+			room = &models.Teleconsult{Room_id: appointmentId, Attendance: []models.TeleconsultAttendance{}}
+		}
+		room.Status = teleconsultOpen
+		room.Opened_at = helper.Now()
+		room.Closed_at = nil
+
+		_, err := appointmentCollection.UpdateOne(ctx, bson.M{"appointment_id": appointmentId}, bson.M{
+			"$set": bson.M{"teleconsult": room, "updated_at": helper.Now()},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "consultation room was not opened"})
+			return
+		}
+		c.JSON(http.StatusOK, room)
+	}
+}
+
+// GetTeleconsult returns the room with who is connected right now.
+func GetTeleconsult() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		appointment, ok := teleconsultAppointment(ctx, c)
+		if !ok {
+			return
+		}
+
+		participants, waiting := signalingHub.Presence(appointment.Teleconsult.Room_id)
+		c.JSON(http.StatusOK, gin.H{
+			"room":         appointment.Teleconsult,
+			"participants": participants,
+			"waiting":      waiting,
+		})
+	}
+}
+
+// IssueJoinToken gives the signed-in user a short-lived token to join the
+// room. The role follows the session: the appointment's doctor, signed in
+// through DoctorLogin, joins as DOCTOR and its patient as PATIENT; nobody
+// else can join.
+func IssueJoinToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request models.JoinRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		appointment, ok := teleconsultAppointment(ctx, c)
+		if !ok {
+			return
+		}
+		if appointment.Teleconsult.Status != teleconsultOpen {
+			c.JSON(http.StatusConflict, gin.H{"error": "the consultation room is closed"})
+			return
+		}
+
+		uid := c.GetString("uid")
+		doctorId, isDoctor := signedInDoctor(c)
+		var role, participantId string
+		switch {
+		case isDoctor && appointment.Doctor_id != nil && doctorId == *appointment.Doctor_id:
+			role, participantId = helper.RoleDoctor, *appointment.Doctor_id
+		case !isDoctor && appointment.Patient_id != nil && uid == *appointment.Patient_id:
+			role, participantId = helper.RolePatient, *appointment.Patient_id
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the doctor and the patient of the appointment can join"})
+			return
+		}
+		if request.Role != "" && request.Role != role {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you can only join this consultation as " + role})
+			return
+		}
+
+		token, expiresAt, err := helper.GenerateJoinToken(appointment.Teleconsult.Room_id, role, participantId, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "join token was not created"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"join_token": token,
+			"expires_at": expiresAt.UTC(),
+			"signal_url": "/teleconsult/" + appointment.Teleconsult.Room_id + "/signal?join=" + token,
+		})
+	}
+}
+
+// CloseTeleconsult ends the consultation and disconnects everyone.
+func CloseTeleconsult() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		appointment, ok := teleconsultAppointment(ctx, c)
+		if !ok {
+			return
+		}
+		if appointment.Teleconsult.Status == teleconsultClosed {
+			c.JSON(http.StatusOK, appointment.Teleconsult)
+			return
+		}
+
+		now := helper.Now()
+		var updated models.Appointment
+		err := appointmentCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"appointment_id": appointment.Appointment_id},
+			bson.M{"$set": bson.M{
+				"teleconsult.status":                     teleconsultClosed,
+				"teleconsult.closed_at":                  now,
+				"teleconsult.attendance.$[open].left_at": now,
+				"updated_at":                             now,
+			}},
+			options.FindOneAndUpdate().
+				SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"open.left_at": nil}}}).
+				SetReturnDocument(options.After),
+		).Decode(&updated)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "consultation room was not closed"})
+			return
+		}
+
+		signalingHub.Close(appointment.Teleconsult.Room_id)
+		c.JSON(http.StatusOK, updated.Teleconsult)
+	}
+}
+
+// SignalTeleconsult is the WebSocket signaling endpoint. Browsers cannot set
+// headers on a WebSocket, so the session token may instead be offered as a
+// "token.<jwt>" subprotocol next to signalProtocol, which the server accepts;
+// it is checked exactly as Authentication checks the header, and must belong
+// to the account the join token was issued to. It is never read from the
+// URL, which ends up in access logs.
+func SignalTeleconsult() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionToken := c.Request.Header.Get("token")
+		if sessionToken == "" {
+			sessionToken = subprotocolToken(c.Request)
+		}
+		if sessionToken == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No Authorization header provided"})
+			return
+		}
+		session, msg := helper.ValidateToken(sessionToken)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		claims, err := helper.ValidateJoinToken(c.Query("join"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		roomId := c.Param("appointment_id")
+		if claims.Room != roomId || claims.Uid != session.Uid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "the join token is not for this room or account"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		appointment, ok := teleconsultAppointment(ctx, c)
+		cancel()
+		if !ok {
+			return
+		}
+		if appointment.Teleconsult.Status != teleconsultOpen {
+			c.JSON(http.StatusConflict, gin.H{"error": "the consultation room is closed"})
+			return
+		}
+
+		participant := helper.Participant{Id: claims.Participant_id, Role: claims.Role, Joined_at: helper.Now()}
+		server := websocket.Server{
+			Handshake: acceptSignalProtocol,
+			Handler: func(ws *websocket.Conn) {
+				ws.MaxPayloadBytes = maxSignalBytes
+				relaySignals(ws, roomId, participant)
+			},
+		}
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// subprotocolToken finds the session token offered as a "token.<jwt>"
+// WebSocket subprotocol.
+func subprotocolToken(req *http.Request) string {
+	for _, header := range req.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if token := strings.TrimPrefix(strings.TrimSpace(protocol), "token."); token != strings.TrimSpace(protocol) {
+				return token
+			}
+		}
+	}
+	return ""
+}
+
+// acceptSignalProtocol answers with signalProtocol when the client offered
+// subprotocols, so the token is never echoed back.
+func acceptSignalProtocol(config *websocket.Config, req *http.Request) error {
+	if len(config.Protocol) > 0 {
+		config.Protocol = []string{signalProtocol}
+	}
+	return nil
+}
+
+// relaySignals runs one participant's connection until either side closes it.
+func relaySignals(ws *websocket.Conn, roomId string, participant helper.Participant) {
+	peer, waiting := signalingHub.Join(roomId, participant)
+	recordAttendance(roomId, participant, waiting)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for message := range peer.Send {
+			if err := websocket.JSON.Send(ws, message); err != nil {
+				break
+			}
+		}
+		ws.Close()
+	}()
+
+	for {
+		var message helper.SignalMessage
+		if err := websocket.JSON.Receive(ws, &message); err != nil {
+			break
+		}
+
+		var err error
+		switch message.Type {
+		case helper.SignalOffer, helper.SignalAnswer, helper.SignalCandidate:
+			err = signalingHub.Relay(peer, message)
+		case helper.SignalAdmit:
+			if err = signalingHub.Admit(peer, message.To); err == nil {
+				recordAdmission(roomId, message.To)
+			}
+		default:
+			err = fmt.Errorf("unknown message type %q", message.Type)
+		}
+		if err != nil {
+			signalingHub.Reply(peer, helper.SignalMessage{Type: helper.SignalError, Error: err.Error()})
+		}
+	}
+
+	signalingHub.Leave(peer)
+	recordDeparture(roomId, participant)
+	<-done
+}
+
+// teleconsultAppointment loads the appointment of the request and writes the
+// response itself when it has no consultation room.
+func teleconsultAppointment(ctx context.Context, c *gin.Context) (models.Appointment, bool) {
+	var appointment models.Appointment
+	if err := appointmentCollection.FindOne(ctx, bson.M{"appointment_id": c.Param("appointment_id")}).Decode(&appointment); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message:Appointment was not found"})
+		return appointment, false
+	}
+	if appointment.Teleconsult == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "the appointment has no consultation room"})
+		return appointment, false
+	}
+	return appointment, true
+}
+
+// recordAttendance, recordAdmission and recordDeparture keep the join and
+// leave times of every connection on the appointment. The room id is the
+// appointment id.
+func recordAttendance(roomId string, participant helper.Participant, waiting bool) {
+	entry := models.TeleconsultAttendance{
+		Participant_id: participant.Id,
+		Role:           participant.Role,
+		Joined_at:      participant.Joined_at,
+	}
+	if !waiting {
+		entry.Admitted_at = &participant.Joined_at
+	}
+	updateAttendance(roomId, bson.M{"$push": bson.M{"teleconsult.attendance": entry}}, nil)
+}
+
+func recordAdmission(roomId string, participantId string) {
+	updateAttendance(roomId,
+		bson.M{"$set": bson.M{"teleconsult.attendance.$[entry].admitted_at": helper.Now()}},
+		bson.M{"entry.participant_id": participantId, "entry.admitted_at": nil, "entry.left_at": nil},
+	)
+}
+
+func recordDeparture(roomId string, participant helper.Participant) {
+	updateAttendance(roomId,
+		bson.M{"$set": bson.M{"teleconsult.attendance.$[entry].left_at": helper.Now()}},
+		bson.M{"entry.participant_id": participant.Id, "entry.joined_at": participant.Joined_at, "entry.left_at": nil},
+	)
+}
+
+func updateAttendance(roomId string, update bson.M, entryFilter bson.M) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Update()
+	if entryFilter != nil {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{entryFilter}})
+	}
+	if _, err := appointmentCollection.UpdateOne(ctx, bson.M{"appointment_id": roomId}, update, opts); err != nil {
+		log.Printf("teleconsult %s: attendance not recorded: %v", roomId, err)
+	}
+}
@@ -0,0 +1,390 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var codeSetCollection *mongo.Collection = database.OpenCollection(database.Client, "codeSet")
+var conceptCollection *mongo.Collection = database.OpenCollection(database.Client, "concept")
+
+// conceptBatch is how many codes an import writes at once.
+const conceptBatch = 1000
+
+// codeQuery tells a code typed into the search from words: a letter and a
+// digit for ICD-10, digits only for SNOMED.
+var codeQuery = regexp.MustCompile(`^([A-Za-z][0-9][0-9A-Za-z.]*|[0-9]+)$`)
+
+// errCodeSetLoaded refuses to load a version again: diagnoses recorded
+// against it point at its codes, so a changed file needs a new version.
+var errCodeSetLoaded = errors.New("this version of the code system is already loaded, import the file as a new version")
+
+// TerminologyImport reports what a code set import did. Updated and removed
+// codes are those left by an earlier import of the version that did not
+// finish.
+type TerminologyImport struct {
+	Code_set models.CodeSet       `json:"code_set"`
+	Imported int                  `json:"imported"`
+	Updated  int                  `json:"updated"`
+	Removed  int64                `json:"removed"`
+	Rejected []FormularyRejection `json:"rejected"`
+}
+
+// UploadTerminology imports a code set version sent as described by
+// datasetUpload. The system and version are query parameters; the version
+// becomes the current one unless activate=false.
+func UploadTerminology() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 300*time.Second)
+		defer cancel()
+
+		system := strings.ToUpper(c.Query("system"))
+		version := strings.TrimSpace(c.Query("version"))
+		if system != helper.ICD10 && system != helper.SNOMED {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "system must be ICD10 or SNOMED"})
+			return
+		}
+		if version == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "version is required"})
+			return
+		}
+		activate := c.DefaultQuery("activate", "true") != "false"
+
+		body, format, ok := datasetUpload(c)
+		if !ok {
+			return
+		}
+		defer body.Close()
+		if value := c.Query("format"); value != "" {
+			format = value
+		}
+
+		records, err := helper.ParseTerminology(body, helper.TerminologyFormat(system, format))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := ImportTerminology(ctx, system, version, "upload", records, activate)
+		if errors.Is(err, errCodeSetLoaded) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "code set import failed: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// ImportTerminology loads the records as a new version of a code system. A
+// version already loaded is refused, since recorded diagnoses reference its
+// codes. It is used by the upload endpoint and the terminologyimport command.
+func ImportTerminology(ctx context.Context, system string, version string, source string, records []helper.TerminologyRecord, activate bool) (TerminologyImport, error) {
+	result := TerminologyImport{Rejected: []FormularyRejection{}}
+
+	_, err := conceptCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "system", Value: 1}, {Key: "version", Value: 1}, {Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "system", Value: 1}, {Key: "version", Value: 1}, {Key: "code_key", Value: 1}}},
+		{Keys: bson.D{{Key: "system", Value: 1}, {Key: "version", Value: 1}, {Key: "search_words", Value: 1}}},
+	})
+	if err != nil {
+		return result, err
+	}
+	_, err = codeSetCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "system", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return result, err
+	}
+	loaded, err := codeSetCollection.CountDocuments(ctx, bson.M{"system": system, "version": version})
+	if err != nil {
+		return result, err
+	}
+	if loaded > 0 {
+		return result, errCodeSetLoaded
+	}
+
+	// a code listed twice takes its last display
+	codes := []string{}
+	displays := map[string]string{}
+	for _, record := range records {
+		code, msg := helper.NormalizeCode(system, record.Code)
+		if msg == "" && strings.TrimSpace(record.Display) == "" {
+			msg = "the code has no display"
+		}
+		if msg != "" {
+			result.Rejected = append(result.Rejected, FormularyRejection{Line: record.Line, Code: record.Code, Error: msg})
+			continue
+		}
+		if _, ok := displays[code]; !ok {
+			codes = append(codes, code)
+		}
+		displays[code] = strings.TrimSpace(record.Display)
+	}
+	if len(codes) == 0 {
+		return result, fmt.Errorf("the file has no valid codes")
+	}
+
+	now := helper.Now()
+	importId := primitive.NewObjectID().Hex()
+	for start := 0; start < len(codes); start += conceptBatch {
+		end := start + conceptBatch
+		if end > len(codes) {
+			end = len(codes)
+		}
+		writes := []mongo.WriteModel{}
+		for _, code := range codes[start:end] {
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"system": system, "version": version, "code": code}).
+				SetUpdate(bson.M{
+					"$set": bson.M{
+						"display":      displays[code],
+						"code_key":     helper.CodeKey(code),
+						"search_words": helper.SearchWords(displays[code]),
+						"import_id":    importId,
+						"updated_at":   now,
+					},
+					"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+				}).
+				SetUpsert(true))
+		}
+		written, err := conceptCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return result, err
+		}
+		result.Imported += int(written.UpsertedCount)
+		result.Updated += int(written.MatchedCount)
+	}
+
+	// only an interrupted import of this version can have left codes behind
+	removed, err := conceptCollection.DeleteMany(ctx, bson.M{"system": system, "version": version, "import_id": bson.M{"$ne": importId}})
+	if err != nil {
+		return result, err
+	}
+	result.Removed = removed.DeletedCount
+
+	count, err := conceptCollection.CountDocuments(ctx, bson.M{"system": system, "version": version})
+	if err != nil {
+		return result, err
+	}
+	err = codeSetCollection.FindOneAndUpdate(ctx,
+		bson.M{"system": system, "version": version},
+		bson.M{
+			"$set": bson.M{"source": source, "concept_count": count, "updated_at": now},
+			"$setOnInsert": bson.M{
+				"_id":         primitive.NewObjectID(),
+				"code_set_id": primitive.NewObjectID().Hex(),
+				"current":     false,
+				"created_at":  now,
+			},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&result.Code_set)
+	if err != nil {
+		return result, err
+	}
+	if activate {
+		if err := activateCodeSet(ctx, &result.Code_set); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// GetCodeSets lists the loaded code set versions, newest first; system
+// narrows them to one code system.
+func GetCodeSets() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if system := c.Query("system"); system != "" {
+			filter["system"] = strings.ToUpper(system)
+		}
+		cursor, err := codeSetCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "system", Value: 1}, {Key: "created_at", Value: -1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the code sets"})
+			return
+		}
+		codeSets := []models.CodeSet{}
+		if err = cursor.All(ctx, &codeSets); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the code sets"})
+			return
+		}
+		c.JSON(http.StatusOK, codeSets)
+	}
+}
+
+// ActivateCodeSet makes a version the one new diagnoses are coded against,
+// such as going back to an earlier version.
+func ActivateCodeSet() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var codeSet models.CodeSet
+		if err := codeSetCollection.FindOne(ctx, bson.M{"code_set_id": c.Param("code_set_id")}).Decode(&codeSet); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "code set not found"})
+			return
+		}
+		if err := activateCodeSet(ctx, &codeSet); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the code set was not activated"})
+			return
+		}
+		c.JSON(http.StatusOK, codeSet)
+	}
+}
+
+func activateCodeSet(ctx context.Context, codeSet *models.CodeSet) error {
+	now := helper.Now()
+	_, err := codeSetCollection.UpdateMany(ctx,
+		bson.M{"system": codeSet.System, "code_set_id": bson.M{"$ne": codeSet.Code_set_id}},
+		bson.M{"$set": bson.M{"current": false, "updated_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = codeSetCollection.UpdateOne(ctx,
+		bson.M{"code_set_id": codeSet.Code_set_id},
+		bson.M{"$set": bson.M{"current": true, "updated_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	codeSet.Current = true
+	codeSet.Updated_at = now
+	return nil
+}
+
+// SearchConcepts finds codes of a code set version, the current one unless
+// version is given. A query that looks like a code matches codes starting
+// with it, dot or not; otherwise every word must start a word of the
+// display.
+func SearchConcepts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 || limit > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		codeSet, status, msg := findCodeSet(ctx, strings.ToUpper(c.DefaultQuery("system", helper.ICD10)), c.Query("version"))
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{"system": codeSet.System, "version": codeSet.Version}
+		if codeQuery.MatchString(query) {
+			filter["code_key"] = bson.M{"$regex": "^" + regexp.QuoteMeta(helper.CodeKey(query))}
+		} else {
+			words := helper.SearchWords(query)
+			if len(words) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "q has no words to search for"})
+				return
+			}
+			patterns := []primitive.Regex{}
+			for _, word := range words {
+				patterns = append(patterns, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(word)})
+			}
+			filter["search_words"] = bson.M{"$all": patterns}
+		}
+
+		cursor, err := conceptCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"code_key": 1}).SetLimit(int64(limit)))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while searching the codes"})
+			return
+		}
+		concepts := []models.Concept{}
+		if err = cursor.All(ctx, &concepts); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while searching the codes"})
+			return
+		}
+		c.JSON(http.StatusOK, concepts)
+	}
+}
+
+// GetConcept looks a code up in a code set version, the current one unless
+// version is given.
+func GetConcept() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		concept, status, msg := findConcept(ctx, strings.ToUpper(c.Param("system")), c.Query("version"), c.Param("code"))
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+		c.JSON(http.StatusOK, concept)
+	}
+}
+
+// findCodeSet reads a version of a code system, or its current version when
+// none is given. A non-empty message is the problem, with its status.
+func findCodeSet(ctx context.Context, system string, version string) (models.CodeSet, int, string) {
+	var codeSet models.CodeSet
+	filter := bson.M{"system": system, "current": true}
+	if version != "" {
+		filter = bson.M{"system": system, "version": version}
+	}
+	err := codeSetCollection.FindOne(ctx, filter).Decode(&codeSet)
+	if err == mongo.ErrNoDocuments {
+		if version != "" {
+			return codeSet, http.StatusNotFound, fmt.Sprintf("version %s of %s is not loaded", version, system)
+		}
+		return codeSet, http.StatusNotFound, fmt.Sprintf("no version of %s is loaded", system)
+	}
+	if err != nil {
+		return codeSet, http.StatusInternalServerError, "error occured while reading the code sets"
+	}
+	return codeSet, 0, ""
+}
+
+// findConcept reads a code of a code set version, the current one unless
+// version is given. A non-empty message is the problem, with its status.
+func findConcept(ctx context.Context, system string, version string, code string) (models.Concept, int, string) {
+	var concept models.Concept
+	code, msg := helper.NormalizeCode(system, code)
+	if msg != "" {
+		return concept, http.StatusBadRequest, msg
+	}
+	codeSet, status, msg := findCodeSet(ctx, system, version)
+	if msg != "" {
+		return concept, status, msg
+	}
+	err := conceptCollection.FindOne(ctx, bson.M{"system": system, "version": codeSet.Version, "code": code}).Decode(&concept)
+	if err == mongo.ErrNoDocuments {
+		return concept, http.StatusNotFound, fmt.Sprintf("%s is not a code of %s version %s", code, system, codeSet.Version)
+	}
+	if err != nil {
+		return concept, http.StatusInternalServerError, "error occured while reading the codes"
+	}
+	return concept, 0, ""
+}
@@ -0,0 +1,356 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"golang-hospital-management/database"
+	helper "golang-hospital-management/helpers"
+	"golang-hospital-management/models"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var vitalCollection *mongo.Collection = database.OpenCollection(database.Client, "vital")
+
+// vitalTypes is the order series are charted in.
+var vitalTypes = []string{
+	helper.VitalSystolic, helper.VitalDiastolic, helper.VitalPulse, helper.VitalRespiratory,
+	helper.VitalTemperature, helper.VitalSpO2, helper.VitalWeight, helper.VitalHeight, helper.VitalBMI,
+}
+
+// VitalsChart is the answer to a vitals query: one series per type, either
+// raw points or, when an interval is asked for, min/max/avg buckets.
+type VitalsChart struct {
+	Patient_id string        `json:"patient_id"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Interval   string        `json:"interval"`
+	Series     []VitalSeries `json:"series"`
+}
+
+// VitalSeries is the readings of one type. Reference is the range for the
+// patient's current age, for drawing the normal band.
+type VitalSeries struct {
+	Type      string                 `json:"type"`
+	Unit      string                 `json:"unit"`
+	Reference *helper.ReferenceRange `json:"reference"`
+	Points    []VitalPoint           `json:"points,omitempty"`
+	Buckets   []VitalBucket          `json:"buckets,omitempty"`
+}
+
+type VitalPoint struct {
+	Measured_at time.Time `json:"measured_at"`
+	Value       float64   `json:"value"`
+	Flag        *string   `json:"flag"`
+}
+
+// VitalBucket aggregates the readings of one interval; Abnormal counts those
+// not flagged NORMAL.
+type VitalBucket struct {
+	Start    time.Time `json:"start"`
+	Count    int       `json:"count"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Avg      float64   `json:"avg"`
+	Abnormal int       `json:"abnormal"`
+}
+
+// RecordVitals stores a set of readings for the patient. Each is converted to
+// its canonical unit and flagged against the range for the patient's age when
+// measured; adult ranges apply when the date of birth is not known.
+func RecordVitals() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var entry models.VitalsEntry
+		if err := c.BindJSON(&entry); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if validationErr := validate.Struct(entry); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var patient models.Patient
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": c.Param("patient_id")}).Decode(&patient); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message:Patient was not found"})
+			return
+		}
+		if entry.Encounter_id != nil {
+			count, err := encounterCollection.CountDocuments(ctx, bson.M{"encounter_id": entry.Encounter_id, "patient_id": patient.Patient_id})
+			if err != nil || count == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "encounter not found for this patient"})
+				return
+			}
+		}
+
+		measuredAt := helper.Now()
+		if entry.Measured_at != nil {
+			measuredAt = entry.Measured_at.UTC().Truncate(time.Second)
+		}
+		if measuredAt.After(helper.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "measured_at cannot be in the future"})
+			return
+		}
+		age := patientAge(patient, measuredAt)
+
+		setId := primitive.NewObjectID().Hex()
+		values := map[string]float64{}
+		var vitals []models.Vital
+		for _, reading := range entry.Readings {
+			if _, seen := values[*reading.Type]; seen {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is given more than once", *reading.Type)})
+				return
+			}
+			unit := ""
+			if reading.Unit != nil {
+				unit = *reading.Unit
+			}
+			value, err := helper.NormalizeVital(*reading.Type, *reading.Value, unit)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			values[*reading.Type] = value
+
+			vital := newVital(*reading.Type, value, age)
+			if unit != "" && unit != helper.VitalUnits[*reading.Type] {
+				vital.Original_value = reading.Value
+				vital.Original_unit = reading.Unit
+			}
+			vitals = append(vitals, vital)
+		}
+
+		systolic, hasSystolic := values[helper.VitalSystolic]
+		diastolic, hasDiastolic := values[helper.VitalDiastolic]
+		if hasSystolic != hasDiastolic {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "blood pressure needs both the systolic and the diastolic reading"})
+			return
+		}
+		if hasSystolic && systolic <= diastolic {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "systolic pressure must be above diastolic"})
+			return
+		}
+
+		if weight, ok := values[helper.VitalWeight]; ok {
+			height, hasHeight := values[helper.VitalHeight]
+			if !hasHeight {
+				var latest models.Vital
+				err := vitalCollection.FindOne(ctx,
+					bson.M{"patient_id": patient.Patient_id, "type": helper.VitalHeight, "measured_at": bson.M{"$lte": measuredAt}},
+					options.FindOne().SetSort(bson.M{"measured_at": -1}),
+				).Decode(&latest)
+				if err != nil && err != mongo.ErrNoDocuments {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the height"})
+					return
+				}
+				height, hasHeight = latest.Value, err == nil
+			}
+			if hasHeight {
+				vitals = append(vitals, newVital(helper.VitalBMI, helper.BMI(weight, height), age))
+			}
+		}
+
+		for i := range vitals {
+			vitals[i].Encounter_id = entry.Encounter_id
+		}
+		if insertErr := insertVitals(ctx, vitals, patient.Patient_id, setId, measuredAt, c.GetString("uid")); insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "vitals were not recorded"})
+			return
+		}
+		c.JSON(http.StatusOK, vitals)
+	}
+}
+
+// GetPatientVitals returns the patient's vitals as chart series. type is a
+// comma-separated list of types (all by default); from and to are RFC 3339
+// times or facility dates, a date "to" covering the whole day, and default to
+// the last 30 days. interval (hour, day or week) aggregates the readings.
+func GetPatientVitals() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var patient models.Patient
+		if err := patientCollection.FindOne(ctx, bson.M{"patient_id": c.Param("patient_id")}).Decode(&patient); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message:Patient was not found"})
+			return
+		}
+
+		to := helper.Now()
+		if value := c.Query("to"); value != "" {
+			parsed, err := vitalsTime(value, true)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid to %q", value)})
+				return
+			}
+			to = parsed
+		}
+		from := to.AddDate(0, 0, -30)
+		if value := c.Query("from"); value != "" {
+			parsed, err := vitalsTime(value, false)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid from %q", value)})
+				return
+			}
+			from = parsed
+		}
+		if !to.After(from) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from"})
+			return
+		}
+
+		interval := c.Query("interval")
+		if interval != "" {
+			if _, err := helper.IntervalStart(from, interval); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		types := vitalTypes
+		if value := c.Query("type"); value != "" {
+			types = strings.Split(strings.ToUpper(value), ",")
+			for _, vitalType := range types {
+				if _, ok := helper.VitalUnits[vitalType]; !ok {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown vital type %q", vitalType)})
+					return
+				}
+			}
+		}
+
+		filter := bson.M{
+			"patient_id":  patient.Patient_id,
+			"type":        bson.M{"$in": types},
+			"measured_at": bson.M{"$gte": from, "$lt": to},
+		}
+		cursor, err := vitalCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"measured_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the vitals"})
+			return
+		}
+		var vitals []models.Vital
+		if err = cursor.All(ctx, &vitals); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing the vitals"})
+			return
+		}
+
+		age := patientAge(patient, helper.Now())
+		chart := VitalsChart{Patient_id: patient.Patient_id, From: from, To: to, Interval: interval, Series: []VitalSeries{}}
+		for _, vitalType := range types {
+			series := VitalSeries{Type: vitalType, Unit: helper.VitalUnits[vitalType], Points: []VitalPoint{}}
+			if reference, ok := helper.VitalReference(vitalType, age); ok {
+				series.Reference = &reference
+			}
+			for _, vital := range vitals {
+				if vital.Type == vitalType {
+					series.Points = append(series.Points, VitalPoint{Measured_at: vital.Measured_at, Value: vital.Value, Flag: vital.Flag})
+				}
+			}
+			if interval != "" {
+				series.Buckets = bucketVitals(series.Points, interval)
+				series.Points = nil
+			}
+			chart.Series = append(chart.Series, series)
+		}
+		c.JSON(http.StatusOK, chart)
+	}
+}
+
+// newVital is a reading in canonical units, flagged against the range for
+// the age when there is one.
+func newVital(vitalType string, value float64, age float64) models.Vital {
+	vital := models.Vital{Type: vitalType, Value: value, Unit: helper.VitalUnits[vitalType]}
+	if reference, ok := helper.VitalReference(vitalType, age); ok {
+		flag := helper.VitalFlag(value, reference)
+		vital.Flag = &flag
+		vital.Reference_low = &reference.Low
+		vital.Reference_high = &reference.High
+	}
+	return vital
+}
+
+// insertVitals stores the readings of one set.
+func insertVitals(ctx context.Context, vitals []models.Vital, patientId string, setId string, measuredAt time.Time, recordedBy string) error {
+	documents := make([]interface{}, len(vitals))
+	for i := range vitals {
+		vitals[i].ID = primitive.NewObjectID()
+		vitals[i].Vital_id = vitals[i].ID.Hex()
+		vitals[i].Set_id = setId
+		vitals[i].Patient_id = patientId
+		vitals[i].Measured_at = measuredAt
+		vitals[i].Recorded_by = recordedBy
+		vitals[i].Created_at = helper.Now()
+		documents[i] = vitals[i]
+	}
+	_, err := vitalCollection.InsertMany(ctx, documents)
+	return err
+}
+
+// patientAge is the patient's age in years at a time, or -1 when the date of
+// birth is not known.
+func patientAge(patient models.Patient, at time.Time) float64 {
+	if patient.Date_of_birth == nil {
+		return -1
+	}
+	return helper.AgeYears(*patient.Date_of_birth, at)
+}
+
+// bucketVitals aggregates points, sorted by time, into the intervals they
+// fall in. Empty intervals are left out.
+func bucketVitals(points []VitalPoint, interval string) []VitalBucket {
+	buckets := map[time.Time]*VitalBucket{}
+	var starts []time.Time
+	for _, point := range points {
+		start, _ := helper.IntervalStart(point.Measured_at, interval)
+		bucket, ok := buckets[start]
+		if !ok {
+			bucket = &VitalBucket{Start: start, Min: point.Value, Max: point.Value}
+			buckets[start] = bucket
+			starts = append(starts, start)
+		}
+		bucket.Count++
+		bucket.Min = math.Min(bucket.Min, point.Value)
+		bucket.Max = math.Max(bucket.Max, point.Value)
+		bucket.Avg += point.Value
+		if point.Flag != nil && *point.Flag != helper.FlagNormal {
+			bucket.Abnormal++
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	result := []VitalBucket{}
+	for _, start := range starts {
+		bucket := buckets[start]
+		bucket.Avg = math.Round(bucket.Avg/float64(bucket.Count)*100) / 100
+		result = append(result, *bucket)
+	}
+	return result
+}
+
+// vitalsTime reads an RFC 3339 time or a facility date. A date read as the
+// end of a range covers the whole day.
+func vitalsTime(value string, end bool) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+	parsed, err := time.ParseInLocation("2006-01-02", value, helper.FacilityLocation())
+	if err != nil {
+		return parsed, err
+	}
+	if end {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	return parsed.UTC(), nil
+}
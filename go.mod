@@ -9,6 +9,7 @@ require (
 	github.com/swaggo/swag v1.8.8
 	go.mongodb.org/mongo-driver v1.11.0
 	golang.org/x/crypto v0.3.0
+	golang.org/x/net v0.2.0
 )
 
 require (
@@ -42,7 +43,6 @@ require (
 	github.com/xdg-go/scram v1.1.1 // indirect
 	github.com/xdg-go/stringprep v1.0.3 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
-	golang.org/x/net v0.2.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.3.0 // indirect
 	golang.org/x/text v0.5.0 // indirect
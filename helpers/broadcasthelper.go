@@ -0,0 +1,48 @@
+package helper
+
+import "sync"
+
+// Broadcaster fans messages out to in-process subscribers by topic. Slow
+// subscribers miss messages rather than blocking the publisher.
+type Broadcaster struct {
+	mu   sync.Mutex
+	subs map[string]map[chan []byte]struct{}
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subs: map[string]map[chan []byte]struct{}{}}
+}
+
+// Subscribe returns a channel receiving the topic's messages and a function
+// that must be called to unsubscribe.
+func (b *Broadcaster) Subscribe(topic string) (chan []byte, func()) {
+	ch := make(chan []byte, 8)
+
+	b.mu.Lock()
+	if b.subs[topic] == nil {
+		b.subs[topic] = map[chan []byte]struct{}{}
+	}
+	b.subs[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[topic], ch)
+		if len(b.subs[topic]) == 0 {
+			delete(b.subs, topic)
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *Broadcaster) Publish(topic string, message []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[topic] {
+		select {
+		case ch <- message:
+		default:
+		}
+	}
+}
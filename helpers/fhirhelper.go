@@ -0,0 +1,76 @@
+package helper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// fhirPrefixes are the comparators a FHIR date search value may start with.
+var fhirPrefixes = []string{"eq", "ne", "gt", "lt", "ge", "le", "sa", "eb"}
+
+// FhirPrefix splits the comparator off a search value; without one it is
+// "eq".
+func FhirPrefix(value string) (string, string) {
+	for _, prefix := range fhirPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return prefix, value[len(prefix):]
+		}
+	}
+	return "eq", value
+}
+
+// ParseFhirDate reads a FHIR date or dateTime and returns the span its
+// precision covers, end exclusive: 2024 is the year, 2024-03-01 the day and
+// a full dateTime its second. Values without an offset are facility time.
+func ParseFhirDate(value string) (time.Time, time.Time, error) {
+	loc := FacilityLocation()
+	switch len(value) {
+	case 4:
+		start, err := time.ParseInLocation("2006", value, loc)
+		return start.UTC(), start.AddDate(1, 0, 0).UTC(), fhirDateError(value, err)
+	case 7:
+		start, err := time.ParseInLocation("2006-01", value, loc)
+		return start.UTC(), start.AddDate(0, 1, 0).UTC(), fhirDateError(value, err)
+	case 10:
+		start, err := time.ParseInLocation("2006-01-02", value, loc)
+		return start.UTC(), start.AddDate(0, 0, 1).UTC(), fhirDateError(value, err)
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00"} {
+		if start, err := time.Parse(layout, value); err == nil {
+			step := time.Second
+			if layout != time.RFC3339Nano {
+				step = time.Minute
+			}
+			start = start.Truncate(step)
+			return start.UTC(), start.Add(step).UTC(), nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if start, err := time.ParseInLocation(layout, value, loc); err == nil {
+			step := time.Second
+			if len(value) == 16 {
+				step = time.Minute
+			}
+			return start.UTC(), start.Add(step).UTC(), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fhirDateError(value, fmt.Errorf("unknown layout"))
+}
+
+func fhirDateError(value string, err error) error {
+	if err != nil {
+		return fmt.Errorf("invalid date %q", value)
+	}
+	return nil
+}
+
+// FhirInstant writes a time as a FHIR instant in facility time.
+func FhirInstant(t time.Time) string {
+	return InFacility(t).Format(time.RFC3339)
+}
+
+// FhirDate writes the facility-time day of a time as a FHIR date.
+func FhirDate(t time.Time) string {
+	return InFacility(t).Format("2006-01-02")
+}
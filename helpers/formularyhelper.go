@@ -0,0 +1,253 @@
+package helper
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FormularyRecord is one drug of a formulary dataset. In CSV the header names
+// the columns by their JSON names and list columns separate values with "|".
+type FormularyRecord struct {
+	Line         int      `json:"-"`
+	Code         string   `json:"code"`
+	Generic_name string   `json:"generic_name"`
+	Brand_names  []string `json:"brand_names"`
+	Atc_code     string   `json:"atc_code"`
+	Forms        []string `json:"forms"`
+	Strengths    []string `json:"strengths"`
+	Schedule     string   `json:"schedule"`
+}
+
+var formularyColumns = []string{"code", "generic_name", "brand_names", "atc_code", "forms", "strengths", "schedule"}
+
+// ParseFormulary reads a dataset in the given format, "csv" or "json" (an
+// array of records).
+func ParseFormulary(r io.Reader, format string) ([]FormularyRecord, error) {
+	switch strings.ToLower(format) {
+	case "csv":
+		return parseFormularyCSV(r)
+	case "json":
+		var records []FormularyRecord
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, fmt.Errorf("invalid formulary JSON: %v", err)
+		}
+		for i := range records {
+			records[i].Line = i + 1
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("unknown formulary format %q, expected csv or json", format)
+}
+
+func parseFormularyCSV(r io.Reader) ([]FormularyRecord, error) {
+	rows, err := readCSVDataset(r, "formulary", formularyColumns, []string{"code", "generic_name", "forms", "strengths"})
+	if err != nil {
+		return nil, err
+	}
+	var records []FormularyRecord
+	for _, row := range rows {
+		records = append(records, FormularyRecord{
+			Line:         row.Line,
+			Code:         row.Fields["code"],
+			Generic_name: row.Fields["generic_name"],
+			Brand_names:  splitList(row.Fields["brand_names"]),
+			Atc_code:     row.Fields["atc_code"],
+			Forms:        splitList(strings.ToUpper(row.Fields["forms"])),
+			Strengths:    splitList(row.Fields["strengths"]),
+			Schedule:     strings.ToUpper(row.Fields["schedule"]),
+		})
+	}
+	return records, nil
+}
+
+// InteractionRecord is one row of an interaction table. Each side names a
+// formulary code, or a whole ATC class when written "atc:" and a code prefix.
+type InteractionRecord struct {
+	Line        int    `json:"-"`
+	Drug_a      string `json:"drug_a"`
+	Drug_b      string `json:"drug_b"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+	Management  string `json:"management"`
+}
+
+var interactionColumns = []string{"drug_a", "drug_b", "severity", "description", "management"}
+
+// ParseInteractions reads an interaction table in the given format, "csv" or
+// "json" (an array of records).
+func ParseInteractions(r io.Reader, format string) ([]InteractionRecord, error) {
+	var records []InteractionRecord
+	switch strings.ToLower(format) {
+	case "csv":
+		rows, err := readCSVDataset(r, "interaction", interactionColumns, []string{"drug_a", "drug_b", "severity", "description"})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			records = append(records, InteractionRecord{
+				Line:        row.Line,
+				Drug_a:      row.Fields["drug_a"],
+				Drug_b:      row.Fields["drug_b"],
+				Severity:    row.Fields["severity"],
+				Description: row.Fields["description"],
+				Management:  row.Fields["management"],
+			})
+		}
+	case "json":
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, fmt.Errorf("invalid interaction JSON: %v", err)
+		}
+		for i := range records {
+			records[i].Line = i + 1
+		}
+	default:
+		return nil, fmt.Errorf("unknown interaction format %q, expected csv or json", format)
+	}
+	for i := range records {
+		records[i].Drug_a = InteractionKey(records[i].Drug_a)
+		records[i].Drug_b = InteractionKey(records[i].Drug_b)
+		records[i].Severity = strings.ToUpper(strings.TrimSpace(records[i].Severity))
+	}
+	return records, nil
+}
+
+// InteractionKey is the stored form of one side of an interaction: formulary
+// codes as they are, ATC classes as "atc:" and the upper-case prefix.
+func InteractionKey(side string) string {
+	side = strings.TrimSpace(side)
+	if len(side) > 4 && strings.EqualFold(side[:4], "atc:") {
+		return "atc:" + strings.ToUpper(strings.TrimSpace(side[4:]))
+	}
+	return side
+}
+
+// DrugKeys lists every interaction key a drug answers to: its code and each
+// level of its ATC code.
+func DrugKeys(code string, atc string) []string {
+	keys := []string{code}
+	atc = strings.ToUpper(atc)
+	for _, length := range []int{1, 3, 4, 5, 7} {
+		if len(atc) >= length {
+			keys = append(keys, "atc:"+atc[:length])
+		}
+	}
+	return keys
+}
+
+type csvRow struct {
+	Line   int
+	Fields map[string]string
+}
+
+// readCSVDataset reads a CSV file whose header names the columns, checking
+// the required ones are present.
+func readCSVDataset(r io.Reader, name string, columns []string, required []string) ([]csvRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s CSV: %v", name, err)
+	}
+	index := map[string]int{}
+	for i, column := range header {
+		index[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	for _, column := range required {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("%s CSV has no %q column; columns are %s", name, column, strings.Join(columns, ","))
+		}
+	}
+
+	var rows []csvRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s CSV: %v", name, err)
+		}
+		line, _ := reader.FieldPos(0)
+		row := csvRow{Line: line, Fields: map[string]string{}}
+		for _, column := range columns {
+			if i, ok := index[column]; ok && i < len(record) {
+				row.Fields[column] = strings.TrimSpace(record[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// NormalizeStrength makes "500 mg", "500mg" and "500 MG" compare equal.
+func NormalizeStrength(strength string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strength), ""))
+}
+
+// SearchName is the form drug names are matched in.
+func SearchName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// PrefixDistance is the smallest edit distance between query and the start
+// of any word of name, so "amoxycil" is one edit from "amoxicillin".
+func PrefixDistance(query string, name string) int {
+	q := []rune(query)
+	best := len(q)
+	for _, word := range strings.Fields(name) {
+		prefix := []rune(word)
+		if len(prefix) > len(q) {
+			prefix = prefix[:len(q)]
+		}
+		if d := editDistance(q, prefix); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance, counting a swap of neighbouring
+// letters as one edit since that is the commonest typo.
+func editDistance(a []rune, b []rune) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			rows[i][j] = rows[i-1][j] + 1
+			if d := rows[i][j-1] + 1; d < rows[i][j] {
+				rows[i][j] = d
+			}
+			if d := rows[i-1][j-1] + cost; d < rows[i][j] {
+				rows[i][j] = d
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && rows[i-2][j-2]+1 < rows[i][j] {
+				rows[i][j] = rows[i-2][j-2] + 1
+			}
+		}
+	}
+	return rows[len(a)][len(b)]
+}
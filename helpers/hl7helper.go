@@ -0,0 +1,382 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MLLP frames an HL7 v2 message between a start block and an end block
+// followed by a carriage return.
+const (
+	mllpStart = 0x0b
+	mllpEnd   = 0x1c
+	mllpCR    = 0x0d
+)
+
+// maxHL7Message bounds a single frame, so a peer that never sends the end
+// block cannot exhaust memory.
+const maxHL7Message = 4 << 20
+
+// HL7Message is a parsed HL7 v2 message. Fields are kept raw, still escaped;
+// the accessors split and unescape them.
+type HL7Message struct {
+	Segments []HL7Segment
+	field    byte
+	comp     byte
+	rep      byte
+	escape   byte
+	sub      byte
+}
+
+// HL7Segment is one segment. Fields[0] is the segment name, so Fields[n] is
+// field n as numbered by the standard; for MSH, Fields[1] is the field
+// separator itself.
+type HL7Segment struct {
+	Fields []string
+	msg    *HL7Message
+}
+
+// ParseHL7 parses a message. It is lenient about what vendors get wrong:
+// MLLP framing left on, \n or \r\n between segments, blank lines, a byte
+// order mark, lowercase segment names and missing trailing fields.
+func ParseHL7(raw []byte) (*HL7Message, error) {
+	raw = bytes.Trim(raw, "\x0b\x1c\r\n \t")
+	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+	start := bytes.Index(raw, []byte("MSH"))
+	if start < 0 {
+		return nil, fmt.Errorf("message has no MSH segment")
+	}
+	raw = raw[start:]
+	if len(raw) < 8 {
+		return nil, fmt.Errorf("MSH segment is too short")
+	}
+
+	msg := &HL7Message{field: raw[3], comp: '^', rep: '~', escape: '\\', sub: '&'}
+	encoding := raw[4:]
+	if end := bytes.IndexByte(encoding, msg.field); end >= 0 {
+		encoding = encoding[:end]
+	}
+	for i, target := range []*byte{&msg.comp, &msg.rep, &msg.escape, &msg.sub} {
+		if i < len(encoding) {
+			*target = encoding[i]
+		}
+	}
+
+	normalized := strings.NewReplacer("\r\n", "\r", "\n", "\r").Replace(string(raw))
+	for _, line := range strings.Split(normalized, "\r") {
+		line = strings.TrimSpace(line)
+		if len(line) < 3 {
+			continue
+		}
+		name := strings.ToUpper(line[:3])
+		var fields []string
+		if name == "MSH" {
+			// MSH-1 is the separator and MSH-2 the encoding characters,
+			// which would otherwise be split apart
+			rest := strings.Split(line[3:], string(msg.field))
+			fields = append([]string{name, string(msg.field)}, rest[1:]...)
+		} else {
+			fields = strings.Split(line, string(msg.field))
+			fields[0] = name
+		}
+		msg.Segments = append(msg.Segments, HL7Segment{Fields: fields, msg: msg})
+	}
+	if len(msg.Segments) == 0 || msg.Segments[0].Fields[0] != "MSH" {
+		return nil, fmt.Errorf("message does not start with MSH")
+	}
+	return msg, nil
+}
+
+// Segment is the first segment with the name, or an empty one.
+func (m *HL7Message) Segment(name string) HL7Segment {
+	for _, segment := range m.Segments {
+		if segment.Fields[0] == name {
+			return segment
+		}
+	}
+	return HL7Segment{Fields: []string{name}, msg: m}
+}
+
+// Has reports whether the message has a segment with the name.
+func (m *HL7Message) Has(name string) bool {
+	for _, segment := range m.Segments {
+		if segment.Fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Type is the message code and trigger event of MSH-9, such as "ADT" and
+// "A01". "ADT_A01" in the first component is accepted too.
+func (m *HL7Message) Type() (string, string) {
+	msh := m.Segment("MSH")
+	code, trigger := msh.Component(9, 1), msh.Component(9, 2)
+	if trigger == "" && strings.Contains(code, "_") {
+		parts := strings.SplitN(code, "_", 2)
+		code, trigger = parts[0], parts[1]
+	}
+	return strings.ToUpper(code), strings.ToUpper(trigger)
+}
+
+// ControlId is MSH-10, which the acknowledgement refers to.
+func (m *HL7Message) ControlId() string {
+	return m.Segment("MSH").Field(10)
+}
+
+// Field is field n, unescaped; for repeating fields, the first repetition.
+func (s HL7Segment) Field(n int) string {
+	reps := s.Repetitions(n)
+	if len(reps) == 0 {
+		return ""
+	}
+	return s.msg.Unescape(reps[0])
+}
+
+// Repetitions is field n split into its repetitions, still escaped.
+func (s HL7Segment) Repetitions(n int) []string {
+	if n >= len(s.Fields) || s.Fields[n] == "" {
+		return nil
+	}
+	if s.Fields[0] == "MSH" && n <= 2 {
+		return []string{s.Fields[n]}
+	}
+	return strings.Split(s.Fields[n], string(s.msg.rep))
+}
+
+// Component is component c (from 1) of the first repetition of field n,
+// unescaped. A subcomponented value gives its first subcomponent.
+func (s HL7Segment) Component(n int, c int) string {
+	reps := s.Repetitions(n)
+	if len(reps) == 0 {
+		return ""
+	}
+	return s.msg.RepComponent(reps[0], c)
+}
+
+// RepComponent is component c (from 1) of one repetition, unescaped.
+func (m *HL7Message) RepComponent(rep string, c int) string {
+	components := strings.Split(rep, string(m.comp))
+	if c < 1 || c > len(components) {
+		return ""
+	}
+	value := components[c-1]
+	if i := strings.IndexByte(value, m.sub); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(m.Unescape(value))
+}
+
+// Unescape replaces the standard escape sequences for the delimiters.
+func (m *HL7Message) Unescape(value string) string {
+	if !strings.ContainsRune(value, rune(m.escape)) {
+		return value
+	}
+	e := string(m.escape)
+	return strings.NewReplacer(
+		e+"F"+e, string(m.field),
+		e+"S"+e, string(m.comp),
+		e+"R"+e, string(m.rep),
+		e+"T"+e, string(m.sub),
+		e+"E"+e, e,
+		e+".br"+e, "\n",
+	).Replace(value)
+}
+
+// ParseHL7Time reads a DTM value (YYYY[MM[DD[HH[MM[SS[.S]]]]]][+/-ZZZZ]).
+// Without an offset it is facility time.
+func ParseHL7Time(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("empty time")
+	}
+	loc := FacilityLocation()
+	if i := strings.IndexAny(value, "+-"); i > 0 {
+		offset := value[i:]
+		value = value[:i]
+		if len(offset) == 5 {
+			hours, errH := strconv.Atoi(offset[1:3])
+			minutes, errM := strconv.Atoi(offset[3:5])
+			if errH != nil || errM != nil {
+				return time.Time{}, fmt.Errorf("invalid time zone %q", offset)
+			}
+			seconds := hours*3600 + minutes*60
+			if offset[0] == '-' {
+				seconds = -seconds
+			}
+			loc = time.FixedZone(offset, seconds)
+		}
+	}
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value = value[:i]
+	}
+	layouts := map[int]string{4: "2006", 6: "200601", 8: "20060102", 10: "2006010215", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(value)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	parsed, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return parsed.UTC(), nil
+}
+
+// FormatHL7Time writes a time as facility-time DTM with its offset.
+func FormatHL7Time(t time.Time) string {
+	return InFacility(t).Format("20060102150405-0700")
+}
+
+// HL7Facility names this system in MSH-3/4 and as the assigning authority of
+// its patient identifiers; HL7_APPLICATION and HL7_FACILITY override the
+// defaults.
+func HL7Facility() (application string, facility string) {
+	application, facility = os.Getenv("HL7_APPLICATION"), os.Getenv("HL7_FACILITY")
+	if application == "" {
+		application = "HMS"
+	}
+	if facility == "" {
+		facility = "HOSPITAL"
+	}
+	return
+}
+
+// HL7Builder writes a message with the standard delimiters.
+type HL7Builder struct {
+	segments []string
+}
+
+// NewHL7Builder starts a message with its MSH segment, addressed to the
+// receiving application and facility.
+func NewHL7Builder(code string, trigger string, structure string, controlId string, receivingApp string, receivingFacility string) *HL7Builder {
+	application, facility := HL7Facility()
+	b := &HL7Builder{}
+	b.segments = append(b.segments, strings.Join([]string{
+		"MSH", "^~\\&", HL7Escape(application), HL7Escape(facility), HL7Escape(receivingApp), HL7Escape(receivingFacility),
+		FormatHL7Time(Now()), "", code + "^" + trigger + "^" + structure, HL7Escape(controlId), "P", "2.5.1",
+	}, "|"))
+	return b
+}
+
+// Add appends a segment. Fields are written as given, so values must be
+// escaped with HL7Escape and components joined with "^" by the caller.
+func (b *HL7Builder) Add(name string, fields ...string) *HL7Builder {
+	for len(fields) > 0 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+	b.segments = append(b.segments, name+"|"+strings.Join(fields, "|"))
+	return b
+}
+
+func (b *HL7Builder) Bytes() []byte {
+	return []byte(strings.Join(b.segments, "\r") + "\r")
+}
+
+// HL7Escape escapes the delimiters in a value. Line breaks become \.br\.
+func HL7Escape(value string) string {
+	return strings.NewReplacer(
+		"\\", "\\E\\",
+		"|", "\\F\\",
+		"^", "\\S\\",
+		"~", "\\R\\",
+		"&", "\\T\\",
+		"\r\n", "\\.br\\",
+		"\n", "\\.br\\",
+		"\r", "\\.br\\",
+	).Replace(value)
+}
+
+// HL7Components joins escaped components.
+func HL7Components(components ...string) string {
+	escaped := make([]string, len(components))
+	for i, component := range components {
+		escaped[i] = HL7Escape(component)
+	}
+	joined := strings.Join(escaped, "^")
+	return strings.TrimRight(joined, "^")
+}
+
+// BuildHL7Ack answers a message with an MSA segment carrying the code (AA,
+// AE or AR) and, for errors, an ERR segment with the text.
+func BuildHL7Ack(msg *HL7Message, code string, text string) []byte {
+	controlId, receivingApp, receivingFacility, trigger := "", "", "", ""
+	if msg != nil {
+		msh := msg.Segment("MSH")
+		controlId = msg.ControlId()
+		receivingApp, receivingFacility = msh.Component(3, 1), msh.Component(4, 1)
+		_, trigger = msg.Type()
+	}
+	b := NewHL7Builder("ACK", trigger, "ACK", fmt.Sprintf("ACK%d", time.Now().UnixNano()), receivingApp, receivingFacility)
+	b.Add("MSA", code, HL7Escape(controlId), HL7Escape(text))
+	if code != "AA" && text != "" {
+		b.Add("ERR", "", "", "", "E", "", "", "", HL7Escape(text))
+	}
+	return b.Bytes()
+}
+
+// ReadMLLP reads one framed message. Bytes before the start block are
+// skipped; io.EOF means the peer closed the connection between messages.
+func ReadMLLP(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == mllpStart {
+			break
+		}
+	}
+	var buf bytes.Buffer
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b == mllpEnd {
+			// the trailing carriage return is optional for lenient peers
+			if next, err := r.Peek(1); err == nil && next[0] == mllpCR {
+				r.ReadByte()
+			}
+			return buf.Bytes(), nil
+		}
+		if buf.Len() >= maxHL7Message {
+			return nil, fmt.Errorf("message exceeds %d bytes", maxHL7Message)
+		}
+		buf.WriteByte(b)
+	}
+}
+
+// WriteMLLP writes one framed message.
+func WriteMLLP(w io.Writer, payload []byte) error {
+	framed := make([]byte, 0, len(payload)+3)
+	framed = append(framed, mllpStart)
+	framed = append(framed, payload...)
+	framed = append(framed, mllpEnd, mllpCR)
+	_, err := w.Write(framed)
+	return err
+}
+
+// SendMLLP sends a message to addr and returns the acknowledgement.
+func SendMLLP(addr string, payload []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err = WriteMLLP(conn, payload); err != nil {
+		return nil, err
+	}
+	return ReadMLLP(bufio.NewReader(conn))
+}
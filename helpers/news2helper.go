@@ -0,0 +1,160 @@
+package helper
+
+import "fmt"
+
+// NEWS2 parameters, consciousness levels and clinical risk levels, as
+// published by the Royal College of Physicians (2017).
+const (
+	News2Respiratory   = "RESPIRATORY_RATE"
+	News2SpO2          = "SPO2"
+	News2Oxygen        = "AIR_OR_OXYGEN"
+	News2Systolic      = "BP_SYSTOLIC"
+	News2Pulse         = "PULSE"
+	News2Consciousness = "CONSCIOUSNESS"
+	News2Temperature   = "TEMPERATURE"
+
+	ConsciousnessAlert = "ALERT"
+
+	RiskLow       = "LOW"
+	RiskLowMedium = "LOW_MEDIUM"
+	RiskMedium    = "MEDIUM"
+	RiskHigh      = "HIGH"
+)
+
+// news2Band scores values up to and including upTo; the last band of a
+// table has no upper bound.
+type news2Band struct {
+	upTo  float64
+	score int
+}
+
+const unbounded = 1e9
+
+var (
+	respiratoryBands = []news2Band{{8, 3}, {11, 1}, {20, 0}, {24, 2}, {unbounded, 3}}
+	// scale 1 is the usual SpO2 scale
+	spo2Scale1Bands = []news2Band{{91, 3}, {93, 2}, {95, 1}, {unbounded, 0}}
+	// scale 2 is for patients with a prescribed target of 88-92%, such as in
+	// hypercapnic respiratory failure; above 92% it only scores on oxygen
+	spo2Scale2Bands       = []news2Band{{83, 3}, {85, 2}, {87, 1}, {unbounded, 0}}
+	spo2Scale2OxygenBands = []news2Band{{83, 3}, {85, 2}, {87, 1}, {92, 0}, {94, 1}, {96, 2}, {unbounded, 3}}
+	systolicBands         = []news2Band{{90, 3}, {100, 2}, {110, 1}, {219, 0}, {unbounded, 3}}
+	pulseBands            = []news2Band{{40, 3}, {50, 1}, {90, 0}, {110, 1}, {130, 2}, {unbounded, 3}}
+	temperatureBands      = []news2Band{{35, 3}, {36, 1}, {38, 0}, {39, 1}, {unbounded, 2}}
+)
+
+// News2Observations are the readings a NEWS2 score is computed from, in
+// canonical units (breaths/min, %, mmHg, beats/min, Cel).
+type News2Observations struct {
+	Respiratory_rate    float64
+	Spo2                float64
+	Spo2_scale          int
+	Supplemental_oxygen bool
+	Systolic            float64
+	Pulse               float64
+	Consciousness       string
+	Temperature         float64
+}
+
+type News2Component struct {
+	Parameter string `json:"parameter"`
+	Value     string `json:"value"`
+	Score     int    `json:"score"`
+}
+
+// News2Score is the aggregate score with its breakdown and the clinical risk
+// it puts the patient at.
+type News2Score struct {
+	Total      int              `json:"total"`
+	Components []News2Component `json:"components"`
+	Risk       string           `json:"risk"`
+	Response   string           `json:"response"`
+}
+
+// riskResponses is the clinical response each risk level calls for.
+var riskResponses = map[string]string{
+	RiskLow:       "ward-based response; continue routine monitoring",
+	RiskLowMedium: "urgent ward-based review by a clinician",
+	RiskMedium:    "urgent response; review by a clinician competent in acute illness",
+	RiskHigh:      "emergency response; continuous monitoring and critical care review",
+}
+
+// RiskRank orders risk levels, LOW being 0.
+func RiskRank(risk string) int {
+	switch risk {
+	case RiskLowMedium:
+		return 1
+	case RiskMedium:
+		return 2
+	case RiskHigh:
+		return 3
+	}
+	return 0
+}
+
+func bandScore(bands []news2Band, value float64) int {
+	for _, band := range bands {
+		if value <= band.upTo {
+			return band.score
+		}
+	}
+	return bands[len(bands)-1].score
+}
+
+// ComputeNews2 scores a set of observations. A scale other than 1 or 2 is an
+// error.
+func ComputeNews2(obs News2Observations) (News2Score, error) {
+	var spo2Bands []news2Band
+	switch {
+	case obs.Spo2_scale == 1:
+		spo2Bands = spo2Scale1Bands
+	case obs.Spo2_scale == 2 && obs.Supplemental_oxygen:
+		spo2Bands = spo2Scale2OxygenBands
+	case obs.Spo2_scale == 2:
+		spo2Bands = spo2Scale2Bands
+	default:
+		return News2Score{}, fmt.Errorf("unknown SpO2 scale %d", obs.Spo2_scale)
+	}
+
+	oxygen, oxygenScore := "AIR", 0
+	if obs.Supplemental_oxygen {
+		oxygen, oxygenScore = "OXYGEN", 2
+	}
+	consciousnessScore := 3
+	if obs.Consciousness == ConsciousnessAlert {
+		consciousnessScore = 0
+	}
+
+	components := []News2Component{
+		reading(News2Respiratory, obs.Respiratory_rate, respiratoryBands),
+		reading(News2SpO2, obs.Spo2, spo2Bands),
+		{Parameter: News2Oxygen, Value: oxygen, Score: oxygenScore},
+		reading(News2Systolic, obs.Systolic, systolicBands),
+		reading(News2Pulse, obs.Pulse, pulseBands),
+		{Parameter: News2Consciousness, Value: obs.Consciousness, Score: consciousnessScore},
+		reading(News2Temperature, obs.Temperature, temperatureBands),
+	}
+
+	score := News2Score{Components: components, Risk: RiskLow}
+	singleRed := false
+	for _, component := range components {
+		score.Total += component.Score
+		if component.Score == 3 {
+			singleRed = true
+		}
+	}
+	switch {
+	case score.Total >= 7:
+		score.Risk = RiskHigh
+	case score.Total >= 5:
+		score.Risk = RiskMedium
+	case singleRed:
+		score.Risk = RiskLowMedium
+	}
+	score.Response = riskResponses[score.Risk]
+	return score, nil
+}
+
+func reading(parameter string, value float64, bands []news2Band) News2Component {
+	return News2Component{Parameter: parameter, Value: fmt.Sprintf("%g", value), Score: bandScore(bands, value)}
+}
@@ -0,0 +1,195 @@
+package helper
+
+import "testing"
+
+// normal is a set of observations scoring 0, which each case changes.
+func normal() News2Observations {
+	return News2Observations{
+		Respiratory_rate: 16,
+		Spo2:             97,
+		Spo2_scale:       1,
+		Systolic:         120,
+		Pulse:            70,
+		Consciousness:    ConsciousnessAlert,
+		Temperature:      37,
+	}
+}
+
+func componentScore(t *testing.T, score News2Score, parameter string) int {
+	t.Helper()
+	for _, component := range score.Components {
+		if component.Parameter == parameter {
+			return component.Score
+		}
+	}
+	t.Fatalf("no %s component", parameter)
+	return 0
+}
+
+// TestNews2Bands checks each band edge of the RCP 2017 chart.
+func TestNews2Bands(t *testing.T) {
+	cases := []struct {
+		parameter string
+		set       func(*News2Observations, float64)
+		value     float64
+		want      int
+	}{
+		{News2Respiratory, setRespiratory, 8, 3},
+		{News2Respiratory, setRespiratory, 9, 1},
+		{News2Respiratory, setRespiratory, 11, 1},
+		{News2Respiratory, setRespiratory, 12, 0},
+		{News2Respiratory, setRespiratory, 20, 0},
+		{News2Respiratory, setRespiratory, 21, 2},
+		{News2Respiratory, setRespiratory, 24, 2},
+		{News2Respiratory, setRespiratory, 25, 3},
+
+		{News2Systolic, setSystolic, 90, 3},
+		{News2Systolic, setSystolic, 91, 2},
+		{News2Systolic, setSystolic, 100, 2},
+		{News2Systolic, setSystolic, 101, 1},
+		{News2Systolic, setSystolic, 110, 1},
+		{News2Systolic, setSystolic, 111, 0},
+		{News2Systolic, setSystolic, 219, 0},
+		{News2Systolic, setSystolic, 220, 3},
+
+		{News2Temperature, setTemperature, 35.0, 3},
+		{News2Temperature, setTemperature, 35.1, 1},
+		{News2Temperature, setTemperature, 36.0, 1},
+		{News2Temperature, setTemperature, 36.1, 0},
+		{News2Temperature, setTemperature, 38.0, 0},
+		{News2Temperature, setTemperature, 38.1, 1},
+		{News2Temperature, setTemperature, 39.0, 1},
+		{News2Temperature, setTemperature, 39.1, 2},
+
+		{News2Pulse, setPulse, 40, 3},
+		{News2Pulse, setPulse, 41, 1},
+		{News2Pulse, setPulse, 51, 0},
+		{News2Pulse, setPulse, 91, 1},
+		{News2Pulse, setPulse, 111, 2},
+		{News2Pulse, setPulse, 131, 3},
+
+		{News2SpO2, setSpo2, 91, 3},
+		{News2SpO2, setSpo2, 92, 2},
+		{News2SpO2, setSpo2, 94, 1},
+		{News2SpO2, setSpo2, 96, 0},
+	}
+	for _, tc := range cases {
+		obs := normal()
+		tc.set(&obs, tc.value)
+		score, err := ComputeNews2(obs)
+		if err != nil {
+			t.Fatalf("%s %g: %v", tc.parameter, tc.value, err)
+		}
+		if got := componentScore(t, score, tc.parameter); got != tc.want {
+			t.Errorf("%s %g scored %d, want %d", tc.parameter, tc.value, got, tc.want)
+		}
+	}
+}
+
+func setRespiratory(obs *News2Observations, value float64) { obs.Respiratory_rate = value }
+func setSystolic(obs *News2Observations, value float64)    { obs.Systolic = value }
+func setTemperature(obs *News2Observations, value float64) { obs.Temperature = value }
+func setPulse(obs *News2Observations, value float64)       { obs.Pulse = value }
+func setSpo2(obs *News2Observations, value float64)        { obs.Spo2 = value }
+
+// TestNews2Scale2 checks SpO2 scale 2, where high saturations only score on
+// oxygen.
+func TestNews2Scale2(t *testing.T) {
+	cases := []struct {
+		spo2   float64
+		oxygen bool
+		want   int
+	}{
+		{83, false, 3},
+		{84, false, 2},
+		{86, false, 1},
+		{88, false, 0},
+		{92, false, 0},
+		{97, false, 0},
+		{88, true, 0},
+		{92, true, 0},
+		{93, true, 1},
+		{94, true, 1},
+		{95, true, 2},
+		{96, true, 2},
+		{97, true, 3},
+	}
+	for _, tc := range cases {
+		obs := normal()
+		obs.Spo2_scale = 2
+		obs.Spo2 = tc.spo2
+		obs.Supplemental_oxygen = tc.oxygen
+		score, err := ComputeNews2(obs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := componentScore(t, score, News2SpO2); got != tc.want {
+			t.Errorf("scale 2 SpO2 %g (oxygen %v) scored %d, want %d", tc.spo2, tc.oxygen, got, tc.want)
+		}
+		wantOxygen := 0
+		if tc.oxygen {
+			wantOxygen = 2
+		}
+		if got := componentScore(t, score, News2Oxygen); got != wantOxygen {
+			t.Errorf("oxygen %v scored %d, want %d", tc.oxygen, got, wantOxygen)
+		}
+	}
+}
+
+// TestNews2Risk checks the aggregate thresholds and the single-parameter
+// rule.
+func TestNews2Risk(t *testing.T) {
+	cases := []struct {
+		name  string
+		set   func(*News2Observations)
+		total int
+		risk  string
+	}{
+		{"all normal", func(obs *News2Observations) {}, 0, RiskLow},
+		{"total 4 without a 3", func(obs *News2Observations) {
+			obs.Respiratory_rate = 22 // 2
+			obs.Pulse = 115           // 2
+		}, 4, RiskLow},
+		{"single 3 alone", func(obs *News2Observations) {
+			obs.Consciousness = "CONFUSION"
+		}, 3, RiskLowMedium},
+		{"single 3 with total 4", func(obs *News2Observations) {
+			obs.Systolic = 90      // 3
+			obs.Temperature = 38.5 // 1
+		}, 4, RiskLowMedium},
+		{"total 5", func(obs *News2Observations) {
+			obs.Respiratory_rate = 22 // 2
+			obs.Pulse = 115           // 2
+			obs.Temperature = 35.5    // 1
+		}, 5, RiskMedium},
+		{"total 6", func(obs *News2Observations) {
+			obs.Respiratory_rate = 22 // 2
+			obs.Pulse = 115           // 2
+			obs.Supplemental_oxygen = true
+		}, 6, RiskMedium},
+		{"total 7", func(obs *News2Observations) {
+			obs.Respiratory_rate = 25 // 3
+			obs.Pulse = 115           // 2
+			obs.Temperature = 39.5    // 2
+		}, 7, RiskHigh},
+	}
+	for _, tc := range cases {
+		obs := normal()
+		tc.set(&obs)
+		score, err := ComputeNews2(obs)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if score.Total != tc.total || score.Risk != tc.risk {
+			t.Errorf("%s: got total %d risk %s, want %d %s", tc.name, score.Total, score.Risk, tc.total, tc.risk)
+		}
+	}
+}
+
+func TestNews2UnknownScale(t *testing.T) {
+	obs := normal()
+	obs.Spo2_scale = 3
+	if _, err := ComputeNews2(obs); err == nil {
+		t.Error("scale 3 was accepted")
+	}
+}
@@ -0,0 +1,348 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	ChannelEmail   = "EMAIL"
+	ChannelSMS     = "SMS"
+	ChannelWebhook = "WEBHOOK"
+)
+
+type Notification struct {
+	Channel    string
+	Recipient  string
+	Subject    string
+	Body       string
+	Attachment *Attachment
+}
+
+type Attachment struct {
+	Name         string
+	Content_type string
+	Content      []byte
+}
+
+// Notifier delivers a rendered notification over one channel.
+type Notifier interface {
+	Send(n Notification) error
+}
+
+// NewNotifier picks the implementation for a channel from NOTIFICATION_DRIVER.
+// "console" (the default) and "file" never leave the machine, so local
+// development works without SMTP or an SMS gateway; "live" uses the real
+// channel.
+func NewNotifier(channel string) Notifier {
+	switch os.Getenv("NOTIFICATION_DRIVER") {
+	case "live":
+		switch channel {
+		case ChannelEmail:
+			return EmailNotifier{
+				Host:     os.Getenv("SMTP_HOST"),
+				Port:     os.Getenv("SMTP_PORT"),
+				Username: os.Getenv("SMTP_USERNAME"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+				From:     os.Getenv("SMTP_FROM"),
+			}
+		case ChannelSMS:
+			return SMSNotifier{GatewayURL: os.Getenv("SMS_GATEWAY_URL"), Token: os.Getenv("SMS_GATEWAY_TOKEN")}
+		case ChannelWebhook:
+			return WebhookNotifier{}
+		}
+	case "file":
+		path := os.Getenv("NOTIFICATION_FILE")
+		if path == "" {
+			path = "notifications.log"
+		}
+		return FileNotifier{Path: path}
+	}
+	return ConsoleNotifier{}
+}
+
+type EmailNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (e EmailNotifier) Send(n Notification) error {
+	if e.Host == "" {
+		return fmt.Errorf("SMTP_HOST is not configured")
+	}
+	port := e.Port
+	if port == "" {
+		port = "25"
+	}
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+
+	msg, err := buildEmail(e.From, n)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(e.Host+":"+port, auth, e.From, []string{n.Recipient}, msg)
+}
+
+func buildEmail(from string, n Notification) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + n.Recipient + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", n.Subject) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if n.Attachment == nil {
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		buf.WriteString(n.Body)
+		return buf.Bytes(), nil
+	}
+
+	writer := multipart.NewWriter(&buf)
+	buf.WriteString("Content-Type: multipart/mixed; boundary=" + writer.Boundary() + "\r\n\r\n")
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(n.Body))
+
+	part, err = writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {n.Attachment.Content_type},
+		"Content-Disposition":       {`attachment; filename="` + n.Attachment.Name + `"`},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(base64.StdEncoding.EncodeToString(n.Attachment.Content)))
+
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SMSNotifier posts the message to an HTTP SMS gateway as a form with "to"
+// and "message" fields.
+type SMSNotifier struct {
+	GatewayURL string
+	Token      string
+}
+
+func (s SMSNotifier) Send(n Notification) error {
+	if s.GatewayURL == "" {
+		return fmt.Errorf("SMS_GATEWAY_URL is not configured")
+	}
+	form := url.Values{"to": {n.Recipient}, "message": {n.Body}}
+	req, err := http.NewRequest(http.MethodPost, s.GatewayURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	return doNotificationRequest(req)
+}
+
+// WebhookNotifier posts the notification as JSON to the recipient URL.
+type WebhookNotifier struct{}
+
+func (WebhookNotifier) Send(n Notification) error {
+	fields := map[string]string{"subject": n.Subject, "body": n.Body}
+	if n.Attachment != nil {
+		fields["attachment_name"] = n.Attachment.Name
+		fields["attachment"] = string(n.Attachment.Content)
+	}
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.Recipient, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doNotificationRequest(req)
+}
+
+var notificationClient = &http.Client{Timeout: 15 * time.Second}
+
+func doNotificationRequest(req *http.Request) error {
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+type ConsoleNotifier struct{}
+
+func (ConsoleNotifier) Send(n Notification) error {
+	log.Printf("[%s to %s] %s\n%s%s", n.Channel, n.Recipient, n.Subject, n.Body, attachmentNote(n))
+	return nil
+}
+
+// FileNotifier appends every notification to a local file.
+type FileNotifier struct {
+	Path string
+}
+
+var fileNotifierMu sync.Mutex
+
+func (f FileNotifier) Send(n Notification) error {
+	fileNotifierMu.Lock()
+	defer fileNotifierMu.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s [%s to %s] %s\n%s%s\n\n", time.Now().UTC().Format(time.RFC3339), n.Channel, n.Recipient, n.Subject, n.Body, attachmentNote(n))
+	return err
+}
+
+func attachmentNote(n Notification) string {
+	if n.Attachment == nil {
+		return ""
+	}
+	return fmt.Sprintf("\n(attachment %s, %d bytes)", n.Attachment.Name, len(n.Attachment.Content))
+}
+
+// ReminderData is what the "reminder" templates are rendered with.
+type ReminderData struct {
+	Patient_name    string
+	Doctor_name     string
+	Appointment_at  string
+	Appointment_id  string
+	Hours_remaining int
+}
+
+// ConfirmationData is what the "confirmation" templates are rendered with.
+type ConfirmationData struct {
+	Patient_name      string
+	Doctor_name       string
+	Appointment_at    string
+	Appointment_id    string
+	Appointment_type  string
+	Prep_instructions string
+}
+
+// RescheduleData is what the "reschedule" and "restored" templates are
+// rendered with.
+type RescheduleData struct {
+	Patient_name   string
+	Doctor_name    string
+	Appointment_at string
+	Appointment_id string
+	Reason         string
+	Proposals      []string
+}
+
+// messageSubjects and messageBodies hold the templates per message name, with
+// one body per channel.
+var messageSubjects = map[string]*template.Template{
+	"reminder": template.Must(template.New("reminder").Parse(
+		`Reminder: appointment with {{.Doctor_name}} at {{.Appointment_at}}`)),
+	"confirmation": template.Must(template.New("confirmation").Parse(
+		`Appointment confirmed with {{.Doctor_name}} at {{.Appointment_at}}`)),
+	"reschedule": template.Must(template.New("reschedule").Parse(
+		`Your appointment on {{.Appointment_at}} needs to be rescheduled`)),
+	"restored": template.Must(template.New("restored").Parse(
+		`Your appointment on {{.Appointment_at}} goes ahead as planned`)),
+}
+
+var messageBodies = map[string]map[string]*template.Template{
+	"reminder": {
+		ChannelEmail: template.Must(template.New(ChannelEmail).Parse(
+			"Dear {{.Patient_name}},\n\n" +
+				"This is a reminder of your appointment with {{.Doctor_name}} on {{.Appointment_at}} " +
+				"(in about {{.Hours_remaining}} hours).\n\n" +
+				"Appointment reference: {{.Appointment_id}}\n")),
+		ChannelSMS: template.Must(template.New(ChannelSMS).Parse(
+			`Hi {{.Patient_name}}, reminder: appointment with {{.Doctor_name}} on {{.Appointment_at}}. Ref {{.Appointment_id}}`)),
+		ChannelWebhook: template.Must(template.New(ChannelWebhook).Parse(
+			`appointment {{.Appointment_id}} with {{.Doctor_name}} starts at {{.Appointment_at}}`)),
+	},
+	"confirmation": {
+		ChannelEmail: template.Must(template.New(ChannelEmail).Parse(
+			"Dear {{.Patient_name}},\n\n" +
+				"Your {{with .Appointment_type}}{{.}} {{end}}appointment with {{.Doctor_name}} on {{.Appointment_at}} is confirmed. " +
+				"The attached calendar file adds it to your calendar.\n\n" +
+				"{{with .Prep_instructions}}Before your visit: {{.}}\n\n{{end}}" +
+				"Appointment reference: {{.Appointment_id}}\n")),
+		ChannelSMS: template.Must(template.New(ChannelSMS).Parse(
+			`Hi {{.Patient_name}}, your appointment with {{.Doctor_name}} on {{.Appointment_at}} is confirmed.{{with .Prep_instructions}} Before your visit: {{.}}{{end}} Ref {{.Appointment_id}}`)),
+		ChannelWebhook: template.Must(template.New(ChannelWebhook).Parse(
+			`appointment {{.Appointment_id}} with {{.Doctor_name}} confirmed for {{.Appointment_at}}{{with .Prep_instructions}}; preparation: {{.}}{{end}}`)),
+	},
+	"reschedule": {
+		ChannelEmail: template.Must(template.New(ChannelEmail).Parse(
+			"Dear {{.Patient_name}},\n\n" +
+				"We are sorry, your appointment with {{.Doctor_name}} on {{.Appointment_at}} cannot go ahead because {{.Reason}}.\n\n" +
+				"{{if .Proposals}}These times are available instead:\n{{range .Proposals}}  - {{.}}\n{{end}}\n{{end}}" +
+				"Please contact reception to choose a new time.\n\n" +
+				"Appointment reference: {{.Appointment_id}}\n")),
+		ChannelSMS: template.Must(template.New(ChannelSMS).Parse(
+			`Hi {{.Patient_name}}, your appointment on {{.Appointment_at}} must be rescheduled ({{.Reason}}).{{if .Proposals}} Next free: {{index .Proposals 0}}.{{end}} Ref {{.Appointment_id}}`)),
+		ChannelWebhook: template.Must(template.New(ChannelWebhook).Parse(
+			`appointment {{.Appointment_id}} on {{.Appointment_at}} needs rescheduling: {{.Reason}}`)),
+	},
+	"restored": {
+		ChannelEmail: template.Must(template.New(ChannelEmail).Parse(
+			"Dear {{.Patient_name}},\n\n" +
+				"Your appointment with {{.Doctor_name}} on {{.Appointment_at}} can go ahead after all because {{.Reason}}. " +
+				"There is no need to choose a new time.\n\n" +
+				"Appointment reference: {{.Appointment_id}}\n")),
+		ChannelSMS: template.Must(template.New(ChannelSMS).Parse(
+			`Hi {{.Patient_name}}, your appointment with {{.Doctor_name}} on {{.Appointment_at}} goes ahead as planned ({{.Reason}}). Ref {{.Appointment_id}}`)),
+		ChannelWebhook: template.Must(template.New(ChannelWebhook).Parse(
+			`appointment {{.Appointment_id}} on {{.Appointment_at}} restored: {{.Reason}}`)),
+	},
+}
+
+func RenderMessage(name string, channel string, data interface{}) (subject string, body string, err error) {
+	tmpl, ok := messageBodies[name][channel]
+	if !ok {
+		return "", "", fmt.Errorf("no %s template for channel %s", name, channel)
+	}
+
+	var buf bytes.Buffer
+	if err = messageSubjects[name].Execute(&buf, data); err != nil {
+		return
+	}
+	subject = buf.String()
+
+	buf.Reset()
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return
+	}
+	body = buf.String()
+	return
+}
@@ -0,0 +1,160 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxOccurrences caps how many appointments a single series can expand into.
+const MaxOccurrences = 200
+
+// Recurrence is the subset of an RFC 5545 RRULE used for appointment series,
+// e.g. "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=16" or "FREQ=WEEKLY;INTERVAL=2;UNTIL=20230301".
+type Recurrence struct {
+	Freq     string
+	Interval int
+	ByDay    []time.Weekday
+	Count    int
+	Until    time.Time
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+func ParseRecurrence(rule string) (Recurrence, error) {
+	r := Recurrence{Interval: 1}
+
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return r, fmt.Errorf("invalid recurrence part %q", part)
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+
+		switch key {
+		case "FREQ":
+			if value != "DAILY" && value != "WEEKLY" && value != "MONTHLY" {
+				return r, fmt.Errorf("unsupported frequency %q", value)
+			}
+			r.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid interval %q", value)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid count %q", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			until, err := parseUntil(value)
+			if err != nil {
+				return r, err
+			}
+			r.Until = until
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				day, ok := weekdays[d]
+				if !ok {
+					return r, fmt.Errorf("invalid weekday %q", d)
+				}
+				r.ByDay = append(r.ByDay, day)
+			}
+		default:
+			return r, fmt.Errorf("unsupported recurrence rule part %q", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return r, errors.New("recurrence needs a FREQ")
+	}
+	if r.Count == 0 && r.Until.IsZero() {
+		return r, errors.New("recurrence needs either COUNT or UNTIL")
+	}
+	if len(r.ByDay) > 0 && r.Freq != "WEEKLY" {
+		return r, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	}
+
+	sort.Slice(r.ByDay, func(i, j int) bool {
+		return mondayFirst(r.ByDay[i]) < mondayFirst(r.ByDay[j])
+	})
+	return r, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"20060102", "2006-01-02"} {
+		// a bare date is a facility-local date and includes the whole day
+		if t, err := time.ParseInLocation(layout, value, FacilityLocation()); err == nil {
+			return t.AddDate(0, 0, 1).Add(-time.Second), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid until date %q", value)
+}
+
+func mondayFirst(d time.Weekday) int {
+	return (int(d) + 6) % 7
+}
+
+// Occurrences expands the rule from start. The start itself is always the
+// first occurrence when it matches the rule.
+func (r Recurrence) Occurrences(start time.Time) []time.Time {
+	var out []time.Time
+
+	add := func(t time.Time) bool {
+		if t.Before(start) {
+			return true
+		}
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		out = append(out, t)
+		if r.Count > 0 && len(out) >= r.Count {
+			return false
+		}
+		return len(out) < MaxOccurrences
+	}
+
+	switch r.Freq {
+	case "DAILY":
+		for i := 0; add(start.AddDate(0, 0, i*r.Interval)); i++ {
+		}
+	case "MONTHLY":
+		for i := 0; add(start.AddDate(0, i*r.Interval, 0)); i++ {
+		}
+	case "WEEKLY":
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{start.Weekday()}
+		}
+		weekStart := start.AddDate(0, 0, -mondayFirst(start.Weekday()))
+		for week := 0; ; week += r.Interval {
+			for _, d := range days {
+				if !add(weekStart.AddDate(0, 0, week*7+mondayFirst(d))) {
+					return out
+				}
+			}
+		}
+	}
+	return out
+}
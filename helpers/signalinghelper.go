@@ -0,0 +1,306 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// JoinTokenTTL is how long a consultation join token can be used to connect.
+var JoinTokenTTL = joinTokenTTL()
+
+func joinTokenTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("JOIN_TOKEN_TTL"))
+	if err != nil || ttl <= 0 {
+		return 5 * time.Minute
+	}
+	return ttl
+}
+
+// joinTokenKey signs join tokens with a key of their own, so a join token is
+// never accepted as a session token or the other way round.
+func joinTokenKey() []byte {
+	return []byte(SECRET_KEY + ":teleconsult")
+}
+
+// JoinClaims admit one participant to one consultation room. Uid is the
+// account the token was issued to, which must be the account connecting.
+type JoinClaims struct {
+	Room           string
+	Role           string
+	Participant_id string
+	Uid            string
+	jwt.StandardClaims
+}
+
+func GenerateJoinToken(room string, role string, participantId string, uid string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(JoinTokenTTL)
+	claims := &JoinClaims{
+		Room:           room,
+		Role:           role,
+		Participant_id: participantId,
+		Uid:            uid,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   "teleconsult",
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(joinTokenKey())
+	return token, expiresAt, err
+}
+
+func ValidateJoinToken(signedToken string) (*JoinClaims, error) {
+	token, err := jwt.ParseWithClaims(signedToken, &JoinClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return joinTokenKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*JoinClaims)
+	if !ok || !token.Valid || claims.Subject != "teleconsult" {
+		return nil, fmt.Errorf("the join token is invalid")
+	}
+	return claims, nil
+}
+
+// SignalMessage is one message on the signaling socket. Offers, answers and
+// ICE candidates carry the browser's payload untouched; To names a single
+// recipient, otherwise the message goes to everyone else admitted.
+type SignalMessage struct {
+	Type         string          `json:"type"`
+	From         string          `json:"from,omitempty"`
+	To           string          `json:"to,omitempty"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+	Participants []Participant   `json:"participants,omitempty"`
+	Waiting      []Participant   `json:"waiting,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+const (
+	SignalOffer     = "offer"
+	SignalAnswer    = "answer"
+	SignalCandidate = "ice"
+	SignalAdmit     = "admit"
+	SignalPresence  = "presence"
+	SignalWaiting   = "waiting"
+	SignalAdmitted  = "admitted"
+	SignalError     = "error"
+)
+
+type Participant struct {
+	Id        string    `json:"id"`
+	Role      string    `json:"role"`
+	Joined_at time.Time `json:"joined_at"`
+}
+
+// Peer is one connection in a room. Messages for it arrive on Send, which is
+// closed when the peer leaves, is replaced by a newer connection of the same
+// participant or the room is closed.
+type Peer struct {
+	Participant
+	room *signalingRoom
+	Send chan SignalMessage
+}
+
+type signalingRoom struct {
+	id       string
+	peers    map[string]*Peer
+	waiting  map[string]*Peer
+	admitted map[string]bool
+}
+
+// SignalingHub relays WebRTC signaling between the participants of each
+// consultation room. Doctors enter directly; patients wait until a doctor
+// admits them, and stay admitted across reconnects while the room lives.
+type SignalingHub struct {
+	mu    sync.Mutex
+	rooms map[string]*signalingRoom
+}
+
+func NewSignalingHub() *SignalingHub {
+	return &SignalingHub{rooms: map[string]*signalingRoom{}}
+}
+
+// Join adds the participant to the room, replacing an older connection of
+// theirs, and reports whether they went to the waiting room.
+func (h *SignalingHub) Join(roomId string, participant Participant) (*Peer, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room := h.rooms[roomId]
+	if room == nil {
+		room = &signalingRoom{id: roomId, peers: map[string]*Peer{}, waiting: map[string]*Peer{}, admitted: map[string]bool{}}
+		h.rooms[roomId] = room
+	}
+
+	for _, set := range []map[string]*Peer{room.peers, room.waiting} {
+		if old, ok := set[participant.Id]; ok {
+			delete(set, participant.Id)
+			close(old.Send)
+		}
+	}
+
+	peer := &Peer{Participant: participant, room: room, Send: make(chan SignalMessage, 32)}
+	waiting := participant.Role != RoleDoctor && !room.admitted[participant.Id]
+	if waiting {
+		room.waiting[participant.Id] = peer
+		deliver(peer, SignalMessage{Type: SignalWaiting})
+	} else {
+		room.peers[participant.Id] = peer
+	}
+	room.announce()
+	return peer, waiting
+}
+
+// Leave removes the peer if it is still the participant's current connection
+// and reports whether it was.
+func (h *SignalingHub) Leave(peer *Peer) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room := peer.room
+	removed := false
+	if room.peers[peer.Id] == peer {
+		delete(room.peers, peer.Id)
+		removed = true
+	}
+	if room.waiting[peer.Id] == peer {
+		delete(room.waiting, peer.Id)
+		removed = true
+	}
+	if !removed {
+		return false
+	}
+	close(peer.Send)
+
+	if len(room.peers) == 0 && len(room.waiting) == 0 {
+		delete(h.rooms, room.id)
+	} else {
+		room.announce()
+	}
+	return true
+}
+
+// Admit moves a waiting participant into the room. Only doctors admit.
+func (h *SignalingHub) Admit(by *Peer, participantId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room := by.room
+	if by.Role != RoleDoctor || room.peers[by.Id] != by {
+		return fmt.Errorf("only a doctor in the room can admit participants")
+	}
+	peer, ok := room.waiting[participantId]
+	if !ok {
+		return fmt.Errorf("%s is not in the waiting room", participantId)
+	}
+
+	delete(room.waiting, participantId)
+	room.peers[participantId] = peer
+	room.admitted[participantId] = true
+	deliver(peer, SignalMessage{Type: SignalAdmitted})
+	room.announce()
+	return nil
+}
+
+// Relay forwards an offer, answer or ICE candidate from an admitted peer.
+func (h *SignalingHub) Relay(from *Peer, message SignalMessage) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room := from.room
+	if room.peers[from.Id] != from {
+		return fmt.Errorf("you have not been admitted yet")
+	}
+
+	message.From = from.Id
+	message.Participants = nil
+	message.Waiting = nil
+	if message.To != "" {
+		peer, ok := room.peers[message.To]
+		if !ok {
+			return fmt.Errorf("%s is not in the room", message.To)
+		}
+		deliver(peer, message)
+		return nil
+	}
+	for id, peer := range room.peers {
+		if id != from.Id {
+			deliver(peer, message)
+		}
+	}
+	return nil
+}
+
+// Reply sends a message to the peer alone, if it is still connected.
+func (h *SignalingHub) Reply(peer *Peer, message SignalMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if peer.room.peers[peer.Id] == peer || peer.room.waiting[peer.Id] == peer {
+		deliver(peer, message)
+	}
+}
+
+// Close ends every connection to the room.
+func (h *SignalingHub) Close(roomId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room := h.rooms[roomId]
+	if room == nil {
+		return
+	}
+	for _, set := range []map[string]*Peer{room.peers, room.waiting} {
+		for id, peer := range set {
+			delete(set, id)
+			close(peer.Send)
+		}
+	}
+	delete(h.rooms, roomId)
+}
+
+// Presence lists who is in the room and who is waiting.
+func (h *SignalingHub) Presence(roomId string) ([]Participant, []Participant) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	room := h.rooms[roomId]
+	if room == nil {
+		return []Participant{}, []Participant{}
+	}
+	return participants(room.peers), participants(room.waiting)
+}
+
+// announce sends the presence to everyone admitted. The caller holds the lock.
+func (r *signalingRoom) announce() {
+	message := SignalMessage{Type: SignalPresence, Participants: participants(r.peers), Waiting: participants(r.waiting)}
+	for _, peer := range r.peers {
+		deliver(peer, message)
+	}
+}
+
+func participants(peers map[string]*Peer) []Participant {
+	list := []Participant{}
+	for _, peer := range peers {
+		list = append(list, peer.Participant)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Joined_at.Before(list[j].Joined_at) })
+	return list
+}
+
+// deliver drops the message if the peer is not keeping up, like Broadcaster.
+func deliver(peer *Peer, message SignalMessage) {
+	select {
+	case peer.Send <- message:
+	default:
+	}
+}
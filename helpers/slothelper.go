@@ -0,0 +1,96 @@
+package helper
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// SlotStep is the spacing of candidate appointment start times.
+const SlotStep = 15 * time.Minute
+
+// ClinicSchedule is when outpatient appointments can be offered, as minutes
+// after midnight in facility local time.
+type ClinicSchedule struct {
+	Opens  int
+	Closes int
+	Days   map[time.Weekday]bool
+}
+
+var clinicSchedule = loadClinicSchedule()
+
+// loadClinicSchedule reads CLINIC_HOURS ("09:00-17:00") and CLINIC_DAYS
+// ("MO,TU,WE,TH,FR,SA").
+func loadClinicSchedule() ClinicSchedule {
+	hours := os.Getenv("CLINIC_HOURS")
+	if hours == "" {
+		hours = "09:00-17:00"
+	}
+	days := os.Getenv("CLINIC_DAYS")
+	if days == "" {
+		days = "MO,TU,WE,TH,FR,SA"
+	}
+
+	schedule := ClinicSchedule{Days: map[time.Weekday]bool{}}
+
+	bounds := strings.Split(hours, "-")
+	if len(bounds) != 2 {
+		log.Fatalf("invalid CLINIC_HOURS %q", hours)
+	}
+	opens, err := time.Parse("15:04", strings.TrimSpace(bounds[0]))
+	if err != nil {
+		log.Fatalf("invalid CLINIC_HOURS %q", hours)
+	}
+	closes, err := time.Parse("15:04", strings.TrimSpace(bounds[1]))
+	if err != nil || !closes.After(opens) {
+		log.Fatalf("invalid CLINIC_HOURS %q", hours)
+	}
+	schedule.Opens = opens.Hour()*60 + opens.Minute()
+	schedule.Closes = closes.Hour()*60 + closes.Minute()
+
+	for _, d := range strings.Split(strings.ToUpper(days), ",") {
+		day, ok := weekdays[strings.TrimSpace(d)]
+		if !ok {
+			log.Fatalf("invalid CLINIC_DAYS %q", days)
+		}
+		schedule.Days[day] = true
+	}
+	return schedule
+}
+
+func Clinic() ClinicSchedule {
+	return clinicSchedule
+}
+
+// CandidateSlots lists start times in [from, until) on the clinic's days and
+// hours for appointments lasting length. Slots are laid out on the facility
+// wall clock and returned in UTC.
+func CandidateSlots(from time.Time, until time.Time, length time.Duration) []time.Time {
+	loc := FacilityLocation()
+	local := from.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	var slots []time.Time
+	for ; day.Before(until); day = day.AddDate(0, 0, 1) {
+		if !clinicSchedule.Days[day.Weekday()] {
+			continue
+		}
+		opens := wallClock(day, clinicSchedule.Opens)
+		closes := wallClock(day, clinicSchedule.Closes)
+
+		for start := opens; !start.Add(length).After(closes); start = start.Add(SlotStep) {
+			if start.Before(from) || !start.Before(until) {
+				continue
+			}
+			slots = append(slots, start.UTC())
+		}
+	}
+	return slots
+}
+
+// wallClock is the given minute of the day on the wall clock, which is not
+// midnight plus that many minutes on DST change days.
+func wallClock(day time.Time, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minute/60, minute%60, 0, 0, day.Location())
+}
@@ -0,0 +1,155 @@
+package helper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// The code systems diagnoses can be coded in.
+const (
+	ICD10  = "ICD10"
+	SNOMED = "SNOMED"
+)
+
+// rf2FullySpecifiedName is the description type of a SNOMED concept's
+// fully specified name.
+const rf2FullySpecifiedName = "900000000000003001"
+
+var (
+	icd10Code  = regexp.MustCompile(`^[A-Z][0-9][0-9A-Z](\.[0-9A-Z]{1,4})?$`)
+	snomedCode = regexp.MustCompile(`^[0-9]{6,18}$`)
+	// semanticTag is the "(disorder)" ending of a fully specified name
+	semanticTag = regexp.MustCompile(`\s*\([^()]+\)$`)
+)
+
+// TerminologyRecord is one code of a code set file.
+type TerminologyRecord struct {
+	Line    int
+	Code    string
+	Display string
+}
+
+var terminologyColumns = []string{"code", "display"}
+
+// TerminologyFormat is the format a code set file is read in by default: a
+// .txt file is the CMS ICD-10-CM code file for ICD-10 and an RF2
+// description file for SNOMED.
+func TerminologyFormat(system string, extension string) string {
+	if extension == "txt" {
+		if system == SNOMED {
+			return "rf2"
+		}
+		return "cms"
+	}
+	return extension
+}
+
+// ParseTerminology reads a code set file in the given format: "csv" with
+// code and display columns, "cms" with a code and its description on each
+// line, or "rf2", a SNOMED description file whose active fully specified
+// names are taken without their semantic tag.
+func ParseTerminology(r io.Reader, format string) ([]TerminologyRecord, error) {
+	var records []TerminologyRecord
+	switch strings.ToLower(format) {
+	case "csv":
+		rows, err := readCSVDataset(r, "code set", terminologyColumns, terminologyColumns)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			records = append(records, TerminologyRecord{Line: row.Line, Code: row.Fields["code"], Display: row.Fields["display"]})
+		}
+	case "cms":
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+			records = append(records, TerminologyRecord{Line: line, Code: fields[0], Display: strings.Join(fields[1:], " ")})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("invalid code file: %v", err)
+		}
+	case "rf2":
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		var index map[string]int
+		for line := 1; scanner.Scan(); line++ {
+			fields := strings.Split(scanner.Text(), "\t")
+			if index == nil {
+				index = map[string]int{}
+				for i, column := range fields {
+					index[strings.TrimSpace(column)] = i
+				}
+				for _, column := range []string{"active", "conceptId", "typeId", "term"} {
+					if _, ok := index[column]; !ok {
+						return nil, fmt.Errorf("RF2 description file has no %q column", column)
+					}
+				}
+				continue
+			}
+			if len(fields) < len(index) || fields[index["active"]] != "1" || fields[index["typeId"]] != rf2FullySpecifiedName {
+				continue
+			}
+			records = append(records, TerminologyRecord{
+				Line:    line,
+				Code:    fields[index["conceptId"]],
+				Display: semanticTag.ReplaceAllString(fields[index["term"]], ""),
+			})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("invalid RF2 file: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown code set format %q, expected csv, cms or rf2", format)
+	}
+	return records, nil
+}
+
+// NormalizeCode is the stored form of a code: ICD-10 codes in upper case
+// with the dot after the category, so "e119" and "E11.9" are the same code.
+// The message is empty when the code is well formed.
+func NormalizeCode(system string, code string) (string, string) {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	switch system {
+	case ICD10:
+		if len(code) > 3 && !strings.Contains(code, ".") {
+			code = code[:3] + "." + code[3:]
+		}
+		if !icd10Code.MatchString(code) {
+			return code, fmt.Sprintf("%q is not an ICD-10 code", code)
+		}
+	case SNOMED:
+		if !snomedCode.MatchString(code) {
+			return code, fmt.Sprintf("%q is not a SNOMED CT concept id", code)
+		}
+	default:
+		return code, fmt.Sprintf("unknown code system %q, expected %s or %s", system, ICD10, SNOMED)
+	}
+	return code, ""
+}
+
+// CodeKey is the form codes are prefix-matched in, without the dot.
+func CodeKey(code string) string {
+	return strings.ReplaceAll(strings.ToUpper(strings.TrimSpace(code)), ".", "")
+}
+
+// SearchWords are the distinct words of a display name in their SearchName
+// form.
+func SearchWords(display string) []string {
+	words := []string{}
+	seen := map[string]bool{}
+	for _, word := range strings.FieldsFunc(SearchName(display), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '(' || r == ')' || r == '/' || r == ';' || r == '-'
+	}) {
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
@@ -0,0 +1,66 @@
+package helper
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// LocalLayout is how facility-local times are rendered in API responses and
+// messages.
+const LocalLayout = "2006-01-02T15:04:05-07:00"
+
+var facilityLocation = loadFacilityLocation()
+
+// loadFacilityLocation reads FACILITY_TIMEZONE (an IANA name such as
+// "Asia/Kolkata"). Schedules are laid out in this zone; everything persisted
+// stays in UTC.
+func loadFacilityLocation() *time.Location {
+	name := os.Getenv("FACILITY_TIMEZONE")
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Fatalf("invalid FACILITY_TIMEZONE %q: %v", name, err)
+	}
+	return loc
+}
+
+func FacilityLocation() *time.Location {
+	return facilityLocation
+}
+
+// Now is the current instant in UTC at second precision, the form every
+// created_at/updated_at is stored in.
+func Now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// InFacility returns t on the facility's wall clock.
+func InFacility(t time.Time) time.Time {
+	return t.In(facilityLocation)
+}
+
+// FormatLocal renders an instant as facility-local time with its offset.
+func FormatLocal(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(facilityLocation).Format(LocalLayout)
+}
+
+// ShiftWallClock moves t by the change from "from" to "to" as seen on the
+// facility's wall clock: the same number of calendar days and the new time of
+// day. Unlike adding to.Sub(from), this keeps a 09:00 slot at 09:00 when a DST
+// change falls in between.
+func ShiftWallClock(t time.Time, from time.Time, to time.Time) time.Time {
+	tl, fl, nl := t.In(facilityLocation), from.In(facilityLocation), to.In(facilityLocation)
+
+	fromDay := time.Date(fl.Year(), fl.Month(), fl.Day(), 0, 0, 0, 0, time.UTC)
+	toDay := time.Date(nl.Year(), nl.Month(), nl.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(toDay.Sub(fromDay).Hours() / 24)
+
+	shifted := time.Date(tl.Year(), tl.Month(), tl.Day()+days, nl.Hour(), nl.Minute(), nl.Second(), 0, facilityLocation)
+	return shifted.UTC()
+}
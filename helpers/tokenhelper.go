@@ -15,11 +15,20 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Role tells whose id Uid is, and which side of a teleconsult a join token is
+// for. Session tokens issued before roles existed carry none and are
+// patients'.
+const (
+	RolePatient = "PATIENT"
+	RoleDoctor  = "DOCTOR"
+)
+
 type SignedDetails struct {
 	Email      string
 	First_name string
 	Last_name  string
 	Uid        string
+	Role       string
 	jwt.StandardClaims
 }
 
@@ -27,20 +36,21 @@ var userCollection *mongo.Collection = database.OpenCollection(database.Client,
 
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
-func GenerateAllTokens(email string, firstName string, lastName string, uid string) (signedToken string, signedRefreshToken string, err error) {
+func GenerateAllTokens(email string, firstName string, lastName string, uid string, role string) (signedToken string, signedRefreshToken string, err error) {
 	claims := &SignedDetails{
 		Email:      email,
 		First_name: firstName,
 		Last_name:  lastName,
 		Uid:        uid,
+		Role:       role,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			ExpiresAt: time.Now().Add(time.Hour * time.Duration(24)).Unix(),
 		},
 	}
 
 	refreshClaims := &SignedDetails{
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			ExpiresAt: time.Now().Add(time.Hour * time.Duration(168)).Unix(),
 		},
 	}
 
@@ -65,7 +75,7 @@ func UpdateAllTokens(signedToken string, signedRefreshToken string, patientId st
 	updateObj = append(updateObj, bson.E{"token", signedToken})
 	updateObj = append(updateObj, bson.E{"refresh_token", signedRefreshToken})
 
-	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	Updated_at := Now()
 	updateObj = append(updateObj, bson.E{"updated_at", Updated_at})
 
 	upsert := true
@@ -103,18 +113,20 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	)
 
 	//the token is invalid
+	if err != nil {
+		msg = err.Error()
+		return
+	}
 
 	claims, ok := token.Claims.(*SignedDetails)
 	if !ok {
 		msg = fmt.Sprintf("the token is invalid")
-		msg = err.Error()
 		return
 	}
 
 	//the token is expired
-	if claims.ExpiresAt < time.Now().Local().Unix() {
+	if claims.ExpiresAt < time.Now().Unix() {
 		msg = fmt.Sprint("token is expired")
-		msg = err.Error()
 		return
 	}
 
@@ -0,0 +1,212 @@
+package helper
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Vital sign types. Every reading is stored in the canonical unit of its
+// type.
+const (
+	VitalSystolic    = "BP_SYSTOLIC"
+	VitalDiastolic   = "BP_DIASTOLIC"
+	VitalPulse       = "PULSE"
+	VitalTemperature = "TEMPERATURE"
+	VitalSpO2        = "SPO2"
+	VitalRespiratory = "RESPIRATORY_RATE"
+	VitalWeight      = "WEIGHT"
+	VitalHeight      = "HEIGHT"
+	VitalBMI         = "BMI"
+)
+
+// Abnormal flags of a reading against its reference range.
+const (
+	FlagNormal       = "NORMAL"
+	FlagLow          = "LOW"
+	FlagHigh         = "HIGH"
+	FlagCriticalLow  = "CRITICAL_LOW"
+	FlagCriticalHigh = "CRITICAL_HIGH"
+)
+
+// VitalUnits is the canonical unit of each type.
+var VitalUnits = map[string]string{
+	VitalSystolic:    "mmHg",
+	VitalDiastolic:   "mmHg",
+	VitalPulse:       "/min",
+	VitalTemperature: "Cel",
+	VitalSpO2:        "%",
+	VitalRespiratory: "/min",
+	VitalWeight:      "kg",
+	VitalHeight:      "cm",
+	VitalBMI:         "kg/m2",
+}
+
+// vitalConversions turns other units into the canonical one. Unit names are
+// matched case-insensitively.
+var vitalConversions = map[string]map[string]func(float64) float64{
+	VitalSystolic:    {"mmhg": same, "kpa": func(v float64) float64 { return v * 7.50062 }},
+	VitalDiastolic:   {"mmhg": same, "kpa": func(v float64) float64 { return v * 7.50062 }},
+	VitalPulse:       {"/min": same, "bpm": same},
+	VitalRespiratory: {"/min": same, "bpm": same, "breaths/min": same},
+	VitalTemperature: {
+		"cel": same, "c": same, "degc": same,
+		"f": func(v float64) float64 { return (v - 32) * 5 / 9 }, "degf": func(v float64) float64 { return (v - 32) * 5 / 9 },
+	},
+	VitalSpO2: {"%": same, "fraction": func(v float64) float64 { return v * 100 }},
+	VitalWeight: {
+		"kg": same, "g": func(v float64) float64 { return v / 1000 },
+		"lb": func(v float64) float64 { return v * 0.45359237 }, "lbs": func(v float64) float64 { return v * 0.45359237 },
+	},
+	VitalHeight: {
+		"cm": same, "m": func(v float64) float64 { return v * 100 }, "mm": func(v float64) float64 { return v / 10 },
+		"in": func(v float64) float64 { return v * 2.54 },
+	},
+}
+
+func same(v float64) float64 { return v }
+
+// vitalPlausible bounds what can be measured on a living patient, to catch
+// typing and unit mistakes.
+var vitalPlausible = map[string][2]float64{
+	VitalSystolic:    {30, 300},
+	VitalDiastolic:   {10, 200},
+	VitalPulse:       {10, 300},
+	VitalTemperature: {25, 45},
+	VitalSpO2:        {30, 100},
+	VitalRespiratory: {2, 120},
+	VitalWeight:      {0.3, 500},
+	VitalHeight:      {20, 260},
+}
+
+// NormalizeVital converts a reading into the canonical unit of its type and
+// checks it is plausible. An empty unit means the canonical unit.
+func NormalizeVital(vitalType string, value float64, unit string) (float64, error) {
+	conversions, ok := vitalConversions[vitalType]
+	if !ok {
+		return 0, fmt.Errorf("unknown vital type %q", vitalType)
+	}
+	if unit == "" {
+		unit = VitalUnits[vitalType]
+	}
+	convert, ok := conversions[strings.ToLower(strings.TrimSpace(unit))]
+	if !ok {
+		return 0, fmt.Errorf("%s cannot be recorded in %q", vitalType, unit)
+	}
+	normalized := math.Round(convert(value)*100) / 100
+	bounds := vitalPlausible[vitalType]
+	if normalized < bounds[0] || normalized > bounds[1] {
+		return 0, fmt.Errorf("%s of %g %s is not plausible", vitalType, value, unit)
+	}
+	return normalized, nil
+}
+
+// BMI is weight over height squared, rounded to one decimal.
+func BMI(weightKg float64, heightCm float64) float64 {
+	meters := heightCm / 100
+	return math.Round(weightKg/(meters*meters)*10) / 10
+}
+
+// ReferenceRange is the normal interval of a vital for an age band. A zero
+// critical bound means none is defined for the band.
+type ReferenceRange struct {
+	Low           float64 `json:"low"`
+	High          float64 `json:"high"`
+	Critical_low  float64 `json:"critical_low,omitempty"`
+	Critical_high float64 `json:"critical_high,omitempty"`
+}
+
+type ageRange struct {
+	vitalType string
+	fromYears float64
+	toYears   float64
+	ReferenceRange
+}
+
+// referenceRanges are the paediatric and adult normal ranges, ages in years
+// from (inclusive) to (exclusive). Adult rows also apply when the age is not
+// known.
+var referenceRanges = []ageRange{
+	{VitalPulse, 0, 1, ReferenceRange{100, 160, 0, 0}},
+	{VitalPulse, 1, 3, ReferenceRange{90, 150, 0, 0}},
+	{VitalPulse, 3, 6, ReferenceRange{80, 140, 0, 0}},
+	{VitalPulse, 6, 12, ReferenceRange{70, 120, 0, 0}},
+	{VitalPulse, 12, 18, ReferenceRange{60, 100, 0, 0}},
+	{VitalPulse, 18, 200, ReferenceRange{60, 100, 40, 130}},
+
+	{VitalRespiratory, 0, 1, ReferenceRange{30, 60, 0, 0}},
+	{VitalRespiratory, 1, 3, ReferenceRange{24, 40, 0, 0}},
+	{VitalRespiratory, 3, 6, ReferenceRange{22, 34, 0, 0}},
+	{VitalRespiratory, 6, 12, ReferenceRange{18, 30, 0, 0}},
+	{VitalRespiratory, 12, 18, ReferenceRange{12, 20, 0, 0}},
+	{VitalRespiratory, 18, 200, ReferenceRange{12, 20, 8, 30}},
+
+	{VitalSystolic, 0, 1, ReferenceRange{70, 100, 0, 0}},
+	{VitalSystolic, 1, 6, ReferenceRange{80, 110, 0, 0}},
+	{VitalSystolic, 6, 12, ReferenceRange{85, 120, 0, 0}},
+	{VitalSystolic, 12, 18, ReferenceRange{95, 130, 0, 0}},
+	{VitalSystolic, 18, 200, ReferenceRange{90, 139, 70, 180}},
+
+	{VitalDiastolic, 0, 1, ReferenceRange{35, 65, 0, 0}},
+	{VitalDiastolic, 1, 6, ReferenceRange{45, 70, 0, 0}},
+	{VitalDiastolic, 6, 12, ReferenceRange{50, 80, 0, 0}},
+	{VitalDiastolic, 12, 18, ReferenceRange{60, 85, 0, 0}},
+	{VitalDiastolic, 18, 200, ReferenceRange{60, 89, 40, 120}},
+
+	{VitalTemperature, 0, 200, ReferenceRange{36.1, 37.9, 35, 40}},
+	{VitalSpO2, 0, 200, ReferenceRange{95, 100, 90, 0}},
+	{VitalBMI, 18, 200, ReferenceRange{18.5, 24.9, 0, 0}},
+}
+
+// VitalReference finds the reference range of a vital for an age in years;
+// a negative age means it is not known and the adult range is used. Weight,
+// height and children's BMI have no range.
+func VitalReference(vitalType string, ageYears float64) (ReferenceRange, bool) {
+	if ageYears < 0 {
+		ageYears = 18
+	}
+	for _, row := range referenceRanges {
+		if row.vitalType == vitalType && ageYears >= row.fromYears && ageYears < row.toYears {
+			return row.ReferenceRange, true
+		}
+	}
+	return ReferenceRange{}, false
+}
+
+// VitalFlag grades a reading against its range.
+func VitalFlag(value float64, reference ReferenceRange) string {
+	switch {
+	case reference.Critical_low > 0 && value < reference.Critical_low:
+		return FlagCriticalLow
+	case reference.Critical_high > 0 && value > reference.Critical_high:
+		return FlagCriticalHigh
+	case value < reference.Low:
+		return FlagLow
+	case value > reference.High:
+		return FlagHigh
+	}
+	return FlagNormal
+}
+
+// AgeYears is the age in years, with the fraction, at a given time.
+func AgeYears(birth time.Time, at time.Time) float64 {
+	return at.Sub(birth).Hours() / 24 / 365.25
+}
+
+// IntervalStart is the start of the facility-time hour, day or week (from
+// Monday) that t falls in.
+func IntervalStart(t time.Time, interval string) (time.Time, error) {
+	local := InFacility(t)
+	switch interval {
+	case "hour":
+		// Truncate works on absolute time and would split half-hour zones
+		return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, local.Location()), nil
+	case "day":
+		return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location()), nil
+	case "week":
+		day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7)), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown interval %q, expected hour, day or week", interval)
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"os"
 
+	controller "golang-hospital-management/controllers"
 	"golang-hospital-management/database"
 	middleware "golang-hospital-management/middleware"
 	routes "golang-hospital-management/routes"
@@ -23,12 +25,42 @@ func main() {
 	router := gin.New()
 	router.Use(gin.Logger())
 	routes.PatientRoutes(router)
+	routes.DoctorAuthRoutes(router)
+	routes.CalendarFeedRoutes(router)
+	routes.QueueDisplayRoutes(router)
+	routes.TeleconsultSignalRoutes(router)
 	router.Use(middleware.Authentication())
 
 	routes.DoctorRoutes(router)
 	routes.PrescriptionRoutes(router)
 	routes.BookappointmentRoutes(router)
+	routes.AppointmentSeriesRoutes(router)
 	routes.InvoiceRoutes(router)
+	routes.NotificationRoutes(router)
+	routes.CalendarRoutes(router)
+	routes.QueueRoutes(router)
+	routes.ResourceRoutes(router)
+	routes.LeaveRoutes(router)
+	routes.AppointmentTypeRoutes(router)
+	routes.ReferralRoutes(router)
+	routes.TeleconsultRoutes(router)
+	routes.FormularyRoutes(router)
+	routes.AllergyRoutes(router)
+	routes.PharmacyRoutes(router)
+	routes.InventoryRoutes(router)
+	routes.SupplierRoutes(router)
+	routes.PurchaseOrderRoutes(router)
+	routes.EncounterRoutes(router)
+	routes.VitalsRoutes(router)
+	routes.ObservationRoutes(router)
+	routes.LabRoutes(router)
+	routes.HL7Routes(router)
+	routes.FhirRoutes(router)
+	routes.TerminologyRoutes(router)
+	routes.DiagnosisRoutes(router)
+
+	controller.StartBackgroundJobs(context.Background())
+	controller.StartHL7Listener(context.Background())
 
 	router.Run(":" + port)
 }
@@ -28,6 +28,11 @@ func Authentication() gin.HandlerFunc {
 		c.Set("first_name", claims.First_name)
 		c.Set("last_name", claims.Last_name)
 		c.Set("uid", claims.Uid)
+		role := claims.Role
+		if role == "" {
+			role = helper.RolePatient
+		}
+		c.Set("role", role)
 
 		c.Next()
 	}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Admission is an inpatient (or emergency, or day-case) stay, created and
+// closed by ADT messages from the HIS. Visit_number is the HIS's visit
+// identifier. Status is ADMITTED or DISCHARGED.
+type Admission struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	Admission_id      string             `json:"admission_id"`
+	Patient_id        string             `json:"patient_id"`
+	Visit_number      *string            `json:"visit_number"`
+	Patient_class     string             `json:"patient_class"`
+	Ward              *string            `json:"ward"`
+	Room              *string            `json:"room"`
+	Bed               *string            `json:"bed"`
+	Doctor_id         *string            `json:"doctor_id"`
+	Attending_name    *string            `json:"attending_name"`
+	Status            string             `json:"status"`
+	Admitted_at       time.Time          `json:"admitted_at"`
+	Discharged_at     *time.Time         `json:"discharged_at"`
+	Source_message_id string             `json:"source_message_id"`
+	Created_at        time.Time          `json:"created_at"`
+	Updated_at        time.Time          `json:"updated_at"`
+}
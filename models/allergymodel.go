@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Allergy is an allergy or intolerance of a patient. It matches a drug by its
+// formulary code, by an ATC class the drug belongs to, or by the substance
+// name appearing in the drug's name.
+type Allergy struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Allergy_id  string             `json:"allergy_id"`
+	Patient_id  *string            `json:"patient_id"`
+	Substance   *string            `json:"substance" validate:"required,max=200"`
+	Drug_code   *string            `json:"drug_code" validate:"omitempty,max=50"`
+	Atc_class   *string            `json:"atc_class" validate:"omitempty,alphanum,max=7"`
+	Type        *string            `json:"type" validate:"omitempty,eq=ALLERGY|eq=INTOLERANCE"`
+	Category    *string            `json:"category" validate:"omitempty,eq=MEDICATION|eq=FOOD|eq=ENVIRONMENT|eq=BIOLOGIC"`
+	Reaction    *string            `json:"reaction" validate:"omitempty,max=500"`
+	Severity    *string            `json:"severity" validate:"omitempty,eq=MILD|eq=MODERATE|eq=SEVERE"`
+	Status      *string            `json:"status" validate:"omitempty,eq=ACTIVE|eq=INACTIVE|eq=RESOLVED|eq=ENTERED_IN_ERROR"`
+	Onset       *time.Time         `json:"onset"`
+	Notes       *string            `json:"notes" validate:"omitempty,max=2000"`
+	Recorded_by string             `json:"recorded_by"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+}
+
+// AllergyChange is the body accepted when an allergy record is corrected.
+type AllergyChange struct {
+	Substance *string    `json:"substance" validate:"omitempty,max=200"`
+	Drug_code *string    `json:"drug_code" validate:"omitempty,max=50"`
+	Atc_class *string    `json:"atc_class" validate:"omitempty,alphanum,max=7"`
+	Type      *string    `json:"type" validate:"omitempty,eq=ALLERGY|eq=INTOLERANCE"`
+	Category  *string    `json:"category" validate:"omitempty,eq=MEDICATION|eq=FOOD|eq=ENVIRONMENT|eq=BIOLOGIC"`
+	Reaction  *string    `json:"reaction" validate:"omitempty,max=500"`
+	Severity  *string    `json:"severity" validate:"omitempty,eq=MILD|eq=MODERATE|eq=SEVERE"`
+	Status    *string    `json:"status" validate:"omitempty,eq=ACTIVE|eq=INACTIVE|eq=RESOLVED|eq=ENTERED_IN_ERROR"`
+	Onset     *time.Time `json:"onset"`
+	Notes     *string    `json:"notes" validate:"omitempty,max=2000"`
+}
+
+// AllergyAssessment records that someone asked the patient about allergies
+// and there were none, which is not the same as never having asked.
+type AllergyAssessment struct {
+	No_known_allergies bool      `json:"no_known_allergies"`
+	Recorded_by        string    `json:"recorded_by"`
+	Recorded_at        time.Time `json:"recorded_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AppointmentSeries struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	Series_id        string             `json:"series_id"`
+	Recurrence       *string            `json:"recurrence" validate:"required"`
+	Start_Date       *time.Time         `json:"start_date" validate:"required"`
+	Start_local      string             `json:"start_date_local" bson:"-"`
+	Time_zone        string             `json:"time_zone" bson:"-"`
+	Duration_minutes int                `json:"duration_minutes" validate:"omitempty,min=5,max=480"`
+	Type_id          *string            `json:"type_id"`
+	Doctor_id        *string            `json:"doctor_id" validate:"required"`
+	Patient_id       *string            `json:"patient_id" validate:"required"`
+	Invoice_id       *string            `json:"Invoice_id" validate:"required"`
+	Status           *string            `json:"status" validate:"omitempty,eq=ACTIVE|eq=CANCELLED"`
+	Occurrences      int                `json:"occurrences"`
+	Conflicts        []SeriesConflict   `json:"conflicts"`
+	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
+}
+
+// SeriesConflict records an occurrence that could not be booked because the
+// doctor already has an appointment at that time.
+type SeriesConflict struct {
+	Occurrence_Date  time.Time `json:"occurrence_date"`
+	Occurrence_local string    `json:"occurrence_date_local" bson:"-"`
+	Appointment_id   string    `json:"Appointment_id"`
+}
+
+// SeriesChange is the body accepted when editing or cancelling part of a series.
+type SeriesChange struct {
+	Scope            *string    `json:"scope" validate:"required,eq=THIS|eq=FOLLOWING|eq=ALL"`
+	Appointment_id   string     `json:"Appointment_id"`
+	Appointment_Date *time.Time `json:"Appointment_date"`
+	Duration_minutes int        `json:"duration_minutes" validate:"omitempty,min=5,max=480"`
+	Doctor_id        *string    `json:"doctor_id"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AppointmentType is a catalog entry such as a new consultation, follow-up,
+// procedure or teleconsult. Specialities limits which doctors may perform it;
+// an empty list means any doctor.
+type AppointmentType struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	Type_id           string             `json:"type_id"`
+	Code              *string            `json:"code" validate:"required,min=2,max=40"`
+	Name              *string            `json:"name" validate:"required,min=2,max=100"`
+	Duration_minutes  int                `json:"duration_minutes" validate:"required,min=5,max=480"`
+	Buffer_minutes    int                `json:"buffer_minutes" validate:"min=0,max=120"`
+	Specialities      []string           `json:"specialities"`
+	Prep_instructions *string            `json:"prep_instructions" validate:"omitempty,max=2000"`
+	Default_fee       *float64           `json:"default_fee" validate:"omitempty,min=0"`
+	Teleconsult       bool               `json:"teleconsult"`
+	Active            *bool              `json:"active"`
+	Created_at        time.Time          `json:"created_at"`
+	Updated_at        time.Time          `json:"updated_at"`
+}
+
+// AppointmentTypeChange is the body accepted when an appointment type is
+// edited. Only the fields given change; a zero buffer_minutes removes the
+// buffer.
+type AppointmentTypeChange struct {
+	Name              *string  `json:"name" validate:"omitempty,min=2,max=100"`
+	Duration_minutes  *int     `json:"duration_minutes" validate:"omitempty,min=5,max=480"`
+	Buffer_minutes    *int     `json:"buffer_minutes" validate:"omitempty,min=0,max=120"`
+	Specialities      []string `json:"specialities"`
+	Prep_instructions *string  `json:"prep_instructions" validate:"omitempty,max=2000"`
+	Default_fee       *float64 `json:"default_fee" validate:"omitempty,min=0"`
+	Active            *bool    `json:"active"`
+}
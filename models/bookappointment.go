@@ -7,13 +7,24 @@ import (
 )
 
 type Appointment struct {
-	ID         primitive.ObjectID `bson:"_id"`
+	ID               primitive.ObjectID `bson:"_id"`
 	Appointment_Date time.Time          `json:"Appointment_date" validate:"required"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
+	Date_local       string             `json:"Appointment_date_local" bson:"-"`
+	Time_zone        string             `json:"time_zone" bson:"-"`
+	Duration_minutes int                `json:"duration_minutes" validate:"omitempty,min=5,max=480"`
+	Buffer_minutes   int                `json:"buffer_minutes" validate:"min=0,max=120"`
+	Type_id          *string            `json:"type_id"`
+	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
 	Appointment_id   string             `json:"Appointment_id"`
-	Invoice_id   *string            `json:"Invoice_id" validate:"required"`
-	Prescription_id   string            `json:"Prescription_id"`
-	Doctor_id    *string             `json:"doctor_id"`
-	
-}
\ No newline at end of file
+	Invoice_id       *string            `json:"Invoice_id" validate:"required"`
+	Prescription_id  string             `json:"Prescription_id"`
+	Doctor_id        *string            `json:"doctor_id"`
+	Patient_id       *string            `json:"patient_id"`
+	Resource_ids     []string           `json:"resource_ids"`
+	Status           *string            `json:"status" validate:"omitempty,eq=SCHEDULED|eq=CANCELLED|eq=NEEDS_RESCHEDULE"`
+	Reschedule       *Reschedule        `json:"reschedule"`
+	Series_id        *string            `json:"series_id"`
+	Series_index     int                `json:"series_index"`
+	Teleconsult      *Teleconsult       `json:"teleconsult"`
+}
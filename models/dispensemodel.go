@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Dispense records medication handed out against one prescription item.
+type Dispense struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Dispense_id     string             `json:"dispense_id"`
+	Prescription_id string             `json:"prescription_id"`
+	Patient_id      string             `json:"patient_id"`
+	Item_id         string             `json:"item_id"`
+	Drug_code       string             `json:"drug_code"`
+	Fill_number     int                `json:"fill_number"`
+	Quantity        float64            `json:"quantity"`
+	Location_id     string             `json:"location_id"`
+	Batch           string             `json:"batch"`
+	Pharmacist_id   string             `json:"pharmacist_id"`
+	Notes           *string            `json:"notes"`
+	Dispensed_at    time.Time          `json:"dispensed_at"`
+}
+
+// DispenseRequest is the body accepted when the pharmacy hands out items
+// from the stock of a dispensing location.
+type DispenseRequest struct {
+	Location_id string         `json:"location_id" validate:"required"`
+	Items       []DispenseLine `json:"items" validate:"required,min=1,max=50,dive"`
+	Notes       *string        `json:"notes" validate:"omitempty,max=2000"`
+}
+
+// DispenseLine is one item handed out. Without a quantity the rest of the
+// current fill is dispensed; without a batch stock is picked
+// first-expiry-first-out.
+type DispenseLine struct {
+	Item_id  string  `json:"item_id" validate:"required"`
+	Quantity float64 `json:"quantity" validate:"gte=0"`
+	Batch    *string `json:"batch" validate:"omitempty,max=50"`
+}
+
+// RefillRequest asks the prescribing doctor for another fill of some items
+// of a prescription. It moves PENDING -> APPROVED or DENIED.
+type RefillRequest struct {
+	ID                 primitive.ObjectID `bson:"_id"`
+	Refill_request_id  string             `json:"refill_request_id"`
+	Prescription_id    string             `json:"prescription_id"`
+	Patient_id         string             `json:"patient_id"`
+	Doctor_id          string             `json:"doctor_id"`
+	Item_ids           []string           `json:"item_ids"`
+	Note               *string            `json:"note" validate:"omitempty,max=1000"`
+	Status             string             `json:"status"`
+	Additional_refills int                `json:"additional_refills"`
+	Decided_by         *string            `json:"decided_by"`
+	Decided_at         *time.Time         `json:"decided_at"`
+	Decision_reason    *string            `json:"decision_reason"`
+	Created_at         time.Time          `json:"created_at"`
+	Updated_at         time.Time          `json:"updated_at"`
+}
+
+// RefillDecision is the body accepted when the doctor answers a refill
+// request, as the signed-in doctor. Additional_refills is needed for items
+// with no refills left.
+type RefillDecision struct {
+	Additional_refills int        `json:"additional_refills" validate:"min=0,max=12"`
+	End_Date           *time.Time `json:"end_date"`
+	Reason             *string    `json:"reason" validate:"omitempty,max=1000"`
+}
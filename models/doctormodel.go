@@ -7,13 +7,23 @@ import (
 )
 
 type Doctor struct {
-	ID            primitive.ObjectID `bson:"_id"`
+	ID         primitive.ObjectID `bson:"_id"`
 	Name       *string            `json:"name" validate:"required,min=2,max=100"`
-	Speciality      *string           `json:"speciality" validate:"required"`
-	
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Doctor_id    string             `json:"doctor_id"`
+	Speciality *string            `json:"speciality" validate:"required"`
 
-	
-}
\ No newline at end of file
+	Created_at     time.Time `json:"created_at"`
+	Updated_at     time.Time `json:"updated_at"`
+	Doctor_id      string    `json:"doctor_id"`
+	Calendar_token *string   `json:"-"`
+
+	// set by the doctorcredentials command; a doctor without them cannot
+	// sign in
+	Email    *string `json:"email,omitempty"`
+	Password *string `json:"-"`
+}
+
+// DoctorLogin is the body accepted when a doctor signs in.
+type DoctorLogin struct {
+	Email    *string `json:"email" validate:"required,email"`
+	Password *string `json:"password" validate:"required"`
+}
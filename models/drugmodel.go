@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Drug is one formulary entry. Drug_id is the code of the source dataset, so
+// re-importing the dataset updates entries in place.
+type Drug struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Drug_id      string             `json:"drug_id" validate:"required,max=50"`
+	Generic_name *string            `json:"generic_name" validate:"required,max=200"`
+	Brand_names  []string           `json:"brand_names" validate:"dive,max=200"`
+	Atc_code     *string            `json:"atc_code" validate:"omitempty,alphanum,max=7"`
+	Forms        []string           `json:"forms" validate:"required,min=1,dive,eq=TABLET|eq=CAPSULE|eq=SYRUP|eq=SUSPENSION|eq=INJECTION|eq=CREAM|eq=OINTMENT|eq=DROPS|eq=INHALER|eq=PATCH|eq=OTHER"`
+	Strengths    []string           `json:"strengths" validate:"required,min=1,dive,max=50"`
+	Schedule     *string            `json:"schedule" validate:"omitempty,eq=NONE|eq=I|eq=II|eq=III|eq=IV|eq=V"`
+	Search_names []string           `json:"-"`
+	Active       bool               `json:"active"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+}
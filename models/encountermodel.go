@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Encounter is the doctor's note of a consultation, written in SOAP
+// sections. A DRAFT can be edited by its doctor; once SIGNED it can no longer
+// change and corrections are added as addenda.
+type Encounter struct {
+	ID              primitive.ObjectID   `bson:"_id"`
+	Encounter_id    string               `json:"encounter_id"`
+	Appointment_id  *string              `json:"appointment_id" validate:"required"`
+	Patient_id      string               `json:"patient_id"`
+	Doctor_id       string               `json:"doctor_id"`
+	Chief_complaint *string              `json:"chief_complaint" validate:"omitempty,max=500"`
+	Subjective      *string              `json:"subjective" validate:"omitempty,max=20000"`
+	Objective       *string              `json:"objective" validate:"omitempty,max=20000"`
+	Assessment      *string              `json:"assessment" validate:"omitempty,max=20000"`
+	Plan            *string              `json:"plan" validate:"omitempty,max=20000"`
+	Diagnoses       []EncounterDiagnosis `json:"diagnoses" validate:"omitempty,max=50,dive"`
+	Orders          []EncounterOrder     `json:"orders" validate:"omitempty,max=100,dive"`
+	Status          string               `json:"status"`
+	Signed_by       *string              `json:"signed_by"`
+	Signed_at       *time.Time           `json:"signed_at"`
+	Addenda         []EncounterAddendum  `json:"addenda"`
+	Created_at      time.Time            `json:"created_at"`
+	Updated_at      time.Time            `json:"updated_at"`
+}
+
+// EncounterDiagnosis is one diagnosis of the encounter; at most one is the
+// primary diagnosis.
+type EncounterDiagnosis struct {
+	Code        *string `json:"code" validate:"omitempty,max=20"`
+	Description *string `json:"description" validate:"required,max=500"`
+	Primary     bool    `json:"primary"`
+	Certainty   *string `json:"certainty" validate:"omitempty,eq=CONFIRMED|eq=PROVISIONAL|eq=DIFFERENTIAL|eq=RULED_OUT"`
+}
+
+// EncounterOrder is something the doctor ordered during the encounter.
+// Reference_id points at the record it became, such as a referral.
+type EncounterOrder struct {
+	Order_id     string  `json:"order_id"`
+	Kind         *string `json:"kind" validate:"required,eq=LAB|eq=IMAGING|eq=PROCEDURE|eq=REFERRAL|eq=MEDICATION|eq=FOLLOW_UP|eq=OTHER"`
+	Description  *string `json:"description" validate:"required,max=1000"`
+	Reference_id *string `json:"reference_id" validate:"omitempty,max=100"`
+}
+
+// EncounterAddendum is a note added to a signed encounter. Its author is the
+// signed-in doctor, never taken from the body.
+type EncounterAddendum struct {
+	Addendum_id string    `json:"addendum_id"`
+	Author_id   *string   `json:"author_id"`
+	Text        *string   `json:"text" validate:"required,min=1,max=10000"`
+	Created_at  time.Time `json:"created_at"`
+}
+
+// EncounterChange is the body accepted when a draft encounter is edited.
+// Diagnoses and orders, when given, replace the whole list.
+type EncounterChange struct {
+	Chief_complaint *string              `json:"chief_complaint" validate:"omitempty,max=500"`
+	Subjective      *string              `json:"subjective" validate:"omitempty,max=20000"`
+	Objective       *string              `json:"objective" validate:"omitempty,max=20000"`
+	Assessment      *string              `json:"assessment" validate:"omitempty,max=20000"`
+	Plan            *string              `json:"plan" validate:"omitempty,max=20000"`
+	Diagnoses       []EncounterDiagnosis `json:"diagnoses" validate:"omitempty,max=50,dive"`
+	Orders          []EncounterOrder     `json:"orders" validate:"omitempty,max=100,dive"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FhirExport is a bulk data export: the request as it was kicked off, then
+// its progress and the NDJSON files written for it. Status is QUEUED,
+// RUNNING, COMPLETED, FAILED, CANCELLED or, once its files are removed,
+// EXPIRED.
+type FhirExport struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	Export_id        string             `json:"export_id"`
+	Level            string             `json:"level"`
+	Types            []string           `json:"types"`
+	Since            *time.Time         `json:"since"`
+	Request_url      string             `json:"request_url"`
+	Requested_by     string             `json:"requested_by"`
+	Status           string             `json:"status"`
+	Progress         string             `json:"progress"`
+	Error            string             `json:"error"`
+	Output           []FhirExportOutput `json:"output"`
+	Transaction_time *time.Time         `json:"transaction_time"`
+	Completed_at     *time.Time         `json:"completed_at"`
+	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
+}
+
+// FhirExportOutput is one NDJSON file of an export, named after its type.
+type FhirExportOutput struct {
+	Type  string `json:"type"`
+	File  string `json:"file"`
+	Count int64  `json:"count"`
+}
+
+// FhirExportManifest is the completed export as the status endpoint returns
+// it, with the files to download.
+type FhirExportManifest struct {
+	Transaction_time      string           `json:"transactionTime"`
+	Request               string           `json:"request"`
+	Requires_access_token bool             `json:"requiresAccessToken"`
+	Output                []FhirExportFile `json:"output"`
+	Error                 []FhirExportFile `json:"error"`
+}
+
+type FhirExportFile struct {
+	Type  string `json:"type"`
+	Url   string `json:"url"`
+	Count int64  `json:"count"`
+}
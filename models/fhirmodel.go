@@ -0,0 +1,253 @@
+package models
+
+// The Fhir types are the FHIR R4 resources the /fhir facade reads and
+// writes, limited to the elements it maps. They are JSON only and never
+// stored; each is converted from and to the record it stands for.
+
+type FhirMeta struct {
+	Last_updated string `json:"lastUpdated,omitempty"`
+}
+
+type FhirIdentifier struct {
+	Use    string `json:"use,omitempty"`
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+type FhirHumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+}
+
+type FhirContactPoint struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Use    string `json:"use,omitempty"`
+}
+
+type FhirReference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+type FhirCoding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type FhirCodeableConcept struct {
+	Coding []FhirCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type FhirQuantity struct {
+	Value  *float64 `json:"value,omitempty"`
+	Unit   string   `json:"unit,omitempty"`
+	System string   `json:"system,omitempty"`
+	Code   string   `json:"code,omitempty"`
+}
+
+type FhirMoney struct {
+	Value    *float64 `json:"value,omitempty"`
+	Currency string   `json:"currency,omitempty"`
+}
+
+type FhirPeriod struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+type FhirAnnotation struct {
+	Text string `json:"text"`
+}
+
+// FhirExtension carries what a resource has no element for. Only the value
+// types the facade uses are listed.
+type FhirExtension struct {
+	Url             string         `json:"url"`
+	Value_string    *string        `json:"valueString,omitempty"`
+	Value_code      *string        `json:"valueCode,omitempty"`
+	Value_reference *FhirReference `json:"valueReference,omitempty"`
+}
+
+type FhirPatient struct {
+	Resource_type        string             `json:"resourceType"`
+	Id                   string             `json:"id,omitempty"`
+	Meta                 *FhirMeta          `json:"meta,omitempty"`
+	Identifier           []FhirIdentifier   `json:"identifier,omitempty"`
+	Active               *bool              `json:"active,omitempty"`
+	Name                 []FhirHumanName    `json:"name,omitempty"`
+	Telecom              []FhirContactPoint `json:"telecom,omitempty"`
+	Birth_date           string             `json:"birthDate,omitempty"`
+	General_practitioner []FhirReference    `json:"generalPractitioner,omitempty"`
+}
+
+type FhirPractitioner struct {
+	Resource_type string              `json:"resourceType"`
+	Id            string              `json:"id,omitempty"`
+	Meta          *FhirMeta           `json:"meta,omitempty"`
+	Identifier    []FhirIdentifier    `json:"identifier,omitempty"`
+	Active        *bool               `json:"active,omitempty"`
+	Name          []FhirHumanName     `json:"name,omitempty"`
+	Qualification []FhirQualification `json:"qualification,omitempty"`
+}
+
+type FhirQualification struct {
+	Code FhirCodeableConcept `json:"code"`
+}
+
+type FhirAppointment struct {
+	Resource_type          string                       `json:"resourceType"`
+	Id                     string                       `json:"id,omitempty"`
+	Meta                   *FhirMeta                    `json:"meta,omitempty"`
+	Identifier             []FhirIdentifier             `json:"identifier,omitempty"`
+	Status                 string                       `json:"status"`
+	Appointment_type       *FhirCodeableConcept         `json:"appointmentType,omitempty"`
+	Start                  string                       `json:"start,omitempty"`
+	End                    string                       `json:"end,omitempty"`
+	Minutes_duration       int                          `json:"minutesDuration,omitempty"`
+	Created                string                       `json:"created,omitempty"`
+	Supporting_information []FhirReference              `json:"supportingInformation,omitempty"`
+	Participant            []FhirAppointmentParticipant `json:"participant"`
+}
+
+type FhirAppointmentParticipant struct {
+	Actor  FhirReference `json:"actor"`
+	Status string        `json:"status"`
+}
+
+type FhirMedicationRequest struct {
+	Resource_type               string               `json:"resourceType"`
+	Id                          string               `json:"id,omitempty"`
+	Meta                        *FhirMeta            `json:"meta,omitempty"`
+	Extension                   []FhirExtension      `json:"extension,omitempty"`
+	Identifier                  []FhirIdentifier     `json:"identifier,omitempty"`
+	Group_identifier            *FhirIdentifier      `json:"groupIdentifier,omitempty"`
+	Status                      string               `json:"status"`
+	Intent                      string               `json:"intent"`
+	Medication_codeable_concept *FhirCodeableConcept `json:"medicationCodeableConcept,omitempty"`
+	Subject                     FhirReference        `json:"subject"`
+	Encounter                   *FhirReference       `json:"encounter,omitempty"`
+	Authored_on                 string               `json:"authoredOn,omitempty"`
+	Requester                   *FhirReference       `json:"requester,omitempty"`
+	Note                        []FhirAnnotation     `json:"note,omitempty"`
+	Dosage_instruction          []FhirDosage         `json:"dosageInstruction,omitempty"`
+	Dispense_request            *FhirDispenseRequest `json:"dispenseRequest,omitempty"`
+}
+
+type FhirDosage struct {
+	Text                string               `json:"text,omitempty"`
+	Patient_instruction string               `json:"patientInstruction,omitempty"`
+	Timing              *FhirTiming          `json:"timing,omitempty"`
+	As_needed_boolean   *bool                `json:"asNeededBoolean,omitempty"`
+	Route               *FhirCodeableConcept `json:"route,omitempty"`
+}
+
+type FhirTiming struct {
+	Code *FhirCodeableConcept `json:"code,omitempty"`
+}
+
+type FhirDispenseRequest struct {
+	Validity_period           *FhirPeriod   `json:"validityPeriod,omitempty"`
+	Number_of_repeats_allowed int           `json:"numberOfRepeatsAllowed"`
+	Quantity                  *FhirQuantity `json:"quantity,omitempty"`
+	Expected_supply_duration  *FhirQuantity `json:"expectedSupplyDuration,omitempty"`
+}
+
+type FhirInvoice struct {
+	Resource_type string           `json:"resourceType"`
+	Id            string           `json:"id,omitempty"`
+	Meta          *FhirMeta        `json:"meta,omitempty"`
+	Extension     []FhirExtension  `json:"extension,omitempty"`
+	Identifier    []FhirIdentifier `json:"identifier,omitempty"`
+	Status        string           `json:"status"`
+	Subject       *FhirReference   `json:"subject,omitempty"`
+	Date          string           `json:"date,omitempty"`
+	Total_net     *FhirMoney       `json:"totalNet,omitempty"`
+	Total_gross   *FhirMoney       `json:"totalGross,omitempty"`
+	Payment_terms string           `json:"paymentTerms,omitempty"`
+}
+
+// FhirBundle is a searchset: the page of matches, how many there are in
+// all, and the links to the neighbouring pages.
+type FhirBundle struct {
+	Resource_type string            `json:"resourceType"`
+	Type          string            `json:"type"`
+	Total         int64             `json:"total"`
+	Link          []FhirBundleLink  `json:"link"`
+	Entry         []FhirBundleEntry `json:"entry"`
+}
+
+type FhirBundleLink struct {
+	Relation string `json:"relation"`
+	Url      string `json:"url"`
+}
+
+type FhirBundleEntry struct {
+	Full_url string           `json:"fullUrl"`
+	Resource interface{}      `json:"resource"`
+	Search   FhirBundleSearch `json:"search"`
+}
+
+type FhirBundleSearch struct {
+	Mode string `json:"mode"`
+}
+
+// FhirOperationOutcome is the body of every FHIR error, and of warnings
+// that came with a success.
+type FhirOperationOutcome struct {
+	Resource_type string      `json:"resourceType"`
+	Issue         []FhirIssue `json:"issue"`
+}
+
+type FhirIssue struct {
+	Severity    string `json:"severity"`
+	Code        string `json:"code"`
+	Diagnostics string `json:"diagnostics,omitempty"`
+}
+
+type FhirCapabilityStatement struct {
+	Resource_type string               `json:"resourceType"`
+	Status        string               `json:"status"`
+	Date          string               `json:"date"`
+	Kind          string               `json:"kind"`
+	Software      FhirSoftware         `json:"software"`
+	Fhir_version  string               `json:"fhirVersion"`
+	Format        []string             `json:"format"`
+	Rest          []FhirCapabilityRest `json:"rest"`
+}
+
+type FhirSoftware struct {
+	Name string `json:"name"`
+}
+
+type FhirCapabilityRest struct {
+	Mode      string                   `json:"mode"`
+	Resource  []FhirCapabilityResource `json:"resource"`
+	Operation []FhirOperation          `json:"operation,omitempty"`
+}
+
+type FhirCapabilityResource struct {
+	Type          string            `json:"type"`
+	Interaction   []FhirInteraction `json:"interaction"`
+	Update_create bool              `json:"updateCreate"`
+	Search_param  []FhirSearchParam `json:"searchParam"`
+	Operation     []FhirOperation   `json:"operation,omitempty"`
+}
+
+type FhirInteraction struct {
+	Code string `json:"code"`
+}
+
+type FhirOperation struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+type FhirSearchParam struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
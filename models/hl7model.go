@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HL7Message is the log entry of one HL7 v2 message sent or received over
+// the interface. Outbound entries double as the delivery outbox. Inbound
+// entries are RECEIVED, then PROCESSED, ERROR, REJECTED or DUPLICATE;
+// outbound ones are PENDING, RETRYING, SENT or FAILED. Sender is the
+// application^facility of MSH-3/4 and Peer the network address.
+type HL7Message struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Message_id      string             `json:"message_id"`
+	Direction       string             `json:"direction"`
+	Message_type    string             `json:"message_type"`
+	Control_id      string             `json:"control_id"`
+	Sender          string             `json:"sender"`
+	Peer            string             `json:"peer"`
+	Raw             string             `json:"raw"`
+	Status          string             `json:"status"`
+	Error           string             `json:"error"`
+	Ack_code        string             `json:"ack_code"`
+	Ack             string             `json:"ack"`
+	Attempts        int                `json:"attempts"`
+	Next_attempt_at time.Time          `json:"next_attempt_at"`
+	Patient_id      string             `json:"patient_id"`
+	Reference_id    string             `json:"reference_id"`
+	Replay_of       *string            `json:"replay_of"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DrugInteraction is one row of the interaction table. Each side is a
+// formulary code or an ATC class written "atc:" and a code prefix.
+type DrugInteraction struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Drug_a      string             `json:"drug_a" validate:"required,max=50"`
+	Drug_b      string             `json:"drug_b" validate:"required,max=50"`
+	Severity    string             `json:"severity" validate:"required,eq=MINOR|eq=MODERATE|eq=MAJOR|eq=CONTRAINDICATED"`
+	Description *string            `json:"description" validate:"required,max=2000"`
+	Management  *string            `json:"management" validate:"omitempty,max=2000"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+}
+
+// ClinicalWarning is one finding of the prescribing checks. MAJOR and
+// CONTRAINDICATED warnings block the prescription unless overridden.
+type ClinicalWarning struct {
+	Kind                  string   `json:"kind"`
+	Severity              string   `json:"severity"`
+	Item_ids              []string `json:"item_ids"`
+	Drug_codes            []string `json:"drug_codes"`
+	Other_prescription_id string   `json:"other_prescription_id,omitempty"`
+	Allergy_id            string   `json:"allergy_id,omitempty"`
+	Message               string   `json:"message"`
+	Management            string   `json:"management,omitempty"`
+}
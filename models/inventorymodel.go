@@ -0,0 +1,112 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StockItem is something the hospital keeps in stock: a formulary drug,
+// counted in the units it is prescribed in, or a consumable.
+type StockItem struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	Item_id          string             `json:"item_id"`
+	Code             *string            `json:"code" validate:"required,min=2,max=40"`
+	Name             *string            `json:"name" validate:"required,min=2,max=200"`
+	Kind             *string            `json:"kind" validate:"required,eq=DRUG|eq=CONSUMABLE"`
+	Drug_code        *string            `json:"drug_code" validate:"required_if=Kind DRUG,omitempty,max=50"`
+	Unit             *string            `json:"unit" validate:"required,max=20"`
+	Reorder_level    float64            `json:"reorder_level" validate:"min=0"`
+	Reorder_quantity float64            `json:"reorder_quantity" validate:"min=0"`
+	Active           *bool              `json:"active"`
+	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
+}
+
+// StockItemChange is the body accepted when a stock item is updated.
+type StockItemChange struct {
+	Name             *string  `json:"name" validate:"omitempty,min=2,max=200"`
+	Unit             *string  `json:"unit" validate:"omitempty,max=20"`
+	Reorder_level    *float64 `json:"reorder_level" validate:"omitempty,min=0"`
+	Reorder_quantity *float64 `json:"reorder_quantity" validate:"omitempty,min=0"`
+	Active           *bool    `json:"active"`
+}
+
+// StockLocation is a store, pharmacy or ward cupboard holding stock. The
+// pharmacy dispenses prescriptions from locations marked Dispensing.
+type StockLocation struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Location_id string             `json:"location_id"`
+	Code        *string            `json:"code" validate:"required,min=2,max=40"`
+	Name        *string            `json:"name" validate:"required,min=2,max=100"`
+	Kind        *string            `json:"kind" validate:"required,eq=STORE|eq=PHARMACY|eq=WARD"`
+	Dispensing  bool               `json:"dispensing"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+}
+
+// StockMovement is one entry of the stock ledger. Entries are only ever
+// added: on-hand stock is the sum of the quantities of an item's entries at a
+// location and batch, and mistakes are corrected by adjustments.
+type StockMovement struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Movement_id string             `json:"movement_id"`
+	Item_id     string             `json:"item_id"`
+	Location_id string             `json:"location_id"`
+	Batch       string             `json:"batch"`
+	Expiry_date time.Time          `json:"expiry_date"`
+	Quantity    float64            `json:"quantity"`
+	Kind        string             `json:"kind"`
+	Reason      *string            `json:"reason"`
+	Note        *string            `json:"note"`
+	Reference   string             `json:"reference"`
+	Recorded_by string             `json:"recorded_by"`
+	Recorded_at time.Time          `json:"recorded_at"`
+}
+
+// StockBalance is the on-hand quantity of one batch at one location.
+type StockBalance struct {
+	Item_id     string    `json:"item_id"`
+	Location_id string    `json:"location_id"`
+	Batch       string    `json:"batch"`
+	Expiry_date time.Time `json:"expiry_date"`
+	On_hand     float64   `json:"on_hand"`
+	Expired     bool      `json:"expired"`
+}
+
+// GoodsReceipt is the body accepted when a delivery is put into stock.
+type GoodsReceipt struct {
+	Location_id *string       `json:"location_id" validate:"required"`
+	Supplier    *string       `json:"supplier" validate:"omitempty,max=200"`
+	Reference   *string       `json:"reference" validate:"omitempty,max=100"`
+	Lines       []ReceiptLine `json:"lines" validate:"required,min=1,max=200,dive"`
+}
+
+type ReceiptLine struct {
+	Item_id     string     `json:"item_id" validate:"required"`
+	Batch       string     `json:"batch" validate:"required,max=50"`
+	Expiry_date *time.Time `json:"expiry_date" validate:"required"`
+	Quantity    float64    `json:"quantity" validate:"gt=0"`
+}
+
+// StockTransfer is the body accepted when stock moves between locations.
+// Without a batch the stock is picked first-expiry-first-out.
+type StockTransfer struct {
+	Item_id          string  `json:"item_id" validate:"required"`
+	From_location_id string  `json:"from_location_id" validate:"required"`
+	To_location_id   string  `json:"to_location_id" validate:"required,nefield=From_location_id"`
+	Batch            *string `json:"batch" validate:"omitempty,max=50"`
+	Quantity         float64 `json:"quantity" validate:"gt=0"`
+	Reason           *string `json:"reason" validate:"omitempty,max=500"`
+}
+
+// StockAdjustment is the body accepted when stock is corrected; a negative
+// quantity takes stock away.
+type StockAdjustment struct {
+	Item_id     string  `json:"item_id" validate:"required"`
+	Location_id string  `json:"location_id" validate:"required"`
+	Batch       string  `json:"batch" validate:"required,max=50"`
+	Quantity    float64 `json:"quantity" validate:"required"`
+	Reason      *string `json:"reason" validate:"required,eq=COUNT_CORRECTION|eq=DAMAGED|eq=EXPIRED|eq=LOST|eq=RETURNED|eq=OTHER"`
+	Note        *string `json:"note" validate:"omitempty,max=500"`
+}
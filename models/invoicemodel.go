@@ -9,11 +9,12 @@ import (
 type Invoice struct {
 	ID               primitive.ObjectID `bson:"_id"`
 	Invoice_id       string             `json:"invoice_id"`
-	Appointment_id   string       `            json:"Appointment_id"`
-	Prescription_id   string            `json:"Prescription_id"`
+	Appointment_id   string             `json:"Appointment_id"`
+	Prescription_id  string             `json:"Prescription_id"`
+	Amount           *float64           `json:"amount" validate:"omitempty,min=0"`
 	Payment_method   *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
 	Payment_status   *string            `json:"payment_status" validate:"required,eq=PENDING|eq=PAID"`
 	Payment_due_date time.Time          `json:"Payment_due_date"`
 	Created_at       time.Time          `json:"created_at"`
 	Updated_at       time.Time          `json:"updated_at"`
-}
\ No newline at end of file
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LabTest is an entry of the laboratory test catalog. Numeric tests carry the
+// unit results are entered in and the reference range they are flagged
+// against; either bound of a range may be left out.
+type LabTest struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	Test_id          string             `json:"test_id"`
+	Code             *string            `json:"code" validate:"required,min=2,max=20"`
+	Name             *string            `json:"name" validate:"required,min=2,max=200"`
+	Specimen_type    *string            `json:"specimen_type" validate:"required,eq=BLOOD|eq=SERUM|eq=PLASMA|eq=URINE|eq=STOOL|eq=CSF|eq=SWAB|eq=SPUTUM|eq=TISSUE|eq=OTHER"`
+	Result_type      *string            `json:"result_type" validate:"required,eq=NUMERIC|eq=TEXT"`
+	Unit             *string            `json:"unit" validate:"omitempty,max=20"`
+	Reference_low    *float64           `json:"reference_low"`
+	Reference_high   *float64           `json:"reference_high"`
+	Critical_low     *float64           `json:"critical_low"`
+	Critical_high    *float64           `json:"critical_high"`
+	Turnaround_hours *int               `json:"turnaround_hours" validate:"omitempty,min=1,max=2000"`
+	Active           *bool              `json:"active"`
+	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
+}
+
+// LabTestChange is the body accepted when a catalog entry is edited. The
+// code, specimen type and result type cannot be changed.
+type LabTestChange struct {
+	Name             *string  `json:"name" validate:"omitempty,min=2,max=200"`
+	Unit             *string  `json:"unit" validate:"omitempty,max=20"`
+	Reference_low    *float64 `json:"reference_low"`
+	Reference_high   *float64 `json:"reference_high"`
+	Critical_low     *float64 `json:"critical_low"`
+	Critical_high    *float64 `json:"critical_high"`
+	Turnaround_hours *int     `json:"turnaround_hours" validate:"omitempty,min=1,max=2000"`
+	Active           *bool    `json:"active"`
+}
+
+// LabOrder is a doctor's request for laboratory tests on a patient. A
+// specimen is collected for each specimen type the tests need; results are
+// kept as LabResult records, one per test. Status is derived from the
+// specimens and results: ORDERED, COLLECTED, RECEIVED, PARTIALLY_RESULTED,
+// COMPLETED, or CANCELLED.
+type LabOrder struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Order_id       string             `json:"order_id"`
+	Patient_id     *string            `json:"patient_id" validate:"required"`
+	Doctor_id      *string            `json:"doctor_id" validate:"required"`
+	Appointment_id *string            `json:"appointment_id"`
+	Encounter_id   *string            `json:"encounter_id"`
+	Priority       *string            `json:"priority" validate:"omitempty,eq=ROUTINE|eq=URGENT|eq=STAT"`
+	Clinical_notes *string            `json:"clinical_notes" validate:"omitempty,max=2000"`
+	Tests          []LabOrderTest     `json:"tests" validate:"required,min=1,max=50,dive"`
+	Specimens      []Specimen         `json:"specimens"`
+	Status         string             `json:"status"`
+	Cancel_reason  *string            `json:"cancel_reason"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+}
+
+// LabOrderTest is one test of an order. The name and specimen type are
+// filled in from the catalog; Result_id and Result_status follow the result
+// once one is entered.
+type LabOrderTest struct {
+	Line_id       string  `json:"line_id"`
+	Test_code     *string `json:"test_code" validate:"required,max=20"`
+	Test_name     *string `json:"test_name"`
+	Specimen_type *string `json:"specimen_type"`
+	Result_id     *string `json:"result_id"`
+	Result_status *string `json:"result_status"`
+}
+
+// Specimen tracks the sample of one specimen type from collection on the
+// ward to receipt in the laboratory. Status is PENDING, COLLECTED or
+// RECEIVED.
+type Specimen struct {
+	Specimen_id   string     `json:"specimen_id"`
+	Specimen_type string     `json:"specimen_type"`
+	Status        string     `json:"status"`
+	Label         *string    `json:"label"`
+	Collected_by  *string    `json:"collected_by"`
+	Collected_at  *time.Time `json:"collected_at"`
+	Received_by   *string    `json:"received_by"`
+	Received_at   *time.Time `json:"received_at"`
+}
+
+// SpecimenEvent is the body accepted when specimens are collected or
+// received. Without specimen types it applies to every specimen of the order
+// that is at the previous step.
+type SpecimenEvent struct {
+	Specimen_types []string   `json:"specimen_types" validate:"omitempty,dive,eq=BLOOD|eq=SERUM|eq=PLASMA|eq=URINE|eq=STOOL|eq=CSF|eq=SWAB|eq=SPUTUM|eq=TISSUE|eq=OTHER"`
+	Label          *string    `json:"label" validate:"omitempty,max=100"`
+	At             *time.Time `json:"at"`
+}
+
+type LabOrderCancellation struct {
+	Reason *string `json:"reason" validate:"required,min=3,max=500"`
+}
+
+// LabResult is the result of one test of an order. A PRELIMINARY result can
+// be re-entered; once FINAL it is only changed by a correction, which keeps
+// the replaced values in Revisions, makes it CORRECTED and sends it back to
+// the doctor for review.
+type LabResult struct {
+	ID             primitive.ObjectID  `bson:"_id"`
+	Result_id      string              `json:"result_id"`
+	Order_id       string              `json:"order_id"`
+	Line_id        string              `json:"line_id"`
+	Patient_id     string              `json:"patient_id"`
+	Doctor_id      string              `json:"doctor_id"`
+	Test_code      string              `json:"test_code"`
+	Test_name      string              `json:"test_name"`
+	Value_numeric  *float64            `json:"value_numeric"`
+	Value_text     *string             `json:"value_text"`
+	Unit           *string             `json:"unit"`
+	Reference_low  *float64            `json:"reference_low"`
+	Reference_high *float64            `json:"reference_high"`
+	Flag           *string             `json:"flag"`
+	Comment        *string             `json:"comment"`
+	Status         string              `json:"status"`
+	Performed_by   string              `json:"performed_by"`
+	Resulted_at    time.Time           `json:"resulted_at"`
+	Reviewed_by    *string             `json:"reviewed_by"`
+	Reviewed_at    *time.Time          `json:"reviewed_at"`
+	Revisions      []LabResultRevision `json:"revisions"`
+	Created_at     time.Time           `json:"created_at"`
+	Updated_at     time.Time           `json:"updated_at"`
+}
+
+// LabResultRevision is a value a correction replaced, with why.
+type LabResultRevision struct {
+	Value_numeric *float64  `json:"value_numeric"`
+	Value_text    *string   `json:"value_text"`
+	Flag          *string   `json:"flag"`
+	Status        string    `json:"status"`
+	Resulted_at   time.Time `json:"resulted_at"`
+	Replaced_by   string    `json:"replaced_by"`
+	Replaced_at   time.Time `json:"replaced_at"`
+	Reason        string    `json:"reason"`
+}
+
+// LabResultEntry is the body accepted when the laboratory enters the result
+// of an order's test. Numeric results are given in the catalog unit.
+type LabResultEntry struct {
+	Line_id       *string  `json:"line_id" validate:"required"`
+	Value_numeric *float64 `json:"value_numeric"`
+	Value_text    *string  `json:"value_text" validate:"omitempty,max=5000"`
+	Comment       *string  `json:"comment" validate:"omitempty,max=2000"`
+	Status        *string  `json:"status" validate:"required,eq=PRELIMINARY|eq=FINAL"`
+}
+
+// LabResultCorrection is the body accepted when a released result is
+// corrected.
+type LabResultCorrection struct {
+	Value_numeric *float64 `json:"value_numeric"`
+	Value_text    *string  `json:"value_text" validate:"omitempty,max=5000"`
+	Comment       *string  `json:"comment" validate:"omitempty,max=2000"`
+	Reason        *string  `json:"reason" validate:"required,min=5,max=1000"`
+}
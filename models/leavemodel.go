@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DoctorLeave struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Leave_id   string             `json:"leave_id"`
+	Doctor_id  string             `json:"doctor_id"`
+	Start_Date *time.Time         `json:"start_date" validate:"required"`
+	End_Date   *time.Time         `json:"end_date" validate:"required,gtfield=Start_Date"`
+	Reason     *string            `json:"reason" validate:"omitempty,max=200"`
+	Status     string             `json:"status"`
+	Affected   int                `json:"affected"`
+	Created_at time.Time          `json:"created_at"`
+	Updated_at time.Time          `json:"updated_at"`
+}
+
+// Holiday closes the whole facility for one facility-local date.
+type Holiday struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Holiday_id string             `json:"holiday_id"`
+	Date       *string            `json:"date" validate:"required,datetime=2006-01-02"`
+	Name       *string            `json:"name" validate:"required,min=2,max=100"`
+	Affected   int                `json:"affected"`
+	Created_at time.Time          `json:"created_at"`
+	Updated_at time.Time          `json:"updated_at"`
+}
+
+// Reschedule tracks an appointment that lost its slot until it is moved or
+// cancelled.
+type Reschedule struct {
+	Reason      string         `json:"reason"`
+	Leave_id    string         `json:"leave_id"`
+	Holiday_id  string         `json:"holiday_id"`
+	Proposals   []SlotProposal `json:"proposals"`
+	Raised_at   time.Time      `json:"raised_at"`
+	Resolved_at *time.Time     `json:"resolved_at"`
+	Resolution  string         `json:"resolution"`
+}
+
+type SlotProposal struct {
+	Doctor_id        string    `json:"doctor_id"`
+	Doctor_name      string    `json:"doctor_name"`
+	Appointment_Date time.Time `json:"Appointment_date"`
+	Date_local       string    `json:"Appointment_date_local"`
+}
+
+// RescheduleDecision is the body accepted when resolving a flagged
+// appointment: either a new time (optionally with another doctor) or a
+// cancellation.
+type RescheduleDecision struct {
+	Appointment_Date *time.Time `json:"Appointment_date" validate:"required_without=Cancel"`
+	Doctor_id        *string    `json:"doctor_id"`
+	Cancel           bool       `json:"cancel"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification is one message to one recipient over one channel. The
+// collection doubles as the outbox the delivery job works from and as the
+// delivery log.
+type Notification struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Notification_id string             `json:"notification_id"`
+	Kind            string             `json:"kind"`
+	Reminder        string             `json:"reminder"`
+	Appointment_id  string             `json:"Appointment_id"`
+	Appointment_at  time.Time          `json:"appointment_at"`
+	Patient_id      string             `json:"Patient_id"`
+	Channel         string             `json:"channel"`
+	Recipient       string             `json:"recipient"`
+	Subject         string             `json:"subject"`
+	Body            string             `json:"body"`
+	Attachment_name string             `json:"attachment_name"`
+	Attachment_type string             `json:"attachment_type"`
+	Attachment      []byte             `json:"-"`
+	Status          string             `json:"status"`
+	Attempts        int                `json:"attempts"`
+	Last_error      string             `json:"last_error"`
+	Next_attempt_at time.Time          `json:"next_attempt_at"`
+	Sent_at         *time.Time         `json:"sent_at"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+}
+
+type NotificationPreferences struct {
+	Notification_channels []string `json:"notification_channels" validate:"required,dive,eq=EMAIL|eq=SMS|eq=WEBHOOK"`
+	Webhook_url           *string  `json:"webhook_url" validate:"omitempty,url"`
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ObservationSet is one round of bedside observations on a ward patient with
+// the NEWS2 score computed from it. Its readings are also written to the
+// vitals time-series under the same Set_id.
+type ObservationSet struct {
+	ID                  primitive.ObjectID `bson:"_id"`
+	Set_id              string             `json:"set_id"`
+	Patient_id          string             `json:"patient_id"`
+	Respiratory_rate    float64            `json:"respiratory_rate"`
+	Spo2                float64            `json:"spo2"`
+	Spo2_scale          int                `json:"spo2_scale"`
+	Supplemental_oxygen bool               `json:"supplemental_oxygen"`
+	Oxygen_flow         *float64           `json:"oxygen_flow"`
+	Systolic            float64            `json:"systolic"`
+	Diastolic           *float64           `json:"diastolic"`
+	Pulse               float64            `json:"pulse"`
+	Consciousness       string             `json:"consciousness"`
+	Temperature         float64            `json:"temperature"`
+	News2               News2Score         `json:"news2"`
+	Alert_id            *string            `json:"alert_id"`
+	Measured_at         time.Time          `json:"measured_at"`
+	Recorded_by         string             `json:"recorded_by"`
+	Created_at          time.Time          `json:"created_at"`
+}
+
+// News2Score is the NEWS2 aggregate score of a set with the score of each
+// parameter and the clinical risk it puts the patient at.
+type News2Score struct {
+	Total      int              `json:"total"`
+	Components []News2Component `json:"components"`
+	Risk       string           `json:"risk"`
+	Response   string           `json:"response"`
+}
+
+type News2Component struct {
+	Parameter string `json:"parameter"`
+	Value     string `json:"value"`
+	Score     int    `json:"score"`
+}
+
+// ObservationEntry is the body accepted when a set of observations is
+// recorded. Spo2_scale defaults to 1; scale 2 is only for patients with a
+// prescribed target saturation of 88-92%. Temperature_unit defaults to Cel.
+type ObservationEntry struct {
+	Measured_at         *time.Time `json:"measured_at"`
+	Respiratory_rate    *float64   `json:"respiratory_rate" validate:"required"`
+	Spo2                *float64   `json:"spo2" validate:"required"`
+	Spo2_scale          *int       `json:"spo2_scale" validate:"omitempty,oneof=1 2"`
+	Supplemental_oxygen *bool      `json:"supplemental_oxygen" validate:"required"`
+	Oxygen_flow         *float64   `json:"oxygen_flow" validate:"omitempty,gt=0,lte=60"`
+	Systolic            *float64   `json:"systolic" validate:"required"`
+	Diastolic           *float64   `json:"diastolic"`
+	Pulse               *float64   `json:"pulse" validate:"required"`
+	Consciousness       *string    `json:"consciousness" validate:"required,eq=ALERT|eq=NEW_CONFUSION|eq=VOICE|eq=PAIN|eq=UNRESPONSIVE"`
+	Temperature         *float64   `json:"temperature" validate:"required"`
+	Temperature_unit    *string    `json:"temperature_unit" validate:"omitempty,max=10"`
+}
+
+// EscalationAlert is raised to the patient's doctor when an observation set
+// puts the patient at a higher NEWS2 risk than the set before it. It stays
+// OPEN until a doctor acknowledges it.
+type EscalationAlert struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Alert_id        string             `json:"alert_id"`
+	Patient_id      string             `json:"patient_id"`
+	Doctor_id       string             `json:"doctor_id"`
+	Set_id          string             `json:"set_id"`
+	Risk            string             `json:"risk"`
+	Score           int                `json:"score"`
+	Reasons         []string           `json:"reasons"`
+	Response        string             `json:"response"`
+	Status          string             `json:"status"`
+	Acknowledged_by *string            `json:"acknowledged_by"`
+	Acknowledged_at *time.Time         `json:"acknowledged_at"`
+	Note            *string            `json:"note"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+}
+
+// AlertAcknowledgement is the optional body accepted when a doctor
+// acknowledges an escalation alert.
+type AlertAcknowledgement struct {
+	Note *string `json:"note" validate:"omitempty,max=1000"`
+}
@@ -7,22 +7,34 @@ import (
 )
 
 type Patient struct {
-	ID            primitive.ObjectID `bson:"_id"`
-	First_name    *string            `json:"first_name" validate:"required,min=2,max=100"`
-	Last_name     *string            `json:"last_name" validate:"required,min=2,max=100"`
-	Password      *string            `json:"Password" validate:"required,min=6"`
-	Email         *string            `json:"email" validate:"email,required"`
-	
-	Phone         *string            `json:"phone" validate:"required"`
-	Token         *string            `json:"token"`
-	Refresh_Token *string            `json:"refresh_token"`
-	Created_at    time.Time          `json:"created_at"`
-	Updated_at    time.Time          `json:"updated_at"`
-	Patient_id       string           `json:"Patient_id"`
-	Doctor_id       string             `json:"Doctor_id"`
-	Prescription_id       string       `json:"Prescription_id"`
-	Appointment_id string `json:"Appointment_id"`
-	Invoice_id       string             `json:"Invoice_id"`
+	ID         primitive.ObjectID `bson:"_id"`
+	First_name *string            `json:"first_name" validate:"required,min=2,max=100"`
+	Last_name  *string            `json:"last_name" validate:"required,min=2,max=100"`
+	Password   *string            `json:"Password" validate:"required,min=6"`
+	Email      *string            `json:"email" validate:"email,required"`
 
+	Phone                 *string             `json:"phone" validate:"required"`
+	Token                 *string             `json:"token"`
+	Refresh_Token         *string             `json:"refresh_token"`
+	Created_at            time.Time           `json:"created_at"`
+	Updated_at            time.Time           `json:"updated_at"`
+	Patient_id            string              `json:"Patient_id"`
+	Doctor_id             string              `json:"Doctor_id"`
+	Prescription_id       string              `json:"Prescription_id"`
+	Appointment_id        string              `json:"Appointment_id"`
+	Invoice_id            string              `json:"Invoice_id"`
+	Date_of_birth         *time.Time          `json:"date_of_birth"`
+	Identifiers           []PatientIdentifier `json:"identifiers" validate:"omitempty,max=20,dive"`
+	Notification_channels []string            `json:"notification_channels" validate:"omitempty,dive,eq=EMAIL|eq=SMS|eq=WEBHOOK"`
+	Webhook_url           *string             `json:"webhook_url" validate:"omitempty,url"`
+	Calendar_token        *string             `json:"-"`
+	Allergy_assessment    *AllergyAssessment  `json:"-"`
+}
 
-}
\ No newline at end of file
+// PatientIdentifier is the patient's number in another system, such as the
+// medical record number the HIS sends in HL7 messages. System is the
+// assigning authority.
+type PatientIdentifier struct {
+	System *string `json:"system" validate:"required,max=100"`
+	Value  *string `json:"value" validate:"required,max=100"`
+}
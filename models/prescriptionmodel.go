@@ -1,4 +1,3 @@
-
 package models
 
 import (
@@ -7,15 +6,71 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Prescription is one medication order of a doctor for a patient, made of one
+// or more line items. Diagnosis_ids are the patient's diagnoses it treats.
 type Prescription struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Drugs       string             `json:"name" validate:"required"`
-	Dosage      string             `json:"category" validate:"required"`
-	Start_Date *time.Time         `json:"start_date"`
-	End_Date   *time.Time         `json:"end_date"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Prescription_id    string             `json:"food_id"`
-	Doctor_id    string             `json:"doctor_id"`
-
-}
\ No newline at end of file
+	ID              primitive.ObjectID `bson:"_id"`
+	Prescription_id string             `json:"prescription_id"`
+	Patient_id      *string            `json:"patient_id" validate:"required"`
+	Doctor_id       *string            `json:"doctor_id" validate:"required"`
+	Appointment_id  *string            `json:"appointment_id"`
+	Encounter_id    *string            `json:"encounter_id"`
+	Diagnosis_ids   []string           `json:"diagnosis_ids" validate:"omitempty,max=20"`
+	Items           []MedicationItem   `json:"items" validate:"required,min=1,max=50,dive"`
+	Notes           *string            `json:"notes" validate:"omitempty,max=2000"`
+	Status          string             `json:"status"`
+	Start_Date      *time.Time         `json:"start_date"`
+	End_Date        *time.Time         `json:"end_date"`
+	Warnings        []ClinicalWarning  `json:"warnings"`
+	Override_reason *string            `json:"override_reason" validate:"omitempty,min=10,max=1000"`
+	Overridden_at   *time.Time         `json:"overridden_at"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+
+	// Drugs and Dosage are the free-text fields of prescriptions written
+	// before line items existed; they are read once and turned into an item.
+	Drugs  string `json:"-" bson:"drugs,omitempty"`
+	Dosage string `json:"-" bson:"dosage,omitempty"`
+}
+
+// MedicationItem is one drug on a prescription. Drug_code is the formulary
+// entry; the name and controlled-substance schedule are filled in from it.
+type MedicationItem struct {
+	Item_id       string  `json:"item_id"`
+	Drug_code     *string `json:"drug_code" validate:"required,max=50"`
+	Drug_name     *string `json:"drug_name" validate:"omitempty,max=200"`
+	Schedule      *string `json:"schedule"`
+	Strength      *string `json:"strength" validate:"required,max=50"`
+	Form          *string `json:"form" validate:"required,eq=TABLET|eq=CAPSULE|eq=SYRUP|eq=SUSPENSION|eq=INJECTION|eq=CREAM|eq=OINTMENT|eq=DROPS|eq=INHALER|eq=PATCH|eq=OTHER"`
+	Route         *string `json:"route" validate:"required,eq=ORAL|eq=IV|eq=IM|eq=SC|eq=TOPICAL|eq=INHALED|eq=SUBLINGUAL|eq=RECTAL|eq=OPHTHALMIC|eq=OTIC|eq=NASAL|eq=OTHER"`
+	Frequency     *string `json:"frequency" validate:"required,max=50"`
+	As_needed     bool    `json:"as_needed"`
+	Duration_days int     `json:"duration_days" validate:"omitempty,min=1,max=365"`
+	Quantity      float64 `json:"quantity" validate:"gt=0"`
+	Quantity_unit *string `json:"quantity_unit" validate:"omitempty,max=20"`
+	Refills       int     `json:"refills" validate:"min=0,max=12"`
+	Instructions  *string `json:"instructions" validate:"omitempty,max=1000"`
+
+	// Dispensing state, kept by the pharmacy endpoints. Fills counts the
+	// fills started, the first one included; Fill_dispensed is how much of
+	// the current fill has been handed out.
+	Fills             int     `json:"fills"`
+	Fill_dispensed    float64 `json:"fill_dispensed"`
+	Refills_remaining int     `json:"refills_remaining"`
+	Refill_due        bool    `json:"refill_due"`
+}
+
+// PrescriptionChange is the body accepted when updating a prescription.
+// Items, when given, replace the whole list and are checked again.
+type PrescriptionChange struct {
+	Items      []MedicationItem `json:"items" validate:"omitempty,min=1,max=50,dive"`
+	Notes      *string          `json:"notes" validate:"omitempty,max=2000"`
+	Status     *string          `json:"status" validate:"omitempty,eq=ACTIVE|eq=CANCELLED"`
+	Start_Date *time.Time       `json:"start_date"`
+	End_Date   *time.Time       `json:"end_date"`
+
+	// Diagnosis_ids, when given, replace the reasons for the prescription.
+	Diagnosis_ids []string `json:"diagnosis_ids" validate:"omitempty,max=20"`
+
+	Override_reason *string `json:"override_reason" validate:"omitempty,min=10,max=1000"`
+}
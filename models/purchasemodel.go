@@ -0,0 +1,130 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Supplier struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Supplier_id    string             `json:"supplier_id"`
+	Code           *string            `json:"code" validate:"required,min=2,max=40"`
+	Name           *string            `json:"name" validate:"required,min=2,max=200"`
+	Contact_name   *string            `json:"contact_name" validate:"omitempty,max=100"`
+	Email          *string            `json:"email" validate:"omitempty,email"`
+	Phone          *string            `json:"phone" validate:"omitempty,max=30"`
+	Address        *string            `json:"address" validate:"omitempty,max=500"`
+	Lead_time_days int                `json:"lead_time_days" validate:"min=0,max=365"`
+	Active         *bool              `json:"active"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+}
+
+// SupplierChange is the body accepted when a supplier is updated.
+type SupplierChange struct {
+	Name           *string `json:"name" validate:"omitempty,min=2,max=200"`
+	Contact_name   *string `json:"contact_name" validate:"omitempty,max=100"`
+	Email          *string `json:"email" validate:"omitempty,email"`
+	Phone          *string `json:"phone" validate:"omitempty,max=30"`
+	Address        *string `json:"address" validate:"omitempty,max=500"`
+	Lead_time_days *int    `json:"lead_time_days" validate:"omitempty,min=0,max=365"`
+	Active         *bool   `json:"active"`
+}
+
+// SupplierPrice is one price a supplier was paid for an item, kept when a
+// purchase order is approved.
+type SupplierPrice struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Supplier_id string             `json:"supplier_id"`
+	Item_id     string             `json:"item_id"`
+	Unit_price  float64            `json:"unit_price"`
+	Po_id       string             `json:"po_id"`
+	Recorded_at time.Time          `json:"recorded_at"`
+}
+
+// PurchaseOrder is an order to a supplier for delivery to a stock location.
+// It moves DRAFT -> APPROVED -> SENT -> PARTIALLY_RECEIVED -> CLOSED, and can
+// be closed early from any state. As a payable it is owed for what has been
+// received, which is separate from the patient invoices.
+type PurchaseOrder struct {
+	ID             primitive.ObjectID  `bson:"_id"`
+	Po_id          string              `json:"po_id"`
+	Po_number      string              `json:"po_number"`
+	Supplier_id    *string             `json:"supplier_id" validate:"required"`
+	Location_id    *string             `json:"location_id" validate:"required"`
+	Lines          []PurchaseOrderLine `json:"lines" validate:"required,min=1,max=200,dive"`
+	Notes          *string             `json:"notes" validate:"omitempty,max=2000"`
+	Source         string              `json:"source"`
+	Status         string              `json:"status"`
+	Total          float64             `json:"total"`
+	Created_by     string              `json:"created_by"`
+	Approved_by    *string             `json:"approved_by"`
+	Approved_at    *time.Time          `json:"approved_at"`
+	Sent_at        *time.Time          `json:"sent_at"`
+	Closed_at      *time.Time          `json:"closed_at"`
+	Close_reason   *string             `json:"close_reason"`
+	Amount_due     float64             `json:"amount_due"`
+	Amount_paid    float64             `json:"amount_paid"`
+	Payment_status string              `json:"payment_status"`
+	Payments       []PoPayment         `json:"payments"`
+	Created_at     time.Time           `json:"created_at"`
+	Updated_at     time.Time           `json:"updated_at"`
+}
+
+// PurchaseOrderLine is one item ordered. Received counts what has arrived,
+// which may be more than ordered when an over-delivery was accepted.
+type PurchaseOrderLine struct {
+	Line_id    string  `json:"line_id"`
+	Item_id    string  `json:"item_id" validate:"required"`
+	Quantity   float64 `json:"quantity" validate:"gt=0"`
+	Unit_price float64 `json:"unit_price" validate:"min=0"`
+	Received   float64 `json:"received"`
+}
+
+// PurchaseOrderChange is the body accepted when a draft order is edited.
+type PurchaseOrderChange struct {
+	Location_id *string             `json:"location_id"`
+	Lines       []PurchaseOrderLine `json:"lines" validate:"omitempty,min=1,max=200,dive"`
+	Notes       *string             `json:"notes" validate:"omitempty,max=2000"`
+}
+
+// ReorderOrder is the body accepted when a draft order is made from the
+// reorder alerts. Without item_ids every alerted item is ordered.
+type ReorderOrder struct {
+	Supplier_id *string  `json:"supplier_id" validate:"required"`
+	Location_id *string  `json:"location_id" validate:"required"`
+	Item_ids    []string `json:"item_ids"`
+}
+
+// PoReceipt is the body accepted when a delivery against an order arrives.
+// Quantities beyond what is still expected, and beyond the tolerance, are
+// refused unless Accept_over_delivery is set; Close ends the order even if
+// it was under-delivered.
+type PoReceipt struct {
+	Reference            *string         `json:"reference" validate:"omitempty,max=100"`
+	Lines                []PoReceiptLine `json:"lines" validate:"required,min=1,max=200,dive"`
+	Accept_over_delivery bool            `json:"accept_over_delivery"`
+	Close                bool            `json:"close"`
+}
+
+type PoReceiptLine struct {
+	Line_id     string     `json:"line_id" validate:"required"`
+	Batch       string     `json:"batch" validate:"required,max=50"`
+	Expiry_date *time.Time `json:"expiry_date" validate:"required"`
+	Quantity    float64    `json:"quantity" validate:"gt=0"`
+}
+
+// PoClose is the body accepted when an order is closed before it is fully
+// received.
+type PoClose struct {
+	Reason *string `json:"reason" validate:"required,max=1000"`
+}
+
+type PoPayment struct {
+	Amount      float64   `json:"amount" validate:"gt=0"`
+	Reference   *string   `json:"reference" validate:"omitempty,max=100"`
+	Method      *string   `json:"method" validate:"omitempty,eq=BANK_TRANSFER|eq=CHEQUE|eq=CARD|eq=CASH"`
+	Recorded_by string    `json:"recorded_by"`
+	Paid_at     time.Time `json:"paid_at"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QueueEntry is one walk-in token. A queue is either a doctor's or a
+// department's for one facility-local day.
+type QueueEntry struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Entry_id       string             `json:"entry_id"`
+	Queue_date     string             `json:"queue_date"`
+	Doctor_id      *string            `json:"doctor_id" validate:"required_without=Department"`
+	Department     *string            `json:"department" validate:"required_without=Doctor_id"`
+	Patient_id     *string            `json:"patient_id" validate:"required"`
+	Token_number   int                `json:"token_number"`
+	Status         string             `json:"status"`
+	Recalls        int                `json:"recalls"`
+	Issued_at      time.Time          `json:"issued_at"`
+	Called_at      *time.Time         `json:"called_at"`
+	Served_at      *time.Time         `json:"served_at"`
+	Appointment_id string             `json:"Appointment_id"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+}
+
+// QueueRef names a queue in request bodies.
+type QueueRef struct {
+	Doctor_id  *string `json:"doctor_id" validate:"required_without=Department"`
+	Department *string `json:"department" validate:"required_without=Doctor_id"`
+}
+
+// QueueServe is the body accepted when marking a token served.
+type QueueServe struct {
+	Create_appointment bool    `json:"create_appointment"`
+	Invoice_id         *string `json:"Invoice_id"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Referral hands a patient from one doctor to another doctor or to any doctor
+// of a speciality. It moves SENT -> ACCEPTED or DECLINED, ACCEPTED -> BOOKED
+// through BOOKING while the appointment is made, and any open state -> CLOSED.
+type Referral struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	Referral_id      string             `json:"referral_id"`
+	From_doctor_id   *string            `json:"from_doctor_id" validate:"required"`
+	To_doctor_id     *string            `json:"to_doctor_id" validate:"required_without=To_speciality"`
+	To_speciality    *string            `json:"to_speciality" validate:"required_without=To_doctor_id"`
+	Patient_id       *string            `json:"patient_id" validate:"required"`
+	Reason           *string            `json:"reason" validate:"required,max=2000"`
+	Urgency          *string            `json:"urgency" validate:"omitempty,eq=ROUTINE|eq=URGENT|eq=EMERGENCY"`
+	Notes            *string            `json:"notes" validate:"omitempty,max=10000"`
+	Prescription_ids []string           `json:"prescription_ids"`
+	Type_id          *string            `json:"type_id"`
+	Status           string             `json:"status"`
+	Accepted_by      *string            `json:"accepted_by"`
+	Accepted_at      *time.Time         `json:"accepted_at"`
+	Decline_reason   *string            `json:"decline_reason"`
+	Appointment_id   string             `json:"Appointment_id"`
+	Closed_at        *time.Time         `json:"closed_at"`
+	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
+}
+
+// ReferralDecision is the body accepted when the receiving side declines a
+// referral.
+type ReferralDecision struct {
+	Reason *string `json:"reason" validate:"omitempty,max=2000"`
+}
+
+// ReferralBooking is the body accepted when an accepted referral is turned
+// into an appointment. Without a date the first free slot is taken.
+type ReferralBooking struct {
+	Appointment_Date *time.Time `json:"Appointment_date"`
+	Duration_minutes int        `json:"duration_minutes" validate:"omitempty,min=5,max=480"`
+	Invoice_id       *string    `json:"Invoice_id" validate:"required"`
+	Resource_ids     []string   `json:"resource_ids"`
+}
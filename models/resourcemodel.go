@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Resource is a room or piece of equipment that appointments reserve along
+// with the doctor. Capacity is how many appointments may hold it at once.
+type Resource struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Resource_id  string             `json:"resource_id"`
+	Name         *string            `json:"name" validate:"required,min=2,max=100"`
+	Kind         *string            `json:"kind" validate:"required,eq=ROOM|eq=EQUIPMENT"`
+	Location     *string            `json:"location"`
+	Capacity     int                `json:"capacity" validate:"omitempty,min=1"`
+	Status       *string            `json:"status" validate:"omitempty,eq=AVAILABLE|eq=OUT_OF_SERVICE"`
+	Availability []ResourceHours    `json:"availability" validate:"omitempty,dive"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+}
+
+// ResourceHours is a weekly opening window in facility-local time. A resource
+// without any is bookable around the clock.
+type ResourceHours struct {
+	Weekday int    `json:"weekday" validate:"min=0,max=6"`
+	Opens   string `json:"opens" validate:"required,datetime=15:04"`
+	Closes  string `json:"closes" validate:"required,datetime=15:04"`
+}
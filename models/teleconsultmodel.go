@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Teleconsult is the video consultation room of an appointment.
+type Teleconsult struct {
+	Room_id    string                  `json:"room_id"`
+	Status     string                  `json:"status" validate:"eq=OPEN|eq=CLOSED"`
+	Opened_at  time.Time               `json:"opened_at"`
+	Closed_at  *time.Time              `json:"closed_at"`
+	Attendance []TeleconsultAttendance `json:"attendance"`
+}
+
+// TeleconsultAttendance is one connection of a participant to the room.
+// Admitted_at stays empty while a patient is in the waiting room.
+type TeleconsultAttendance struct {
+	Participant_id string     `json:"participant_id"`
+	Role           string     `json:"role"`
+	Joined_at      time.Time  `json:"joined_at"`
+	Admitted_at    *time.Time `json:"admitted_at"`
+	Left_at        *time.Time `json:"left_at"`
+}
+
+// JoinRequest is the body accepted when asking for a join token. The role
+// follows from who asks; when given it must be that role.
+type JoinRequest struct {
+	Role string `json:"role" validate:"omitempty,eq=DOCTOR|eq=PATIENT"`
+}
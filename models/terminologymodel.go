@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CodeSet is one version of a code system loaded from a file. Diagnoses are
+// coded against the current version of their system; the older versions
+// stay so codes recorded against them can still be looked up.
+type CodeSet struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Code_set_id   string             `json:"code_set_id"`
+	System        string             `json:"system"`
+	Version       string             `json:"version"`
+	Source        string             `json:"source"`
+	Concept_count int64              `json:"concept_count"`
+	Current       bool               `json:"current"`
+	Created_at    time.Time          `json:"created_at"`
+	Updated_at    time.Time          `json:"updated_at"`
+}
+
+// Concept is one code of a code set version.
+type Concept struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	System       string             `json:"system"`
+	Version      string             `json:"version"`
+	Code         string             `json:"code"`
+	Display      string             `json:"display"`
+	Code_key     string             `json:"-"`
+	Search_words []string           `json:"-"`
+	Import_id    string             `json:"-"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+}
+
+// Diagnosis is a coded condition of a patient, made at an appointment or
+// carried over from elsewhere. The code is recorded with the version of the
+// code set it was chosen from and its display at the time, so later versions
+// do not change what was recorded. Status is ACTIVE, RESOLVED or
+// ENTERED_IN_ERROR.
+type Diagnosis struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Diagnosis_id   string             `json:"diagnosis_id"`
+	Patient_id     *string            `json:"patient_id" validate:"required"`
+	Appointment_id *string            `json:"appointment_id"`
+	System         *string            `json:"system" validate:"omitempty,eq=ICD10|eq=SNOMED"`
+	Version        string             `json:"version"`
+	Code           *string            `json:"code" validate:"required,max=20"`
+	Display        string             `json:"display"`
+	Certainty      *string            `json:"certainty" validate:"omitempty,eq=CONFIRMED|eq=PROVISIONAL|eq=DIFFERENTIAL|eq=RULED_OUT"`
+	Primary        bool               `json:"primary"`
+	Status         string             `json:"status"`
+	Onset_date     *time.Time         `json:"onset_date"`
+	Resolved_at    *time.Time         `json:"resolved_at"`
+	Notes          *string            `json:"notes" validate:"omitempty,max=2000"`
+	Recorded_by    string             `json:"recorded_by"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+}
+
+// DiagnosisChange is the body accepted when a diagnosis is edited. A new
+// code is taken from the current version of the diagnosis's system.
+type DiagnosisChange struct {
+	Code       *string    `json:"code" validate:"omitempty,max=20"`
+	Certainty  *string    `json:"certainty" validate:"omitempty,eq=CONFIRMED|eq=PROVISIONAL|eq=DIFFERENTIAL|eq=RULED_OUT"`
+	Primary    *bool      `json:"primary"`
+	Status     *string    `json:"status" validate:"omitempty,eq=ACTIVE|eq=RESOLVED|eq=ENTERED_IN_ERROR"`
+	Onset_date *time.Time `json:"onset_date"`
+	Notes      *string    `json:"notes" validate:"omitempty,max=2000"`
+}
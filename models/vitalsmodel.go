@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Vital is one reading of the patient's vitals time-series, stored in the
+// canonical unit of its type with the flag it got against the reference
+// range for the patient's age. Readings taken together share a Set_id.
+type Vital struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Vital_id       string             `json:"vital_id"`
+	Set_id         string             `json:"set_id"`
+	Patient_id     string             `json:"patient_id"`
+	Encounter_id   *string            `json:"encounter_id"`
+	Type           string             `json:"type"`
+	Value          float64            `json:"value"`
+	Unit           string             `json:"unit"`
+	Original_value *float64           `json:"original_value"`
+	Original_unit  *string            `json:"original_unit"`
+	Flag           *string            `json:"flag"`
+	Reference_low  *float64           `json:"reference_low"`
+	Reference_high *float64           `json:"reference_high"`
+	Measured_at    time.Time          `json:"measured_at"`
+	Recorded_by    string             `json:"recorded_by"`
+	Created_at     time.Time          `json:"created_at"`
+}
+
+// VitalsEntry is the body accepted when a nurse records a set of vitals.
+// BMI is not entered; it is computed from the weight and the height of the
+// set or, failing that, the latest height on record.
+type VitalsEntry struct {
+	Measured_at  *time.Time     `json:"measured_at"`
+	Encounter_id *string        `json:"encounter_id"`
+	Readings     []VitalReading `json:"readings" validate:"required,min=1,max=20,dive"`
+}
+
+// VitalReading is one measurement of a VitalsEntry. An empty unit means the
+// canonical unit of the type.
+type VitalReading struct {
+	Type  *string  `json:"type" validate:"required,eq=BP_SYSTOLIC|eq=BP_DIASTOLIC|eq=PULSE|eq=TEMPERATURE|eq=SPO2|eq=RESPIRATORY_RATE|eq=WEIGHT|eq=HEIGHT"`
+	Value *float64 `json:"value" validate:"required"`
+	Unit  *string  `json:"unit" validate:"omitempty,max=20"`
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AllergyRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/patients/:patient_id/allergies", controller.GetPatientAllergies())
+	incomingRoutes.POST("/patients/:patient_id/allergies", controller.CreateAllergy())
+	incomingRoutes.PUT("/patients/:patient_id/allergies/no-known", controller.RecordNoKnownAllergies())
+	incomingRoutes.GET("/allergies/:allergy_id", controller.GetAllergy())
+	incomingRoutes.PATCH("/allergies/:allergy_id", controller.UpdateAllergy())
+	incomingRoutes.DELETE("/allergies/:allergy_id", controller.DeleteAllergy())
+}
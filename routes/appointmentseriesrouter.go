@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AppointmentSeriesRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/appointment-series", controller.CreateAppointmentSeries())
+	incomingRoutes.GET("/appointment-series/:series_id", controller.GetAppointmentSeries())
+	incomingRoutes.PATCH("/appointment-series/:series_id", controller.UpdateAppointmentSeries())
+	incomingRoutes.POST("/appointment-series/:series_id/cancel", controller.CancelAppointmentSeries())
+}
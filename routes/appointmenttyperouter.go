@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AppointmentTypeRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/appointment-types", controller.GetAppointmentTypes())
+	incomingRoutes.GET("/appointment-types/:type_id", controller.GetAppointmentType())
+	incomingRoutes.POST("/appointment-types", controller.CreateAppointmentType())
+	incomingRoutes.PATCH("/appointment-types/:type_id", controller.UpdateAppointmentType())
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CalendarFeedRoutes are read by calendar apps that cannot send the token
+// header, so they are registered before the authentication middleware and
+// checked against the feed token in the URL instead.
+func CalendarFeedRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/doctors/:doctor_id/calendar.ics", controller.GetDoctorCalendar())
+	incomingRoutes.GET("/patients/:patient_id/calendar.ics", controller.GetPatientCalendar())
+}
+
+func CalendarRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/doctors/:doctor_id/calendar-token", controller.CreateDoctorCalendarToken())
+	incomingRoutes.POST("/patients/:patient_id/calendar-token", controller.CreatePatientCalendarToken())
+	incomingRoutes.GET("/appointment/:appointment_id/calendar.ics", controller.GetAppointmentICS())
+}
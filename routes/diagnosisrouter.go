@@ -0,0 +1,16 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func DiagnosisRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/diagnoses", controller.CreateDiagnosis())
+	incomingRoutes.GET("/diagnoses/:diagnosis_id", controller.GetDiagnosis())
+	incomingRoutes.PATCH("/diagnoses/:diagnosis_id", controller.UpdateDiagnosis())
+	incomingRoutes.GET("/patients/:patient_id/diagnoses", controller.GetPatientDiagnoses())
+	incomingRoutes.GET("/appointment/:appointment_id/diagnoses", controller.GetAppointmentDiagnoses())
+	incomingRoutes.GET("/prescription/:prescription_id/diagnoses", controller.GetPrescriptionDiagnoses())
+}
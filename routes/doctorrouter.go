@@ -6,6 +6,12 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// DoctorAuthRoutes hands out the token, so it is registered before the
+// authentication middleware.
+func DoctorAuthRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/doctors/login", controller.DoctorLogin())
+}
+
 func DoctorRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/doctors", controller.GetDoctors())
 	incomingRoutes.GET("/doctors/:doctor_id", controller.GetDoctor())
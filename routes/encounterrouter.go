@@ -0,0 +1,16 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func EncounterRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/encounters", controller.CreateEncounter())
+	incomingRoutes.GET("/encounters/:encounter_id", controller.GetEncounter())
+	incomingRoutes.PATCH("/encounters/:encounter_id", controller.UpdateEncounter())
+	incomingRoutes.POST("/encounters/:encounter_id/sign", controller.SignEncounter())
+	incomingRoutes.POST("/encounters/:encounter_id/addenda", controller.AddEncounterAddendum())
+	incomingRoutes.GET("/patients/:patient_id/encounters", controller.GetPatientEncounters())
+}
@@ -0,0 +1,42 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func FhirRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/fhir/metadata", controller.FhirMetadata())
+
+	incomingRoutes.GET("/fhir/$export", controller.ExportFhirData())
+	incomingRoutes.GET("/fhir/Patient/$export", controller.ExportFhirPatientData())
+	incomingRoutes.GET("/fhir/$export-status/:export_id", controller.GetFhirExportStatus())
+	incomingRoutes.DELETE("/fhir/$export-status/:export_id", controller.CancelFhirExport())
+	incomingRoutes.GET("/fhir/$export-file/:export_id/:file", controller.GetFhirExportFile())
+
+	incomingRoutes.GET("/fhir/Patient", controller.SearchFhirPatients())
+	incomingRoutes.GET("/fhir/Patient/:id", controller.ReadFhirPatient())
+	incomingRoutes.POST("/fhir/Patient", controller.CreateFhirPatient())
+	incomingRoutes.PUT("/fhir/Patient/:id", controller.UpdateFhirPatient())
+
+	incomingRoutes.GET("/fhir/Practitioner", controller.SearchFhirPractitioners())
+	incomingRoutes.GET("/fhir/Practitioner/:id", controller.ReadFhirPractitioner())
+	incomingRoutes.POST("/fhir/Practitioner", controller.CreateFhirPractitioner())
+	incomingRoutes.PUT("/fhir/Practitioner/:id", controller.UpdateFhirPractitioner())
+
+	incomingRoutes.GET("/fhir/Appointment", controller.SearchFhirAppointments())
+	incomingRoutes.GET("/fhir/Appointment/:id", controller.ReadFhirAppointment())
+	incomingRoutes.POST("/fhir/Appointment", controller.CreateFhirAppointment())
+	incomingRoutes.PUT("/fhir/Appointment/:id", controller.UpdateFhirAppointment())
+
+	incomingRoutes.GET("/fhir/MedicationRequest", controller.SearchFhirMedicationRequests())
+	incomingRoutes.GET("/fhir/MedicationRequest/:id", controller.ReadFhirMedicationRequest())
+	incomingRoutes.POST("/fhir/MedicationRequest", controller.CreateFhirMedicationRequest())
+	incomingRoutes.PUT("/fhir/MedicationRequest/:id", controller.UpdateFhirMedicationRequest())
+
+	incomingRoutes.GET("/fhir/Invoice", controller.SearchFhirInvoices())
+	incomingRoutes.GET("/fhir/Invoice/:id", controller.ReadFhirInvoice())
+	incomingRoutes.POST("/fhir/Invoice", controller.CreateFhirInvoice())
+	incomingRoutes.PUT("/fhir/Invoice/:id", controller.UpdateFhirInvoice())
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func FormularyRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/formulary/search", controller.SearchFormulary())
+	incomingRoutes.GET("/formulary/drugs/:drug_id", controller.GetDrug())
+	incomingRoutes.POST("/formulary/import", controller.UploadFormulary())
+	incomingRoutes.GET("/formulary/drugs/:drug_id/interactions", controller.GetDrugInteractions())
+	incomingRoutes.POST("/formulary/interactions/import", controller.UploadInteractions())
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func HL7Routes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/hl7/inbound", controller.ReceiveHL7Message())
+	incomingRoutes.GET("/hl7/messages", controller.GetHL7Messages())
+	incomingRoutes.GET("/hl7/messages/:message_id", controller.GetHL7Message())
+	incomingRoutes.POST("/hl7/messages/:message_id/replay", controller.ReplayHL7Message())
+
+	incomingRoutes.GET("/admissions", controller.GetAdmissions())
+	incomingRoutes.GET("/admission/:admission_id", controller.GetAdmission())
+}
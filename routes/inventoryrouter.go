@@ -0,0 +1,23 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func InventoryRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/inventory/items", controller.GetStockItems())
+	incomingRoutes.GET("/inventory/items/:item_id", controller.GetStockItem())
+	incomingRoutes.POST("/inventory/items", controller.CreateStockItem())
+	incomingRoutes.PATCH("/inventory/items/:item_id", controller.UpdateStockItem())
+	incomingRoutes.GET("/inventory/locations", controller.GetStockLocations())
+	incomingRoutes.POST("/inventory/locations", controller.CreateStockLocation())
+	incomingRoutes.POST("/inventory/receipts", controller.ReceiveGoods())
+	incomingRoutes.POST("/inventory/transfers", controller.TransferStock())
+	incomingRoutes.POST("/inventory/adjustments", controller.AdjustStock())
+	incomingRoutes.GET("/inventory/stock", controller.GetStockBalances())
+	incomingRoutes.GET("/inventory/movements", controller.GetStockMovements())
+	incomingRoutes.GET("/inventory/alerts/reorder", controller.GetReorderAlerts())
+	incomingRoutes.GET("/inventory/reports/expiring", controller.GetExpiringStock())
+}
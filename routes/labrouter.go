@@ -0,0 +1,27 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func LabRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/lab-tests", controller.GetLabTests())
+	incomingRoutes.POST("/lab-tests", controller.CreateLabTest())
+	incomingRoutes.PATCH("/lab-tests/:test_id", controller.UpdateLabTest())
+
+	incomingRoutes.GET("/lab-orders", controller.GetLabOrders())
+	incomingRoutes.GET("/lab-order/:order_id", controller.GetLabOrder())
+	incomingRoutes.POST("/lab-order", controller.CreateLabOrder())
+	incomingRoutes.POST("/lab-order/:order_id/collect", controller.CollectSpecimens())
+	incomingRoutes.POST("/lab-order/:order_id/receive", controller.ReceiveSpecimens())
+	incomingRoutes.POST("/lab-order/:order_id/cancel", controller.CancelLabOrder())
+	incomingRoutes.POST("/lab-order/:order_id/results", controller.EnterLabResult())
+
+	incomingRoutes.GET("/lab-result/:result_id", controller.GetLabResult())
+	incomingRoutes.POST("/lab-result/:result_id/correct", controller.CorrectLabResult())
+	incomingRoutes.POST("/lab-result/:result_id/review", controller.ReviewLabResult())
+	incomingRoutes.GET("/doctors/:doctor_id/lab-results/unreviewed", controller.GetUnreviewedLabResults())
+	incomingRoutes.GET("/patients/:patient_id/lab-results", controller.GetPatientLabResults())
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func LeaveRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/doctors/:doctor_id/slots", controller.GetDoctorSlots())
+	incomingRoutes.GET("/doctors/:doctor_id/leave", controller.GetDoctorLeave())
+	incomingRoutes.POST("/doctors/:doctor_id/leave", controller.RecordDoctorLeave())
+	incomingRoutes.POST("/doctors/:doctor_id/leave/:leave_id/withdraw", controller.WithdrawDoctorLeave())
+
+	incomingRoutes.GET("/holidays", controller.GetHolidays())
+	incomingRoutes.POST("/holidays", controller.CreateHoliday())
+	incomingRoutes.DELETE("/holidays/:holiday_id", controller.DeleteHoliday())
+
+	incomingRoutes.GET("/appointments/needs-reschedule", controller.GetRescheduleWorklist())
+	incomingRoutes.POST("/appointment/:appointment_id/reschedule", controller.ResolveReschedule())
+}
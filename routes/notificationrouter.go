@@ -0,0 +1,12 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func NotificationRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/notifications", controller.GetNotifications())
+	incomingRoutes.PATCH("/patients/:patient_id/notification-preferences", controller.UpdateNotificationPreferences())
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ObservationRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/patients/:patient_id/observations", controller.RecordObservations())
+	incomingRoutes.GET("/patients/:patient_id/observations", controller.GetPatientObservations())
+	incomingRoutes.GET("/escalation-alerts", controller.GetEscalationAlerts())
+	incomingRoutes.POST("/escalation-alerts/:alert_id/acknowledge", controller.AcknowledgeEscalationAlert())
+	incomingRoutes.GET("/doctors/:doctor_id/escalation-alerts/stream", controller.StreamEscalationAlerts())
+}
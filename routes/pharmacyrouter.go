@@ -0,0 +1,17 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PharmacyRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/pharmacy/queue", controller.GetDispenseQueue())
+	incomingRoutes.POST("/prescription/:prescription_id/dispense", controller.DispensePrescription())
+	incomingRoutes.GET("/prescription/:prescription_id/dispenses", controller.GetDispenses())
+	incomingRoutes.POST("/prescription/:prescription_id/refill-requests", controller.CreateRefillRequest())
+	incomingRoutes.GET("/refill-requests", controller.GetRefillRequests())
+	incomingRoutes.POST("/refill-requests/:refill_request_id/approve", controller.ApproveRefillRequest())
+	incomingRoutes.POST("/refill-requests/:refill_request_id/deny", controller.DenyRefillRequest())
+}
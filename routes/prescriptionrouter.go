@@ -9,6 +9,9 @@ import (
 func PrescriptionRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/prescriptions", controller.GetPrescriptions())
 	incomingRoutes.GET("/prescription/:prescription_id", controller.GetPrescription())
+	incomingRoutes.POST("/prescription", controller.CreatePrescription())
+	// the original misspelt path stays for existing clients
 	incomingRoutes.POST("/precription", controller.CreatePrescription())
 	incomingRoutes.PATCH("/prescription/:prescription_id", controller.UpdatePrescription())
+	incomingRoutes.POST("/prescriptions/check", controller.CheckPrescription())
 }
\ No newline at end of file
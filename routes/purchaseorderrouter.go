@@ -0,0 +1,20 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PurchaseOrderRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/purchase-orders", controller.GetPurchaseOrders())
+	incomingRoutes.GET("/purchase-orders/:po_id", controller.GetPurchaseOrder())
+	incomingRoutes.POST("/purchase-orders", controller.CreatePurchaseOrder())
+	incomingRoutes.POST("/purchase-orders/from-reorder-alerts", controller.CreateReorderPurchaseOrder())
+	incomingRoutes.PATCH("/purchase-orders/:po_id", controller.UpdatePurchaseOrder())
+	incomingRoutes.POST("/purchase-orders/:po_id/approve", controller.ApprovePurchaseOrder())
+	incomingRoutes.POST("/purchase-orders/:po_id/send", controller.SendPurchaseOrder())
+	incomingRoutes.POST("/purchase-orders/:po_id/receive", controller.ReceivePurchaseOrder())
+	incomingRoutes.POST("/purchase-orders/:po_id/close", controller.ClosePurchaseOrder())
+	incomingRoutes.POST("/purchase-orders/:po_id/payments", controller.RecordPoPayment())
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueueDisplayRoutes serves waiting-room screens, which show token numbers
+// only and cannot send the token header, so it sits before authentication.
+func QueueDisplayRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/queue/stream", controller.StreamQueueDisplay())
+}
+
+func QueueRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/queue", controller.GetQueue())
+	incomingRoutes.POST("/queue/tokens", controller.IssueQueueToken())
+	incomingRoutes.POST("/queue/call-next", controller.CallNextQueueToken())
+	incomingRoutes.POST("/queue/tokens/:entry_id/skip", controller.SkipQueueToken())
+	incomingRoutes.POST("/queue/tokens/:entry_id/recall", controller.RecallQueueToken())
+	incomingRoutes.POST("/queue/tokens/:entry_id/serve", controller.ServeQueueToken())
+	incomingRoutes.GET("/queue/tokens/:entry_id/position", controller.GetQueuePosition())
+}
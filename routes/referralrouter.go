@@ -0,0 +1,18 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ReferralRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/referrals", controller.GetReferrals())
+	incomingRoutes.GET("/referrals/:referral_id", controller.GetReferral())
+	incomingRoutes.POST("/referrals", controller.CreateReferral())
+	incomingRoutes.POST("/referrals/:referral_id/accept", controller.AcceptReferral())
+	incomingRoutes.POST("/referrals/:referral_id/decline", controller.DeclineReferral())
+	incomingRoutes.POST("/referrals/:referral_id/book", controller.BookReferral())
+	incomingRoutes.POST("/referrals/:referral_id/close", controller.CloseReferral())
+	incomingRoutes.GET("/doctors/:doctor_id/referrals/inbox", controller.GetReferralInbox())
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ResourceRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/resources", controller.GetResources())
+	incomingRoutes.GET("/resources/:resource_id", controller.GetResource())
+	incomingRoutes.POST("/resources", controller.CreateResource())
+	incomingRoutes.PATCH("/resources/:resource_id", controller.UpdateResource())
+	incomingRoutes.GET("/resources/:resource_id/calendar", controller.GetResourceCalendar())
+	incomingRoutes.GET("/resources/:resource_id/utilisation", controller.GetResourceUtilisation())
+}
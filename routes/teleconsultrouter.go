@@ -0,0 +1,21 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TeleconsultSignalRoutes serves the WebSocket signaling endpoint, which
+// checks the session token itself because browsers cannot send the token
+// header on a WebSocket, so it sits before authentication.
+func TeleconsultSignalRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/teleconsult/:appointment_id/signal", controller.SignalTeleconsult())
+}
+
+func TeleconsultRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/appointment/:appointment_id/teleconsult", controller.OpenTeleconsult())
+	incomingRoutes.GET("/appointment/:appointment_id/teleconsult", controller.GetTeleconsult())
+	incomingRoutes.POST("/appointment/:appointment_id/teleconsult/join-token", controller.IssueJoinToken())
+	incomingRoutes.POST("/appointment/:appointment_id/teleconsult/close", controller.CloseTeleconsult())
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TerminologyRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/terminology/search", controller.SearchConcepts())
+	incomingRoutes.GET("/terminology/concepts/:system/:code", controller.GetConcept())
+	incomingRoutes.GET("/terminology/code-sets", controller.GetCodeSets())
+	incomingRoutes.POST("/terminology/code-sets/:code_set_id/activate", controller.ActivateCodeSet())
+	incomingRoutes.POST("/terminology/import", controller.UploadTerminology())
+}
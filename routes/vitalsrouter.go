@@ -0,0 +1,12 @@
+package routes
+
+import (
+	controller "golang-hospital-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func VitalsRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/patients/:patient_id/vitals", controller.RecordVitals())
+	incomingRoutes.GET("/patients/:patient_id/vitals", controller.GetPatientVitals())
+}